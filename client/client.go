@@ -9,8 +9,11 @@ import (
 	"bufio"
 	"bytes"
 	"../lynxutil"
-	"../mycrypt"
-	"compress/gzip"
+	"../metainfo"
+	"../throttle"
+	"../torrent"
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -18,143 +21,185 @@ import (
 	"net/textproto"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// An array of the lynks found from parsing the lynks.txt file
-var lynks []lynxutil.Lynk
+// Client - The client of a Lynx node. It finds the node's lynks on their peers, keeps their
+// meta.info files up to date and downloads their files.
+type Client struct {
+	*lynxutil.Node
+
+	// Guards fileTableIndex - every GUI request reads or sets it
+	fileTableMu    sync.Mutex
+	fileTableIndex int // The lynk shown in the GUI's file table
+
+	// Guards the index files - the sync loop and incoming pushes both use them
+	indexMu sync.Mutex
+
+	// Guards metaLocks - each lynk's meta.info has its own lock, held across every change to it
+	metaMu    sync.Mutex
+	metaLocks map[string]*sync.Mutex
+
+	// Guards queues - the GUI, the API and incoming pushes all use them
+	queuesMu  sync.Mutex
+	queues    map[string]*lynkQueue // The queue of every lynk that has had a download, by name
+	downloads *scheduler            // The scheduler every download goes through
+
+	// Guards limits and the buckets - the GUI changes them while transfers use them
+	limitsMu     sync.Mutex
+	limits       Limits                // The limits in force
+	globalShares sharePair             // The global limits, split fairly between busy lynks
+	lynkBuckets  map[string]bucketPair // The buckets of each lynk that has had a transfer
+	peerBuckets  map[string]bucketPair // The buckets of each peer that has had a transfer
+}
 
-// A special symbol we use to denote the end of 1 entry in the metainfo file
-const endOfEntry = ":#!"
+// Default - The client of the default node. It is nil until LoadDefault has created it.
+var Default *Client
+
+// Guards the creation of the default client
+var defaultMu sync.Mutex
+
+// New - Creates the client of a node and loads the node's lynks, meta.info files and limits.
+// @param *lynxutil.Node node - The node
+// @return *Client - The client
+func New(node *lynxutil.Node) *Client {
+	c := &Client{Node: node, fileTableIndex: -1, queues: map[string]*lynkQueue{},
+		metaLocks: map[string]*sync.Mutex{},
+		downloads: &scheduler{total: MaxDownloads, perLynk: MaxLynkDownloads,
+			active: map[string]int{}},
+		limits: Limits{Lynks: map[string]Rate{}, Peers: map[string]Rate{},
+			MaxDownloads: MaxDownloads, MaxLynkDownloads: MaxLynkDownloads},
+		globalShares: sharePair{throttle.NewShare(0), throttle.NewShare(0)},
+		lynkBuckets:  map[string]bucketPair{}, peerBuckets: map[string]bucketPair{}}
+
+	c.ParseLynks(c.Home + "lynks.txt")
+	c.genLynks()
+	c.loadLimits()
+	return c
+}
 
-// The array index of our metainfo values
-const metaValueIndex = 1
+// LockMeta - Locks a lynk's meta.info so it can be read, changed and written back without
+// another change landing in between and being lost. Every change to a meta.info holds the lock.
+// @param string lynkName - The name of the lynk
+// @return func() - Unlocks the meta.info
+func (c *Client) LockMeta(lynkName string) func() {
+	c.metaMu.Lock()
+	if c.metaLocks == nil {
+		c.metaLocks = map[string]*sync.Mutex{}
+	}
+	lock, ok := c.metaLocks[lynkName]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.metaLocks[lynkName] = lock
+	}
+	c.metaMu.Unlock()
 
-//holds the variable for the table lynk index
-var fileTableIndex = -1
+	lock.Lock()
+	return lock.Unlock
+}
 
 // DeleteFile - Function that deletes an entry from a lynk's files array.
-// @param string nameToDelete - This is the name of the file we want to delete
+// @param string nameToDelete - This is the path of the file we want to delete, relative to the lynk
 // @param string lynkName - The lynk we want to delete it from
-func DeleteFile(nameToDelete, lynkName string) error {
+func (c *Client) DeleteFile(nameToDelete, lynkName string) error {
 	// Need to delete the local file too - so parseMeta properly picks it up
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	var err error
-
-	if lynk == nil {
-		err = errors.New("Could not delete file")
-	}
-
-	i := 0
-	for i < len(lynk.Files) {
-		if nameToDelete == lynk.Files[i].Name {
-			lynk.Files = append(lynk.Files[:i], lynk.Files[i+1:]...)
+	found := c.Lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		i := 0
+		for i < len(lynk.Files) {
+			if nameToDelete == lynk.Files[i].Path {
+				lynk.Files = append(lynk.Files[:i], lynk.Files[i+1:]...)
+			} else {
+				i++
+			}
 		}
-		i++
-	}
+	})
 
-	return err
+	if !found {
+		return errors.New("Could not delete file")
+	}
+	return nil
 }
 
-// DeleteFileIndex - Deletes a file from a lynk
+// DeleteFileIndex - Deletes a file from a lynk and leaves a tombstone for it in the meta.info
 // fileDelete - the index of the file in the array
 // lynkIndex - the lynk which the file corresponds to
-func DeleteFileIndex(fileDelete, lynkIndex int) {
-	lynk := lynks[lynkIndex]
-	os.Remove(lynk.Files[fileDelete].Path)
-	lynk.Files = append(lynk.Files[:fileDelete], lynk.Files[fileDelete+1:]...)
+func (c *Client) DeleteFileIndex(fileDelete, lynkIndex int) {
+	lynk, ok := c.Lynks.At(lynkIndex)
+	if !ok || fileDelete < 0 || fileDelete >= len(lynk.Files) {
+		return
+	}
+	relPath := lynk.Files[fileDelete].Path
+	// Keeps the deleted copy as an old version in case it is wanted back
+	if c.saveVersion(lynk.Name, relPath) != nil {
+		os.Remove(c.Home + lynk.Name + "/" + relPath)
+	}
 
-	//fmt.Println(lynks[lynkIndex].Files)
-	//fmt.Println(lynk.Files)
-	lynks[lynkIndex].Files = lynk.Files
+	// Leaves a tombstone in the meta.info so peers delete their copies too - if we may change it
+	metaPath := c.Home + lynk.Name + "/meta.info"
+	unlock := c.LockMeta(lynk.Name)
+	if m, err := metainfo.Read(metaPath); err == nil && m.Authorised(c.KeyFingerprint) &&
+		m.Delete(relPath, time.Now()) {
+		metainfo.Write(metaPath, m)
+	}
+	unlock()
+	c.DeleteFile(relPath, lynk.Name)
 }
 
-// UpdateMetainfo - Deletes the current meta.info and replaces it with a new version that
-// accurately reflects the array of Files after they have been modified.
-// @return error - An error can be produced when issues arise from trying to create
-// or remove the meta file - otherwise error will be nil.
-func UpdateMetainfo(metaPath string) error {
-	ParseMetainfo(metaPath)
-	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-
-	err := os.Remove(metaPath)
-	if err != nil {
-		fmt.Println(err)
-		return err
+// UpdateMetainfo - Replaces the current meta.info with a new version that accurately reflects
+// the array of Files after they have been modified.
+// @return error - An error can be produced when issues arise from trying to read or write
+// the meta file - otherwise error will be nil.
+func (c *Client) UpdateMetainfo(metaPath string) error {
+	lynkName := c.GetLynkName(metaPath)
+	defer c.LockMeta(lynkName)()
+	c.ParseMetainfo(metaPath)
+	lynk, ok := c.Lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk Not Found")
 	}
 
-	newMetainfo, err := os.Create(metaPath)
+	m := metainfo.FromLynk(&lynk)
+	if old, err := metainfo.Read(metaPath); err == nil {
+		m.Sequence = old.Sequence // Pushes keep counting from where they were
+		m.PieceLength, m.Pieces = old.PieceLength, old.Pieces
+	}
+	err := metainfo.Write(metaPath, m)
 	if err != nil {
 		fmt.Println(err)
-		return err
 	}
-
-	newMetainfo.WriteString("announce:::" + lynk.Tracker + "\n") // Write tracker IP
-	newMetainfo.WriteString("lynkName:::" + lynk.Name + "\n")
-	newMetainfo.WriteString("owner:::" + lynk.Owner + "\n")
-	i := 0
-	for i < len(lynk.Files) {
-		newMetainfo.WriteString("length:::" + strconv.Itoa(lynk.Files[i].Length) + "\n") // str conv
-		newMetainfo.WriteString("path:::" + lynk.Files[i].Path + "\n")
-		newMetainfo.WriteString("name:::" + lynk.Files[i].Name + "\n")
-		newMetainfo.WriteString("chunkLength:::" + strconv.Itoa(lynk.Files[i].ChunkLength) + "\n")
-		newMetainfo.WriteString("chunks:::" + lynk.Files[i].Chunks + "\n")
-		newMetainfo.WriteString(endOfEntry + "\n")
-		i++
-	}
-
-	return newMetainfo.Close()
+	return err
 }
 
 // ParseMetainfo - Parses the information in meta.info file and places each entry into a File
-// struct and appends that struct to the array of structs
+// struct in the lynk's array of Files. A meta.info in an older format is migrated as it is read.
 // @param string metaPath - The path to the metainfo file
 // @return error - An error can be produced when issues arise from trying to access
 // the meta file or from an invalid meta file type - otherwise error will be nil.
-func ParseMetainfo(metaPath string) error {
-	lynk := lynxutil.GetLynk(lynks, GetLynkName(metaPath))
-	if lynk == nil {
-		return errors.New("Lynk Not Found")
+func (c *Client) ParseMetainfo(metaPath string) error {
+	var m *metainfo.Meta
+	_, err := os.Stat(metaPath)
+	if err == nil && !strings.Contains(metaPath, "meta.info") {
+		err = errors.New("Invalid File Type")
+	}
+	if err == nil {
+		m, err = metainfo.Read(metaPath)
 	}
-	lynk.Files = nil // Resets files array
 
-	metaFile, err := os.Open(metaPath)
-	if err != nil {
-		return err
-	} else if !strings.Contains(metaPath, "meta.info") {
-		return errors.New("Invalid File Type")
-	}
-
-	scanner := bufio.NewScanner(metaFile)
-	tempFile := lynxutil.File{}
-	for scanner.Scan() { // Scan each line
-		split := strings.Split(strings.TrimSpace(scanner.Text()), ":::")
-		if split[0] == "announce" {
-			lynk.Tracker = split[metaValueIndex]
-		} else if split[0] == "owner" {
-			lynk.Owner = split[metaValueIndex]
-		} else if split[0] == "lynkName" {
-			lynk.Name = split[metaValueIndex]
-		} else if split[0] == "chunkLength" {
-			tempFile.ChunkLength, _ = strconv.Atoi(split[metaValueIndex])
-		} else if split[0] == "length" {
-			tempFile.Length, _ = strconv.Atoi(split[metaValueIndex])
-		} else if split[0] == "path" {
-			tempFile.Path = split[metaValueIndex]
-		} else if split[0] == "name" {
-			tempFile.Name = split[metaValueIndex]
-		} else if split[0] == "chunks" {
-			tempFile.Chunks = split[metaValueIndex]
-		} else if split[0] == endOfEntry {
-			lynk.Files = append(lynk.Files, tempFile) // Append the current file to the file array
-			tempFile = lynxutil.File{}                // Empty the current file
+	// The meta.info is read before the lynk is locked so readers never wait on the disk
+	found := c.Lynks.Update(c.GetLynkName(metaPath), func(lynk *lynxutil.Lynk) {
+		lynk.Files = nil // Resets files array
+		if m != nil {
+			m.ApplyTo(lynk)
 		}
+	})
+	if !found {
+		return errors.New("Lynk Not Found")
 	}
-	return metaFile.Close()
+	return err
 }
 
 // AddToMetainfo - Adds a file to the meta.info by parsing that file's information
@@ -163,8 +208,18 @@ func ParseMetainfo(metaPath string) error {
 // @return error - An error can be produced when issues arise from trying to access
 // the meta file or if the file to be added already exists in the meta file - otherwise
 // error will be nil.
-func AddToMetainfo(addPath, metaPath string) error {
-	metaFile, err := os.OpenFile(metaPath, os.O_APPEND|os.O_WRONLY, 0644) // Opens for appending
+func (c *Client) AddToMetainfo(addPath, metaPath string) error {
+	defer c.LockMeta(c.GetLynkName(metaPath))()
+	return c.addToMetainfo(addPath, metaPath)
+}
+
+// Helper function for AddToMetainfo and CreateMeta - adds a file to the meta.info. The caller
+// holds the lynk's meta.info lock.
+// @param string addPath - the path of the file to be added
+// @param string metaPath - the path of the metainfo file
+// @return error - An error is produced if the file can't be read or is already in the meta.info
+func (c *Client) addToMetainfo(addPath, metaPath string) error {
+	m, err := metainfo.Read(metaPath)
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -176,98 +231,124 @@ func AddToMetainfo(addPath, metaPath string) error {
 		return err
 	}
 
-	ParseMetainfo(metaPath)
-	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	lynkName := c.GetLynkName(metaPath)
 
-	i := 0
-	for i < len(lynk.Files) {
-		if lynk.Files[i].Name == addStat.Name() {
-			return errors.New("Can't Add Duplicates To Metainfo")
-		}
-		i++
+	// Stores the path relative to the lynk's directory - files from outside the lynk go at the top
+	tempPath, err := filepath.Abs(addPath) // Find the path of the current file
+	if err != nil {
+		return err
+	}
+	relPath, err := filepath.Rel(c.Home+lynkName, tempPath)
+	if err == nil {
+		relPath, err = lynxutil.CleanPath(relPath)
+	}
+	if err != nil {
+		relPath = addStat.Name()
 	}
 
-	lengthStr := strconv.FormatInt(addStat.Size(), 10) // Convert int64 to string
-	metaFile.WriteString("length:::" + lengthStr + "\n")
+	if lynxutil.GetFile(m.Files, relPath) != nil {
+		return errors.New("Can't Add Duplicates To Metainfo")
+	}
 
-	tempPath, err := filepath.Abs(addPath) // Find the path of the current file
+	chunks, hash, err := lynxutil.HashFile(addPath, lynxutil.ChunkLength)
 	if err != nil {
 		return err
 	}
 
-	// Write to metainfo file using ::: to separate keys and values
-	metaFile.WriteString("path:::" + tempPath + "\n")
-	metaFile.WriteString("name:::" + addStat.Name() + "\n")
-	metaFile.WriteString("chunkLength:::32\n")
-	metaFile.WriteString("chunks:::256\n")
-	metaFile.WriteString(endOfEntry + "\n")
-	return metaFile.Close()
+	m.Add(lynxutil.File{
+		Length:      int(addStat.Size()),
+		Path:        relPath,
+		Name:        addStat.Name(),
+		Chunks:      chunks,
+		ChunkLength: lynxutil.ChunkLength,
+		Hash:        hash,
+		Versions:    map[string]int{c.PeerID: 1},
+	})
+	return metainfo.Write(metaPath, m)
 }
 
 // HaveFile - Checks to see if we have the passed in file.
-// @param string filePath - The name of the file to check for - This includes the lynk name.
-// E.G. - 'Cool_Lynk/coolFile.txt'
+// @param string filePath - The path of the file to check for - This includes the lynk name and
+// any folders inside the lynk. E.G. - 'Cool_Lynk/coolFile.txt' or 'Cool_Lynk/docs/coolFile.txt'
 // @return bool - A boolean indicating whether or not we have a file in our
 // files array.
-func HaveFile(filePath string) bool {
-	have := false
-
-	lynkInfo := strings.Split(filePath, "/")
-	if len(lynkInfo) != 2 {
+func (c *Client) HaveFile(filePath string) bool {
+	lynkName, relPath, err := SplitFilePath(filePath)
+	if err != nil {
 		fmt.Println(filePath + " is an invalid filepath")
-		return have
+		return false
 	}
 
-	lynkName := lynkInfo[0]
-	fileName := lynkInfo[1]
-	metaPath := lynxutil.HomePath + lynkName + "/meta.info"
-	ParseMetainfo(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	metaPath := c.Home + lynkName + "/meta.info"
+	c.ParseMetainfo(metaPath)
+	_, ok := c.Lynks.GetFile(lynkName, relPath)
+	return ok
+}
 
-	i := 0
-	for i < len(lynk.Files) && !have {
-		if lynk.Files[i].Name == fileName {
-			have = true
-		}
-		i++
+// SplitFilePath - Splits a requested file path into the lynk's name and the file's path inside
+// that lynk.
+// @param string filePath - E.G. - 'Cool_Lynk/docs/coolFile.txt'
+// @return string - The lynk's name - E.G. - 'Cool_Lynk'
+// @return string - The file's path inside the lynk - E.G. - 'docs/coolFile.txt'
+// @return error - An error is produced if there is no lynk name or the path leaves the lynk
+func SplitFilePath(filePath string) (string, string, error) {
+	lynkInfo := strings.SplitN(filePath, "/", 2)
+	if len(lynkInfo) != 2 || lynkInfo[0] == "" || lynkInfo[0] == "." || lynkInfo[0] == ".." {
+		return "", "", errors.New(filePath + " is an invalid filepath")
 	}
 
-	return have
+	relPath, err := lynxutil.CleanPath(lynkInfo[1])
+	if err != nil {
+		return "", "", err
+	}
+	return lynkInfo[0], relPath, nil
 }
 
 // GetTracker - Simply returns the tracker associated with the passed in Lynk
 // @param string metaPath - The meta.info path associated with the lynk we're interested in
 // @return string - A string representing the tracker's IP address.
-func GetTracker(metaPath string) string {
-	ParseMetainfo(metaPath)
-	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+func (c *Client) GetTracker(metaPath string) string {
+	c.ParseMetainfo(metaPath)
+	lynk, _ := c.Lynks.Get(c.GetLynkName(metaPath))
 	return lynk.Tracker
 }
 
 // Gets a file from the peer(s)
-// @param string fileName - The name of the file to find in the peers
+// @param context.Context ctx - Cancelling it stops the download, leaving what was received staged
+// @param string fileName - The path of the file to find in the peers, relative to the lynk
 // @param string metaPath - The meta.info path associated with the lynk we're interested in
 // @return error - An error can be produced if there are connection issues,
 // problems creating or writing to the file, or from not being able to get there
 // desired file - otherwise error will be nil.
-func getFile(fileName, metaPath string) error {
+func (c *Client) getFile(ctx context.Context, fileName, metaPath string) error {
 	// Will parseMetainfo file and then ask tracker for list of peers
-	ParseMetainfo(metaPath)
-	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	c.ParseMetainfo(metaPath)
+	lynkName := c.GetLynkName(metaPath)
 	//fmt.Println("Asking For File From: " + metaPath)
-	askTrackerForPeers(lynkName)
+	c.askTrackerForPeers(lynkName)
+	lynk, ok := c.Lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk " + lynkName + " Not Found")
+	}
 	//fmt.Println(lynk.Peers)
 
+	// Files with chunk hashes are pulled from the whole swarm at once - unless we hold an older
+	// version, in which case only the blocks that changed are fetched
+	file := lynxutil.GetFile(lynk.Files, fileName)
+	if file != nil && file.Hash != "" && len(file.Chunks) > 0 {
+		if c.deltaDownload(ctx, lynkName, *file, lynk.Peers) == nil {
+			return nil
+		}
+		return c.swarmDownload(ctx, lynkName, *file, lynk.Peers)
+	}
+
 	i := 0
 	gotFile := false
 	for i < len(lynk.Peers) && !gotFile {
-		conn, err := net.Dial("tcp", lynk.Peers[i].IP+":"+lynk.Peers[i].Port)
+		conn, err := dialPeer(ctx, lynk.Peers[i])
 		// We don't want to return on err because we might be able to connect to next peer.
 		if err == nil {
-			gotFile = askForFile(lynkName, fileName, conn)
+			gotFile = c.askForFile(lynkName, fileName, conn)
 		}
 		//fmt.Println(i)
 		i++
@@ -322,49 +403,54 @@ func getFile(fileName, metaPath string) error {
 
 // The function responsible for actually asking for a file from a peer
 // @param string lynkName - The name of the lynk we're asking about
-// @param string fileName - The name of the file to find in the peers
+// @param string fileName - The path of the file to find in the peers, relative to the lynk
 // @param net.Conn conn - The connection to the peer
 // @return bool - True or false is returned based on whether or not we successfully received a file
-func askForFile(lynkName, fileName string, conn net.Conn) bool {
+func (c *Client) askForFile(lynkName, fileName string, conn net.Conn) bool {
 	fmt.Fprintf(conn, "Do_You_Have_FileName:"+lynkName+"/"+fileName+"\n")
+	fmt.Fprintln(conn, c.KeyFingerprint) // The peer wraps the file's session key for our key
 
 	fmt.Println("Downloading: " + fileName + " From " + conn.LocalAddr().String())
 
-	reply, err := bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n') // Waits for a String ending in newline
 	reply = strings.TrimSpace(reply)
 	gotFile := false
 
 	// Has file and no errors
 	if reply != "NO" && err == nil {
-		bufIn, err := ioutil.ReadAll(conn)
-		if err != nil {
-			fmt.Println("Did Not Receive File!")
+		metaFile, ok := c.Lynks.GetFile(lynkName, fileName)
+		if !ok {
 			return gotFile
 		}
 
-		// Decrypt
-		key := []byte(lynxutil.PrivateKey)
-		var plainFile []byte
-		if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
-			//log.Fatal(err)
+		// Streams the file into the staging area so it is never held in memory
+		partPath := c.stagingDir(lynkName) + fileName + ".part"
+		if err = os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
 			return gotFile
 		}
+		part, err := os.Create(partPath)
+		if err != nil {
+			return gotFile
+		}
+		defer os.Remove(partPath) // Does nothing once the file has been moved
 
-		// Decompress
-		r, _ := gzip.NewReader(bytes.NewBuffer(plainFile))
-		bufOut, _ := ioutil.ReadAll(r)
-		r.Read(bufOut)
-		r.Close()
-
-		file, err := os.Create(lynxutil.HomePath + lynkName + "/" + fileName)
+		err = c.ReceiveStream(c.DownloadReader(reader, lynkName, conn), part)
+		if cErr := part.Close(); err == nil {
+			err = cErr
+		}
 		if err != nil {
+			fmt.Println("Did Not Receive File!")
 			return gotFile
 		}
-		defer file.Close()
 
-		//fmt.Println(len(bufIn), "Bytes Received")
-		file.Write(bufOut)
-		gotFile = true
+		// Checks every chunk against the meta.info so a corrupt transfer never reaches the lynk
+		if err = lynxutil.VerifyPath(partPath, metaFile); err != nil {
+			fmt.Println("Rejected " + fileName + ": " + err.Error())
+			return gotFile
+		}
+
+		gotFile = c.moveIntoLynk(partPath, lynkName, fileName) == nil
 	}
 
 	return gotFile
@@ -376,8 +462,9 @@ func askForFile(lynkName, fileName string, conn net.Conn) bool {
 // @param string fileName - The name of the file to find in the peers
 // @param net.Conn conn - The connection to the peer
 // @return bool - True or false is returned based on whether or not we successfully received a file
-func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
+func (c *Client) askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 	fmt.Fprintf(conn, "Do_You_Have_FileName:"+lynkName+"/"+fileName+"\n")
+	fmt.Fprintln(conn, c.KeyFingerprint) // The peer wraps the file's session key for our key
 
 	fmt.Println("Downloading: " + fileName + " From " + conn.RemoteAddr().String())
 
@@ -392,7 +479,7 @@ func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 		time.Sleep(time.Duration(10) * time.Second) // Waits X amount of time and then continues
 
 		if err != nil || reply == "YES" {
-			lynk := lynxutil.GetLynk(lynks, lynkName)
+			lynk, _ := c.Lynks.Get(lynkName)
 			var file lynxutil.File
 			for _, f := range lynk.Files {
 				if f.Name == lynkName {
@@ -404,28 +491,21 @@ func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 			return gotFile
 		}
 
-		// Decrypt
-		key := []byte(lynxutil.PrivateKey)
-		var plainFile []byte
-		if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
+		// Decrypt & Decompress
+		var bufOut bytes.Buffer
+		if err = c.ReceiveStream(bytes.NewReader(bufIn), &bufOut); err != nil {
 			//log.Fatal(err)
 			return gotFile
 		}
 
-		// Decompress
-		r, _ := gzip.NewReader(bytes.NewBuffer(plainFile))
-		bufOut, _ := ioutil.ReadAll(r)
-		r.Read(bufOut)
-		r.Close()
-
-		file, err := os.Create(lynxutil.HomePath + lynkName + "/" + fileName)
+		file, err := os.Create(c.Home + lynkName + "/" + fileName)
 		if err != nil {
 			return gotFile
 		}
 		defer file.Close()
 
 		fmt.Println(len(bufIn), "Bytes Received")
-		file.Write(bufOut)
+		file.Write(bufOut.Bytes())
 		gotFile = true
 	}
 
@@ -434,8 +514,11 @@ func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 
 // Asks the tracker for a list of peers and then places them into a lynk's peers array
 // @param string lynkName - The name of the lynk we're interested in
-func askTrackerForPeers(lynkName string) error {
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+func (c *Client) askTrackerForPeers(lynkName string) error {
+	lynk, ok := c.Lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk " + lynkName + " Not Found")
+	}
 	// Connects to tracker
 	conn, err := net.Dial("tcp", lynk.Tracker)
 
@@ -459,8 +542,9 @@ func askTrackerForPeers(lynkName string) error {
 		}
 	}
 
-	// Gives IP and ServerPort So It Can Be Added To swarm.info
-	fmt.Fprintf(conn, "Swarm_Request:"+lynxutil.GetIP()+":"+lynxutil.ServerPort+":"+lynkName+"\n")
+	// Gives IP, ServerPort and our key So They Can Be Added To swarm.info
+	fmt.Fprintf(conn, "Swarm_Request:"+lynxutil.GetIP()+":"+c.ServerPort+":"+lynkName+":"+
+		lynxutil.EncodeKey(c.PublicKey)+"\n")
 	reader := bufio.NewReader(conn)
 	tp := textproto.NewReader(reader)
 
@@ -468,53 +552,147 @@ func askTrackerForPeers(lynkName string) error {
 	//fmt.Println(reply)
 
 	// Tracker will close connection when finished - which will break us out of this loop
+	peers := []lynxutil.Peer{}
 	for err == nil {
-		peerArray := strings.Split(reply, ":::")
-		tmpPeer := lynxutil.Peer{IP: peerArray[0], Port: peerArray[1]}
-		if !contains(lynk.Peers, tmpPeer) {
-			lynk.Peers = append(lynk.Peers, tmpPeer)
+		if tmpPeer, pErr := lynxutil.ParsePeer(reply); pErr == nil && c.pinPeer(tmpPeer) {
+			peers = append(peers, tmpPeer)
 		}
 		reply, err = tp.ReadLine()
 	}
 
+	c.Lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		for _, tmpPeer := range peers {
+			if i := indexOf(lynk.Peers, tmpPeer); i == -1 {
+				lynk.Peers = append(lynk.Peers, tmpPeer)
+			} else if tmpPeer.Key != "" {
+				lynk.Peers[i].Key = tmpPeer.Key
+			}
+		}
+	})
 	return nil // Did not have an error if we reached this point
 }
 
-// Simple helper method that checks peers array for specific peer.
+// Checks a peer's key against the one we pinned for it, trusting it if we have never seen the
+// peer before. A changed key could mean someone is posing as the peer, so it is reported loudly
+// and the peer is left out until the user unpins it.
+// @param lynxutil.Peer peer - The peer as the tracker listed it
+// @return bool - False if the peer announced a different key than the one we pinned
+func (c *Client) pinPeer(peer lynxutil.Peer) bool {
+	if peer.Key == "" {
+		return true // Peers that announce no key have nothing to pin
+	}
+
+	address := peer.IP + ":" + peer.Port
+	fingerprint, err := c.PinKey(address, peer.Key)
+	if err == lynxutil.ErrKeyChanged {
+		pinned, _ := c.PinnedKey(address)
+		fmt.Println("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+		fmt.Println("@    WARNING: THE KEY OF PEER " + address + " HAS CHANGED!")
+		fmt.Println("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+		fmt.Println("Someone could be posing as this peer. Its key was " + pinned)
+		fmt.Println("and is now " + fingerprint + " - it won't be used until you run")
+		fmt.Println("'lynx unpin " + address + "' to trust the new key.")
+		return false
+	} else if err != nil {
+		fmt.Println(err)
+	}
+	return true
+}
+
+// PeerKey - Returns the key of a peer of a lynk, so data it asks for can be encrypted for it.
+// Only keys pinned for the lynk's peers are used, so the peer must be in the lynk's swarm - the
+// tracker is asked for the swarm again if the key isn't known yet.
+// @param string lynkName - The name of the lynk the peer asked about
+// @param string fingerprint - The fingerprint the peer gave for its key
+// @return string - The armored public key
+// @return error - An error is produced if no peer of the lynk has the key
+func (c *Client) PeerKey(lynkName, fingerprint string) (string, error) {
+	for asked := false; ; asked = true {
+		lynk, ok := c.Lynks.Get(lynkName)
+		if !ok {
+			return "", errors.New("Lynk " + lynkName + " Not Found")
+		}
+		for _, peer := range lynk.Peers {
+			pinned, ok := c.PinnedKey(peer.IP + ":" + peer.Port)
+			if ok && fingerprint != "" && strings.EqualFold(pinned, fingerprint) {
+				return c.LookupKey(pinned)
+			}
+		}
+		if asked || c.askTrackerForPeers(lynkName) != nil {
+			return "", errors.New("No Peer Of " + lynkName + " Has Key " + fingerprint)
+		}
+	}
+}
+
+// Simple helper method that finds a peer in a peers array by its address.
 // @param s []peers - The peers array
 // @param e Peer - The peer we are checking for
-func contains(s []lynxutil.Peer, e lynxutil.Peer) bool {
-	for _, a := range s {
-		if a == e {
-			return true
+// @return int - The peer's index, or -1 if it isn't in the array
+func indexOf(s []lynxutil.Peer, e lynxutil.Peer) int {
+	for i, a := range s {
+		if a.IP == e.IP && a.Port == e.Port {
+			return i
 		}
 	}
-	return false
+	return -1
 }
 
 // CreateMeta - This function creates a new metainfo file for use within the GUI server
 // @param name string - The name of the new lynk
-func CreateMeta(name string) error {
-	tDir, err := os.Stat(lynxutil.HomePath + name) // Checks to see if the directory exists
+func (c *Client) CreateMeta(name string) error {
+	tDir, err := os.Stat(c.Home + name) // Checks to see if the directory exists
 	if err != nil || !tDir.IsDir() {
 		return errors.New("Directory " + name + "does not exist in the Lynx directory.")
 	}
 
-	metaFile, err := os.Create(lynxutil.HomePath + name + "/meta.info")
+	defer c.LockMeta(name)()
+	currentUser, _ := user.Current()
+	m := metainfo.New(name, currentUser.Name, lynxutil.GetIP()+":"+c.TrackerPort)
+	m.OwnerKey = c.KeyFingerprint
+	old, oldErr := metainfo.Read(c.Home + name + "/meta.info")
+	if oldErr == nil && !old.Authorised(c.KeyFingerprint) {
+		return errors.New("Not Authorised To Change " + name)
+	} else if oldErr == nil {
+		// Rebuilding the meta.info of a lynk we write to doesn't make it ours
+		m.Owner, m.OwnerKey, m.Announce = old.Owner, old.OwnerKey, old.Announce
+		m.Tombstones = old.Tombstones // Deleted files stay deleted when the meta.info is rebuilt
+		m.Writers = old.Writers
+		m.Sequence = old.Sequence
+	}
+	err = metainfo.Write(c.Home+name+"/meta.info", m)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	currentUser, _ := user.Current()
-	metaFile.WriteString("announce:::" + lynxutil.GetIP() + ":" + lynxutil.TrackerPort + "\n")
-	metaFile.WriteString("lynkName:::" + name + "\n")
-	metaFile.WriteString("owner:::" + currentUser.Name + "\n")
+	c.addLynk(name, currentUser.Name)
+	filepath.Walk(c.Home+name, c.skipIgnored(name, c.visitFiles))
 
-	addLynk(name, currentUser.Name)
-	filepath.Walk(lynxutil.HomePath+name, visitFiles)
+	// Files keep their version history when the meta.info is rebuilt
+	if m, err = metainfo.Read(c.Home + name + "/meta.info"); oldErr == nil && err == nil {
+		i := 0
+		for i < len(m.Files) {
+			if prev := lynxutil.GetFile(old.Files, m.Files[i].Path); prev == nil {
+				// A new file keeps the version AddToMetainfo gave it
+			} else if prev.Hash == m.Files[i].Hash {
+				m.Files[i].Versions = prev.Versions
+			} else {
+				m.Files[i].Versions = metainfo.BumpVersion(prev.Versions, c.PeerID)
+			}
+			i++
+		}
 
-	ParseMetainfo(lynxutil.HomePath + name + "/meta.info")
+		// Files outside the folders we subscribe to are still part of the lynk for everyone else
+		filter := c.loadFilter(name)
+		for _, prev := range old.Files {
+			if !filter.subscribed(prev.Path, false) && lynxutil.GetFile(m.Files, prev.Path) == nil {
+				m.Files = append(m.Files, prev)
+			}
+		}
+		metainfo.Write(c.Home+name+"/meta.info", m)
+	}
+
+	c.ParseMetainfo(c.Home + name + "/meta.info")
 
 	return nil // Everything was fine if we reached this point
 }
@@ -524,15 +702,15 @@ func CreateMeta(name string) error {
 // @param file os.FileInfo - each file within the root or inner directories
 // @param err error - any error we way encoutner along the way
 // @return error - An error can produced if we encounter an invalid file.
-func visitFiles(path string, file os.FileInfo, err error) error {
+func (c *Client) visitFiles(path string, file os.FileInfo, err error) error {
 	// Don't add directories, trackers, or a meta.info file to the new meta.info
 	if !file.IsDir() && !strings.Contains(path, "_Tracker") && file.Name() != "meta.info" {
 		//fmt.Println(file.Name())
 		slashes := strings.Replace(path, "\\", "/", -1)
 		//fmt.Println(slashes)
-		tmpStr := strings.TrimPrefix(slashes, lynxutil.HomePath)
+		tmpStr := strings.TrimPrefix(slashes, c.Home)
 		tmpArr := strings.Split(tmpStr, "/")
-		AddToMetainfo(path, lynxutil.HomePath+tmpArr[0]+"/meta.info")
+		c.addToMetainfo(path, c.Home+tmpArr[0]+"/meta.info") // CreateMeta holds the lock
 	}
 
 	return nil
@@ -542,28 +720,26 @@ func visitFiles(path string, file os.FileInfo, err error) error {
 // @param name string - the name of the lynk
 // @param owner string - the owner of the lynk
 // @return error - An error can be produced if the lynks.txt file cannot be opened
-func addLynk(name, owner string) error {
+func (c *Client) addLynk(name, owner string) error {
 
-	lynkFile, err := os.OpenFile(lynxutil.HomePath+"lynks.txt", os.O_APPEND|os.O_WRONLY, 0644)
+	// A new node has no lynks.txt until its first lynk is added
+	lynkFile, err := os.OpenFile(c.Home+"lynks.txt", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		fmt.Println(err)
-		// create file if not real
+		return err
 	}
 
-	i := 0
-	for i < len(lynks) {
+	for _, lynk := range c.Lynks.All() {
 		// Will have to validate directory names
-		fmt.Println()
-		if strings.TrimSpace(lynks[i].Name+lynks[i].Owner) == strings.TrimSpace(name+owner) {
+		if strings.TrimSpace(lynk.Name+lynk.Owner) == strings.TrimSpace(name+owner) {
 			return errors.New("Can't Add Duplicate Lynk")
 		}
-		i++
 	}
 
 	lynkFile.WriteString(name + ":::Unsynced:::" + owner + "\n")
 
-	ParseLynks(lynxutil.HomePath + "lynks.txt")
-	genLynks()
+	c.ParseLynks(c.Home + "lynks.txt")
+	c.genLynks()
 
 	return lynkFile.Close()
 }
@@ -572,8 +748,9 @@ func addLynk(name, owner string) error {
 // @param string lynksFilePath - The path to the lynks.txt file
 // @return error - An error can be produced when issues arise from trying to access
 // the lynks.txt file.
-func ParseLynks(lynksFilePath string) error {
-	lynks = nil // Resets files array
+func (c *Client) ParseLynks(lynksFilePath string) error {
+	parsed := []lynxutil.Lynk{}
+	defer func() { c.Lynks.Reset(parsed) }() // Replaces every lynk at once
 
 	lynksFile, err := os.Open(lynksFilePath)
 	if err != nil {
@@ -592,7 +769,7 @@ func ParseLynks(lynksFilePath string) error {
 		tempLynk.Synced = split[1]
 		tempLynk.Owner = split[2]
 
-		lynks = append(lynks, tempLynk) // Append the current file to the file array
+		parsed = append(parsed, tempLynk) // Append the current file to the file array
 		tempLynk = lynxutil.Lynk{}      // Empty the current file
 	}
 
@@ -601,38 +778,27 @@ func ParseLynks(lynksFilePath string) error {
 
 // DeleteLynk - This function deletes a Lynk based upon its name from the list of lynks
 // @param nameToDelete string - the lynk we want to remove
-func DeleteLynk(nameToDelete string, deleteLocal bool) {
-	i := 0
-	for i < len(lynks) {
-		if nameToDelete == lynks[i].Name {
-			// Removes this peer from swarm.info file
-			//fmt.Println("deleted lynk")
-			lynks = append(lynks[:i], lynks[i+1:]...)
-		}
-		i++
-	}
-	updateLynksFile()
+func (c *Client) DeleteLynk(nameToDelete string, deleteLocal bool) {
+	c.Lynks.Remove(nameToDelete)
+	c.updateLynksFile()
 
 	if deleteLocal {
-		os.RemoveAll(lynxutil.HomePath + nameToDelete)
+		os.RemoveAll(c.Home + nameToDelete)
+		os.RemoveAll(c.stagingDir(nameToDelete))
 	}
 }
 
 // Function which removes the lynks.txt file and creates a new one based on the current lynks array
 // @returns error - will produce an error if we cannot open the lynks.txt file.
-func updateLynksFile() error {
-	newLynks, err := os.Create(lynxutil.HomePath + "lynks.txt")
+func (c *Client) updateLynksFile() error {
+	newLynks, err := os.Create(c.Home + "lynks.txt")
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	i := 0
-	for i < len(lynks) {
-		newLynks.WriteString(lynks[i].Name + ":::" + lynks[i].Synced + ":::" +
-			lynks[i].Owner + "\n")
-
-		i++
+	for _, lynk := range c.Lynks.All() {
+		newLynks.WriteString(lynk.Name + ":::" + lynk.Synced + ":::" + lynk.Owner + "\n")
 	}
 
 	return newLynks.Close()
@@ -641,71 +807,356 @@ func updateLynksFile() error {
 // JoinLynk - Function which will allow a user to join an existing link by way of its meta.info file
 // @param metaPath string - the path to the meta.info file which will be used to find the
 // information about the lynk
-func JoinLynk(metaPath string) error {
-	metaFile, err := os.Open(metaPath)
+func (c *Client) JoinLynk(metaPath string) error {
+	m, err := metainfo.Read(metaPath)
 	if err != nil {
 		return err
 	}
-	lynkName := ""
-	owner := ""
-	scanner := bufio.NewScanner(metaFile)
-	tempPeer := lynxutil.Peer{}
+	lynkName := m.LynkName
+	owner := m.Owner
 
-	// Scan each line
-	for scanner.Scan() {
+	c.createJoin(lynkName, metaPath)
+	c.addLynk(lynkName, owner)
 
-		line := strings.TrimSpace(scanner.Text()) // Trim helps with errors in \n
-		split := strings.Split(line, ":::")
+	return c.UpdateLynk(lynkName) // Gets all of the files for the lynk over the network
+}
 
-		if split[0] == "announce" {
-			tempPeer.IP = split[metaValueIndex]
-		} else if split[0] == "port" {
-			tempPeer.Port = split[metaValueIndex]
-		} else if split[0] == "lynkName" {
-			lynkName = split[metaValueIndex]
-		} else if split[0] == "owner" {
-			owner = split[metaValueIndex]
-		}
+// JoinURI - Function which will allow a user to join a lynk by way of a lynx:// URI. The meta.info
+// is fetched from the lynk's tracker and checked against the hash in the URI before it is joined.
+// @param rawURI string - the lynx:// URI
+// @return error - An error can be produced if the URI is invalid, the tracker can't be reached, or
+// the meta.info it sends doesn't match the URI
+func (c *Client) JoinURI(rawURI string) error {
+	u, err := metainfo.ParseURI(rawURI)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.askTrackerForMeta(u.Tracker, u.LynkName)
+	if err != nil {
+		return err
+	}
+	m, err := u.Check(data)
+	if err != nil {
+		return err
+	}
+
+	metaPath := filepath.Join(os.TempDir(), m.LynkName+".meta.info")
+	if err = metainfo.Write(metaPath, m); err != nil {
+		return err
+	}
+	defer os.Remove(metaPath)
+
+	return c.JoinLynk(metaPath)
+}
+
+// AuthoriseKey - Lets the owner of a lynk allow another peer's key to change it. Peers only take
+// pushed meta.infos signed by the owner or a key the owner authorised. The meta.info still has to
+// be pushed for the other peers to learn about the new writer.
+// @param string lynkName - The name of the lynk
+// @param string fingerprint - The fingerprint of the key to authorise
+// @return error - An error is produced if we don't own the lynk or the fingerprint is invalid
+func (c *Client) AuthoriseKey(lynkName, fingerprint string) error {
+	fingerprint = strings.ToLower(fingerprint)
+	if raw, err := hex.DecodeString(fingerprint); err != nil || len(raw) != 20 {
+		return errors.New("Invalid Fingerprint " + fingerprint)
+	}
+
+	metaPath := c.Home + lynkName + "/meta.info"
+	defer c.LockMeta(lynkName)()
+	m, err := metainfo.Read(metaPath)
+	if err != nil {
+		return err
+	} else if !strings.EqualFold(m.OwnerKey, c.KeyFingerprint) {
+		return errors.New("Only The Owner Of " + lynkName + " Can Authorise Keys")
+	} else if m.Authorised(fingerprint) {
+		return nil
+	}
 
+	m.Writers = append(m.Writers, fingerprint)
+	if err = metainfo.Write(metaPath, m); err != nil {
+		return err
 	}
+	return c.ParseMetainfo(metaPath)
+}
 
-	createJoin(lynkName, metaPath)
-	addLynk(lynkName, owner)
+// AdoptOwner - Gives a lynk from before meta.infos were signed an owner key. No push is taken for
+// a lynk without one, so each peer adopts the key it trusts to own the lynk - the owner adopts
+// their own and pushes, and the others adopt the fingerprint the owner gave them.
+// @param string lynkName - The name of the lynk
+// @param string fingerprint - The fingerprint of the owner's key
+// @return error - An error is produced if the lynk already has another owner key or the
+// fingerprint is invalid
+func (c *Client) AdoptOwner(lynkName, fingerprint string) error {
+	fingerprint = strings.ToLower(fingerprint)
+	if raw, err := hex.DecodeString(fingerprint); err != nil || len(raw) != 20 {
+		return errors.New("Invalid Fingerprint " + fingerprint)
+	}
 
-	return UpdateLynk(lynkName) // Gets all of the files for the lynk over the network
+	metaPath := c.Home + lynkName + "/meta.info"
+	defer c.LockMeta(lynkName)()
+	m, err := metainfo.Read(metaPath)
+	if err != nil {
+		return err
+	} else if err = m.Adopt(fingerprint); err != nil {
+		return err
+	} else if err = metainfo.Write(metaPath, m); err != nil {
+		return err
+	}
+	return c.ParseMetainfo(metaPath)
 }
 
-// UpdateLynk - Function which will update the files of a Lynk with the current versions.
+// LynkURI - Function which creates the lynx:// URI others can use to join one of our lynks. The
+// URI pins the owner's key and the meta.info as it is right now - later meta.infos are taken if
+// the owner signed them, but a lynk without an owner key has to be shared again after it changes.
+// @param lynkName string - the name of the lynk
+// @return string - the lynx:// URI
+// @return error - An error can be produced if the lynk's meta.info can't be read
+func (c *Client) LynkURI(lynkName string) (string, error) {
+	metaPath := c.Home + lynkName + "/meta.info"
+	// If we are the lynk's tracker, joiners are sent its copy rather than ours
+	trackerPath := c.Home + lynkName + "/" + lynkName + "_Tracker/meta.info"
+	if _, err := os.Stat(trackerPath); err == nil {
+		metaPath = trackerPath
+	}
+	m, err := metainfo.Read(metaPath) // Makes sure the meta.info on disk is in the current format
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return "", err
+	}
+
+	u := metainfo.URI{Tracker: m.Announce, LynkName: m.LynkName, OwnerKey: m.OwnerKey,
+		MetaHash: metainfo.Hash(data)}
+	return u.String(), nil
+}
+
+// Asks a tracker for the meta.info of one of its lynks. The tracker adds us to the lynk's swarm.
+// @param string tracker - The tracker's IP:Port
+// @param string lynkName - The name of the lynk
+// @return []byte - The meta.info exactly as the tracker sent it
+// @return error - An error can be produced if the tracker can't be reached or sends nothing
+func (c *Client) askTrackerForMeta(tracker, lynkName string) ([]byte, error) {
+	conn, err := net.Dial("tcp", tracker)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Gives IP, ServerPort and our key So They Can Be Added To swarm.info
+	fmt.Fprintf(conn, "Meta_Request:"+lynxutil.GetIP()+":"+c.ServerPort+":"+lynkName+":"+
+		lynxutil.EncodeKey(c.PublicKey)+"\n")
+
+	// Tracker will close connection when finished
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	} else if len(data) == 0 {
+		return nil, errors.New("Tracker Does Not Have " + lynkName)
+	}
+	return data, nil
+}
+
+// JoinTorrent - Function which will allow a user to join a lynk by way of a BitTorrent .torrent
+// file. A meta.info is created from the .torrent and joined like any other.
+// @param torrentPath string - the path to the .torrent file
+// @return error - An error can be produced if the .torrent is invalid or the lynk can't be joined
+func (c *Client) JoinTorrent(torrentPath string) error {
+	torrentFile, err := os.Open(torrentPath)
+	if err != nil {
+		return err
+	}
+	m, err := torrent.Import(torrentFile)
+	torrentFile.Close()
+	if err != nil {
+		return err
+	}
+
+	metaPath := filepath.Join(os.TempDir(), m.LynkName+".meta.info")
+	if err = metainfo.Write(metaPath, m); err != nil {
+		return err
+	}
+	defer os.Remove(metaPath)
+
+	return c.JoinLynk(metaPath)
+}
+
+// ExportTorrent - Function which writes a BitTorrent .torrent describing one of our lynks.
+// @param lynkName string - the name of the lynk to export
+// @param torrentPath string - where the .torrent file is written
+// @return error - An error can be produced if the lynk's meta.info or files can't be read
+func (c *Client) ExportTorrent(lynkName, torrentPath string) error {
+	m, err := metainfo.Read(c.Home + lynkName + "/meta.info")
+	if err != nil {
+		return err
+	}
+
+	torrentFile, err := os.Create(torrentPath)
+	if err != nil {
+		return err
+	}
+
+	err = torrent.Export(c.Home+lynkName+"/", m, torrentFile)
+	if cErr := torrentFile.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(torrentPath)
+	}
+	return err
+}
+
+// UpdateLynk - Function which will update the files of a Lynk with the current versions. The
+// files wait in the lynk's download queue, which can be paused, resumed and cancelled.
 // @param lynkName string - the name of the Lynk we want to update
-func UpdateLynk(lynkName string) error {
+func (c *Client) UpdateLynk(lynkName string) error {
+	ctx, err := c.beginRun(lynkName)
+	if err != nil || ctx == nil {
+		return err // Paused, or the run that is already going picks up the changes
+	}
+
+	for ctx != nil {
+		err = c.updateFiles(ctx, lynkName)
+		ctx = c.endPass(ctx, lynkName)
+	}
+	if err == nil {
+		err = c.checkPieces(lynkName)
+	}
+	return err
+}
+
+// Checks the files of a lynk imported from a .torrent against the .torrent's SHA-1 pieces. Files
+// in a bad piece are removed so the next update fetches them again.
+// @param string lynkName - The name of the lynk
+// @return error - An error is produced if a file failed its check or the pieces are invalid
+func (c *Client) checkPieces(lynkName string) error {
+	m, err := metainfo.Read(c.Home + lynkName + "/meta.info")
+	if err != nil || m.Pieces == "" {
+		return nil // Lynks shared from Lynx are checked chunk by chunk as they download
+	}
+
+	bad, err := torrent.Verify(c.Home+lynkName+"/", m)
+	if err != nil {
+		return err
+	}
+	for _, relPath := range bad {
+		os.Remove(c.Home + lynkName + "/" + relPath)
+	}
+	c.forgetFiles(lynkName, bad) // They are fetched again, not deleted from the lynk
+	if len(bad) > 0 {
+		return errors.New(strings.Join(bad, ", ") + " Failed The Torrent's Piece Checks")
+	}
+	return nil
+}
+
+// Makes one pass over a lynk's files, downloading every one we don't have the current version of.
+// @param context.Context ctx - Cancelled when the lynk is paused or its downloads are cancelled
+// @param lynkName string - the name of the Lynk we want to update
+// @return error - An error is produced if the pass is stopped or a file failed to download
+func (c *Client) updateFiles(ctx context.Context, lynkName string) error {
 	// We actually get the files we need over the network.
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	var err error // Creates nil error
+	lynk, ok := c.Lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk " + lynkName + " Not Found")
+	}
+	current := c.upToDate(lynkName, lynk.Files) // Files we already have don't need to be fetched
+
+	// .lynxignore files are fetched first so the patterns they hold apply to the rest
+	ignoreFiles, rest := []string{}, []string{}
+	filter := c.loadFilter(lynkName)
 	for _, file := range lynk.Files {
-		err = getFile(file.Name, lynxutil.HomePath+lynkName+"/meta.info")
-		// If we fail to get the file the first time, we attempt again.
-		if err != nil {
-			for i := 0; i < lynxutil.ReconnAttempts; i++ {
-				err = getFile(file.Name, lynxutil.HomePath+lynkName+"/meta.info")
-			}
+		if current[file.Path] || filter.skips(file.Path, false) {
+			continue
+		} else if path.Base(file.Path) == IgnoreName {
+			ignoreFiles = append(ignoreFiles, file.Path)
+		} else {
+			rest = append(rest, file.Path)
+		}
+	}
+	err := c.downloadFiles(ctx, lynkName, ignoreFiles)
+
+	filter = c.loadFilter(lynkName)
+	pending := []string{}
+	for _, relPath := range rest {
+		if !filter.skips(relPath, false) {
+			pending = append(pending, relPath)
 		}
 	}
+	if restErr := c.downloadFiles(ctx, lynkName, pending); restErr != nil {
+		err = restErr
+	}
+
+	if ctx.Err() != nil {
+		return errors.New("Downloads Of " + lynkName + " Were Stopped")
+	}
+	return err
+}
+
+// Downloads files of a lynk through its queue, as many at once as the scheduler allows and the
+// ones with the highest priority first.
+// @param context.Context ctx - Cancelled when the lynk is paused or its downloads are cancelled
+// @param string lynkName - The name of the lynk
+// @param []string pending - The files' paths inside the lynk
+// @return error - An error is produced if a file failed to download
+func (c *Client) downloadFiles(ctx context.Context, lynkName string, pending []string) error {
+	c.setPending(lynkName, pending)
+
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var err error // Creates nil error
+	// Only waits for a slot when there is a file to use it, so an idle lynk doesn't hold one up
+	for ctx.Err() == nil && c.hasNext(lynkName) {
+		release, slotErr := c.downloads.acquire(ctx, lynkName)
+		if slotErr != nil {
+			break
+		}
+		relPath, fileCtx := c.nextFile(ctx, lynkName)
+		if fileCtx == nil {
+			release()
+			break // Nothing left to download
+		}
 
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer release()
+			fileErr := c.getFile(fileCtx, relPath, c.Home+lynkName+"/meta.info")
+			// If we fail to get the file the first time, we attempt again.
+			i := 0
+			for fileErr != nil && fileCtx.Err() == nil && i < lynxutil.ReconnAttempts {
+				fileErr = c.getFile(fileCtx, relPath, c.Home+lynkName+"/meta.info")
+				i++
+			}
+			c.finishFile(lynkName, relPath, fileErr == nil)
+
+			// A file that was paused isn't a failure - the rest of the lynk carries on
+			if fileErr != nil && (fileCtx.Err() == nil || ctx.Err() != nil) {
+				errMu.Lock()
+				err = fileErr
+				errMu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
 	return err
 }
 
 // Function which creates the directory for a newly joined lynk.
 // @params name string - the name of the new lynk
 // @params oldMetaPath string - the name of the metaPath we are using to create our new metaPath
-func createJoin(name, oldMetaPath string) error {
-	tDir, err := os.Stat(lynxutil.HomePath + name)
+func (c *Client) createJoin(name, oldMetaPath string) error {
+	tDir, err := os.Stat(c.Home + name)
 	// Checks to see if the directory exists so we don't overwrite
 	if err == nil && tDir.IsDir() {
 		fmt.Println("ERROR!" + tDir.Name() + " Already Exists")
 		return errors.New("Directory " + name + " Already Exists")
 	}
 
-	newLynkDir := lynxutil.HomePath + name
+	newLynkDir := c.Home + name
 	os.Mkdir(newLynkDir, 0755)
 
 	err = lynxutil.FileCopy(oldMetaPath, newLynkDir+"/meta.info")
@@ -717,86 +1168,103 @@ func createJoin(name, oldMetaPath string) error {
 	return nil // Everything was fine if we reached this point
 }
 
-// Function init runs as soon as this class is imported and allows us to create an array of Lynks.
-func init() {
-	ParseLynks(lynxutil.HomePath + "lynks.txt")
-	genLynks()
+// LoadDefault - Returns the client of the default node, creating both the first time it is
+// called.
+// @return *Client - The default client
+// @return error - An error is produced if the default node can't be created
+func LoadDefault() (*Client, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if Default != nil {
+		return Default, nil
+	}
+
+	node, err := lynxutil.LoadDefault()
+	if err != nil {
+		return nil, err
+	}
+	Default = New(node)
+	return Default, nil
 }
 
 // Helper function that generates all the data for our lynks array by parsing each corresponding
 // meta.info file.
-func genLynks() {
-	i := 0
-	for i < len(lynks) {
-		ParseMetainfo(lynxutil.HomePath + lynks[i].Name + "/meta.info")
-		i++
+func (c *Client) genLynks() {
+	for _, name := range c.Lynks.Names() {
+		c.ParseMetainfo(c.Home + name + "/meta.info")
 	}
 }
 
 // GetLynkName - Helper function that returns our Lynk name if we pass in its metaPath.
 // @param string metaPath - The meta.info path associated with the lynk we're interested in
 // @returns string - The lynk name
-func GetLynkName(metaPath string) string {
-	return strings.TrimSuffix(strings.TrimPrefix(metaPath, lynxutil.HomePath), "/meta.info")
+func (c *Client) GetLynkName(metaPath string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(metaPath, c.Home), "/meta.info")
 }
 
 // GetLynks - Simply returns our current lynks array.
-// @returns - The current lynks array.
-func GetLynks() []lynxutil.Lynk {
-	return lynks
+// @returns - A copy of the current lynks array.
+func (c *Client) GetLynks() []lynxutil.Lynk {
+	return c.Lynks.All()
+}
+
+// GetLynk - Returns one of our current lynks.
+// @param string lynkName - The name of the lynk
+// @returns lynxutil.Lynk - A copy of the lynk
+// @returns bool - False if we don't have the lynk
+func (c *Client) GetLynk(lynkName string) (lynxutil.Lynk, bool) {
+	return c.Lynks.Get(lynkName)
+}
+
+// WatchLynks - Reports every change to our lynks, such as a push updating a lynk's files.
+// @returns <-chan lynxutil.LynkEvent - Where the changes are sent
+// @returns func() - Stops watching - it must be called exactly once
+func (c *Client) WatchLynks() (<-chan lynxutil.LynkEvent, func()) {
+	return c.Lynks.Watch()
 }
 
 // GetLynksLen - Returns the size of our lynks array.
 // @returns - The current size of our lynks array.
-func GetLynksLen() int {
-	return len(lynks)
+func (c *Client) GetLynksLen() int {
+	return c.Lynks.Len()
 }
 
 // PopulateFilesAndSize - Fills Our Lynks Array With File And Size Information
-func PopulateFilesAndSize() {
-	i := 0
-	for i < len(lynks) {
-		files := lynks[i].Files
-		j := 0
-		if len(lynks[i].FileNames) == 0 && len(lynks[i].FileSize) == 0 {
-			for j < len(files) {
-				lynks[i].FileNames = append(lynks[i].FileNames, files[j].Name)
-				lynks[i].FileSize = append(lynks[i].FileSize, files[j].Length)
-				j++
+func (c *Client) PopulateFilesAndSize() {
+	for _, name := range c.Lynks.Names() {
+		c.Lynks.Update(name, func(lynk *lynxutil.Lynk) {
+			files := lynk.Files
+			j := 0
+			if len(lynk.FileNames) == 0 && len(lynk.FileSize) == 0 {
+				for j < len(files) {
+					lynk.FileNames = append(lynk.FileNames, files[j].Path)
+					lynk.FileSize = append(lynk.FileSize, files[j].Length)
+					j++
+				}
 			}
-		}
-		i++
+		})
 	}
-
-}
-
-// IsDownloading - Returns whether or not the client associated the specified lynk is downloading
-// @param lynkName - the name of the lynk
-// @returns - Returns whether or not the client associated the specified lynk is downloading
-func IsDownloading(lynkName string) bool {
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	return lynk.DLing
-}
-
-// StopDownload - Sets a boolean to stop the lynk from downloading
-func StopDownload(lynkName string) {
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	lynk.DLing = false
 }
 
 // GetFileTableIndex - Gets the file table index
-func GetFileTableIndex() int {
-	return fileTableIndex
+func (c *Client) GetFileTableIndex() int {
+	c.fileTableMu.Lock()
+	defer c.fileTableMu.Unlock()
+	return c.fileTableIndex
 }
 
 // SetFileTableIndex - Sets the file table index
 // @param index - the index of the file in the GUI Table
-func SetFileTableIndex(index int) {
-	fileTableIndex = index
+func (c *Client) SetFileTableIndex(index int) {
+	c.fileTableMu.Lock()
+	defer c.fileTableMu.Unlock()
+	c.fileTableIndex = index
 }
 
 // GetLynkNameFromIndex - Gets Lynk name based on inde
 // @param index - the index of the file in the GUI Table
-func GetLynkNameFromIndex(index int) string {
-	return lynks[index].Name
+// @returns string - The lynk's name, or "" if there is no lynk at that index
+func (c *Client) GetLynkNameFromIndex(index int) string {
+	lynk, _ := c.Lynks.At(index)
+	return lynk.Name
 }
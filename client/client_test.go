@@ -6,18 +6,37 @@ package client
 
 import (
 	"capstone/lynxutil"
+	"capstone/metainfo"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
 	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Count of the # of successful tests.
 var successful = 0
 
 // Total # of the tests.
-const total = 19
+const total = 57
+
+// The passphrase protecting the keys of the nodes the tests create
+var passphrase = []byte("lynx tests")
+
+// Loads the default node the tests run on, under LYNX_PASSPHRASE or else the test passphrase.
+func init() {
+	if os.Getenv(lynxutil.PassphraseEnv) == "" {
+		os.Setenv(lynxutil.PassphraseEnv, string(passphrase))
+	}
+	LoadDefault()
+}
 
 // Gets user's home directory
 var cU, _ = user.Current()
@@ -28,6 +47,21 @@ var hPath = cU.HomeDir + "/Lynx/"
 // Uses homePath and our Tests Lynk to create mPath
 var mPath = hPath + "Tests/meta.info"
 
+// Creates a client of a new node in a temporary directory, so a test can't touch the user's lynks.
+// @param *testing.T t - The wrapper for the test
+// @return *Client - The client - the test removes its directory when it is done
+func newTestClient(t *testing.T) *Client {
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := lynxutil.NewNode(home, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(node)
+}
+
 // Unit tests for our FileCopy function.
 // @param *testing.T t - The wrapper for the test
 func TestFileCopy(t *testing.T) {
@@ -76,9 +110,9 @@ func TestFileCopy(t *testing.T) {
 func TestAddToMetainfo(t *testing.T) {
 	fmt.Println("\n----------------TestAddToMetainfo----------------")
 
-	ParseMetainfo(mPath)
+	Default.ParseMetainfo(mPath)
 	hasTest := false
-	tLynk := lynxutil.GetLynk(lynks, "Tests")
+	tLynk, _ := Default.Lynks.Get("Tests")
 
 	i := 0
 	for i < len(tLynk.Files) {
@@ -91,7 +125,7 @@ func TestAddToMetainfo(t *testing.T) {
 	}
 
 	// add test.txt to the metainfo
-	result := AddToMetainfo("test.txt", mPath)
+	result := Default.AddToMetainfo("test.txt", mPath)
 
 	if result != nil && !hasTest {
 		t.Error("Test failed, expected no errors. Got ", result)
@@ -100,7 +134,7 @@ func TestAddToMetainfo(t *testing.T) {
 		successful++
 	}
 
-	ParseMetainfo(mPath)
+	Default.ParseMetainfo(mPath)
 
 	// check that test.txt is in the File struct list
 	i = 0
@@ -118,7 +152,7 @@ func TestAddToMetainfo(t *testing.T) {
 		successful++
 	}
 
-	result = AddToMetainfo("test.txt", mPath)
+	result = Default.AddToMetainfo("test.txt", mPath)
 
 	if result == nil {
 		t.Error("Test failed, expected failure due to duplicates. Got ", result)
@@ -133,7 +167,7 @@ func TestAddToMetainfo(t *testing.T) {
 func TestParseMetainfo(t *testing.T) {
 	fmt.Println("\n----------------TestParseMetainfo----------------")
 
-	result := ParseMetainfo("fake")
+	result := Default.ParseMetainfo("fake")
 
 	if result == nil {
 		t.Error("Test failed, expected failure due non-existent file. Got ", result)
@@ -142,7 +176,7 @@ func TestParseMetainfo(t *testing.T) {
 		successful++
 	}
 
-	result = ParseMetainfo("test.txt")
+	result = Default.ParseMetainfo("test.txt")
 
 	if result == nil {
 		t.Error("Test failed, expected failure due incorrect file. Got ", result)
@@ -151,7 +185,7 @@ func TestParseMetainfo(t *testing.T) {
 		successful++
 	}
 
-	result = ParseMetainfo(mPath)
+	result = Default.ParseMetainfo(mPath)
 
 	if result != nil {
 		t.Error("Test failed, expected no errors. Got ", result)
@@ -166,9 +200,9 @@ func TestParseMetainfo(t *testing.T) {
 func TestUpdateMetainfo(t *testing.T) {
 	fmt.Println("\n----------------TestUpdateMetainfo----------------")
 
-	ParseMetainfo(mPath)
+	Default.ParseMetainfo(mPath)
 
-	result := UpdateMetainfo(mPath)
+	result := Default.UpdateMetainfo(mPath)
 
 	if result != nil {
 		t.Error("Test failed, expected no errors. Got ", result)
@@ -185,11 +219,11 @@ func TestDeleteFile(t *testing.T) {
 	fmt.Println("\n----------------TestDeleteFiley----------------")
 	failed := false
 
-	ParseMetainfo(mPath)
-	lynkName := GetLynkName(mPath)
-	DeleteFile("test.txt", lynkName)
+	Default.ParseMetainfo(mPath)
+	lynkName := Default.GetLynkName(mPath)
+	Default.DeleteFile("test.txt", lynkName)
 
-	tLynk := lynxutil.GetLynk(lynks, lynkName)
+	tLynk, _ := Default.Lynks.Get(lynkName)
 
 	i := 0
 	for i < len(tLynk.Files) {
@@ -207,7 +241,7 @@ func TestDeleteFile(t *testing.T) {
 		failed = false
 	}
 
-	DeleteFile("test11.txt", lynkName)
+	Default.DeleteFile("test11.txt", lynkName)
 
 	i = 0
 	for i < len(tLynk.Files) {
@@ -229,7 +263,7 @@ func TestDeleteFile(t *testing.T) {
 func TestGetFile(t *testing.T) {
 	fmt.Println("\n----------------TestGetFile----------------")
 
-	err := getFile("test.txt", mPath)
+	err := Default.getFile(context.Background(), "test.txt", mPath)
 
 	if err != nil {
 		t.Error(err.Error())
@@ -238,7 +272,7 @@ func TestGetFile(t *testing.T) {
 		successful++
 	}
 
-	err = getFile("non-existent.txt", mPath)
+	err = Default.getFile(context.Background(), "non-existent.txt", mPath)
 
 	if err != nil {
 		fmt.Println("Successfully Produced Non-Existent File Error")
@@ -254,7 +288,7 @@ func TestGetFile(t *testing.T) {
 func TestHaveFile(t *testing.T) {
 	fmt.Println("\n----------------TestHaveFile----------------")
 
-	result := HaveFile("Tests/test.txt")
+	result := Default.HaveFile("Tests/test.txt")
 
 	if result {
 		fmt.Println("Successfully Found 'test.txt'")
@@ -263,7 +297,7 @@ func TestHaveFile(t *testing.T) {
 		t.Error("Could Not Find 'test.txt'")
 	}
 
-	result = HaveFile("Tests/non-existent.txt")
+	result = Default.HaveFile("Tests/non-existent.txt")
 
 	if !result {
 		fmt.Println("Successfully Produced False For Non-Existent File")
@@ -274,12 +308,36 @@ func TestHaveFile(t *testing.T) {
 
 }
 
+// Unit tests for SplitFilePath function
+// @param *testing.T t - The wrapper for the test
+func TestSplitFilePath(t *testing.T) {
+	fmt.Println("\n----------------TestSplitFilePath----------------")
+
+	lynkName, relPath, err := SplitFilePath("Tests/docs/drafts/test.txt")
+
+	if err != nil || lynkName != "Tests" || relPath != "docs/drafts/test.txt" {
+		t.Error("Test failed, expected 'Tests' and 'docs/drafts/test.txt'. Got ", lynkName, relPath)
+	} else {
+		fmt.Println("Successfully Split Nested Path")
+		successful++
+	}
+
+	_, _, err = SplitFilePath("Tests/../../.ssh/id_rsa")
+
+	if err == nil {
+		t.Error("Test failed, expected a path leaving the lynk to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Path Outside The Lynk")
+		successful++
+	}
+}
+
 // Unit tests for GetTrackerIP function
 // @param *testing.T t - The wrapper for the test
 func TestGetTracker(t *testing.T) {
 	fmt.Println("\n----------------TestGetTracker----------------")
 
-	ip := GetTracker(mPath) // Should be 127.0.0.1 during testing
+	ip := Default.GetTracker(mPath) // Should be 127.0.0.1 during testing
 	content, _ := ioutil.ReadFile(mPath)
 	s := string(content)
 
@@ -291,14 +349,683 @@ func TestGetTracker(t *testing.T) {
 	}
 }
 
+// Unit tests for the chunkPicker used when downloading from the swarm
+// @param *testing.T t - The wrapper for the test
+func TestChunkPicker(t *testing.T) {
+	fmt.Println("\n----------------TestChunkPicker----------------")
+
+	// Chunk 2 is only held by one peer so it should be picked first
+	everything := []bool{true, true, true}
+	picker := newChunkPicker(3, [][]bool{everything, {true, true, false}, nil})
+	c1, c2 := &net.TCPConn{}, &net.TCPConn{}
+
+	first := picker.next(everything, c1)
+	if first != 2 {
+		t.Error("Test failed, expected the rarest chunk 2. Got ", first)
+	} else {
+		fmt.Println("Successfully Picked Rarest Chunk")
+		successful++
+	}
+
+	// Requests the remaining chunks - the next request must then be an endgame duplicate
+	picker.next(everything, c1)
+	picker.next(everything, c1)
+	picker.complete(0, c1)
+	picker.complete(1, c1)
+	dup := picker.next(everything, c2)
+	if dup != 2 || !picker.complete(2, c2) || picker.complete(2, c1) || !picker.isDone() {
+		t.Error("Test failed, expected an endgame duplicate of chunk 2. Got ", dup)
+	} else {
+		fmt.Println("Successfully Entered Endgame Mode")
+		successful++
+	}
+}
+
+// Unit tests for saving and loading the bitmap of a staged download
+// @param *testing.T t - The wrapper for the test
+func TestBitmap(t *testing.T) {
+	fmt.Println("\n----------------TestBitmap----------------")
+
+	bitmapPath := os.TempDir() + "/lynx_test.bitmap"
+	defer os.Remove(bitmapPath)
+
+	err := saveBitmap(bitmapPath, "abc", []bool{true, false, true})
+	have := loadBitmap(bitmapPath, "abc", 3)
+
+	if err != nil || bitsString(have) != "101" {
+		t.Error("Test failed, expected to resume with chunks 101. Got ", bitsString(have), err)
+	} else {
+		fmt.Println("Successfully Resumed From Bitmap")
+		successful++
+	}
+
+	// A bitmap written for a different version of the file must not be resumed from
+	have = loadBitmap(bitmapPath, "def", 3)
+
+	if hasAny(have) {
+		t.Error("Test failed, expected an empty bitmap for a new version. Got ", bitsString(have))
+	} else {
+		fmt.Println("Successfully Ignored Stale Bitmap")
+		successful++
+	}
+}
+
+// Unit tests for our GetBitfield function - only the version in the meta.info is served whole
+// @param *testing.T t - The wrapper for the test
+func TestGetBitfield(t *testing.T) {
+	fmt.Println("\n----------------TestGetBitfield----------------")
+
+	c := newTestClient(t)
+	defer os.RemoveAll(c.Home)
+
+	os.MkdirAll(c.Home+"Bits", 0755)
+	ioutil.WriteFile(c.Home+"Bits/a.txt", []byte(strings.Repeat("a", 1000)), 0644)
+	ioutil.WriteFile(c.Home+"lynks.txt", nil, 0644)
+	c.CreateMeta("Bits")
+	whole := c.GetBitfield("Bits/a.txt")
+
+	// A copy edited here is the same size but not the version peers are asking for
+	ioutil.WriteFile(c.Home+"Bits/a.txt", []byte(strings.Repeat("b", 1000)), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(c.Home+"Bits/a.txt", later, later)
+	edited := c.GetBitfield("Bits/a.txt")
+
+	if whole != "1" || edited != "0" {
+		t.Error("Test failed, expected only the meta.info's version to be served. Got ", whole,
+			edited)
+	} else {
+		fmt.Println("Successfully Served Only The Current Version")
+		successful++
+	}
+}
+
+// Unit tests for deciding whether a deleted file was changed locally after it was deleted
+// @param *testing.T t - The wrapper for the test
+func TestChangedAfter(t *testing.T) {
+	fmt.Println("\n----------------TestChangedAfter----------------")
+
+	localPath := os.TempDir() + "/lynx_test_tombstone.txt"
+	defer os.Remove(localPath)
+	ioutil.WriteFile(localPath, []byte("deleted version"), 0644)
+	_, hash, _ := lynxutil.HashFile(localPath, lynxutil.ChunkLength)
+	info, _ := os.Stat(localPath)
+
+	deletedBefore := lynxutil.Tombstone{Hash: hash, Deleted: info.ModTime().Unix() - 60}
+	if changedAfter(localPath, info, deletedBefore) {
+		t.Error("Test failed, expected the deleted version itself to be removed.")
+	} else {
+		fmt.Println("Successfully Removed Unchanged File")
+		successful++
+	}
+
+	deletedBefore.Hash = "some other version"
+	if !changedAfter(localPath, info, deletedBefore) {
+		t.Error("Test failed, expected a file changed after the deletion to be kept.")
+	} else {
+		fmt.Println("Successfully Kept File Changed After Deletion")
+		successful++
+	}
+}
+
+// Unit tests for the change detection index
+// @param *testing.T t - The wrapper for the test
+func TestIndexLookup(t *testing.T) {
+	fmt.Println("\n----------------TestIndexLookup----------------")
+
+	path := os.TempDir() + "/lynx_test_index.txt"
+	defer os.Remove(path)
+	ioutil.WriteFile(path, []byte("first version"), 0644)
+	idx := &lynkIndex{Files: map[string]indexEntry{}}
+
+	info, _ := os.Stat(path)
+	entry, _ := idx.lookup("test.txt", path, info)
+	entry.Hash = "cached"
+	idx.Files["test.txt"] = entry
+	entry, err := idx.lookup("test.txt", path, info)
+
+	if err != nil || entry.Hash != "cached" {
+		t.Error("Test failed, expected an unchanged file not to be hashed again. Got ", entry.Hash, err)
+	} else {
+		fmt.Println("Successfully Used Cached Hash")
+		successful++
+	}
+
+	ioutil.WriteFile(path, []byte("second version!"), 0644)
+	info, _ = os.Stat(path)
+	entry, err = idx.lookup("test.txt", path, info)
+	_, hash, _ := lynxutil.HashFile(path, lynxutil.ChunkLength)
+
+	if err != nil || entry.Hash != hash {
+		t.Error("Test failed, expected a changed file to be hashed again. Got ", entry.Hash, err)
+	} else {
+		fmt.Println("Successfully Detected Changed Contents")
+		successful++
+	}
+}
+
+// Unit tests for our RefreshMeta function - only the owner and writers record changes
+// @param *testing.T t - The wrapper for the test
+func TestRefreshMeta(t *testing.T) {
+	fmt.Println("\n----------------TestRefreshMeta----------------")
+
+	c := newTestClient(t)
+	defer os.RemoveAll(c.Home)
+
+	metaPath := c.Home + "Tests/meta.info"
+	os.MkdirAll(c.Home+"Tests", 0755)
+	ioutil.WriteFile(c.Home+"Tests/test.txt", []byte("edited here"), 0644)
+	m := metainfo.New("Tests", "Tester", "127.0.0.1:9000")
+	m.OwnerKey = strings.Repeat("ab", 20)
+	m.Add(lynxutil.File{Path: "test.txt", Hash: "theirs", Versions: map[string]int{"a": 1}})
+	metainfo.Write(metaPath, m)
+
+	changed, err := c.RefreshMeta("Tests")
+	m, _ = metainfo.Read(metaPath)
+	edits := c.UnsharedEdits("Tests")
+	if changed || err == nil || m.Files[0].Hash != "theirs" || len(edits) != 1 {
+		t.Error("Test failed, expected a non-writer's edit to be reported, not recorded. Got ",
+			changed, err, m.Files[0].Hash, edits)
+	} else {
+		fmt.Println("Successfully Reported Unshared Edit")
+		successful++
+	}
+
+	m.Writers = []string{c.KeyFingerprint}
+	metainfo.Write(metaPath, m)
+	changed, err = c.RefreshMeta("Tests")
+	m, _ = metainfo.Read(metaPath)
+	if !changed || err != nil || m.Files[0].Hash == "theirs" || m.Files[0].Versions[c.PeerID] != 1 {
+		t.Error("Test failed, expected a writer's edit to be recorded. Got ", changed, err,
+			m.Files[0])
+	} else {
+		fmt.Println("Successfully Recorded Writer's Edit")
+		successful++
+	}
+
+	// A file deleted here is tombstoned - one we never had and files still being written aren't
+	m.Add(lynxutil.File{Path: "pending.txt", Hash: "theirs"})
+	metainfo.Write(metaPath, m)
+	os.Remove(c.Home + "Tests/test.txt")
+	ioutil.WriteFile(c.Home+"Tests/meta.info.tmp", []byte("half written"), 0644)
+	ioutil.WriteFile(c.Home+"Tests/download.part", []byte("half written"), 0644)
+	changed, err = c.RefreshMeta("Tests")
+	m, _ = metainfo.Read(metaPath)
+	if !changed || err != nil || len(m.Files) != 1 || m.Files[0].Path != "pending.txt" ||
+		len(m.Tombstones) != 1 || m.Tombstones[0].Path != "test.txt" {
+		t.Error("Test failed, expected only the deleted file to be tombstoned. Got ", changed, err,
+			m.Files, m.Tombstones)
+	} else {
+		fmt.Println("Successfully Tombstoned Deleted File")
+		successful++
+	}
+}
+
+// Unit tests for refreshing a meta.info while a push is merged into it - neither change is lost
+// @param *testing.T t - The wrapper for the test
+func TestRefreshDuringPush(t *testing.T) {
+	fmt.Println("\n----------------TestRefreshDuringPush----------------")
+
+	c := newTestClient(t)
+	defer os.RemoveAll(c.Home)
+
+	metaPath := c.Home + "Tests/meta.info"
+	os.MkdirAll(c.Home+"Tests", 0755)
+	m := metainfo.New("Tests", "Tester", "127.0.0.1:9000")
+	m.OwnerKey = c.KeyFingerprint
+	metainfo.Write(metaPath, m)
+
+	// Enough files that refreshing takes a while, so the push lands in the middle of it
+	for i := 0; i < 200; i++ {
+		ioutil.WriteFile(c.Home+"Tests/file"+strconv.Itoa(i)+".txt", make([]byte, 1<<14), 0644)
+	}
+	c.RefreshMeta("Tests")
+
+	const rounds = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*rounds)
+	for i := 0; i < rounds; i++ {
+		round := strconv.Itoa(i)
+		ioutil.WriteFile(c.Home+"Tests/mine"+round+".txt", []byte("edited here"), 0644)
+		incoming, _ := metainfo.Read(metaPath)
+		incoming.Add(lynxutil.File{Path: "theirs" + round + ".txt", Hash: "theirs",
+			Versions: map[string]int{"peer": 1}})
+		incoming.Sign(c.Node)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := c.RefreshMeta("Tests"); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.MergePush("Tests", incoming); err != nil {
+				errs <- err
+			}
+		}()
+		wg.Wait()
+	}
+	close(errs)
+
+	m, _ = metainfo.Read(metaPath)
+	lost := []string{}
+	for i := 0; i < rounds; i++ {
+		for _, name := range []string{"mine", "theirs"} {
+			if lynxutil.GetFile(m.Files, name+strconv.Itoa(i)+".txt") == nil {
+				lost = append(lost, name+strconv.Itoa(i)+".txt")
+			}
+		}
+	}
+	for err := range errs {
+		lost = append(lost, err.Error())
+	}
+	if len(lost) != 0 || m.Sequence != rounds {
+		t.Error("Test failed, expected every edit and push to be kept. Got ", lost, m.Sequence)
+	} else {
+		fmt.Println("Successfully Kept Edits And Pushes")
+		successful++
+	}
+}
+
+// Unit tests for keeping our copies of conflicting files
+// @param *testing.T t - The wrapper for the test
+func TestKeepConflicts(t *testing.T) {
+	fmt.Println("\n----------------TestKeepConflicts----------------")
+
+	c := newTestClient(t)
+	defer os.RemoveAll(c.Home)
+
+	os.MkdirAll(c.Home+"Tests", 0755)
+	ioutil.WriteFile(c.Home+"Tests/test.txt", []byte("ours"), 0644)
+	err := c.KeepConflicts("Tests", []metainfo.Conflict{{Path: "test.txt", LocalHash: "ours"},
+		{Path: "missing.txt", LocalHash: "ours"}})
+	matches, _ := filepath.Glob(c.Home + "Tests/test.txt.conflict-" + c.PeerID + "-*")
+	if _, statErr := os.Stat(c.Home + "Tests/test.txt"); err != nil || len(matches) != 1 ||
+		!os.IsNotExist(statErr) {
+		t.Error("Test failed, expected our copy to be moved aside. Got ", err, matches)
+	} else {
+		fmt.Println("Successfully Kept Conflicting Copy")
+		successful++
+	}
+}
+
+// Unit tests for keeping and restoring old versions of a file
+// @param *testing.T t - The wrapper for the test
+func TestVersions(t *testing.T) {
+	fmt.Println("\n----------------TestVersions----------------")
+
+	c := newTestClient(t)
+	defer os.RemoveAll(c.Home)
+
+	path := c.Home + "Tests/test.txt"
+	os.MkdirAll(c.Home+"Tests", 0755)
+	ioutil.WriteFile(path, []byte("first version"), 0644)
+	c.saveVersion("Tests", "test.txt")
+	ioutil.WriteFile(path, []byte("second version"), 0644)
+	c.saveVersion("Tests", "test.txt")
+
+	versions, err := c.ListVersions("Tests", "test.txt")
+	if err != nil || len(versions) != 2 || versions[0].Length != int64(len("second version")) {
+		t.Error("Test failed, expected two versions, newest first. Got ", versions, err)
+	} else {
+		fmt.Println("Successfully Listed Versions")
+		successful++
+	}
+
+	ioutil.WriteFile(path, []byte("third version"), 0644)
+	err = c.RestoreVersion("Tests", "test.txt", versions[1].ID)
+	data, _ := ioutil.ReadFile(path)
+	if err != nil || string(data) != "first version" {
+		t.Error("Test failed, expected the first version to be restored. Got ", string(data), err)
+	} else {
+		fmt.Println("Successfully Restored Version")
+		successful++
+	}
+
+	versions, _ = c.ListVersions("Tests", "test.txt")
+	if len(versions) != 3 {
+		t.Error("Test failed, expected the replaced copy to be kept. Got ", len(versions))
+	} else {
+		fmt.Println("Successfully Kept Replaced Copy")
+		successful++
+	}
+
+	// A file a peer deleted goes to the versions store and is listed as deleted
+	ioutil.WriteFile(c.Home+"Tests/gone.txt", []byte("deleted by a peer"), 0644)
+	_, hash, _ := lynxutil.HashFile(c.Home+"Tests/gone.txt", lynxutil.ChunkLength)
+	c.Lynks.Add(lynxutil.Lynk{Name: "Tests", Files: []lynxutil.File{{Path: "test.txt"}},
+		Tombstones: []lynxutil.Tombstone{{Path: "gone.txt", Hash: hash,
+			Deleted: time.Now().Unix() + 60}}})
+	err = c.ApplyTombstones("Tests")
+	versions, _ = c.ListVersions("Tests", "gone.txt")
+	deleted := c.DeletedFiles("Tests")
+	if _, statErr := os.Stat(c.Home + "Tests/gone.txt"); err != nil || statErr == nil ||
+		len(versions) != 1 || len(deleted) != 1 || deleted[0] != "gone.txt" {
+		t.Error("Test failed, expected the deleted file among the versions. Got ", err, versions,
+			deleted)
+	} else {
+		fmt.Println("Successfully Kept Deleted File As Version")
+		successful++
+	}
+
+	expired := c.versionsDir("Tests", "old/old.txt")
+	os.MkdirAll(expired, 0755)
+	ioutil.WriteFile(expired+"1-abcd", []byte("long gone"), 0644)
+	err = c.PruneVersions()
+	versions, _ = c.ListVersions("Tests", "test.txt")
+	if _, statErr := os.Stat(c.versionsDir("Tests", "old")); err != nil ||
+		!os.IsNotExist(statErr) || len(versions) != 3 {
+		t.Error("Test failed, expected only the expired version to be pruned. Got ", err,
+			statErr, len(versions))
+	} else {
+		fmt.Println("Successfully Pruned Every Lynk")
+		successful++
+	}
+}
+
+// Unit tests for .lynxignore patterns and subscriptions
+// @param *testing.T t - The wrapper for the test
+func TestSyncFilter(t *testing.T) {
+	fmt.Println("\n----------------TestSyncFilter----------------")
+
+	c := newTestClient(t)
+	defer os.RemoveAll(c.Home)
+
+	os.MkdirAll(c.Home+"Tests/docs", 0755)
+	ioutil.WriteFile(c.Home+"Tests/"+IgnoreName,
+		[]byte("# Editor files\n*.swp\nnode_modules/\n*.log\n!keep.log\n"), 0644)
+	ioutil.WriteFile(c.Home+"Tests/docs/"+IgnoreName, []byte("/draft.md\n"), 0644)
+	filter := c.loadFilter("Tests")
+
+	if !filter.skips("a.swp", false) || !filter.skips("node_modules/x/index.js", false) ||
+		!filter.skips("docs/draft.md", false) || !filter.skips("docs/old/b.log", false) {
+		t.Error("Test failed, expected ignored paths to be skipped.")
+	} else {
+		fmt.Println("Successfully Skipped Ignored Paths")
+		successful++
+	}
+
+	if filter.skips("draft.md", false) || filter.skips("docs/old/draft.md", false) ||
+		filter.skips("keep.log", false) || filter.skips("node_modules", false) {
+		t.Error("Test failed, expected other paths to be synced.")
+	} else {
+		fmt.Println("Successfully Synced Other Paths")
+		successful++
+	}
+
+	c.Subscribe("Tests", []string{"docs/specs"})
+	filter = c.loadFilter("Tests")
+	if !filter.skips("src/main.go", false) || filter.skips("docs", true) ||
+		filter.skips("docs/specs/a.md", false) {
+		t.Error("Test failed, expected only subscribed folders to be synced. Got ",
+			c.Subscriptions("Tests"))
+	} else {
+		fmt.Println("Successfully Synced Subscribed Folders")
+		successful++
+	}
+}
+
+// Unit tests for pausing, resuming and cancelling a lynk's download queue
+// @param *testing.T t - The wrapper for the test
+func TestQueue(t *testing.T) {
+	fmt.Println("\n----------------TestQueue----------------")
+
+	ctx, _ := Default.beginRun("QueueTest")
+	if again, err := Default.beginRun("QueueTest"); again != nil || err != nil {
+		t.Error("Test failed, expected a second run to be folded into the first. Got ", err)
+	} else {
+		fmt.Println("Successfully Folded Second Run")
+		successful++
+	}
+
+	Default.setPending("QueueTest", []string{"a.txt", "b.txt", "c.txt"})
+	Default.PauseFile("QueueTest", "b.txt")
+	Default.SetPriority("QueueTest", "c.txt", PriorityHigh)
+	relPath, fileCtx := Default.nextFile(ctx, "QueueTest")
+	state := Default.GetQueueState("QueueTest")
+	Default.PauseFile("QueueTest", relPath)
+
+	if relPath != "c.txt" || state.State != QueueDownloading || len(state.Active) != 1 ||
+		len(state.Pending) != 2 || fileCtx.Err() == nil {
+		t.Error("Test failed, expected the high priority file to be downloaded first. Got ",
+			relPath, state)
+	} else {
+		fmt.Println("Successfully Picked Priority File")
+		successful++
+	}
+	Default.finishFile("QueueTest", relPath, false)
+
+	// Priorities are saved, and can be lowered as well as raised
+	Default.SetPriority("QueueTest", "a.txt", PriorityLow)
+	Default.SetPriority("QueueTest", "a.txt", PriorityNormal)
+	reloaded := New(Default.Node).GetQueueState("QueueTest").Priorities
+	if len(reloaded) != 1 || reloaded["c.txt"] != PriorityHigh {
+		t.Error("Test failed, expected the priorities to be saved. Got ", reloaded)
+	} else {
+		fmt.Println("Successfully Saved Priorities")
+		successful++
+	}
+
+	if relPath, _ = Default.nextFile(ctx, "QueueTest"); relPath != "a.txt" {
+		t.Error("Test failed, expected paused files to be skipped. Got ", relPath)
+	} else {
+		fmt.Println("Successfully Skipped Paused File")
+		successful++
+	}
+	Default.finishFile("QueueTest", relPath, true)
+
+	if Default.endPass(ctx, "QueueTest") != ctx || Default.endPass(ctx, "QueueTest") != nil ||
+		ctx.Err() == nil || Default.GetQueueState("QueueTest").State != QueueIdle {
+		t.Error("Test failed, expected one more pass and then an idle queue. Got ",
+			Default.GetQueueState("QueueTest"))
+	} else {
+		fmt.Println("Successfully Finished Run")
+		successful++
+	}
+
+	// Resuming before the paused pass has stopped, or a change before a cancel, isn't lost
+	ctx, _ = Default.beginRun("QueueTest")
+	Default.PauseLynk("QueueTest")
+	Default.ResumeLynk("QueueTest")
+	resumed := Default.endPass(ctx, "QueueTest")
+	Default.beginRun("QueueTest")
+	Default.CancelLynk("QueueTest")
+	var changed context.Context
+	if resumed != nil {
+		changed = Default.endPass(resumed, "QueueTest")
+	}
+	if resumed == nil || resumed.Err() == nil || changed == nil || changed.Err() != nil ||
+		Default.endPass(changed, "QueueTest") != nil {
+		t.Error("Test failed, expected passes after resuming and after a change to follow. Got ",
+			resumed, changed)
+	} else {
+		fmt.Println("Successfully Followed Resumed And Changed Passes")
+		successful++
+	}
+
+	Default.PauseLynk("QueueTest")
+	if _, err := Default.beginRun("QueueTest"); err == nil ||
+		Default.GetQueueState("QueueTest").State != QueuePaused {
+		t.Error("Test failed, expected a paused lynk not to start.")
+	} else {
+		fmt.Println("Successfully Paused Lynk")
+		successful++
+	}
+}
+
+// Unit tests for sharing download slots between lynks
+// @param *testing.T t - The wrapper for the test
+func TestScheduler(t *testing.T) {
+	fmt.Println("\n----------------TestScheduler----------------")
+
+	s := &scheduler{total: 2, perLynk: 2, active: map[string]int{}}
+	ctx := context.Background()
+	waitFor := func(n int) {
+		s.mu.Lock()
+		for len(s.waiting) < n {
+			s.mu.Unlock()
+			time.Sleep(time.Millisecond)
+			s.mu.Lock()
+		}
+		s.mu.Unlock()
+	}
+	releaseBig, _ := s.acquire(ctx, "Big")
+	s.acquire(ctx, "Big")
+
+	// Both slots are taken - the small lynk waits behind another request from the big one
+	bigGranted := make(chan bool, 1)
+	smallGranted := make(chan bool, 1)
+	go func() {
+		s.acquire(ctx, "Small")
+		smallGranted <- true
+	}()
+	waitFor(1)
+	go func() {
+		s.acquire(ctx, "Big")
+		bigGranted <- true
+	}()
+	waitFor(2)
+
+	releaseBig()
+	select {
+	case <-smallGranted:
+		fmt.Println("Successfully Shared Slot Fairly")
+		successful++
+	case <-time.After(time.Second):
+		t.Error("Test failed, expected the lynk with fewer downloads to get the free slot.")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.acquire(cancelled, "Small"); err == nil || len(bigGranted) != 0 {
+		t.Error("Test failed, expected a cancelled request to give up without a slot.")
+	} else {
+		fmt.Println("Successfully Gave Up Waiting")
+		successful++
+	}
+
+	// A lynk with nothing left to download doesn't wait for a slot
+	c := New(Default.Node)
+	c.downloads = &scheduler{total: 1, perLynk: 1, active: map[string]int{}}
+	release, _ := c.downloads.acquire(ctx, "Hog")
+	c.PauseFile("SlotTest", "a.txt")
+	finished := make(chan error, 1)
+	go func() {
+		finished <- c.downloadFiles(ctx, "SlotTest", []string{"a.txt"})
+	}()
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Error("Test failed, expected no error. Got ", err)
+		} else {
+			fmt.Println("Successfully Skipped Slot For Nothing")
+			successful++
+		}
+	case <-time.After(time.Second):
+		t.Error("Test failed, expected a lynk with nothing to download not to wait for a slot.")
+	}
+	release()
+}
+
+// Unit tests for the bandwidth limits
+// @param *testing.T t - The wrapper for the test
+func TestLimits(t *testing.T) {
+	fmt.Println("\n----------------TestLimits----------------")
+
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	Default.applyLimits(Limits{Rate: Rate{Upload: 65536},
+		Lynks: map[string]Rate{"Limited_Lynk": {Download: 32768}}, MaxDownloads: 0,
+		MaxLynkDownloads: 3})
+	buckets := Default.transferBuckets("Limited_Lynk", conn)
+
+	if buckets[0].up.Rate() != 65536 || buckets[1].down.Rate() != 32768 ||
+		buckets[2].up.Rate() != 0 || Default.GetLimits().MaxDownloads != 1 {
+		t.Error("Test failed, expected the limits to reach the buckets. Got ", Default.GetLimits())
+	} else {
+		fmt.Println("Successfully Applied Limits")
+		successful++
+	}
+
+	// Buckets in use by a transfer follow the new limits
+	Default.applyLimits(Limits{MaxDownloads: MaxDownloads, MaxLynkDownloads: MaxLynkDownloads})
+	if buckets[0].up.Rate() != 0 || buckets[1].down.Rate() != 0 {
+		t.Error("Test failed, expected the limits to be lifted at runtime.")
+	} else {
+		fmt.Println("Successfully Changed Limits At Runtime")
+		successful++
+	}
+
+	// Buckets nothing has used for a while are dropped
+	time.Sleep(10 * time.Millisecond)
+	Default.limitsMu.Lock()
+	Default.dropIdleBuckets(5 * time.Millisecond)
+	lynks, peers := len(Default.lynkBuckets), len(Default.peerBuckets)
+	Default.limitsMu.Unlock()
+	if lynks != 0 || peers != 0 {
+		t.Error("Test failed, expected idle buckets to be dropped. Got ", lynks, peers)
+	} else {
+		fmt.Println("Successfully Dropped Idle Buckets")
+		successful++
+	}
+}
+
+// Unit tests for reading and changing lynks from many goroutines at once, as the GUI and the
+// server do - run with -race. Real pushes against a download are in node's TestConcurrentPush.
+// @param *testing.T t - The wrapper for the test
+func TestConcurrentLynks(t *testing.T) {
+	fmt.Println("\n----------------TestConcurrentLynks----------------")
+
+	c := newTestClient(t)
+	defer os.RemoveAll(c.Home)
+
+	os.MkdirAll(c.Home+"Race", 0755)
+	ioutil.WriteFile(c.Home+"Race/a.txt", []byte(strings.Repeat("race", 1000)), 0644)
+	ioutil.WriteFile(c.Home+"lynks.txt", nil, 0644)
+	c.CreateMeta("Race")
+	metaPath := c.Home + "Race/meta.info"
+
+	done := make(chan bool)
+	jobs := []func(){
+		func() { c.ParseMetainfo(metaPath) }, // A push arriving
+		func() { c.UpdateLynk("Race") },      // A download run
+		func() { c.ReadChunk("Race/a.txt", 0) },
+		func() { c.GetBitfield("Race/a.txt") },
+		func() { c.GetLynks(); c.PopulateFilesAndSize() },
+		func() { c.ParseLynks(c.Home + "lynks.txt") },
+	}
+	for _, job := range jobs {
+		go func(job func()) {
+			i := 0
+			for i < 20 {
+				job()
+				i++
+			}
+			done <- true
+		}(job)
+	}
+	for range jobs {
+		<-done
+	}
+
+	if !c.HaveFile("Race/a.txt") || c.GetLynksLen() != 1 {
+		t.Error("Test failed, expected the lynk to survive concurrent use. Got ", c.GetLynks())
+	} else {
+		fmt.Println("Successfully Shared Lynks Between Goroutines")
+		successful++
+	}
+}
+
 // Unit tests for GetTrackerIP function
 // @param *testing.T t - The wrapper for the test
 func TestAskTrackerForPeers(t *testing.T) {
 	fmt.Println("\n----------------TestAskTracker----------------")
 
-	lynkName := GetLynkName(mPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	askTrackerForPeers(lynkName)
+	lynkName := Default.GetLynkName(mPath)
+	Default.askTrackerForPeers(lynkName)
+	lynk, _ := Default.Lynks.Get(lynkName)
 
 	if len(lynk.Peers) <= 0 {
 		t.Error("Did Not Get Correct List Of Peers")
@@ -0,0 +1,101 @@
+// Conflicts for the client - when a file was changed here and by another peer at the same time,
+// our copy is kept next to theirs under a conflict name so someone can resolve it by hand.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"../metainfo"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// MergePush - Checks a meta.info pushed to us and merges it into ours. Our own edits are recorded
+// first so they are merged rather than overwritten, and the whole merge holds the lynk's meta.info
+// lock, so each push is checked against the meta.info the last change left.
+// @param string lynkName - The name of the lynk
+// @param *metainfo.Meta incoming - The pushed meta.info
+// @return *metainfo.Meta - The merged meta.info
+// @return bool - True if the merged meta.info has changes the pushed one didn't
+// @return error - An error is produced if the push is refused or the meta.info can't be written
+func (c *Client) MergePush(lynkName string, incoming *metainfo.Meta) (*metainfo.Meta, bool,
+	error) {
+	metaPath := c.Home + lynkName + "/meta.info"
+	defer c.LockMeta(lynkName)()
+
+	local, err := metainfo.Read(metaPath)
+	if err == nil {
+		// Only the owner and the writers they authorised may change the lynk
+		err = metainfo.CheckPush(local, incoming)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Edits we may not record are kept aside like the losing side of a conflict
+	edits := []metainfo.Conflict{}
+	if local.Authorised(c.KeyFingerprint) {
+		c.refreshMeta(lynkName)
+	} else {
+		edits = c.UnsharedEdits(lynkName)
+	}
+	merged, conflicts, newer := incoming, []metainfo.Conflict{}, false
+	if local, err := metainfo.Read(metaPath); err == nil {
+		merged, conflicts, newer = metainfo.Merge(local, incoming)
+	}
+	conflicts = append(conflicts, edits...)
+	if err = metainfo.Write(metaPath, merged); err != nil {
+		return nil, false, err
+	}
+
+	c.ParseMetainfo(metaPath)
+
+	// Keeps our copies of files that were changed here and there, then puts deleted files away.
+	// Neither stops the push - the files they couldn't handle are left as they are.
+	if err = c.KeepConflicts(lynkName, conflicts); err != nil {
+		fmt.Println("PUSH ERROR: " + err.Error())
+	}
+	c.applyTombstones(lynkName)
+	return merged, newer, nil
+}
+
+// KeepConflicts - Moves our copy of every conflicting file aside so the winning version can be
+// downloaded in its place. The copies are picked up and shared like any other new file. A file
+// whose copy can't be moved aside is paused, so the winning version isn't downloaded over it.
+// @param string lynkName - The name of the lynk
+// @param []metainfo.Conflict conflicts - The conflicting files
+// @return error - An error is produced naming every file whose copy couldn't be moved aside
+func (c *Client) KeepConflicts(lynkName string, conflicts []metainfo.Conflict) error {
+	failed := []string{}
+	now := time.Now()
+	moved := []string{}
+	defer func() { c.forgetFiles(lynkName, moved) }() // They aren't deleted, just moved aside
+
+	for _, conflict := range conflicts {
+		localPath := c.Home + lynkName + "/" + conflict.Path
+		if _, statErr := os.Stat(localPath); statErr != nil {
+			continue // Nothing of ours to keep
+		}
+
+		conflictName := lynxutil.ConflictName(conflict.Path, c.PeerID, now)
+		if rErr := os.Rename(localPath, c.Home+lynkName+"/"+conflictName); rErr != nil {
+			c.PauseFile(lynkName, conflict.Path)
+			failed = append(failed, conflict.Path+" ("+rErr.Error()+")")
+			continue
+		}
+		moved = append(moved, conflict.Path)
+		fmt.Println("Conflict: " + conflict.Path + " was changed by another peer - our copy was " +
+			"kept as " + conflictName)
+	}
+
+	if len(failed) > 0 {
+		return errors.New("Couldn't Keep Our Copies Of " + strings.Join(failed, ", ") +
+			" - They Are Paused Until Resumed")
+	}
+	return nil
+}
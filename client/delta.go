@@ -0,0 +1,167 @@
+// Delta transfer for the client - when we hold an older version of a changed file, only the blocks
+// that changed are fetched from a peer and the new version is rebuilt from the old one.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../delta"
+	"../lynxutil"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Files shorter than this are always fetched whole - a delta would save almost nothing
+const deltaMinLength = lynxutil.ChunkLength
+
+// Fetches a changed file by rebuilding it from the older version we already hold, asking each
+// peer in turn until one can send the delta.
+// @param context.Context ctx - Cancelling it stops the download
+// @param string lynkName - The name of the lynk the file belongs to
+// @param lynxutil.File file - The meta.info entry of the new version
+// @param []lynxutil.Peer peers - The peers of the lynk
+// @return error - An error is produced if we have no older version worth patching or no peer
+// could send a delta that rebuilds the new version
+func (c *Client) deltaDownload(ctx context.Context, lynkName string, file lynxutil.File,
+	peers []lynxutil.Peer) error {
+	oldPath := c.Home + lynkName + "/" + file.Path
+	info, err := os.Stat(oldPath)
+	if err != nil || info.IsDir() || info.Size() < deltaMinLength || file.Length < deltaMinLength {
+		return errors.New("No Older Version Of " + file.Name + " To Patch")
+	}
+
+	old, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	blockLen := delta.BlockLength(info.Size())
+	sums, err := delta.Signature(old, blockLen)
+	if err != nil {
+		return err
+	} else if len(sums) > delta.MaxBlocks {
+		return errors.New(file.Name + " Is Too Big For A Delta")
+	}
+
+	for _, peer := range peers {
+		conn, err := dialPeer(ctx, peer)
+		if err != nil {
+			continue
+		}
+		err = c.askForDelta(lynkName, file, old, blockLen, sums, conn)
+		conn.Close()
+		if err == nil {
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Println("Delta From " + peer.IP + " Failed: " + err.Error())
+	}
+
+	return errors.New("Did not receive delta")
+}
+
+// Sends a peer the signature of our older version of a file and rebuilds the new version from
+// the delta it replies with. The rebuilt file is verified before it replaces the old one.
+// @param string lynkName - The name of the lynk the file belongs to
+// @param lynxutil.File file - The meta.info entry of the new version
+// @param *os.File old - Our older version
+// @param int blockLen - The block length of the signature
+// @param []delta.Sum sums - The signature of our older version
+// @param net.Conn conn - The connection to the peer
+// @return error - An error is produced if the peer can't send a delta or it doesn't rebuild the
+// new version
+func (c *Client) askForDelta(lynkName string, file lynxutil.File, old *os.File, blockLen int,
+	sums []delta.Sum, conn net.Conn) error {
+	// Client syntax is "Delta_Request:<LynkName>/<FilePath>:<BlockLength>:<Blocks>\n" followed
+	// by our key's fingerprint and the signature
+	fmt.Fprintf(conn, "Delta_Request:"+lynkName+"/"+file.Path+":"+strconv.Itoa(blockLen)+":"+
+		strconv.Itoa(len(sums))+"\n")
+	fmt.Fprintln(conn, c.KeyFingerprint) // The peer wraps the delta's session key for our key
+	if err := delta.WriteSignature(conn, sums); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	} else if strings.TrimSpace(reply) != "YES" {
+		return errors.New("Peer Does Not Have " + file.Path)
+	}
+
+	tmpPath := c.stagingDir(lynkName) + file.Path + ".delta"
+	if err = os.MkdirAll(filepath.Dir(tmpPath), 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	// The delta is decrypted as it arrives and applied straight away
+	ops, opsWriter := io.Pipe()
+	go func() {
+		opsWriter.CloseWithError(c.ReceiveStream(c.DownloadReader(reader, lynkName, conn),
+			opsWriter))
+	}()
+	limit := &lynxutil.LimitedWriter{W: tmpFile, N: int64(file.Length)}
+	literal, err := delta.Patch(old, blockLen, ops, limit)
+	ops.Close()
+	if cErr := tmpFile.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = lynxutil.VerifyPath(tmpPath, file)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	fmt.Println("Patched: " + file.Name + " With " + strconv.FormatInt(literal, 10) + " Of " +
+		strconv.Itoa(file.Length) + " Bytes Changed")
+	return c.moveIntoLynk(tmpPath, lynkName, file.Path)
+}
+
+// OpenDelta - Compares our current version of a file against the signature of a peer's older
+// version and returns the delta that turns theirs into ours. Only a copy that matches the
+// meta.info is diffed, so a peer is never patched towards a version that is out of date.
+// @param string filePath - The file including its lynk name. E.G. - 'Cool_Lynk/docs/coolFile.txt'
+// @param int blockLen - The block length of the signature
+// @param []delta.Sum sums - The signature of the peer's older version
+// @return io.ReadCloser - The delta, computed as it is read - it must be closed
+// @return error - An error is produced if we don't hold the current version of the file
+func (c *Client) OpenDelta(filePath string, blockLen int, sums []delta.Sum) (io.ReadCloser, error) {
+	if !c.HaveFile(filePath) {
+		return nil, errors.New("Do Not Have " + filePath)
+	}
+
+	lynkName, relPath, _ := SplitFilePath(filePath)
+	file, ok := c.Lynks.GetFile(lynkName, relPath)
+	if !ok || !c.upToDate(lynkName, []lynxutil.File{file})[relPath] {
+		return nil, errors.New("Do Not Have The Current Version Of " + filePath)
+	}
+
+	f, err := os.Open(c.Home + lynkName + "/" + relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, opsWriter := io.Pipe()
+	go func() {
+		defer f.Close()
+		opsWriter.CloseWithError(delta.Diff(sums, blockLen, f, opsWriter))
+	}()
+	return ops, nil
+}
@@ -0,0 +1,310 @@
+// Selective sync for the client - gitignore-style .lynxignore files keep paths out of a lynk, and
+// a peer can subscribe to only some of a lynk's folders.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreName - The name of the files holding a lynk's ignore patterns. Each applies to the folder
+// it is in and every folder below it, and is shared like any other file so peers agree on it.
+const IgnoreName = ".lynxignore"
+
+// The directory inside a node's Home that holds the folders each lynk is subscribed to
+const subscriptionsName = ".subscriptions"
+
+// ignoreRule - A single pattern from a .lynxignore file.
+type ignoreRule struct {
+	pattern  string
+	negate   bool // A '!' pattern includes paths an earlier pattern ignored
+	dirOnly  bool // A pattern ending in '/' only matches folders
+	anchored bool // A pattern containing '/' matches from its .lynxignore's folder, not at any depth
+}
+
+// syncFilter - Decides which paths of a lynk are synced here. The .lynxignore files are read as
+// the folders they are in are first reached.
+type syncFilter struct {
+	lynkDir string
+	rules   map[string][]ignoreRule // By folder inside the lynk - "" is the top of the lynk
+	subs    []string
+}
+
+// Creates the filter for a lynk.
+// @param string lynkName - The name of the lynk
+// @return *syncFilter - The filter
+func (c *Client) loadFilter(lynkName string) *syncFilter {
+	return &syncFilter{lynkDir: c.Home + lynkName + "/",
+		rules: map[string][]ignoreRule{}, subs: c.Subscriptions(lynkName)}
+}
+
+// Returns whether a path is left out of syncing, either because a .lynxignore pattern matches it
+// or a folder it is in, or because it lies outside the folders we subscribe to.
+// @param string relPath - The path inside the lynk
+// @param bool isDir - Whether the path is a folder
+// @return bool - True if the path is not synced
+func (f *syncFilter) skips(relPath string, isDir bool) bool {
+	if !f.subscribed(relPath, isDir) {
+		return true
+	}
+
+	// Like git, nothing inside an ignored folder can be included again
+	parts := strings.Split(relPath, "/")
+	i := 1
+	for i < len(parts) {
+		if f.ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+		i++
+	}
+	return f.ignored(relPath, isDir)
+}
+
+// Returns whether the last .lynxignore pattern matching a path ignores it. Patterns in deeper
+// folders come later and so win over those above them.
+// @param string relPath - The path inside the lynk
+// @param bool isDir - Whether the path is a folder
+// @return bool - True if the path is ignored
+func (f *syncFilter) ignored(relPath string, isDir bool) bool {
+	ignored := false
+	parts := strings.Split(relPath, "/")
+
+	i := 0
+	for i < len(parts) {
+		dir := strings.Join(parts[:i], "/")
+		for _, rule := range f.load(dir) {
+			if rule.matches(strings.Join(parts[i:], "/"), isDir) {
+				ignored = !rule.negate
+			}
+		}
+		i++
+	}
+	return ignored
+}
+
+// Returns the patterns of the .lynxignore in a folder, reading it the first time.
+// @param string dir - The folder inside the lynk
+// @return []ignoreRule - The patterns, or nil if the folder has no .lynxignore
+func (f *syncFilter) load(dir string) []ignoreRule {
+	rules, ok := f.rules[dir]
+	if !ok {
+		ignorePath := f.lynkDir + dir + "/" + IgnoreName
+		if dir == "" {
+			ignorePath = f.lynkDir + IgnoreName
+		}
+		rules = readIgnore(ignorePath)
+		f.rules[dir] = rules
+	}
+	return rules
+}
+
+// Returns whether a path lies inside one of the folders we subscribe to. A folder above a
+// subscribed folder counts so it can be walked into.
+// @param string relPath - The path inside the lynk
+// @param bool isDir - Whether the path is a folder
+// @return bool - True if the path is synced here
+func (f *syncFilter) subscribed(relPath string, isDir bool) bool {
+	if len(f.subs) == 0 {
+		return true // Subscribed to everything
+	}
+
+	for _, sub := range f.subs {
+		if relPath == sub || strings.HasPrefix(relPath, sub+"/") ||
+			(isDir && strings.HasPrefix(sub, relPath+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Skips - Returns whether a path of a lynk is left out of syncing, because a .lynxignore pattern
+// matches it or it lies outside the folders we subscribe to.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The path inside the lynk
+// @param bool isDir - Whether the path is a folder
+// @return bool - True if the path is not synced
+func (c *Client) Skips(lynkName, relPath string, isDir bool) bool {
+	return c.loadFilter(lynkName).skips(relPath, isDir)
+}
+
+// Wraps a filepath.WalkFunc over a lynk's directory so paths that are not synced are skipped -
+// an ignored folder isn't walked into at all.
+// @param string lynkName - The name of the lynk
+// @param filepath.WalkFunc walk - The function to call for every synced path
+// @return filepath.WalkFunc - The wrapped function
+func (c *Client) skipIgnored(lynkName string, walk filepath.WalkFunc) filepath.WalkFunc {
+	filter := c.loadFilter(lynkName)
+	lynkDir := c.Home + lynkName
+
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return walk(path, info, err)
+		}
+
+		relPath, relErr := filepath.Rel(lynkDir, path)
+		relPath = filepath.ToSlash(relPath)
+		if relErr == nil && relPath != "." && filter.skips(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return walk(path, info, err)
+	}
+}
+
+// Reads the patterns of a .lynxignore file.
+// @param string ignorePath - The file's path
+// @return []ignoreRule - The patterns, or nil if the file can't be read
+func readIgnore(ignorePath string) []ignoreRule {
+	ignoreFile, err := os.Open(ignorePath)
+	if err != nil {
+		return nil
+	}
+	defer ignoreFile.Close()
+
+	rules := []ignoreRule{}
+	scanner := bufio.NewScanner(ignoreFile)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Parses one line of a .lynxignore file, which follows the syntax of a .gitignore file.
+// @param string line - The line
+// @return ignoreRule - The pattern
+// @return bool - False if the line is blank or a comment
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+		line = line[1:] // An escaped leading '#' or '!' is part of the pattern
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	rule.pattern = line
+	return rule, line != ""
+}
+
+// Returns whether a pattern matches a path.
+// @param string relPath - The path, relative to the folder of the pattern's .lynxignore
+// @param bool isDir - Whether the path is a folder
+// @return bool - True if the pattern matches
+func (r ignoreRule) matches(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, path.Base(relPath))
+		return ok
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// Matches a pattern against a path one folder at a time, where a '**' segment matches any
+// number of folders.
+// @param []string pattern - The pattern's segments
+// @param []string parts - The path's segments
+// @return bool - True if the pattern matches the whole path
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	if pattern[0] == "**" {
+		i := 0
+		for i <= len(parts) {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+			i++
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchSegments(pattern[1:], parts[1:])
+}
+
+// Returns the path of the file listing the folders a lynk is subscribed to.
+// @param string lynkName - The name of the lynk
+// @return string - The file's path
+func (c *Client) subscriptionsPath(lynkName string) string {
+	return c.Home + subscriptionsName + "/" + lynkName + ".txt"
+}
+
+// Subscriptions - Returns the folders of a lynk that are synced here.
+// @param string lynkName - The name of the lynk
+// @return []string - The folders' paths inside the lynk, or nil if the whole lynk is synced
+func (c *Client) Subscriptions(lynkName string) []string {
+	data, err := ioutil.ReadFile(c.subscriptionsPath(lynkName))
+	if err != nil {
+		return nil
+	}
+
+	var subs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if sub, err := lynxutil.CleanPath(strings.TrimSpace(line)); err == nil {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// Subscribe - Syncs only some folders of a lynk here. Files in the other folders are neither
+// downloaded nor shared, but stay part of the lynk for everyone else.
+// @param string lynkName - The name of the lynk
+// @param []string folders - The folders' paths inside the lynk - none syncs the whole lynk
+// @return error - An error is produced if a folder leaves the lynk or the list can't be saved
+func (c *Client) Subscribe(lynkName string, folders []string) error {
+	if len(folders) == 0 {
+		err := os.Remove(c.subscriptionsPath(lynkName))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	list := ""
+	for _, folder := range folders {
+		sub, err := lynxutil.CleanPath(folder)
+		if err != nil {
+			return err
+		}
+		list += sub + "\n"
+	}
+
+	if err := os.MkdirAll(c.Home+subscriptionsName, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.subscriptionsPath(lynkName), []byte(list), 0644)
+}
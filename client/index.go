@@ -0,0 +1,295 @@
+// The change detection index for the client - remembers the size, modification time and hashes of
+// every file in a lynk so a file is only re-hashed when it may have changed.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"../metainfo"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The directory inside a node's Home that holds every lynk's index
+const indexName = ".index"
+
+// indexEntry - What we last saw of a file. The hashes are trusted for as long as the size and
+// modification time stay the same.
+type indexEntry struct {
+	Size    int64    `json:"size"`
+	ModTime int64    `json:"modTime"` // Nanoseconds since the Unix epoch
+	Hash    string   `json:"hash"`
+	Chunks  []string `json:"chunks"`
+}
+
+// lynkIndex - The index of one lynk, keyed by each file's path inside the lynk.
+type lynkIndex struct {
+	path  string
+	Files map[string]indexEntry `json:"files"`
+}
+
+// Returns the path of a lynk's index.
+// @param string lynkName - The name of the lynk
+// @return string - The index's path
+func (c *Client) indexPath(lynkName string) string {
+	return c.Home + indexName + "/" + lynkName + ".json"
+}
+
+// Reads a lynk's index. A missing or unreadable index is treated as empty - it only means every
+// file is hashed again.
+// @param string lynkName - The name of the lynk
+// @return *lynkIndex - The index
+func (c *Client) loadIndex(lynkName string) *lynkIndex {
+	idx := &lynkIndex{path: c.indexPath(lynkName)}
+	if data, err := ioutil.ReadFile(idx.path); err == nil {
+		json.Unmarshal(data, idx)
+	}
+	if idx.Files == nil {
+		idx.Files = map[string]indexEntry{}
+	}
+	return idx
+}
+
+// Writes the index, replacing the old one in a single rename.
+// @return error - An error can be produced if the index cannot be written
+func (idx *lynkIndex) save() error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(idx.path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(idx.path+".tmp", idx.path)
+}
+
+// Returns the hashes of a file, only reading the file if its size or modification time changed
+// since it was last hashed.
+// @param string relPath - The file's path inside the lynk
+// @param string path - The file's path on disk
+// @param os.FileInfo info - The file's info
+// @return indexEntry - The file's current entry
+// @return error - An error is produced if the file has to be hashed and can't be read
+func (idx *lynkIndex) lookup(relPath, path string, info os.FileInfo) (indexEntry, error) {
+	entry, ok := idx.Files[relPath]
+	if ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+		return entry, nil
+	}
+
+	chunks, hash, err := lynxutil.HashFile(path, lynxutil.ChunkLength)
+	if err != nil {
+		return indexEntry{}, err
+	}
+
+	entry = indexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Hash: hash,
+		Chunks: chunks}
+	idx.Files[relPath] = entry
+	return entry, nil
+}
+
+// RefreshMeta - Checks a lynk's directory for files that have been added, deleted or whose
+// contents have changed and records their new versions, or tombstones, in the lynk's meta.info.
+// Only the owner and writers record changes - anyone else's would never be taken by peers, so
+// they are reported instead.
+// @param string lynkName - The name of the lynk
+// @return bool - True if the meta.info changed and should be pushed to peers
+// @return error - An error can be produced if the meta.info cannot be read or written, or if we
+// changed files of a lynk we may not change
+func (c *Client) RefreshMeta(lynkName string) (bool, error) {
+	defer c.LockMeta(lynkName)()
+	return c.refreshMeta(lynkName)
+}
+
+// Helper function for RefreshMeta and MergePush - records the changes to a lynk's files in its
+// meta.info. The caller holds the lynk's meta.info lock.
+// @param string lynkName - The name of the lynk
+// @return bool - True if the meta.info changed and should be pushed to peers
+// @return error - An error is produced if the meta.info can't be read or written, or if we
+// changed files of a lynk we may not change
+func (c *Client) refreshMeta(lynkName string) (bool, error) {
+	metaPath := c.Home + lynkName + "/meta.info"
+	m, err := metainfo.Read(metaPath)
+	if err != nil {
+		return false, err
+	}
+
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	idx := c.loadIndex(lynkName)
+	seen := map[string]bool{}
+	changed := false
+	writer := m.Authorised(c.KeyFingerprint)
+	unshared := []string{}
+
+	lynkDir := c.Home + lynkName
+	filepath.Walk(lynkDir, c.skipIgnored(lynkName, func(path string, info os.FileInfo,
+		err error) error {
+		// Don't add directories, trackers, a meta.info file or files still being written to the
+		// meta.info
+		if err != nil || info.IsDir() || strings.Contains(path, "_Tracker") ||
+			info.Name() == "meta.info" || lynxutil.IsTemp(info.Name()) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(lynkDir, path)
+		if err == nil {
+			relPath, err = lynxutil.CleanPath(relPath)
+		}
+		if err != nil {
+			return nil
+		}
+
+		entry, err := idx.lookup(relPath, path, info)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		seen[relPath] = true
+
+		file := lynxutil.GetFile(m.Files, relPath)
+		if !writer && (file == nil || file.Hash != entry.Hash) {
+			unshared = append(unshared, relPath)
+		} else if file == nil {
+			fmt.Println("File: " + relPath + " has been added")
+			m.Add(lynxutil.File{Length: int(entry.Size), Path: relPath, Name: info.Name(),
+				Chunks: entry.Chunks, ChunkLength: lynxutil.ChunkLength, Hash: entry.Hash,
+				Versions: map[string]int{c.PeerID: 1}})
+			changed = true
+		} else if file.Hash != entry.Hash {
+			fmt.Println("File: " + relPath + " has been changed")
+			file.Length = int(entry.Size)
+			file.Chunks = entry.Chunks
+			file.ChunkLength = lynxutil.ChunkLength
+			file.Hash = entry.Hash
+			file.Versions = metainfo.BumpVersion(file.Versions, c.PeerID)
+			changed = true
+		}
+		return nil
+	}))
+
+	// Files we had here before but are gone now were deleted, so peers are told to delete theirs.
+	// Files we never had, like ones still being downloaded, aren't. Either way they are forgotten
+	// so the index doesn't grow forever.
+	filter := c.loadFilter(lynkName)
+	for relPath := range idx.Files {
+		if seen[relPath] {
+			continue
+		}
+		delete(idx.Files, relPath)
+		if lynxutil.GetFile(m.Files, relPath) == nil || filter.skips(relPath, false) {
+			continue
+		} else if !writer {
+			unshared = append(unshared, relPath)
+		} else if m.Delete(relPath, time.Now()) {
+			fmt.Println("File: " + relPath + " has been deleted")
+			changed = true
+		}
+	}
+	if err = idx.save(); err != nil {
+		fmt.Println(err)
+	}
+
+	// Tombstones every peer has had long enough to hear about are dropped
+	if writer && m.PruneTombstones(time.Now()) {
+		changed = true
+	}
+
+	if len(unshared) > 0 {
+		return false, errors.New("Not Authorised To Change " + lynkName + " - Changes To " +
+			strings.Join(unshared, ", ") + " Aren't Shared")
+	} else if !changed {
+		return false, nil
+	}
+	if err = metainfo.Write(metaPath, m); err != nil {
+		return false, err
+	}
+	c.ParseMetainfo(metaPath)
+	return true, nil
+}
+
+// Forgets files of a lynk that Lynx moved aside or removed itself, so RefreshMeta doesn't take
+// them for files that were deleted.
+// @param string lynkName - The name of the lynk
+// @param []string relPaths - The files' paths inside the lynk
+func (c *Client) forgetFiles(lynkName string, relPaths []string) {
+	if len(relPaths) == 0 {
+		return
+	}
+
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	idx := c.loadIndex(lynkName)
+	for _, relPath := range relPaths {
+		delete(idx.Files, relPath)
+	}
+	if err := idx.save(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// UnsharedEdits - Returns the files of a lynk whose copies here differ from the versions in its
+// meta.info. For a peer that may not change the lynk, these are edits that were never shared, so
+// they have to be kept aside before the meta.info's versions are downloaded over them.
+// @param string lynkName - The name of the lynk
+// @return []metainfo.Conflict - The files that differ, with the hashes of our copies
+func (c *Client) UnsharedEdits(lynkName string) []metainfo.Conflict {
+	edits := []metainfo.Conflict{}
+	m, err := metainfo.Read(c.Home + lynkName + "/meta.info")
+	if err != nil {
+		return edits
+	}
+
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	idx := c.loadIndex(lynkName)
+	for _, file := range m.Files {
+		path := c.Home + lynkName + "/" + file.Path
+		info, err := os.Stat(path)
+		if file.Hash == "" || err != nil || info.IsDir() {
+			continue
+		}
+		if entry, err := idx.lookup(file.Path, path, info); err == nil && entry.Hash != file.Hash {
+			edits = append(edits, metainfo.Conflict{Path: file.Path, LocalHash: entry.Hash})
+		}
+	}
+
+	idx.save()
+	return edits
+}
+
+// Returns which files of a lynk we already hold the current version of, so they aren't
+// downloaded again.
+// @param string lynkName - The name of the lynk
+// @param []lynxutil.File files - The lynk's files
+// @return map[string]bool - The paths of the files that are up to date
+func (c *Client) upToDate(lynkName string, files []lynxutil.File) map[string]bool {
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	idx := c.loadIndex(lynkName)
+	current := map[string]bool{}
+
+	for _, file := range files {
+		path := c.Home + lynkName + "/" + file.Path
+		info, err := os.Stat(path)
+		if file.Hash == "" || err != nil || info.IsDir() {
+			continue
+		}
+		if entry, err := idx.lookup(file.Path, path, info); err == nil && entry.Hash == file.Hash {
+			current[file.Path] = true
+		}
+	}
+
+	idx.save()
+	return current
+}
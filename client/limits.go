@@ -0,0 +1,231 @@
+// Bandwidth limits for the client - uploads and downloads pass through token buckets so a big
+// sync can't saturate the connection. Limits are set globally, per lynk and per peer, and can be
+// changed while transfers are going. The global limits are split evenly between the lynks that
+// are transferring, so a big lynk can't stall the others.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../throttle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+// The file inside a node's Home that holds the limits
+const limitsName = "limits.json"
+
+// How long the buckets of a lynk or peer go unused before they are dropped - a transfer after that
+// starts with new ones
+const bucketIdle = 10 * time.Minute
+
+// Rate - An upload and a download limit in bytes per second. 0 means unlimited.
+type Rate struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// Limits - Every bandwidth and download limit of the client.
+type Limits struct {
+	Rate                             // Across every lynk and peer
+	Lynks            map[string]Rate `json:"lynks"` // By lynk name
+	Peers            map[string]Rate `json:"peers"` // By peer IP
+	MaxDownloads     int             `json:"maxDownloads"`
+	MaxLynkDownloads int             `json:"maxLynkDownloads"`
+}
+
+// bucketPair - The upload and download buckets of one lynk, one peer or everything.
+type bucketPair struct {
+	up   *throttle.Bucket
+	down *throttle.Bucket
+}
+
+// sharePair - The upload and download rates split between the lynks.
+type sharePair struct {
+	up   *throttle.Share
+	down *throttle.Share
+}
+
+// GetLimits - Returns the limits in force.
+// @return Limits - The limits
+func (c *Client) GetLimits() Limits {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+
+	l := c.limits
+	l.Lynks, l.Peers = map[string]Rate{}, map[string]Rate{}
+	for name, rate := range c.limits.Lynks {
+		l.Lynks[name] = rate
+	}
+	for ip, rate := range c.limits.Peers {
+		l.Peers[ip] = rate
+	}
+	return l
+}
+
+// SetLimits - Changes the limits and saves them. Transfers already going slow down or speed up
+// straight away.
+// @param Limits l - The new limits - lynks and peers left out are unlimited
+// @return error - An error is produced if the limits can't be saved
+func (c *Client) SetLimits(l Limits) error {
+	c.applyLimits(l)
+
+	data, err := json.MarshalIndent(c.GetLimits(), "", "\t")
+	if err != nil {
+		return err
+	}
+	limitsPath := c.Home + limitsName
+	if err = ioutil.WriteFile(limitsPath+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(limitsPath+".tmp", limitsPath)
+}
+
+// Reads the saved limits and puts them in force. Missing or unreadable limits leave everything
+// unlimited.
+func (c *Client) loadLimits() {
+	data, err := ioutil.ReadFile(c.Home + limitsName)
+	if err != nil {
+		return
+	}
+	l := Limits{MaxDownloads: MaxDownloads, MaxLynkDownloads: MaxLynkDownloads}
+	if json.Unmarshal(data, &l) == nil {
+		c.applyLimits(l)
+	}
+}
+
+// Puts limits in force without saving them.
+// @param Limits l - The new limits
+func (c *Client) applyLimits(l Limits) {
+	if l.Lynks == nil {
+		l.Lynks = map[string]Rate{}
+	}
+	if l.Peers == nil {
+		l.Peers = map[string]Rate{}
+	}
+
+	c.SetDownloadLimits(l.MaxDownloads, l.MaxLynkDownloads)
+	l.MaxDownloads, l.MaxLynkDownloads = c.DownloadLimits() // Keeps the values it clamped
+
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	c.limits = l
+	c.globalShares.set(l.Rate)
+	for name, pair := range c.lynkBuckets {
+		pair.set(l.Lynks[name])
+	}
+	for ip, pair := range c.peerBuckets {
+		pair.set(l.Peers[ip])
+	}
+}
+
+// Sets the rates of a pair of buckets.
+// @param Rate rate - The rates
+func (p bucketPair) set(rate Rate) {
+	p.up.SetRate(rate.Upload)
+	p.down.SetRate(rate.Download)
+}
+
+// Returns whether neither bucket of a pair has been used for a while.
+// @param time.Duration after - How long the buckets must have gone unused
+// @return bool - True if the pair can be dropped
+func (p bucketPair) idle(after time.Duration) bool {
+	return p.up.Idle() > after && p.down.Idle() > after
+}
+
+// Sets the rates being split.
+// @param Rate rate - The rates
+func (p sharePair) set(rate Rate) {
+	p.up.SetRate(rate.Upload)
+	p.down.SetRate(rate.Download)
+}
+
+// Drops a lynk's part of the rates being split.
+// @param string lynkName - The name of the lynk
+func (p sharePair) remove(lynkName string) {
+	p.up.Remove(lynkName)
+	p.down.Remove(lynkName)
+}
+
+// Returns a lynk's part of the rates being split.
+// @param string lynkName - The name of the lynk
+// @return bucketPair - The lynk's buckets
+func (p sharePair) bucket(lynkName string) bucketPair {
+	return bucketPair{p.up.Bucket(lynkName), p.down.Bucket(lynkName)}
+}
+
+// Returns the buckets a transfer of a lynk with a peer draws from, creating them the first time.
+// @param string lynkName - The name of the lynk
+// @param net.Conn conn - The connection to the peer
+// @return []bucketPair - The lynk's part of the global limits, and the lynk and peer buckets
+func (c *Client) transferBuckets(lynkName string, conn net.Conn) []bucketPair {
+	peerIP, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		peerIP = conn.RemoteAddr().String()
+	}
+
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	c.dropIdleBuckets(bucketIdle)
+	lynkPair, ok := c.lynkBuckets[lynkName]
+	if !ok {
+		rate := c.limits.Lynks[lynkName]
+		lynkPair = bucketPair{throttle.NewBucket(rate.Upload), throttle.NewBucket(rate.Download)}
+		c.lynkBuckets[lynkName] = lynkPair
+	}
+	peerPair, ok := c.peerBuckets[peerIP]
+	if !ok {
+		rate := c.limits.Peers[peerIP]
+		peerPair = bucketPair{throttle.NewBucket(rate.Upload), throttle.NewBucket(rate.Download)}
+		c.peerBuckets[peerIP] = peerPair
+	}
+	return []bucketPair{c.globalShares.bucket(lynkName), lynkPair, peerPair}
+}
+
+// Drops the buckets of lynks and peers that haven't had a transfer for a while, so they don't pile
+// up as lynks come and go and peers change address - callers must hold limitsMu.
+// @param time.Duration after - How long the buckets must have gone unused
+func (c *Client) dropIdleBuckets(after time.Duration) {
+	for name, pair := range c.lynkBuckets {
+		if pair.idle(after) {
+			delete(c.lynkBuckets, name)
+			c.globalShares.remove(name)
+		}
+	}
+	for ip, pair := range c.peerBuckets {
+		if pair.idle(after) {
+			delete(c.peerBuckets, ip)
+		}
+	}
+}
+
+// UploadWriter - Limits how fast a lynk's data is sent to a peer.
+// @param io.Writer w - Where the data is written
+// @param string lynkName - The name of the lynk
+// @param net.Conn conn - The connection to the peer
+// @return io.Writer - Writes to w no faster than the global, lynk and peer upload limits allow
+func (c *Client) UploadWriter(w io.Writer, lynkName string, conn net.Conn) io.Writer {
+	buckets := []*throttle.Bucket{}
+	for _, pair := range c.transferBuckets(lynkName, conn) {
+		buckets = append(buckets, pair.up)
+	}
+	return &throttle.Writer{W: w, Buckets: buckets}
+}
+
+// DownloadReader - Limits how fast a lynk's data is received from a peer.
+// @param io.Reader r - Where the data is read from
+// @param string lynkName - The name of the lynk
+// @param net.Conn conn - The connection to the peer
+// @return io.Reader - Reads from r no faster than the global, lynk and peer download limits allow
+func (c *Client) DownloadReader(r io.Reader, lynkName string, conn net.Conn) io.Reader {
+	buckets := []*throttle.Bucket{}
+	for _, pair := range c.transferBuckets(lynkName, conn) {
+		buckets = append(buckets, pair.down)
+	}
+	return &throttle.Reader{R: r, Buckets: buckets}
+}
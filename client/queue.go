@@ -0,0 +1,410 @@
+// The download queue for the client - every lynk has a queue of files waiting to be downloaded
+// that can be inspected, paused, resumed and cancelled, as a whole or a file at a time, and whose
+// files can be given priorities.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+)
+
+// The directory inside a node's Home that holds the file priorities each lynk was given
+const prioritiesName = ".priorities"
+
+// The states a lynk's queue can be in
+const (
+	QueueIdle        = "Idle"        // Nothing is being downloaded
+	QueueDownloading = "Downloading" // Files are being downloaded
+	QueuePaused      = "Paused"      // Nothing is downloaded until the lynk is resumed
+)
+
+// File priorities - files with a higher priority are downloaded first
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
+// QueueState - A snapshot of a lynk's download queue.
+type QueueState struct {
+	State       string
+	Active      []string       // The files being downloaded right now
+	Pending     []string       // The files still waiting, in the order they will be downloaded
+	PausedFiles []string       // The files that are skipped until they are resumed
+	Priorities  map[string]int // The files whose priority isn't PriorityNormal
+	Done        int            // How many files the current run has finished
+}
+
+// lynkQueue - The download queue of one lynk. Whether it is paused is kept apart from the run's
+// context - a pass that was cancelled or paused can be followed by another with a fresh context.
+type lynkQueue struct {
+	running     bool
+	paused      bool
+	again       bool                          // Something changed, so another pass follows
+	cancel      context.CancelFunc            // Cancels the current pass
+	active      map[string]context.CancelFunc // Cancels each file being downloaded
+	pending     []string
+	pausedFiles map[string]bool
+	priorities  map[string]int
+	done        int
+}
+
+// Returns a lynk's queue, creating it the first time - callers must hold queuesMu.
+// @param string lynkName - The name of the lynk
+// @return *lynkQueue - The queue
+func (c *Client) getQueue(lynkName string) *lynkQueue {
+	q := c.queues[lynkName]
+	if q == nil {
+		q = &lynkQueue{active: map[string]context.CancelFunc{}, pausedFiles: map[string]bool{},
+			priorities: map[string]int{}}
+		if data, err := ioutil.ReadFile(c.prioritiesPath(lynkName)); err == nil {
+			json.Unmarshal(data, &q.priorities)
+		}
+		c.queues[lynkName] = q
+	}
+	return q
+}
+
+// GetQueueState - Returns a snapshot of a lynk's download queue.
+// @param string lynkName - The name of the lynk
+// @return QueueState - The queue's state
+func (c *Client) GetQueueState(lynkName string) QueueState {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	state := QueueState{State: QueueIdle, Active: []string{}, Pending: q.ordered(),
+		PausedFiles: []string{}, Priorities: map[string]int{}, Done: q.done}
+	if q.paused {
+		state.State = QueuePaused
+	} else if q.running {
+		state.State = QueueDownloading
+	}
+	for relPath := range q.active {
+		state.Active = append(state.Active, relPath)
+	}
+	for relPath := range q.pausedFiles {
+		state.PausedFiles = append(state.PausedFiles, relPath)
+	}
+	for relPath, priority := range q.priorities {
+		state.Priorities[relPath] = priority
+	}
+	sort.Strings(state.Active)
+	sort.Strings(state.PausedFiles)
+	return state
+}
+
+// PauseLynk - Stops a lynk's downloads until it is resumed. Chunks already received stay in the
+// staging area, so resuming picks up where the download stopped.
+// @param string lynkName - The name of the lynk
+func (c *Client) PauseLynk(lynkName string) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	q.paused = true
+	if q.running {
+		q.cancel()
+	}
+}
+
+// ResumeLynk - Starts downloading a paused lynk's files again. If the paused pass hasn't finished
+// stopping yet, another pass follows it.
+// @param string lynkName - The name of the lynk
+func (c *Client) ResumeLynk(lynkName string) {
+	c.queuesMu.Lock()
+	q := c.getQueue(lynkName)
+	start := q.paused && !q.running
+	q.again = q.again || q.paused && q.running
+	q.paused = false
+	c.queuesMu.Unlock()
+
+	if start {
+		go c.UpdateLynk(lynkName)
+	}
+}
+
+// CancelLynk - Stops the files of a lynk that are being downloaded right now. Unlike pausing, the
+// next change to the lynk starts downloading again - as does a change that came in during the pass
+// that was cancelled.
+// @param string lynkName - The name of the lynk
+func (c *Client) CancelLynk(lynkName string) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	if q.running {
+		q.cancel()
+	}
+}
+
+// PauseFile - Skips a single file of a lynk until it is resumed, stopping it if it is being
+// downloaded right now.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+func (c *Client) PauseFile(lynkName, relPath string) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	q.pausedFiles[relPath] = true
+	if cancel, ok := q.active[relPath]; ok {
+		cancel()
+	}
+}
+
+// ResumeFile - Downloads a paused file of a lynk again.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+func (c *Client) ResumeFile(lynkName, relPath string) {
+	c.queuesMu.Lock()
+	q := c.getQueue(lynkName)
+	delete(q.pausedFiles, relPath)
+	start := !q.running && !q.paused
+	q.again = q.running
+	c.queuesMu.Unlock()
+
+	if start {
+		go c.UpdateLynk(lynkName)
+	}
+}
+
+// SetPriority - Sets the priority of a file of a lynk, so for example a README can be downloaded
+// before everything else. It applies to the files still waiting, including in a run that is
+// already going, and is saved so it lasts until it is changed again.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @param int priority - The priority - higher is downloaded sooner, PriorityNormal is the default
+// @return error - An error is produced if the priorities can't be saved
+func (c *Client) SetPriority(lynkName, relPath string, priority int) error {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	if priority == PriorityNormal {
+		delete(q.priorities, relPath)
+	} else {
+		q.priorities[relPath] = priority
+	}
+
+	prioritiesPath := c.prioritiesPath(lynkName)
+	if len(q.priorities) == 0 {
+		if err := os.Remove(prioritiesPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(q.priorities)
+	if err == nil {
+		err = os.MkdirAll(c.Home+prioritiesName, 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(prioritiesPath+".tmp", data, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(prioritiesPath+".tmp", prioritiesPath)
+}
+
+// Returns the path of the file holding a lynk's priorities.
+// @param string lynkName - The name of the lynk
+// @return string - The file's path
+func (c *Client) prioritiesPath(lynkName string) string {
+	return c.Home + prioritiesName + "/" + lynkName + ".json"
+}
+
+// IsDownloading - Returns whether or not the client associated the specified lynk is downloading
+// @param lynkName - the name of the lynk
+// @returns - Returns whether or not the client associated the specified lynk is downloading
+func (c *Client) IsDownloading(lynkName string) bool {
+	return c.GetQueueState(lynkName).State == QueueDownloading
+}
+
+// StopDownload - Stops the lynk from downloading
+// @param lynkName - the name of the lynk
+func (c *Client) StopDownload(lynkName string) {
+	c.CancelLynk(lynkName)
+}
+
+// Starts a run of a lynk's queue. Only one run of a lynk happens at a time - a run asked for while
+// another is going is folded into it.
+// @param string lynkName - The name of the lynk
+// @return context.Context - Cancelled when the run's first pass is paused or cancelled, or nil if
+// another run is going
+// @return error - An error is produced if the lynk is paused
+func (c *Client) beginRun(lynkName string) (context.Context, error) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	if q.paused {
+		return nil, errors.New(lynkName + " Is Paused")
+	} else if q.running {
+		q.again = true
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.running, q.again, q.cancel, q.done = true, false, cancel, 0
+	return ctx, nil
+}
+
+// Finishes a pass over a lynk's files. The paused state is checked again here, as the lynk may
+// have been paused and resumed while the pass was stopping.
+// @param context.Context ctx - The pass's context
+// @param string lynkName - The name of the lynk
+// @return context.Context - The context of the next pass, or nil if the run is over - the lynk is
+// paused, or nothing changed during the pass
+func (c *Client) endPass(ctx context.Context, lynkName string) context.Context {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	q.pending = nil
+	if q.again && !q.paused {
+		q.again = false
+		if ctx.Err() != nil { // The pass was cancelled, so the next one needs a fresh context
+			ctx, q.cancel = context.WithCancel(context.Background())
+		}
+		return ctx
+	}
+
+	q.running, q.again = false, false
+	q.cancel()
+	return nil
+}
+
+// Sets the files a pass over a lynk will download.
+// @param string lynkName - The name of the lynk
+// @param []string pending - The files' paths inside the lynk
+func (c *Client) setPending(lynkName string, pending []string) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	c.getQueue(lynkName).pending = pending
+}
+
+// Returns the files still waiting in the order they will be downloaded - by priority, and then
+// in meta.info order. Callers must hold queuesMu.
+// @return []string - The files' paths inside the lynk
+func (q *lynkQueue) ordered() []string {
+	ordered := append([]string{}, q.pending...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return q.priorities[ordered[i]] > q.priorities[ordered[j]]
+	})
+	return ordered
+}
+
+// Returns whether a lynk has a waiting file that isn't paused.
+// @param string lynkName - The name of the lynk
+// @return bool - True if nextFile would hand out a file
+func (c *Client) hasNext(lynkName string) bool {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	for _, relPath := range q.pending {
+		if !q.pausedFiles[relPath] {
+			return true
+		}
+	}
+	return false
+}
+
+// Takes the waiting file with the highest priority off a lynk's queue to download it. Paused
+// files are skipped.
+// @param context.Context ctx - The run's context
+// @param string lynkName - The name of the lynk
+// @return string - The file's path inside the lynk
+// @return context.Context - Cancelled when the file or the run is paused or cancelled, or nil if
+// no file is left to download
+func (c *Client) nextFile(ctx context.Context, lynkName string) (string, context.Context) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	for _, relPath := range q.ordered() {
+		i := 0
+		for q.pending[i] != relPath {
+			i++
+		}
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+
+		if !q.pausedFiles[relPath] {
+			fileCtx, cancel := context.WithCancel(ctx)
+			q.active[relPath] = cancel
+			return relPath, fileCtx
+		}
+	}
+	return "", nil
+}
+
+// Records that a file being downloaded is finished, or has stopped.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @param bool ok - True if the file was downloaded
+func (c *Client) finishFile(lynkName, relPath string, ok bool) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+	q := c.getQueue(lynkName)
+
+	if cancel, found := q.active[relPath]; found {
+		cancel()
+		delete(q.active, relPath)
+	}
+	if ok {
+		q.done++
+	}
+}
+
+// cancelConn - A connection to a peer that is closed as soon as its context is cancelled, which
+// stops any read or write in flight on it.
+type cancelConn struct {
+	net.Conn
+	once sync.Once
+	stop chan struct{}
+}
+
+// Connects to a peer for as long as a context lasts.
+// @param context.Context ctx - The context
+// @param lynxutil.Peer peer - The peer
+// @return net.Conn - The connection
+// @return error - An error is produced if the peer can't be reached or ctx is already cancelled
+func dialPeer(ctx context.Context, peer lynxutil.Peer) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", peer.IP+":"+peer.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cancelConn{Conn: conn, stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-c.stop:
+		}
+	}()
+	return c, nil
+}
+
+// Close - Closes the connection.
+// @return error - Any error from closing the connection
+func (c *cancelConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.stop)
+		err = c.Conn.Close()
+	})
+	return err
+}
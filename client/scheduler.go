@@ -0,0 +1,142 @@
+// The transfer scheduler for the client - caps how many files are downloaded at once, in total and
+// per lynk, and hands free download slots to lynks fairly so a big lynk can't starve small ones.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// MaxDownloads - The default number of files downloaded at once across every lynk
+const MaxDownloads = 4
+
+// MaxLynkDownloads - The default number of files of a single lynk downloaded at once
+const MaxLynkDownloads = 2
+
+// slotRequest - A lynk waiting for a download slot.
+type slotRequest struct {
+	lynkName string
+	seq      int // Lower numbers have been waiting longer
+	granted  chan struct{}
+}
+
+// scheduler - Hands out download slots. Whenever a slot frees up it goes to the waiting lynk with
+// the fewest downloads going, and between those to the one that has waited longest.
+type scheduler struct {
+	mu      sync.Mutex
+	total   int // Cap across every lynk
+	perLynk int // Cap for each lynk
+	active  map[string]int
+	running int
+	waiting []*slotRequest
+	nextSeq int
+}
+
+// SetDownloadLimits - Changes how many files are downloaded at once. Downloads already going
+// above a lowered limit are allowed to finish.
+// @param int total - The number of files downloaded at once across every lynk
+// @param int perLynk - The number of files of a single lynk downloaded at once
+func (c *Client) SetDownloadLimits(total, perLynk int) {
+	if total < 1 {
+		total = 1
+	}
+	if perLynk < 1 {
+		perLynk = 1
+	}
+
+	c.downloads.mu.Lock()
+	defer c.downloads.mu.Unlock()
+	c.downloads.total, c.downloads.perLynk = total, perLynk
+	c.downloads.dispatch()
+}
+
+// DownloadLimits - Returns how many files are downloaded at once.
+// @return int - The number of files downloaded at once across every lynk
+// @return int - The number of files of a single lynk downloaded at once
+func (c *Client) DownloadLimits() (int, int) {
+	c.downloads.mu.Lock()
+	defer c.downloads.mu.Unlock()
+	return c.downloads.total, c.downloads.perLynk
+}
+
+// Waits for a download slot for a lynk.
+// @param context.Context ctx - Cancelling it gives up waiting
+// @param string lynkName - The name of the lynk
+// @return func() - Frees the slot once the download is finished - it must be called exactly once
+// @return error - An error is produced if ctx is cancelled first
+func (s *scheduler) acquire(ctx context.Context, lynkName string) (func(), error) {
+	s.mu.Lock()
+	req := &slotRequest{lynkName: lynkName, seq: s.nextSeq, granted: make(chan struct{})}
+	s.nextSeq++
+	s.waiting = append(s.waiting, req)
+	s.dispatch()
+	s.mu.Unlock()
+
+	release := func() { s.release(lynkName) }
+	select {
+	case <-req.granted:
+		return release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.dropWaiting(req) {
+			// The slot was granted as we gave up - hand it straight on
+			s.active[lynkName]--
+			s.running--
+			s.dispatch()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Frees a lynk's download slot and hands it to the next lynk in line.
+// @param string lynkName - The name of the lynk
+func (s *scheduler) release(lynkName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[lynkName]--
+	s.running--
+	s.dispatch()
+}
+
+// Grants free slots to waiting lynks, fewest downloads going first - callers must hold s.mu.
+func (s *scheduler) dispatch() {
+	for s.running < s.total {
+		best := -1
+		for i, req := range s.waiting {
+			if s.active[req.lynkName] >= s.perLynk {
+				continue
+			}
+			if best == -1 || s.active[req.lynkName] < s.active[s.waiting[best].lynkName] ||
+				(s.active[req.lynkName] == s.active[s.waiting[best].lynkName] &&
+					req.seq < s.waiting[best].seq) {
+				best = i
+			}
+		}
+		if best == -1 {
+			return
+		}
+
+		req := s.waiting[best]
+		s.waiting = append(s.waiting[:best], s.waiting[best+1:]...)
+		s.active[req.lynkName]++
+		s.running++
+		close(req.granted)
+	}
+}
+
+// Removes a request from the waiting lynks - callers must hold s.mu.
+// @param *slotRequest req - The request
+// @return bool - False if the request had already been granted
+func (s *scheduler) dropWaiting(req *slotRequest) bool {
+	for i, r := range s.waiting {
+		if r == req {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+// Staging for the client - partially downloaded files live outside the lynk along with a bitmap of
+// the chunks received so far, so a restarted node resumes instead of starting over.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The directory inside a node's Home that holds every lynk's partially downloaded files
+const stagingName = ".staging"
+
+// stagedFile - A partially downloaded file along with a bitmap of the chunks that have been
+// received and verified. The bitmap is rewritten after every chunk so it survives a restart.
+type stagedFile struct {
+	mu         sync.Mutex
+	client     *Client // The client downloading the file
+	file       lynxutil.File
+	part       *os.File
+	have       []bool
+	partPath   string
+	bitmapPath string
+}
+
+// Returns the staging directory of a lynk.
+// @param string lynkName - The name of the lynk
+// @return string - The directory's path, ending in a slash
+func (c *Client) stagingDir(lynkName string) string {
+	return c.Home + stagingName + "/" + lynkName + "/"
+}
+
+// Opens or creates the staged copy of a file. An existing bitmap is only reused if it was written
+// for the same version of the file - otherwise the download starts over.
+// @param string lynkName - The name of the lynk the file belongs to
+// @param lynxutil.File file - The meta.info entry of the file
+// @return *stagedFile - The staged file, ready for chunks to be written into it
+// @return error - An error can be produced if the staging area cannot be created
+func (c *Client) openStaged(lynkName string, file lynxutil.File) (*stagedFile, error) {
+	s := &stagedFile{
+		client:     c,
+		file:       file,
+		partPath:   c.stagingDir(lynkName) + file.Path + ".part",
+		bitmapPath: c.stagingDir(lynkName) + file.Path + ".bitmap",
+	}
+	if err := os.MkdirAll(filepath.Dir(s.partPath), 0755); err != nil {
+		return nil, err
+	}
+	s.have = loadBitmap(s.bitmapPath, file.Hash, len(file.Chunks))
+
+	var err error
+	s.part, err = os.OpenFile(s.partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasAny(s.have) {
+		// Nothing usable from a previous run - throw away whatever was there
+		err = s.part.Truncate(0)
+	}
+	if err == nil {
+		err = s.part.Truncate(int64(file.Length))
+	}
+	if err != nil {
+		s.part.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Writes a verified chunk into the staged file and records it in the bitmap. The chunk is synced
+// to disk before the bitmap claims to have it.
+// @param int index - The chunk's index
+// @param []byte data - The chunk's contents
+// @return error - An error can be produced if the chunk or bitmap cannot be written
+func (s *stagedFile) writeChunk(index int, data []byte) error {
+	if _, err := s.part.WriteAt(data, int64(index)*int64(s.file.ChunkLength)); err != nil {
+		return err
+	}
+	if err := s.part.Sync(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.have[index] = true
+	return saveBitmap(s.bitmapPath, s.file.Hash, s.have)
+}
+
+// Checks the whole staged file against its hash and moves it into place in a single rename.
+// @param string lynkName - The name of the lynk the finished file belongs in
+// @return error - An error is produced if the file fails verification or cannot be moved
+func (s *stagedFile) finish(lynkName string) error {
+	if err := s.part.Close(); err != nil {
+		return err
+	}
+
+	_, hash, err := lynxutil.HashFile(s.partPath, s.file.ChunkLength)
+	if err != nil {
+		return err
+	} else if hash != s.file.Hash {
+		// Something on disk went bad - start over next time rather than resume from it
+		os.Remove(s.bitmapPath)
+		return errors.New(s.file.Name + " Failed Verification")
+	}
+
+	if err = s.client.moveIntoLynk(s.partPath, lynkName, s.file.Path); err != nil {
+		return err
+	}
+	return os.Remove(s.bitmapPath)
+}
+
+// Moves a finished file out of the staging area into its place in a lynk, creating any folders
+// it lives in. Staging lives under the node's Home so this is a rename on the same filesystem -
+// never a copy - and the file appears in the lynk all at once. The copy it replaces is kept as an
+// old version.
+// @param string partPath - The finished file in the staging area
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @return error - An error is produced if the folders cannot be created or the rename fails
+func (c *Client) moveIntoLynk(partPath, lynkName, relPath string) error {
+	dst := c.Home + lynkName + "/" + relPath
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := c.saveVersion(lynkName, relPath); err != nil {
+		return err
+	}
+	return os.Rename(partPath, dst)
+}
+
+// Closes the staged file, leaving it and its bitmap behind so the download can be resumed.
+// @return error - An error can be produced if the file cannot be closed
+func (s *stagedFile) close() error {
+	return s.part.Close()
+}
+
+// Reads the bitmap of a staged file.
+// @param string bitmapPath - The path to the bitmap
+// @param string hash - The whole-file hash the bitmap must have been written for
+// @param int numChunks - The number of chunks in the file
+// @return []bool - The chunks that have been received - all false if the bitmap is missing,
+// malformed, or was written for a different version of the file
+func loadBitmap(bitmapPath, hash string, numChunks int) []bool {
+	have := make([]bool, numChunks)
+
+	bitmapFile, err := os.Open(bitmapPath)
+	if err != nil {
+		return have
+	}
+	defer bitmapFile.Close()
+
+	scanner := bufio.NewScanner(bitmapFile)
+	scanner.Buffer(nil, numChunks+64) // One character per chunk
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != hash || !scanner.Scan() {
+		return have
+	}
+
+	bits := strings.TrimSpace(scanner.Text())
+	if len(bits) != numChunks {
+		return have
+	}
+	for i, c := range bits {
+		have[i] = c == '1'
+	}
+	return have
+}
+
+// Writes the bitmap of a staged file, replacing the old one in a single rename so a crash never
+// leaves a half-written bitmap behind.
+// @param string bitmapPath - The path to the bitmap
+// @param string hash - The whole-file hash of the version being downloaded
+// @param []bool have - The chunks that have been received
+// @return error - An error can be produced if the bitmap cannot be written
+func saveBitmap(bitmapPath, hash string, have []bool) error {
+	tmpFile, err := os.Create(bitmapPath + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpFile.WriteString(hash + "\n" + bitsString(have) + "\n")
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(bitmapPath+".tmp", bitmapPath)
+}
+
+// Returns the chunks of a file that have been received into the staging area so far.
+// @param string lynkName - The name of the lynk the file belongs to
+// @param lynxutil.File file - The meta.info entry of the file
+// @return []bool - The staged chunks
+func (c *Client) stagedChunks(lynkName string, file lynxutil.File) []bool {
+	return loadBitmap(c.stagingDir(lynkName)+file.Path+".bitmap", file.Hash, len(file.Chunks))
+}
+
+// Turns a bitmap into the string of '0's and '1's used on disk and on the wire.
+// @param []bool have - The bitmap
+// @return string - One character per chunk
+func bitsString(have []bool) string {
+	bits := make([]byte, len(have))
+	for i, h := range have {
+		bits[i] = '0'
+		if h {
+			bits[i] = '1'
+		}
+	}
+	return string(bits)
+}
+
+// Returns whether any chunk in a bitmap is set.
+// @param []bool have - The bitmap
+// @return bool - True if at least one chunk has been received
+func hasAny(have []bool) bool {
+	for _, h := range have {
+		if h {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads a single chunk out of the staging area so a partial download can be shared with peers.
+// @param string lynkName - The name of the lynk the file belongs to
+// @param lynxutil.File file - The meta.info entry of the file
+// @param int index - The chunk to read
+// @return []byte - The chunk's contents
+// @return error - An error is produced if the chunk has not been received yet
+func (c *Client) readStagedChunk(lynkName string, file lynxutil.File, index int) ([]byte, error) {
+	have := c.stagedChunks(lynkName, file)
+	if index < 0 || index >= len(have) || !have[index] {
+		return nil, errors.New("Chunk " + strconv.Itoa(index) + " Has Not Been Received")
+	}
+
+	return readChunkAt(c.stagingDir(lynkName)+file.Path+".part", file, index)
+}
@@ -0,0 +1,421 @@
+// Swarm downloading for the client - a file is split into its meta.info chunks and different
+// chunks are requested from several peers at once, rarest chunks first.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The maximum number of peers a single file is downloaded from at the same time
+const maxSwarmPeers = 8
+
+// chunkPicker - Hands out chunks to the peers downloading a file. Chunks are picked rarest-first
+// and, once every remaining chunk has been requested, in endgame mode where outstanding chunks
+// are requested from more than one peer so a single slow peer cannot hold up the download.
+type chunkPicker struct {
+	mu        sync.Mutex
+	avail     []int              // How many peers have each chunk
+	done      []bool             // Which chunks have been received and verified
+	requests  map[int][]net.Conn // The connections currently fetching each chunk
+	remaining int
+}
+
+// Creates a chunkPicker for a file given the bitfield each peer reported.
+// @param int numChunks - the number of chunks in the file
+// @param [][]bool bitfields - the chunks each peer has - a nil entry is a peer without the file
+// @return *chunkPicker - the new picker
+func newChunkPicker(numChunks int, bitfields [][]bool) *chunkPicker {
+	p := &chunkPicker{
+		avail:     make([]int, numChunks),
+		done:      make([]bool, numChunks),
+		requests:  make(map[int][]net.Conn),
+		remaining: numChunks,
+	}
+
+	for _, has := range bitfields {
+		i := 0
+		for i < len(has) && i < numChunks {
+			if has[i] {
+				p.avail[i]++
+			}
+			i++
+		}
+	}
+
+	return p
+}
+
+// Picks the next chunk a peer should download and records conn as fetching it.
+// @param []bool has - the chunks the peer has
+// @param net.Conn conn - the connection the chunk will be fetched over
+// @return int - the chunk index, or -1 if the peer has nothing left worth fetching
+func (p *chunkPicker) next(has []bool, conn net.Conn) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := -1
+	// Rarest-first over the chunks nobody is fetching yet
+	for i := range p.done {
+		if has[i] && !p.done[i] && len(p.requests[i]) == 0 &&
+			(best == -1 || p.avail[i] < p.avail[best]) {
+			best = i
+		}
+	}
+
+	// Endgame - every remaining chunk is already requested, so double up on the least requested
+	if best == -1 {
+		for i := range p.done {
+			if has[i] && !p.done[i] && (best == -1 || len(p.requests[i]) < len(p.requests[best])) {
+				best = i
+			}
+		}
+	}
+
+	if best != -1 {
+		p.requests[best] = append(p.requests[best], conn)
+	}
+	return best
+}
+
+// Removes conn from the connections fetching a chunk.
+// @param int index - the chunk
+// @param net.Conn conn - the connection that stopped fetching it
+// @return bool - true if the chunk was already finished by another peer
+func (p *chunkPicker) release(index int, conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dropRequest(index, conn)
+	return p.done[index]
+}
+
+// Marks a chunk as received and cancels any duplicate requests for it.
+// @param int index - the chunk
+// @param net.Conn conn - the connection it was received over
+// @return bool - true if this is the first copy of the chunk and it should be written out
+func (p *chunkPicker) complete(index int, conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dropRequest(index, conn)
+	if p.done[index] {
+		return false
+	}
+
+	p.done[index] = true
+	p.remaining--
+	for _, c := range p.requests[index] {
+		c.Close() // Endgame duplicates are no longer needed
+	}
+	delete(p.requests, index)
+
+	return true
+}
+
+// Helper that removes conn from a chunk's outstanding requests - callers must hold p.mu.
+// @param int index - the chunk
+// @param net.Conn conn - the connection to remove
+func (p *chunkPicker) dropRequest(index int, conn net.Conn) {
+	conns := p.requests[index]
+	i := 0
+	for i < len(conns) {
+		if conns[i] == conn {
+			conns = append(conns[:i], conns[i+1:]...)
+		} else {
+			i++
+		}
+	}
+	p.requests[index] = conns
+}
+
+// Marks a chunk as already received - used for chunks left in the staging area by an earlier run.
+// @param int index - the chunk
+func (p *chunkPicker) markDone(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.done[index] {
+		p.done[index] = true
+		p.remaining--
+	}
+}
+
+// Puts a chunk back up for download after it could not be saved.
+// @param int index - the chunk
+func (p *chunkPicker) markUndone(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done[index] {
+		p.done[index] = false
+		p.remaining++
+	}
+}
+
+// Returns whether every chunk has been received.
+// @return bool - true once the download is finished
+func (p *chunkPicker) isDone() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.remaining == 0
+}
+
+// Downloads a file from every peer that has some of it. Verified chunks are written into the
+// staging area as they arrive, so an interrupted download resumes from where it left off, and the
+// finished file is moved into the lynk in one step.
+// @param context.Context ctx - Cancelling it stops the download, leaving what was received staged
+// @param string lynkName - The name of the lynk the file belongs to
+// @param lynxutil.File file - The meta.info entry of the file
+// @param []lynxutil.Peer peers - The peers of the lynk
+// @return error - An error is produced if the file could not be fully received
+func (c *Client) swarmDownload(ctx context.Context, lynkName string, file lynxutil.File,
+	peers []lynxutil.Peer) error {
+	staged, err := c.openStaged(lynkName, file)
+	if err != nil {
+		return err
+	}
+
+	// Asks every peer which chunks it has
+	bitfields := make([][]bool, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer lynxutil.Peer) {
+			defer wg.Done()
+			bitfields[i] = askForBitfield(ctx, lynkName, file, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	picker := newChunkPicker(len(file.Chunks), bitfields)
+	for i, have := range staged.have {
+		if have {
+			picker.markDone(i)
+		}
+	}
+
+	if picker.remaining < len(file.Chunks) {
+		fmt.Println("Resuming: " + file.Name + " With " + strconv.Itoa(picker.remaining) +
+			" Chunks Left")
+	}
+	fmt.Println("Downloading: " + file.Name + " From " + strconv.Itoa(len(peers)) + " Peers")
+
+	started := 0
+	for i, peer := range peers {
+		if bitfields[i] != nil && started < maxSwarmPeers {
+			wg.Add(1)
+			go func(peer lynxutil.Peer, has []bool) {
+				defer wg.Done()
+				c.fetchChunks(ctx, lynkName, file, peer, has, staged, picker)
+			}(peer, bitfields[i])
+			started++
+		}
+	}
+	wg.Wait()
+
+	if !picker.isDone() {
+		staged.close() // Leaves the staged chunks behind for next time
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.New("Did not receive file")
+	}
+
+	return staged.finish(lynkName)
+}
+
+// Worker that keeps downloading chunks from a single peer until the picker has nothing left for
+// it, the peer fails or the download is stopped.
+// @param context.Context ctx - Cancelling it stops the worker
+// @param string lynkName - The name of the lynk the file belongs to
+// @param lynxutil.File file - The meta.info entry of the file
+// @param lynxutil.Peer peer - The peer to download from
+// @param []bool has - The chunks the peer has
+// @param *stagedFile staged - The staged file verified chunks are written into
+// @param *chunkPicker picker - The picker shared by every worker of this download
+func (c *Client) fetchChunks(ctx context.Context, lynkName string, file lynxutil.File,
+	peer lynxutil.Peer, has []bool, staged *stagedFile, picker *chunkPicker) {
+	for !picker.isDone() && ctx.Err() == nil {
+		conn, err := dialPeer(ctx, peer)
+		if err != nil {
+			return
+		}
+
+		index := picker.next(has, conn)
+		if index == -1 {
+			conn.Close()
+			return
+		}
+
+		data, err := c.askForChunk(lynkName, file, index, conn)
+		conn.Close()
+		if err == nil {
+			err = lynxutil.VerifyChunk(data, index, file)
+		}
+
+		if err != nil {
+			// A chunk finished elsewhere means our duplicate request was cancelled - keep going
+			if picker.release(index, conn) {
+				continue
+			} else if ctx.Err() != nil {
+				return // Stopped - the chunk stays up for grabs next time
+			}
+			fmt.Println("Dropping Peer " + peer.IP + ": " + err.Error())
+			return
+		}
+
+		if picker.complete(index, conn) {
+			if err = staged.writeChunk(index, data); err != nil {
+				picker.markUndone(index)
+				fmt.Println(err)
+				return
+			}
+		}
+	}
+}
+
+// Asks a peer which chunks of a file it has.
+// @param context.Context ctx - Cancelling it stops the request
+// @param string lynkName - The name of the lynk we're asking about
+// @param lynxutil.File file - The meta.info entry of the file
+// @param lynxutil.Peer peer - The peer to ask
+// @return []bool - The chunks the peer has, or nil if it has none of them or could not be reached
+func askForBitfield(ctx context.Context, lynkName string, file lynxutil.File,
+	peer lynxutil.Peer) []bool {
+	conn, err := dialPeer(ctx, peer)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "Bitfield_Request:"+lynkName+"/"+file.Path+"\n")
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	reply = strings.TrimSpace(reply)
+	if err != nil || len(reply) != len(file.Chunks) || !strings.Contains(reply, "1") {
+		return nil
+	}
+
+	has := make([]bool, len(reply))
+	for i, c := range reply {
+		has[i] = c == '1'
+	}
+	return has
+}
+
+// Asks a peer for a single chunk of a file.
+// @param string lynkName - The name of the lynk we're asking about
+// @param lynxutil.File file - The meta.info entry of the file
+// @param int index - The chunk we want
+// @param net.Conn conn - The connection to the peer
+// @return []byte - The chunk's contents, not yet verified
+// @return error - An error is produced if the peer does not have the chunk or the transfer fails
+func (c *Client) askForChunk(lynkName string, file lynxutil.File, index int,
+	conn net.Conn) ([]byte, error) {
+	fmt.Fprintf(conn, "Chunk_Request:"+lynkName+"/"+file.Path+":"+strconv.Itoa(index)+"\n")
+	fmt.Fprintln(conn, c.KeyFingerprint) // The peer wraps the chunk's session key for our key
+
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	} else if strings.TrimSpace(reply) != "YES" {
+		return nil, errors.New("Peer Does Not Have Chunk " + strconv.Itoa(index))
+	}
+
+	// A chunk is never longer than its file's chunk length - anything more is a misbehaving peer
+	var chunk bytes.Buffer
+	limit := &lynxutil.LimitedWriter{W: &chunk, N: int64(file.ChunkLength)}
+	if err = c.ReceiveStream(c.DownloadReader(reader, lynkName, conn), limit); err != nil {
+		return nil, err
+	}
+	return chunk.Bytes(), nil
+}
+
+// GetBitfield - Describes which chunks of a file we can serve, one character per chunk. Chunks
+// of a file we are still downloading are included so peers can fetch them from us early.
+// @param string filePath - The file including its lynk name. E.G. - 'Cool_Lynk/docs/coolFile.txt'
+// @return string - '1' for every chunk we have and '0' for every chunk we don't, or "NO" if we
+// don't know the file at all
+func (c *Client) GetBitfield(filePath string) string {
+	if !c.HaveFile(filePath) {
+		return "NO"
+	}
+
+	lynkName, relPath, _ := SplitFilePath(filePath)
+	file, ok := c.Lynks.GetFile(lynkName, relPath)
+	if !ok {
+		return "NO"
+	}
+
+	// Our copy only has every chunk if it is the version in the meta.info, not just the same size
+	if c.upToDate(lynkName, []lynxutil.File{file})[relPath] {
+		return strings.Repeat("1", len(file.Chunks))
+	}
+	return bitsString(c.stagedChunks(lynkName, file))
+}
+
+// ReadChunk - Reads a single chunk of a file we are sharing, or of a file we are still
+// downloading if that chunk has already been received.
+// @param string filePath - The file including its lynk name. E.G. - 'Cool_Lynk/docs/coolFile.txt'
+// @param int index - The chunk to read
+// @return []byte - The chunk's contents
+// @return error - An error is produced if we don't have the file or the chunk is out of range
+func (c *Client) ReadChunk(filePath string, index int) ([]byte, error) {
+	if !c.HaveFile(filePath) {
+		return nil, errors.New("Do Not Have " + filePath)
+	}
+
+	lynkName, relPath, _ := SplitFilePath(filePath)
+	file, ok := c.Lynks.GetFile(lynkName, relPath)
+	if !ok {
+		return nil, errors.New("Do Not Have " + filePath)
+	}
+
+	if !c.upToDate(lynkName, []lynxutil.File{file})[relPath] {
+		return c.readStagedChunk(lynkName, file, index)
+	}
+	return readChunkAt(c.Home+lynkName+"/"+relPath, file, index)
+}
+
+// Reads a single chunk out of a file on disk.
+// @param string path - The file to read from
+// @param lynxutil.File file - The meta.info entry describing the file's chunks
+// @param int index - The chunk to read
+// @return []byte - The chunk's contents
+// @return error - An error is produced if the chunk is out of range or cannot be read
+func readChunkAt(path string, file lynxutil.File, index int) ([]byte, error) {
+	if index < 0 || index >= len(file.Chunks) {
+		return nil, errors.New("Chunk " + strconv.Itoa(index) + " Is Out Of Range")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	start := index * file.ChunkLength
+	size := file.ChunkLength
+	if start+size > file.Length {
+		size = file.Length - start
+	}
+
+	data := make([]byte, size)
+	if _, err = f.ReadAt(data, int64(start)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
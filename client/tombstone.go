@@ -0,0 +1,76 @@
+// Tombstones for the client - files deleted from a lynk are moved into the versions store on every
+// peer, where they can be restored from, unless the peer changed its copy after the file was
+// deleted.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ApplyTombstones - Moves every file a lynk's meta.info says was deleted into the versions store,
+// like a file deleted here. A file that was changed here after it was deleted is kept.
+// @param string lynkName - The name of the lynk
+// @return error - An error can be produced if a file cannot be moved into the versions store
+func (c *Client) ApplyTombstones(lynkName string) error {
+	defer c.LockMeta(lynkName)()
+	return c.applyTombstones(lynkName)
+}
+
+// Helper function for ApplyTombstones and MergePush - puts the lynk's deleted files away. The
+// caller holds the lynk's meta.info lock.
+// @param string lynkName - The name of the lynk
+// @return error - An error is produced if a file cannot be moved into the versions store
+func (c *Client) applyTombstones(lynkName string) error {
+	lynk, ok := c.Lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk Not Found")
+	}
+
+	var err error
+	for _, t := range lynk.Tombstones {
+		if lynxutil.GetFile(lynk.Files, t.Path) != nil {
+			continue // The file has been added back since it was deleted
+		}
+
+		// A partial download of a deleted file is no longer needed
+		os.Remove(c.stagingDir(lynkName) + t.Path + ".part")
+		os.Remove(c.stagingDir(lynkName) + t.Path + ".bitmap")
+
+		localPath := c.Home + lynkName + "/" + t.Path
+		info, statErr := os.Stat(localPath)
+		if statErr != nil || info.IsDir() {
+			continue
+		}
+
+		if changedAfter(localPath, info, t) {
+			fmt.Println("Keeping " + t.Path + " - it was changed after it was deleted")
+		} else if tErr := c.saveVersion(lynkName, t.Path); tErr != nil {
+			fmt.Println(tErr)
+			err = tErr
+		}
+	}
+
+	return err
+}
+
+// Returns whether a local file was changed after the tombstone was written. The deleted version
+// itself is never considered changed, whatever its modification time.
+// @param string localPath - The local copy of the file
+// @param os.FileInfo info - The local copy's info
+// @param lynxutil.Tombstone t - The file's tombstone
+// @return bool - True if the local copy should be kept
+func changedAfter(localPath string, info os.FileInfo, t lynxutil.Tombstone) bool {
+	if !info.ModTime().After(time.Unix(t.Deleted, 0)) {
+		return false
+	}
+
+	_, hash, err := lynxutil.HashFile(localPath, lynxutil.ChunkLength)
+	return err != nil || hash != t.Hash
+}
@@ -0,0 +1,216 @@
+// Version history for the client - whenever Lynx replaces or deletes a file in a lynk, the old
+// copy is kept in a hidden versions store so it can be restored later.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package client
+
+import (
+	"../lynxutil"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The directory inside a node's Home that holds every lynk's old versions
+const versionsName = ".versions"
+
+// MaxVersions - How many old versions of each file are kept
+const MaxVersions = 10
+
+// MaxVersionAge - How long an old version is kept
+const MaxVersionAge = 30 * 24 * time.Hour
+
+// PruneInterval - How often every lynk's versions should be pruned, so versions of files that
+// are never saved again still age out
+const PruneInterval = 24 * time.Hour
+
+// Version - An old version of a file kept in the versions store.
+type Version struct {
+	ID     string // Identifies the version when restoring it
+	Saved  time.Time
+	Length int64
+	Hash   string // SHA-256 hex digest of the version
+}
+
+// Returns the directory that holds the old versions of a file.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @return string - The directory's path, ending in a slash
+func (c *Client) versionsDir(lynkName, relPath string) string {
+	return c.Home + versionsName + "/" + lynkName + "/" + relPath + "/"
+}
+
+// Moves a file in a lynk into its versions store before Lynx replaces or deletes it. A file whose
+// contents are already the newest saved version is simply removed.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @return error - An error can be produced if the file cannot be hashed or moved
+func (c *Client) saveVersion(lynkName, relPath string) error {
+	path := c.Home + lynkName + "/" + relPath
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return nil // Nothing to save
+	}
+
+	_, hash, err := lynxutil.HashFile(path, lynxutil.ChunkLength)
+	if err != nil {
+		return err
+	}
+
+	versions, _ := c.ListVersions(lynkName, relPath)
+	if len(versions) > 0 && versions[0].Hash == hash {
+		return os.Remove(path)
+	}
+
+	dir := c.versionsDir(lynkName, relPath)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + hash
+	if err = os.Rename(path, dir+id); err != nil {
+		return err
+	}
+
+	return c.pruneVersions(lynkName, relPath)
+}
+
+// ListVersions - Lists the old versions of a file that can be restored.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @return []Version - The versions, newest first
+// @return error - An error can be produced if the versions store can't be read
+func (c *Client) ListVersions(lynkName, relPath string) ([]Version, error) {
+	entries, err := ioutil.ReadDir(c.versionsDir(lynkName, relPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	versions := []Version{}
+	for _, entry := range entries {
+		split := strings.SplitN(entry.Name(), "-", 2)
+		nanos, err := strconv.ParseInt(split[0], 10, 64)
+		if entry.IsDir() || len(split) != 2 || err != nil {
+			continue // A folder of versions for a file inside a folder of the same name
+		}
+		versions = append(versions, Version{ID: entry.Name(), Saved: time.Unix(0, nanos),
+			Length: entry.Size(), Hash: split[1]})
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Saved.After(versions[j].Saved) })
+	return versions, nil
+}
+
+// RestoreVersion - Puts an old version of a file back into the lynk. The copy it replaces is saved
+// as a version first, so a restore can be undone. Peers get the restored file like any other edit.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @param string id - The ID of the version to restore
+// @return error - An error is produced if the version doesn't exist or cannot be copied
+func (c *Client) RestoreVersion(lynkName, relPath, id string) error {
+	if strings.ContainsAny(id, "/\\") {
+		return errors.New("Invalid Version")
+	}
+	src := c.versionsDir(lynkName, relPath) + id
+	if _, err := os.Stat(src); err != nil {
+		return errors.New("Version " + id + " Of " + relPath + " Not Found")
+	}
+
+	// Copies into staging first so the restored file appears in the lynk all at once
+	tmpPath := c.stagingDir(lynkName) + relPath + ".restore"
+	if err := os.MkdirAll(filepath.Dir(tmpPath), 0755); err != nil {
+		return err
+	}
+	if err := lynxutil.FileCopy(src, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err := c.moveIntoLynk(tmpPath, lynkName, relPath) // Saves the copy being replaced
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// Deletes the versions of a file that are past the retention limits.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @return error - An error can be produced if the versions store can't be read
+func (c *Client) pruneVersions(lynkName, relPath string) error {
+	versions, err := c.ListVersions(lynkName, relPath)
+	if err != nil {
+		return err
+	}
+
+	for i, v := range versions {
+		if i >= MaxVersions || time.Since(v.Saved) > MaxVersionAge {
+			os.Remove(c.versionsDir(lynkName, relPath) + v.ID)
+		}
+	}
+	return nil
+}
+
+// PruneVersions - Deletes the versions past the retention limits of every file of every lynk,
+// including files that are no longer in their lynk, and removes the folders left empty.
+// @return error - An error can be produced if the versions store can't be read
+func (c *Client) PruneVersions() error {
+	root := c.Home + versionsName + "/"
+	dirs := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	// Deepest first, so a folder emptied of its versions is removed before its parent is checked
+	for i := len(dirs) - 1; i >= 0; i-- {
+		relPath, relErr := filepath.Rel(root, dirs[i])
+		split := strings.SplitN(filepath.ToSlash(relPath), "/", 2)
+		if relErr == nil && len(split) == 2 {
+			if pErr := c.pruneVersions(split[0], split[1]); pErr != nil {
+				err = pErr
+			}
+		}
+		if dirs[i]+"/" != root {
+			os.Remove(dirs[i]) // Only succeeds if it is empty
+		}
+	}
+	return err
+}
+
+// DeletedFiles - Lists the files that are gone from a lynk but still have old versions that can
+// be restored.
+// @param string lynkName - The name of the lynk
+// @return []string - The files' paths inside the lynk
+func (c *Client) DeletedFiles(lynkName string) []string {
+	lynk, _ := c.Lynks.Get(lynkName)
+	root := c.Home + versionsName + "/" + lynkName + "/"
+	deleted := []string{}
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil || relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+		versions, _ := c.ListVersions(lynkName, relPath)
+		if len(versions) > 0 && lynxutil.GetFile(lynk.Files, relPath) == nil {
+			deleted = append(deleted, relPath)
+		}
+		return nil
+	})
+	return deleted
+}
@@ -0,0 +1,322 @@
+// Package delta implements rsync-style delta transfer. A peer holding an old version of a file
+// sends a signature of its blocks, the peer holding the new version replies with the blocks it
+// can reuse and the bytes that changed, and the old version is patched into the new one.
+// @author: Michael Bruce
+// @author: Max Kernchen
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MinBlockLength - The shortest block a signature is made of
+const MinBlockLength = 2048
+
+// MaxBlockLength - The longest block a signature is made of
+const MaxBlockLength = 131072
+
+// MaxBlocks - The most blocks a signature may have - it keeps a misbehaving peer from making us
+// hold an enormous signature in memory
+const MaxBlocks = 1 << 20
+
+// The most changed bytes sent in a single literal operation
+const maxLiteral = 65536
+
+// The number of bytes of the SHA-256 digest kept as a block's strong checksum
+const strongLength = 16
+
+// Sum - The checksums of one block of the old version of a file.
+type Sum struct {
+	Weak   uint32 // Rolling checksum, cheap to slide along the new version a byte at a time
+	Strong string // Truncated SHA-256 hex digest, checked whenever the weak checksum matches
+}
+
+// BlockLength - Picks the block length for a file's signature. Like rsync it grows with the
+// square root of the file's length so neither the signature nor the blocks get too big.
+// @param int64 size - The length of the old version of the file
+// @return int - The block length
+func BlockLength(size int64) int {
+	n := int(math.Sqrt(float64(size)))
+	if n < MinBlockLength {
+		n = MinBlockLength
+	} else if n > MaxBlockLength {
+		n = MaxBlockLength
+	}
+	return n &^ 1023 // A whole number of kilobytes
+}
+
+// Signature - Computes the checksums of every block of the old version of a file.
+// @param io.Reader r - The old version
+// @param int blockLen - The block length
+// @return []Sum - The checksums, one per block - the last block may be short
+// @return error - An error is produced if r cannot be read
+func Signature(r io.Reader, blockLen int) ([]Sum, error) {
+	sums := []Sum{}
+	block := make([]byte, blockLen)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			a, b := weakSum(block[:n])
+			sums = append(sums, Sum{Weak: weak(a, b), Strong: strongSum(block[:n])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sums, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WriteSignature - Writes a signature as one line per block.
+// @param io.Writer w - Where the signature is written
+// @param []Sum sums - The signature
+// @return error - An error is produced if w fails
+func WriteSignature(w io.Writer, sums []Sum) error {
+	out := bufio.NewWriter(w)
+	for _, s := range sums {
+		fmt.Fprintf(out, "%08x %s\n", s.Weak, s.Strong)
+	}
+	return out.Flush()
+}
+
+// ReadSignature - Reads a signature written by WriteSignature.
+// @param *bufio.Reader r - Where the signature is read from
+// @param int count - The number of blocks in the signature
+// @return []Sum - The signature
+// @return error - An error is produced if the signature is too big or badly formed
+func ReadSignature(r *bufio.Reader, count int) ([]Sum, error) {
+	if count < 0 || count > MaxBlocks {
+		return nil, errors.New("Invalid Signature Length")
+	}
+
+	sums := make([]Sum, count)
+	i := 0
+	for i < count {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		split := strings.Fields(line)
+		if len(split) != 2 || len(split[1]) != 2*strongLength {
+			return nil, errors.New("Invalid Signature Syntax")
+		}
+		w, err := strconv.ParseUint(split[0], 16, 32)
+		if err != nil {
+			return nil, errors.New("Invalid Signature Syntax")
+		}
+		sums[i] = Sum{Weak: uint32(w), Strong: split[1]}
+		i++
+	}
+	return sums, nil
+}
+
+// Diff - Compares the new version of a file against the signature of an old version and writes
+// the operations that turn the old version into the new one. The new version is read once,
+// sliding a rolling checksum along it a byte at a time, so memory use does not depend on its size.
+// @param []Sum sums - The signature of the old version
+// @param int blockLen - The block length the signature was made with
+// @param io.Reader r - The new version
+// @param io.Writer w - Where the operations are written
+// @return error - An error is produced if r cannot be read or w fails
+func Diff(sums []Sum, blockLen int, r io.Reader, w io.Writer) error {
+	if blockLen < MinBlockLength || blockLen > MaxBlockLength {
+		return errors.New("Invalid Block Length")
+	}
+
+	blocks := map[uint32][]int{}
+	for i, s := range sums {
+		blocks[s.Weak] = append(blocks[s.Weak], i)
+	}
+
+	in := bufio.NewReader(r)
+	out := bufio.NewWriter(w)
+
+	// data holds the changed bytes not yet sent followed by the window being checked
+	data := make([]byte, 0, maxLiteral+blockLen)
+	start := 0
+	eof := false
+
+	// Fills the window to a whole block, or as much of one as the new version has left
+	fill := func() error {
+		for !eof && len(data)-start < blockLen {
+			c, err := in.ReadByte()
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			} else {
+				data = append(data, c)
+			}
+		}
+		return nil
+	}
+
+	if err := fill(); err != nil {
+		return err
+	}
+	a, b := weakSum(data[start:])
+
+	for len(data) > start {
+		if match := findBlock(blocks, sums, weak(a, b), data[start:]); match != -1 {
+			writeLiteral(out, data[:start])
+			fmt.Fprintf(out, "C %d\n", match)
+			data = data[:0]
+			start = 0
+			if err := fill(); err != nil {
+				return err
+			}
+			a, b = weakSum(data)
+			continue
+		}
+
+		// No block starts here - the byte is sent as it is and the window slides along
+		x := uint32(data[start])
+		n := uint32(len(data) - start)
+		start++
+		c, err := in.ReadByte()
+		if err == nil {
+			data = append(data, c)
+			a = a - x + uint32(c)
+			b = b - n*x + a
+		} else if err == io.EOF {
+			eof = true
+			a -= x
+			b -= n * x
+		} else {
+			return err
+		}
+
+		if start >= maxLiteral {
+			writeLiteral(out, data[:start])
+			data = data[:copy(data, data[start:])]
+			start = 0
+		}
+	}
+
+	writeLiteral(out, data[:start])
+	return out.Flush()
+}
+
+// Patch - Rebuilds the new version of a file from the old version and the operations Diff wrote.
+// @param io.ReaderAt old - The old version
+// @param int blockLen - The block length the signature was made with
+// @param io.Reader ops - The operations
+// @param io.Writer w - Where the new version is written
+// @return int64 - The number of changed bytes that had to be sent
+// @return error - An error is produced if the operations are badly formed or refer to blocks the
+// old version doesn't have
+func Patch(old io.ReaderAt, blockLen int, ops io.Reader, w io.Writer) (int64, error) {
+	if blockLen < MinBlockLength || blockLen > MaxBlockLength {
+		return 0, errors.New("Invalid Block Length")
+	}
+
+	in := bufio.NewReader(ops)
+	block := make([]byte, blockLen)
+	var literal int64
+
+	for {
+		line, err := in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return literal, nil
+		} else if err != nil {
+			return literal, err
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil || n < 0 {
+			return literal, errors.New("Invalid Delta Syntax")
+		}
+
+		switch line[0] {
+		case 'C':
+			read, err := old.ReadAt(block, int64(n)*int64(blockLen))
+			if read == 0 || (err != nil && err != io.EOF) {
+				return literal, errors.New("Block " + strconv.Itoa(n) + " Is Out Of Range")
+			}
+			if _, err = w.Write(block[:read]); err != nil {
+				return literal, err
+			}
+		case 'L':
+			if n > maxLiteral {
+				return literal, errors.New("Invalid Delta Syntax")
+			}
+			if _, err = io.CopyN(w, in, int64(n)); err != nil {
+				return literal, err
+			}
+			literal += int64(n)
+		default:
+			return literal, errors.New("Invalid Delta Syntax")
+		}
+	}
+}
+
+// Returns the block of the old version the window matches, checking the strong checksum of every
+// block whose weak checksum matches.
+// @param map[uint32][]int blocks - The blocks of the signature by weak checksum
+// @param []Sum sums - The signature
+// @param uint32 w - The weak checksum of the window
+// @param []byte window - The window
+// @return int - The block's index, or -1 if no block matches
+func findBlock(blocks map[uint32][]int, sums []Sum, w uint32, window []byte) int {
+	candidates := blocks[w]
+	if len(candidates) == 0 {
+		return -1
+	}
+
+	strong := strongSum(window)
+	for _, i := range candidates {
+		if sums[i].Strong == strong {
+			return i
+		}
+	}
+	return -1
+}
+
+// Writes changed bytes as a literal operation, if there are any.
+// @param *bufio.Writer out - Where the operation is written
+// @param []byte data - The changed bytes - never more than maxLiteral
+func writeLiteral(out *bufio.Writer, data []byte) {
+	if len(data) > 0 {
+		fmt.Fprintf(out, "L %d\n", len(data))
+		out.Write(data)
+	}
+}
+
+// Computes the two halves of the rolling checksum of a block, as in rsync - a is the sum of the
+// bytes and b weights each byte by its distance from the end of the block.
+// @param []byte block - The block
+// @return uint32 - The first half
+// @return uint32 - The second half
+func weakSum(block []byte) (uint32, uint32) {
+	var a, b uint32
+	n := uint32(len(block))
+	for i, c := range block {
+		a += uint32(c)
+		b += (n - uint32(i)) * uint32(c)
+	}
+	return a, b
+}
+
+// Combines the two halves of the rolling checksum into the weak checksum.
+// @param uint32 a - The first half
+// @param uint32 b - The second half
+// @return uint32 - The weak checksum
+func weak(a, b uint32) uint32 {
+	return a&0xffff | b<<16
+}
+
+// Computes the strong checksum of a block.
+// @param []byte block - The block
+// @return string - The truncated SHA-256 hex digest
+func strongSum(block []byte) string {
+	sum := sha256.Sum256(block)
+	return hex.EncodeToString(sum[:strongLength])
+}
@@ -0,0 +1,111 @@
+// The unit tests for the delta package - signing a file's blocks, and diffing a changed file
+// against a signature and patching the old copy back into the new one
+// @author: Michael Bruce
+// @author: Max Kernchen
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 5
+
+// Diffs newData against a signature of oldData and patches oldData with the result.
+// @param []byte oldData - The old version
+// @param []byte newData - The new version
+// @return []byte - The rebuilt new version
+// @return int64 - The number of changed bytes that were sent
+// @return error - Any error from Diff or Patch
+func roundTrip(oldData, newData []byte) ([]byte, int64, error) {
+	blockLen := BlockLength(int64(len(oldData)))
+	sums, err := Signature(bytes.NewReader(oldData), blockLen)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ops, rebuilt bytes.Buffer
+	if err = Diff(sums, blockLen, bytes.NewReader(newData), &ops); err != nil {
+		return nil, 0, err
+	}
+	literal, err := Patch(bytes.NewReader(oldData), blockLen, &ops, &rebuilt)
+	return rebuilt.Bytes(), literal, err
+}
+
+// Unit tests for Diff and Patch.
+// @param *testing.T t - The wrapper for the test
+func TestDiffPatch(t *testing.T) {
+	fmt.Println("\n----------------TestDiffPatch----------------")
+
+	oldData := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(oldData)
+
+	// A single byte changed in place
+	newData := append([]byte{}, oldData...)
+	newData[500000] ^= 0xff
+	rebuilt, literal, err := roundTrip(oldData, newData)
+	if err != nil || !bytes.Equal(rebuilt, newData) || literal > int64(BlockLength(1<<20)) {
+		t.Error("Test failed, expected one block to be sent. Got ", literal, err)
+	} else {
+		fmt.Println("Successfully Sent Changed Block")
+		successful++
+	}
+
+	// Bytes inserted near the start shift every block after them
+	newData = append(append(append([]byte{}, oldData[:1000]...), []byte("inserted")...),
+		oldData[1000:]...)
+	rebuilt, literal, err = roundTrip(oldData, newData)
+	if err != nil || !bytes.Equal(rebuilt, newData) || literal > int64(2*BlockLength(1<<20)) {
+		t.Error("Test failed, expected shifted blocks to be found. Got ", literal, err)
+	} else {
+		fmt.Println("Successfully Found Shifted Blocks")
+		successful++
+	}
+
+	// Nothing in common - everything is sent
+	newData = make([]byte, 300000)
+	rand.New(rand.NewSource(2)).Read(newData)
+	rebuilt, literal, err = roundTrip(oldData, newData)
+	if err != nil || !bytes.Equal(rebuilt, newData) || literal != int64(len(newData)) {
+		t.Error("Test failed, expected the whole file to be sent. Got ", literal, err)
+	} else {
+		fmt.Println("Successfully Sent Whole File")
+		successful++
+	}
+}
+
+// Unit tests for writing and reading signatures.
+// @param *testing.T t - The wrapper for the test
+func TestSignature(t *testing.T) {
+	fmt.Println("\n----------------TestSignature----------------")
+
+	sums, _ := Signature(strings.NewReader(strings.Repeat("lynx", 1000)), MinBlockLength)
+	var buf bytes.Buffer
+	WriteSignature(&buf, sums)
+	read, err := ReadSignature(bufio.NewReader(&buf), len(sums))
+
+	if err != nil || len(read) != 2 || read[0] != sums[0] || read[1] != sums[1] {
+		t.Error("Test failed, expected the signature to be read back. Got ", read, err)
+	} else {
+		fmt.Println("Successfully Read Signature")
+		successful++
+	}
+
+	_, err = ReadSignature(bufio.NewReader(strings.NewReader("nothex abc\n")), 1)
+	if err == nil {
+		t.Error("Test failed, expected a bad signature to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Bad Signature")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -10,37 +10,36 @@ import (
 	"bufio"
 	"../client"
 	"../lynxutil"
+	"../metainfo"
 	"../server"
 	"../tracker"
+	"../watcher"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/jasonlvhit/gocron"
 	"github.com/skratchdot/open-golang/open"
 )
 
+// Watches our lynks' directories for changes
+var lynkWatcher *watcher.Watcher
+
+// How long a lynk's files must stay unchanged before its meta.info is refreshed
+const watchDebounce = 2 * time.Second
+
 // Holds our uploads html page
 var uploads []byte
 
 // Holds our downloads html page
 var downloads []byte
 
-// current form data that was submitted
-var form url.Values
-
-// Holds the currentLynk being checked for changes
-var currentLynk lynxutil.Lynk
-
-// Tells us whether or not our lynk's files have changed
-var changed = true
-
 // UserInput - A struct that we combine with our Go template to produce desired HTML
 type UserInput struct {
 	Name   string
@@ -57,6 +56,7 @@ type MyTemplate struct {
 	Entries    template.HTML
 	Files      template.HTML
 	FileHeader template.HTML
+	Settings   template.HTML
 	JSCode     template.JS
 }
 
@@ -67,14 +67,21 @@ func main() {
 
 // Launches our web server
 func launch() {
-
+	// The server and the tracker both load the default node, which every handler works on
+	if _, err := server.LoadDefault(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	} else if _, err = tracker.LoadDefault(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	// Checks to see if lynks.txt exists - if it doesn't it is created.
-	if _, err := os.Stat(lynxutil.HomePath + "/lynks.txt"); os.IsNotExist(err) {
-		os.Create(lynxutil.HomePath + "lynks.txt")
+	if _, err := os.Stat(lynxutil.Default.Home + "/lynks.txt"); os.IsNotExist(err) {
+		os.Create(lynxutil.Default.Home + "lynks.txt")
 	}
 
-	fmt.Println("Starting server on http://localhost:" + lynxutil.GUIPort)
+	fmt.Println("Starting server on http://localhost:" + lynxutil.Default.GUIPort)
 
 	fs := HTMLFiles{http.Dir("js/")}
 	http.Handle("/js/", http.StripPrefix("/js/", http.FileServer(fs)))
@@ -95,21 +102,34 @@ func launch() {
 	http.HandleFunc("/", SplashHandler)
 	http.HandleFunc("/files", FileHandler)
 	http.HandleFunc("/removefile", RemoveFileHandler)
+	http.HandleFunc("/restorefile", RestoreFileHandler)
+	http.HandleFunc("/queue", QueueHandler)
 
 	// Do jobs with params
-	//gocron.Every(30).Second().Do(checkLynks)
-	//<-gocron.Start()
-
 	// MK - open UI automatically on start of Lynx
-	open.Run("http://localhost:" + lynxutil.GUIPort)
+	open.Run("http://localhost:" + lynxutil.Default.GUIPort)
+
+	lynkWatcher = watcher.New(watchDebounce)
+	lynkWatcher.SetFilter(client.Default.Skips) // Changes to ignored files aren't synced anyway
+	go watchLynks()
 
-	go cronWrapper()
+	go pruneVersions()
 
-	go server.Listen()
+	go server.Default.Listen()
 
-	go tracker.Listen()
+	go tracker.Default.Listen()
 
-	http.ListenAndServe(":"+lynxutil.GUIPort, nil)
+	http.ListenAndServe(":"+lynxutil.Default.GUIPort, nil)
+}
+
+// Prunes every lynk's old versions when Lynx starts and then once every client.PruneInterval
+func pruneVersions() {
+	for {
+		if err := client.Default.PruneVersions(); err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(client.PruneInterval)
+	}
 }
 
 // Open - Method which is called when a new HTMLFiles struct is created it simply opens the
@@ -136,21 +156,22 @@ func IndexHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 	//t,_ = t.ParseFiles("index.html")
 	// get the table of lynks
-	tableEntries := TablePopulate(lynxutil.HomePath + "/lynks.txt")
-	//fmt.Println(client.GetFileTableIndex())
+	tableEntries := TablePopulate(lynxutil.Default.Home + "/lynks.txt")
+	//fmt.Println(client.Default.GetFileTableIndex())
 	// generate the js code for the lynks table
 	jsCode := JSLynkGenerate()
 	myTemp := new(MyTemplate)
 	// intialize our custom template with the lynks and the jscode for it
 	myTemp.Entries = template.HTML(tableEntries)
 	myTemp.JSCode = template.JS(jsCode)
+	myTemp.Settings = template.HTML(settingsForm(client.Default.GetFileTableIndex()))
 	// if a lynk was previously selected, reload the page with the last selected lynk
-	if client.GetFileTableIndex() > -1 {
+	if client.Default.GetFileTableIndex() > -1 {
 		// get our files table for a lynk
-		fileEntry := FilePopulate(client.GetFileTableIndex())
+		fileEntry := FilePopulate(client.Default.GetFileTableIndex())
 		myTemp.Files = template.HTML(fileEntry)
 		// get the header above the file table
-		fileHeader := FileHeader(client.GetFileTableIndex())
+		fileHeader := FileHeader(client.Default.GetFileTableIndex())
 		myTemp.FileHeader = template.HTML(fileHeader)
 		t.ExecuteTemplate(rw, "index.html", myTemp)
 
@@ -168,11 +189,12 @@ func IndexHandler(rw http.ResponseWriter, req *http.Request) {
 // @param *http.Request req - This is the http request sent to the server.
 func CreateHandler(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
-	form = req.Form
+	form := req.Form
 	name := form["Name"]
 
-	client.CreateMeta(name[0])
-	tracker.CreateSwarm(name[0])
+	client.Default.CreateMeta(name[0])
+	tracker.Default.CreateSwarm(name[0])
+	syncWatches()
 
 	IndexHandler(rw, req)
 }
@@ -183,12 +205,20 @@ func CreateHandler(rw http.ResponseWriter, req *http.Request) {
 // @param *http.Request req - This is the http request sent to the server.
 func JoinHandler(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
-	form = req.Form
+	form := req.Form
 	metapath := form["MetaPath"]
-	err := client.JoinLynk(metapath[0])
+	var err error
+	if strings.HasPrefix(metapath[0], metainfo.URIScheme+"://") {
+		err = client.Default.JoinURI(metapath[0])
+	} else if strings.HasSuffix(metapath[0], ".torrent") {
+		err = client.Default.JoinTorrent(metapath[0])
+	} else {
+		err = client.Default.JoinLynk(metapath[0])
+	}
 	if err != nil {
 		fmt.Println(err.Error())
 	}
+	syncWatches()
 
 	IndexHandler(rw, req)
 }
@@ -199,28 +229,63 @@ func JoinHandler(rw http.ResponseWriter, req *http.Request) {
 // @param *http.Request req - This is the http request sent to the server.
 func RemoveHandler(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
-	form = req.Form
+	form := req.Form
 	name := form["index"]
 	if name != nil {
 		index, _ := strconv.Atoi(name[0])
 		//fmt.Println("in here" + name[0])
 
-		client.DeleteLynk(client.GetLynkNameFromIndex(index), false)
+		client.Default.DeleteLynk(client.Default.GetLynkNameFromIndex(index), false)
+		syncWatches()
 		// make sure we dont try and load a just deleted lynk
-		client.SetFileTableIndex(-1)
-		TablePopulate(lynxutil.HomePath + "/lynks.txt")
+		client.Default.SetFileTableIndex(-1)
+		TablePopulate(lynxutil.Default.Home + "/lynks.txt")
 	}
 	IndexHandler(rw, req)
 }
 
-// SettingsHandler - Function that handles requests on the index page: "/settings".
+// SettingsHandler - Function that handles requests on the index page: "/settings". It changes
+// the bandwidth and download limits, which take effect on transfers that are already going.
+// Rates are given in KB/s and 0 means unlimited.
 // @param http.ResponseWriter rw - This is what we use to write our html back to
 // the web page.
 // @param *http.Request req - This is the http request sent to the server.
 func SettingsHandler(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
-	form = req.Form
-	//fmt.Println(form) //returns an array of strings
+	form := req.Form
+	if req.Method == "POST" {
+		limits := client.Default.GetLimits()
+		limits.Upload = kbRate(form.Get("upload"))
+		limits.Download = kbRate(form.Get("download"))
+		limits.MaxDownloads = downloadCap(form.Get("maxdownloads"), limits.MaxDownloads)
+		limits.MaxLynkDownloads = downloadCap(form.Get("maxlynkdownloads"),
+			limits.MaxLynkDownloads)
+
+		// The selected lynk's limits - the other lynks keep theirs
+		if lynkName := form.Get("lynk"); lynkName != "" {
+			rate := client.Rate{Upload: kbRate(form.Get("lynkupload")),
+				Download: kbRate(form.Get("lynkdownload"))}
+			if rate.Upload == 0 && rate.Download == 0 {
+				delete(limits.Lynks, lynkName)
+			} else {
+				limits.Lynks[lynkName] = rate
+			}
+		}
+
+		// Every peer's limits, one '<IP> <Upload> <Download>' per line
+		limits.Peers = map[string]client.Rate{}
+		for _, line := range strings.Split(form.Get("peers"), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 3 {
+				limits.Peers[fields[0]] = client.Rate{Upload: kbRate(fields[1]),
+					Download: kbRate(fields[2])}
+			}
+		}
+
+		if err := client.Default.SetLimits(limits); err != nil {
+			fmt.Println(err)
+		}
+	}
 	IndexHandler(rw, req)
 }
 
@@ -276,7 +341,7 @@ func TablePopulate(pathToTable string) string {
 		// the id of our table row
 		tableEntries += "<tr id= row" + rowStringNum + " > \n"
 		// change the color of the Lynk name when it is selected
-		if i == client.GetFileTableIndex() {
+		if i == client.Default.GetFileTableIndex() {
 			tableEntries += "<td><b style= \"color:blue;\">" + split[0] + "</b></td>\n"
 		} else {
 			tableEntries += "<td>" + split[0] + "</td>\n"
@@ -294,7 +359,7 @@ func TablePopulate(pathToTable string) string {
 		tableEntries += "</tr>\n"
 		i++
 	}
-	//client.ParseLynks(pathToTable)
+	//client.Default.ParseLynks(pathToTable)
 	return tableEntries
 }
 
@@ -326,23 +391,33 @@ func RemoveListPopulate(pathToTable string) string {
 // @param pathToTable - the lynk whose files we want to populate
 // @returns - a string containing all the file entries
 func FilePopulate(index int) string {
-	if index < client.GetLynksLen() {
+	if index < client.Default.GetLynksLen() {
 
-		client.PopulateFilesAndSize()
-		lynks := client.GetLynks()
+		client.Default.PopulateFilesAndSize()
+		lynks := client.Default.GetLynks()
 		fileEntries := ""
 		tempLynk := lynks[index]
 		//fmt.Println(tempLynk.Files)
 		//fmt.Println("file pop")
 		fileNames := tempLynk.Files
-		client.SetFileTableIndex(index)
+		client.Default.SetFileTableIndex(index)
+		queue := client.Default.GetQueueState(tempLynk.Name)
 		i := 0
 
 		for i < len(fileNames) {
 			// the file name and size on one row and the its delete icon on the last one
 			fileEntries += "<tr> \n"
-			fileEntries += "<td>" + fileNames[i].Name + "</td>\n"
+			if lynxutil.IsConflict(fileNames[i].Path) {
+				// Conflicting copies stand out so someone can compare them and delete the loser
+				fileEntries += "<td><b style= \"color:red;\" title=\"Changed by two peers at " +
+					"once - compare it with the original and delete the copy you don't want\">" +
+					template.HTMLEscapeString(fileNames[i].Path) + " (conflict)</b></td>\n"
+			} else {
+				fileEntries += "<td>" + template.HTMLEscapeString(fileNames[i].Path) + "</td>\n"
+			}
 			fileEntries += "<td>" + strconv.Itoa(fileNames[i].Length/1000) +" KB" + "</td>\n"
+			fileEntries += historyCell(tempLynk.Name, fileNames[i].Path)
+			fileEntries += queueCell(tempLynk.Name, fileNames[i].Path, queue)
 			/*fileEntries += "<td><form id=\"remove\" method=\"POST\" action=\"removefile\"> \n" +
 			"<button type=\"submit\" class=\"transparent\" data-toggle=\"tooltip\"" +
 			" data-placement=\"bottom\" \n" +
@@ -355,7 +430,8 @@ func FilePopulate(index int) string {
 				"class=\"transparent\" data-toggle=\"tooltip\"" +
 				"data-placement=\"bottom\" title=\"Delete this file\" ><img " +
 				"src=\"images/file-ex-red.png\"></button><div id=\"remover" + strconv.Itoa(i) + "\">Are you " +
-				"sure you want to delete " + fileNames[i].Name + " ? <input type=\"hidden\"" +
+				"sure you want to delete " + template.HTMLEscapeString(fileNames[i].Path) +
+				" ? <input type=\"hidden\"" +
 				"name=\"index\" value=\"" + strconv.Itoa(i) + "\"> <br><br><button type=\"button\" " +
 				"id=\"close" + strconv.Itoa(i) + "\" name=\"Cancel\"" +
 				" class=\"btn btn-info\">Cancel</button><button type=\"submit\" class=\"btn btn-danger\"" +
@@ -363,6 +439,13 @@ func FilePopulate(index int) string {
 			fileEntries += "</tr>\n"
 			i++
 		}
+
+		// Deleted files are listed too, so their old versions can still be restored
+		for _, relPath := range client.Default.DeletedFiles(tempLynk.Name) {
+			fileEntries += "<tr> \n<td><s title=\"Deleted - restore a version to bring it " +
+				"back\">" + template.HTMLEscapeString(relPath) + "</s></td>\n<td></td>\n" +
+				historyCell(tempLynk.Name, relPath) + "<td></td>\n<td></td>\n</tr>\n"
+		}
 		//fmt.Println(fileEntries)
 
 		return fileEntries
@@ -371,6 +454,178 @@ func FilePopulate(index int) string {
 
 }
 
+// Builds the cell listing the old versions of a file, with a button to restore the one picked.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @return string - The table cell
+func historyCell(lynkName, relPath string) string {
+	versions, _ := client.Default.ListVersions(lynkName, relPath)
+	if len(versions) == 0 {
+		return "<td></td>\n"
+	}
+
+	cell := "<td><form method=\"POST\" action=\"/restorefile\"><input type=\"hidden\" " +
+		"name=\"path\" value=\"" + template.HTMLEscapeString(relPath) + "\"><select name=\"version\">"
+	for _, v := range versions {
+		cell += "<option value=\"" + v.ID + "\">" + v.Saved.Format("Jan 2 15:04") + " - " +
+			strconv.FormatInt(v.Length/1000, 10) + " KB</option>"
+	}
+	cell += "</select> <button type=\"submit\" class=\"btn btn-info btn-xs\" " +
+		"title=\"Put this version back\">Restore</button></form></td>\n"
+	return cell
+}
+
+// Builds the contents of the settings dialog, filled in with the limits in force. The selected
+// lynk's limits are only shown when a lynk is selected.
+// @param int index - The index of the selected lynk, or -1 if none is selected
+// @return string - The html for the settings' inputs
+func settingsForm(index int) string {
+	limits := client.Default.GetLimits()
+	settings := "Upload KB/s <input type=\"number\" min=\"0\" name=\"upload\" value=\"" +
+		kbString(limits.Upload) + "\"><br>Download KB/s <input type=\"number\" min=\"0\" " +
+		"name=\"download\" value=\"" + kbString(limits.Download) + "\"><br>Files At Once " +
+		"<input type=\"number\" min=\"1\" name=\"maxdownloads\" value=\"" +
+		strconv.Itoa(limits.MaxDownloads) + "\"><br>Files At Once Per Lynk <input " +
+		"type=\"number\" min=\"1\" name=\"maxlynkdownloads\" value=\"" +
+		strconv.Itoa(limits.MaxLynkDownloads) + "\"><br>\n"
+
+	lynks := client.Default.GetLynks()
+	if index > -1 && index < len(lynks) {
+		rate := limits.Lynks[lynks[index].Name]
+		settings += "<b>" + template.HTMLEscapeString(lynks[index].Name) + "</b><input " +
+			"type=\"hidden\" name=\"lynk\" value=\"" + template.HTMLEscapeString(lynks[index].Name) +
+			"\"><br>Upload KB/s <input type=\"number\" min=\"0\" name=\"lynkupload\" value=\"" +
+			kbString(rate.Upload) + "\"><br>Download KB/s <input type=\"number\" min=\"0\" " +
+			"name=\"lynkdownload\" value=\"" + kbString(rate.Download) + "\"><br>\n"
+	}
+
+	peers := []string{}
+	for ip, rate := range limits.Peers {
+		peers = append(peers, ip+" "+kbString(rate.Upload)+" "+kbString(rate.Download))
+	}
+	sort.Strings(peers)
+	settings += "Peers - IP Upload Download<br><textarea name=\"peers\" rows=\"3\">" +
+		template.HTMLEscapeString(strings.Join(peers, "\n")) + "</textarea><br>\n"
+	return settings
+}
+
+// Converts a rate typed into the settings in KB/s to bytes per second.
+// @param string kb - The rate in KB/s
+// @return int64 - The rate in bytes per second - 0, meaning unlimited, if kb isn't a number
+func kbRate(kb string) int64 {
+	rate, err := strconv.ParseInt(strings.TrimSpace(kb), 10, 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate * 1024
+}
+
+// Reads a cap on downloads typed into the settings.
+// @param string value - The number of downloads
+// @param int current - The cap in force
+// @return int - The new cap, or the current one if value isn't a positive number
+func downloadCap(value string, current int) int {
+	downloads, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || downloads < 1 {
+		return current
+	}
+	return downloads
+}
+
+// Converts a rate in bytes per second to KB/s for the settings.
+// @param int64 rate - The rate in bytes per second
+// @return string - The rate in KB/s
+func kbString(rate int64) string {
+	return strconv.FormatInt(rate/1024, 10)
+}
+
+// Builds the line under a lynk's header showing its download queue, with buttons to pause,
+// resume or cancel it.
+// @param string lynkName - The name of the lynk
+// @return string - The html for the queue's state and buttons
+func queueControls(lynkName string) string {
+	queue := client.Default.GetQueueState(lynkName)
+	status := queue.State
+	if len(queue.Active) > 0 {
+		status += " " + template.HTMLEscapeString(strings.Join(queue.Active, ", "))
+	}
+	if len(queue.Pending) > 0 {
+		status += " - " + strconv.Itoa(len(queue.Pending)) + " Waiting"
+	}
+
+	controls := "<form id=\"queue\" method=\"POST\" action=\"/queue\"><input type=\"hidden\" " +
+		"name=\"lynk\" value=\"" + template.HTMLEscapeString(lynkName) + "\">" + status + " "
+	if queue.State == client.QueuePaused {
+		controls += "<button type=\"submit\" name=\"action\" value=\"resume\" " +
+			"class=\"btn btn-success btn-xs\">Resume</button>"
+	} else {
+		controls += "<button type=\"submit\" name=\"action\" value=\"pause\" " +
+			"class=\"btn btn-warning btn-xs\">Pause</button>"
+	}
+	if queue.State == client.QueueDownloading {
+		controls += " <button type=\"submit\" name=\"action\" value=\"cancel\" " +
+			"class=\"btn btn-danger btn-xs\">Cancel</button>"
+	}
+	return controls + "</form>"
+}
+
+// Builds the cell with the buttons to pause or resume a single file's download and to move it to
+// the front of the queue. Files that are neither waiting nor paused get an empty cell.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The file's path inside the lynk
+// @param client.QueueState queue - The lynk's download queue
+// @return string - The table cell
+func queueCell(lynkName, relPath string, queue client.QueueState) string {
+	action, label := "", ""
+	for _, paused := range queue.PausedFiles {
+		if paused == relPath {
+			action, label = "resumefile", "Resume"
+		}
+	}
+	for _, active := range queue.Active {
+		if action == "" && active == relPath {
+			action, label = "pausefile", "Pause"
+		}
+	}
+	waiting := false
+	for _, pending := range queue.Pending {
+		if pending == relPath {
+			waiting = true
+		}
+	}
+	if action == "" && waiting {
+		action, label = "pausefile", "Pause"
+	}
+	if action == "" {
+		return "<td></td>\n"
+	}
+
+	cell := "<td><form method=\"POST\" action=\"/queue\"><input type=\"hidden\" name=\"lynk\" " +
+		"value=\"" + template.HTMLEscapeString(lynkName) + "\"><input type=\"hidden\" " +
+		"name=\"file\" value=\"" + template.HTMLEscapeString(relPath) + "\"><button " +
+		"type=\"submit\" name=\"action\" value=\"" + action + "\" class=\"btn btn-default " +
+		"btn-xs\">" + label + "</button>"
+	if waiting {
+		// The priority can be raised, lowered or put back to normal
+		cell += " <select name=\"priority\" class=\"input-xs\">"
+		for _, option := range []struct {
+			priority int
+			label    string
+		}{{client.PriorityHigh, "First"}, {client.PriorityNormal, "Normal"},
+			{client.PriorityLow, "Last"}} {
+			cell += "<option value=\"" + strconv.Itoa(option.priority) + "\""
+			if queue.Priorities[relPath] == option.priority {
+				cell += " selected"
+			}
+			cell += ">" + option.label + "</option>"
+		}
+		cell += "</select> <button type=\"submit\" name=\"action\" value=\"priority\" " +
+			"class=\"btn btn-info btn-xs\" title=\"Change when this file is downloaded\">" +
+			"Set</button>"
+	}
+	return cell + "</form></td>\n"
+}
+
 // FileHandler - handlers function which is called each time a lynk is pressed
 // and the files are displayed
 // on the right table
@@ -378,7 +633,7 @@ func FilePopulate(index int) string {
 // @param: req the form data from the html page
 func FileHandler(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
-	form = req.Form
+	form := req.Form
 	index := form["index"]
 
 	t := template.New("cool template")
@@ -390,9 +645,9 @@ func FileHandler(rw http.ResponseWriter, req *http.Request) {
 	indexInt, _ := strconv.Atoi(index[0])
 	// get the files for that lynk in our list of lynks
 	fileEntry := FilePopulate(indexInt)
-	tableEntries := TablePopulate(lynxutil.HomePath + "/lynks.txt")
+	tableEntries := TablePopulate(lynxutil.Default.Home + "/lynks.txt")
 	// create the header for the file table
-	fileHeader := FileHeader(client.GetFileTableIndex())
+	fileHeader := FileHeader(client.Default.GetFileTableIndex())
 	// generate our javascript code
 	jsCode := JSLynkGenerate()
 	myTemp := new(MyTemplate)
@@ -401,6 +656,7 @@ func FileHandler(rw http.ResponseWriter, req *http.Request) {
 	myTemp.Entries = template.HTML(tableEntries)
 	myTemp.FileHeader = template.HTML(fileHeader)
 	myTemp.Files = template.HTML(fileEntry)
+	myTemp.Settings = template.HTML(settingsForm(client.Default.GetFileTableIndex()))
 
 	t.ExecuteTemplate(rw, "index.html", myTemp)
 }
@@ -412,73 +668,126 @@ func FileHandler(rw http.ResponseWriter, req *http.Request) {
 func RemoveFileHandler(rw http.ResponseWriter, req *http.Request) {
 
 	req.ParseForm()
-	form = req.Form
+	form := req.Form
 	name := form["index"]
 	if name != nil {
 		index, _ := strconv.Atoi(name[0])
 		//fmt.Println(index)
 		// call delete file from the index we provided. while also passing in which lynk we are in
-		client.DeleteFileIndex(index, client.GetFileTableIndex())
-		lynk := client.GetLynkNameFromIndex(client.GetFileTableIndex())
+		client.Default.DeleteFileIndex(index, client.Default.GetFileTableIndex())
+		lynk := client.Default.GetLynkNameFromIndex(client.Default.GetFileTableIndex())
 		//client.DeleteLynk(client.GetLynkNameFromIndex(client.GetFileTableIndex()))
 		// create a new meta.info file and push it to reflect the changes
-		client.CreateMeta(lynk)
-		server.PushMeta(lynxutil.HomePath + lynk + "/meta.info")
-		//tracker.CreateSwarm(lynk)
-		//TablePopulate(lynxutil.HomePath + "/lynks.txt")
+		client.Default.CreateMeta(lynk)
+		server.Default.PushMeta(lynxutil.Default.Home + lynk + "/meta.info")
+		//tracker.Default.CreateSwarm(lynk)
+		//TablePopulate(lynxutil.Default.Home + "/lynks.txt")
 	}
 	// back to home page
 	IndexHandler(rw, req)
 
 }
 
-// Function INIT runs before main and allows us to load the index html before any operations
-// are done on it and find the root directory on the user's computer
-func init() {
-	uploads, _ = ioutil.ReadFile("uploads.html")
-	downloads, _ = ioutil.ReadFile("downloads.html")
+// RestoreFileHandler - function which handles putting an old version of a file back into a
+// specific lynk. The watcher notices the change and pushes it to our peers
+// @param: rw - a response to our html if needed
+// @param: req - the form data from our html
+func RestoreFileHandler(rw http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	form := req.Form
+	name := form["path"]
+	version := form["version"]
+	if name != nil && version != nil {
+		// The file may have been deleted, so it is named by its path rather than its index
+		relPath, err := lynxutil.CleanPath(name[0])
+		lynks := client.Default.GetLynks()
+		tableIndex := client.Default.GetFileTableIndex()
+		if err == nil && tableIndex >= 0 && tableIndex < len(lynks) {
+			err = client.Default.RestoreVersion(lynks[tableIndex].Name, relPath, version[0])
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	// back to home page
+	IndexHandler(rw, req)
 }
 
-// Function which checks the files in a directory to see if any have been added / changed
-// @param path string - the path where the root directory is located
-// @param file os.FileInfo - each file within the root or inner directories
-// @param err error - any error we way encounter along the way
-// @return error - An error can produced if we encounter an invalid file.
-func checkFiles(path string, file os.FileInfo, err error) error {
+// QueueHandler - function which shows and controls a lynk's download queue. A GET returns the
+// queue's state as JSON. A POST pauses, resumes or cancels the lynk, or pauses, resumes or sets
+// the priority of one of its files, and then shows the home page - or the queue's state as JSON
+// if format=json is given
+// @param: rw - a response to our html if needed
+// @param: req - the form data from our html
+func QueueHandler(rw http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	lynkName := req.Form.Get("lynk")
+	if _, ok := client.Default.GetLynk(lynkName); lynkName == "" || !ok {
+		http.Error(rw, "Lynk Not Found", http.StatusNotFound)
+		return
+	}
 
-	inMeta := false
-	for _, f := range currentLynk.Files {
-		// Checks that the file is in the meta.info
+	if req.Method == "POST" {
+		relPath := req.Form.Get("file")
+		switch req.Form.Get("action") {
+		case "pause":
+			client.Default.PauseLynk(lynkName)
+		case "resume":
+			client.Default.ResumeLynk(lynkName)
+		case "cancel":
+			client.Default.CancelLynk(lynkName)
+		case "pausefile":
+			client.Default.PauseFile(lynkName, relPath)
+		case "resumefile":
+			client.Default.ResumeFile(lynkName, relPath)
+		case "priority":
+			priority, err := strconv.Atoi(req.Form.Get("priority"))
+			if err != nil {
+				http.Error(rw, "Invalid Priority", http.StatusBadRequest)
+				return
+			}
+			if err = client.Default.SetPriority(lynkName, relPath, priority); err != nil {
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(rw, "Unknown Action", http.StatusBadRequest)
+			return
+		}
 
-		if f.Name == file.Name() {
-			//fmt.Println("same file name: " + file.Name())
-			inMeta = true
+		if req.Form.Get("format") != "json" {
+			IndexHandler(rw, req)
+			return
 		}
 	}
 
-	// Don't add directories, trackers, or a meta.info file to the new meta.info
-	if !file.IsDir() && !strings.Contains(path, "_Tracker") && file.Name() != "meta.info" && !inMeta {
-		fmt.Println("File: " + file.Name() + " has been added or changed")
-		changed = true
-	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(client.Default.GetQueueState(lynkName))
+}
 
-	return nil
+// Function INIT runs before main and allows us to load the index html before any operations
+// are done on it and find the root directory on the user's computer
+func init() {
+	uploads, _ = ioutil.ReadFile("uploads.html")
+	downloads, _ = ioutil.ReadFile("downloads.html")
 }
 
-// Helper function that we use to check to see if our Lynk has changed
+// Helper function that we use to check to see if our Lynks have changed
 func checkLynks() {
-	// Loops through every Lynk to check to see if their files have changed
-	for _, lynk := range client.GetLynks() {
-		//fmt.Println("Checking..." + lynk.Name)
-		changed = false
-		// Sets currentLynk so it can be used in checkFiles
-		currentLynk = lynk
-		// Sets changed to true if any files have been changed
-		filepath.Walk(lynxutil.HomePath+lynk.Name, checkFiles)
-		if changed {
-			client.CreateMeta(lynk.Name)
-			server.PushMeta(lynxutil.HomePath + lynk.Name + "/meta.info")
-		}
+	for _, lynk := range client.Default.GetLynks() {
+		checkLynk(lynk.Name)
+	}
+}
+
+// Helper function that checks a Lynk's files for additions or changes and pushes its meta.info
+// to peers if there were any
+// @param string lynkName - The name of the lynk
+func checkLynk(lynkName string) {
+	changed, err := client.Default.RefreshMeta(lynkName)
+	if err != nil {
+		fmt.Println(err)
+	} else if changed {
+		server.Default.PushMeta(lynxutil.Default.Home + lynkName + "/meta.info")
 	}
 }
 
@@ -487,7 +796,7 @@ func checkLynks() {
 // @returns: the string of the JS code
 func JSLynkGenerate() string {
 	JSCode := ""
-	length := client.GetLynksLen()
+	length := client.Default.GetLynksLen()
 	i := 0
 
 	for i < length {
@@ -511,20 +820,49 @@ func JSLynkGenerate() string {
 func FileHeader(index int) string {
 	var htmlString string
 
-	lynks := client.GetLynks()
+	lynks := client.Default.GetLynks()
 	tempLynk := lynks[index]
 	lynkName := tempLynk.Name
 	lynkOwner := tempLynk.Owner
 
 	htmlString = "<h3>Lynk:" + lynkName + " | Owner:" + lynkOwner + "</h3>"
+	htmlString += queueControls(lynkName)
 
 	return htmlString
 
 }
 
-// Helper function that wraps around our cron call so we can call it in a goroutine
-func cronWrapper() {
-	s := gocron.NewScheduler()
-	s.Every(10).Seconds().Do(checkLynks)
-	<-s.Start()
+// Watches every lynk's directory and refreshes and pushes a lynk's meta.info whenever its files
+// change. Runs until the program exits, so call it in a goroutine.
+func watchLynks() {
+	syncWatches()
+	checkLynks() // Catches changes made while we weren't running
+
+	for {
+		lynkName, ok := lynkWatcher.Next()
+		if !ok {
+			return
+		}
+		checkLynk(lynkName)
+	}
+}
+
+// Starts watching lynks that were created or joined and stops watching ones that were removed.
+func syncWatches() {
+	if lynkWatcher == nil {
+		return
+	}
+
+	current := map[string]bool{}
+	for _, lynk := range client.Default.GetLynks() {
+		current[lynk.Name] = true
+		if err := lynkWatcher.Add(lynk.Name, lynxutil.Default.Home+lynk.Name); err != nil {
+			fmt.Println(err)
+		}
+	}
+	for _, lynkName := range lynkWatcher.Watched() {
+		if !current[lynkName] {
+			lynkWatcher.Remove(lynkName)
+		}
+	}
 }
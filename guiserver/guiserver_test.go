@@ -12,6 +12,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"os/user"
 	"testing"
 	"time"
@@ -41,6 +42,16 @@ var original = 0
 // Total # of the tests.
 const total = 2
 
+// Loads the default node the system tests run on, under LYNX_PASSPHRASE or else a test
+// passphrase.
+func init() {
+	if os.Getenv(lynxutil.PassphraseEnv) == "" {
+		os.Setenv(lynxutil.PassphraseEnv, "lynx tests")
+	}
+	client.LoadDefault()
+	tracker.LoadDefault()
+}
+
 // If delay > 0 - we will start Lynx after the delay specified
 var delay int
 
@@ -85,7 +96,7 @@ func TestMain(t *testing.T) {
 // Helper function that verifies there has been a change in the files for the system tests lynk.
 // @returns True if there has been a change, False if there has not been a change
 func checkChanges() bool {
-	changed := len(lynxutil.GetLynk(client.GetLynks(), "SysTests").Files)
+	changed := len(lynxutil.GetLynk(client.Default.GetLynks(), "SysTests").Files)
 	result := false
 	if changed != original {
 		original = changed
@@ -116,8 +127,8 @@ func testStartUp() error {
 // @returns nil if successful, error if unsuccessful
 func testCreate() error {
 	//fmt.Println("----------------TestCreate----------------")
-	err := client.CreateMeta("SysTests")
-	tracker.CreateSwarm("SysTests")
+	err := client.Default.CreateMeta("SysTests")
+	tracker.Default.CreateSwarm("SysTests")
 	if err != nil {
 		fmt.Println("Test failed, expected no errors. Got " + err.Error())
 	} else {
@@ -132,7 +143,7 @@ func testCreate() error {
 // @returns nil if successful, error if unsuccessful
 func testJoin() error {
 	//fmt.Println("\n----------------TestJoin----------------")
-	err := client.JoinLynk(joinPath)
+	err := client.Default.JoinLynk(joinPath)
 	if err != nil {
 		fmt.Println("Test failed, expected no errors. Got " + err.Error())
 	} else {
@@ -166,8 +177,8 @@ func testReceive() bool {
 // @returns nil if successful, error if unsuccessful
 func testAdd() error {
 	//fmt.Println("\n----------------TestAddFile----------------")
-	err := client.AddToMetainfo(imgPath, mPath)
-	err = client.UpdateMetainfo(mPath)
+	err := client.Default.AddToMetainfo(imgPath, mPath)
+	err = client.Default.UpdateMetainfo(mPath)
 
 	if err != nil {
 		fmt.Println("Test failed, expected no errors. Got " + err.Error())
@@ -183,7 +194,7 @@ func testAdd() error {
 // @returns nil if successful, error if unsuccessful
 func testRemove() error {
 	//fmt.Println("\n----------------TestRemoveFile----------------")
-	err := client.DeleteFile("funny.jpg", "SysTests")
+	err := client.Default.DeleteFile("funny.jpg", "SysTests")
 
 	if err != nil {
 		fmt.Println("Test failed, expected no errors. Got " + err.Error())
@@ -1,6 +1,5 @@
 #!/bin/bash
 
-go get github.com/jasonlvhit/gocron
 go get github.com/skratchdot/open-golang/open
 go get golang.org/x/crypto/openpgp
 echo Downloaded Required Packages
@@ -35,11 +34,21 @@ go install
 echo GUIServer Installed
 cd ..
 
+cd lynx
+go install
+echo Lynx Installed
+cd ..
+
 cd lynxutil
 go install
 echo Lynxutil Installed
 cd ..
 
+cd metainfo
+go install
+echo Metainfo Installed
+cd ..
+
 cd mycrypt
 go install
 echo Mycrypt Installed
@@ -50,6 +59,31 @@ go install
 echo Mypgp Installed
 cd ..
 
+cd torrent
+go install
+echo Torrent Installed
+cd ..
+
+cd watcher
+go install
+echo Watcher Installed
+cd ..
+
+cd delta
+go install
+echo Delta Installed
+cd ..
+
+cd throttle
+go install
+echo Throttle Installed
+cd ..
+
+cd node
+go install
+echo Node Installed
+cd ..
+
 cd guiserver
 echo Starting Lynx...
 go run guiserver.go
@@ -0,0 +1,158 @@
+// A command line driver for the lynk operations that don't need the GUI.
+// Commands are join, share, subscribe, export-torrent, import-torrent, export-key, import-key,
+// unpin, authorise, claim and adopt - run it with no arguments for usage.
+// @author: Michael Bruce
+// @author: Max Kernchen
+package main
+
+import (
+	"capstone/client"
+	"capstone/lynxutil"
+	"capstone/metainfo"
+	"capstone/server"
+	"capstone/tracker"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Prints how to use the driver and exits.
+func usage() {
+	fmt.Println("Usage: lynx join <lynx:// URI | meta.info path>")
+	fmt.Println("       lynx share <lynk>")
+	fmt.Println("       lynx subscribe <lynk> [folder...]")
+	fmt.Println("       lynx export-torrent <lynk> [file.torrent]")
+	fmt.Println("       lynx import-torrent <file.torrent>")
+	fmt.Println("       lynx export-key <file.asc>")
+	fmt.Println("       lynx import-key <file.asc>")
+	fmt.Println("       lynx unpin <IP:Port>")
+	fmt.Println("       lynx authorise <lynk> <fingerprint>")
+	fmt.Println("       lynx claim <lynk>")
+	fmt.Println("       lynx adopt <lynk> <fingerprint>")
+	os.Exit(2)
+}
+
+// Function used to run a single lynx command
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	// The server loads the default node and its client too
+	if _, err := server.LoadDefault(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "join":
+		if strings.HasPrefix(os.Args[2], metainfo.URIScheme+"://") {
+			err = client.Default.JoinURI(os.Args[2])
+		} else {
+			err = client.Default.JoinLynk(os.Args[2])
+		}
+		if err == nil {
+			fmt.Println("Joined Lynk From " + os.Args[2])
+		}
+	case "share":
+		uri := ""
+		uri, err = client.Default.LynkURI(os.Args[2])
+		if err == nil {
+			fmt.Println(uri)
+		}
+	case "subscribe":
+		err = client.Default.Subscribe(os.Args[2], os.Args[3:])
+		if err == nil && len(os.Args) > 3 {
+			fmt.Println("Syncing Only " + strings.Join(os.Args[3:], ", ") + " Of " + os.Args[2])
+		} else if err == nil {
+			fmt.Println("Syncing All Of " + os.Args[2])
+		}
+	case "export-torrent":
+		torrentPath := os.Args[2] + ".torrent"
+		if len(os.Args) > 3 {
+			torrentPath = os.Args[3]
+		}
+		err = client.Default.ExportTorrent(os.Args[2], torrentPath)
+		if err == nil {
+			fmt.Println("Exported " + os.Args[2] + " To " + torrentPath)
+		}
+	case "import-torrent":
+		err = client.Default.JoinTorrent(os.Args[2])
+		if err == nil {
+			fmt.Println("Joined Lynk From " + os.Args[2])
+		}
+	case "export-key":
+		err = ioutil.WriteFile(os.Args[2], []byte(lynxutil.Default.PublicKey), 0644)
+		if err == nil {
+			fmt.Println("Exported Key " + lynxutil.Default.KeyFingerprint + " To " + os.Args[2])
+		}
+	case "import-key":
+		var armored []byte
+		if armored, err = ioutil.ReadFile(os.Args[2]); err == nil {
+			fingerprint := ""
+			fingerprint, err = lynxutil.Default.ImportKey(string(armored))
+			if err == nil {
+				fmt.Println("Imported Key " + fingerprint)
+			}
+		}
+	case "unpin":
+		err = lynxutil.Default.Unpin(os.Args[2])
+		if err == nil {
+			fmt.Println("The Next Key " + os.Args[2] + " Announces Will Be Trusted")
+		}
+	case "authorise":
+		if len(os.Args) < 4 {
+			usage()
+		}
+		err = client.Default.AuthoriseKey(os.Args[2], os.Args[3])
+		if err == nil {
+			// Peers only learn about the new writer from a push
+			err = server.Default.PushMeta(lynxutil.Default.Home + os.Args[2] + "/meta.info")
+		}
+		if err == nil {
+			fmt.Println("Key " + os.Args[3] + " Can Now Change " + os.Args[2])
+		}
+	case "claim":
+		err = adopt(os.Args[2], lynxutil.Default.KeyFingerprint)
+		if err == nil {
+			err = server.Default.PushMeta(lynxutil.Default.Home + os.Args[2] + "/meta.info")
+		}
+		if err == nil {
+			fmt.Println("Claimed " + os.Args[2] + " - Peers Take Our Pushes Once They Run: lynx " +
+				"adopt " + os.Args[2] + " " + lynxutil.Default.KeyFingerprint)
+		}
+	case "adopt":
+		if len(os.Args) < 4 {
+			usage()
+		}
+		err = adopt(os.Args[2], os.Args[3])
+		if err == nil {
+			fmt.Println("Key " + os.Args[3] + " Now Owns " + os.Args[2])
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Adopts an owner key for a lynk that has none - for our copy of its meta.info and, if we are
+// its tracker, the tracker's copy too.
+// @param string lynkName - The name of the lynk
+// @param string fingerprint - The fingerprint of the owner's key
+// @return error - An error is produced if the lynk already has another owner key
+func adopt(lynkName, fingerprint string) error {
+	t, err := tracker.LoadDefault()
+	if err == nil {
+		err = client.Default.AdoptOwner(lynkName, fingerprint)
+	}
+	if err == nil {
+		err = t.AdoptOwner(lynkName, fingerprint)
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+// A node's identity - its OpenPGP key, created once and kept in its Home encrypted with a
+// passphrase that is never stored - its keyring of the public keys of other peers and the key pinned for each peer.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package lynxutil
+
+import (
+	"../mypgp"
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// PassphraseEnv - The environment variable holding the passphrase that protects the default
+// node's private key. The passphrase is never stored, so it has to be given every time Lynx runs.
+const PassphraseEnv = "LYNX_PASSPHRASE"
+
+// ErrNoPassphrase - Returned when a node is created without a passphrase to protect its key
+var ErrNoPassphrase = errors.New("A Passphrase Is Needed To Protect The Node's Key - Set " +
+	PassphraseEnv)
+
+// ErrKeyChanged - Returned when a peer announces a different key than the one pinned for it
+var ErrKeyChanged = errors.New("Peer Key Changed")
+
+// The files and directory in a node's Home that hold its identity, keyring and pinned keys
+const (
+	identityFile = "identity.asc"
+	keyringDir   = "keyring/"
+	pinsFile     = "pins.txt"
+)
+
+// Earlier versions of Lynx made up a passphrase and kept it in this file beside the key. A node
+// that still has one is moved to the passphrase it is given now, and the file is removed.
+const legacyPassphraseFile = "identity.pass"
+
+// Loads the node's identity from its Home, creating and saving a new key the first time the node
+// runs. An identity that can't be read is an error rather than a reason to make a new one - that
+// would silently give the node a different identity.
+// @return error - An error is produced if the identity can't be read, created or saved
+func (n *Node) loadIdentity() error {
+	armored, err := ioutil.ReadFile(n.Home + identityFile)
+	if err == nil {
+		return n.readIdentity(string(armored))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	name := "lynx"
+	if currentusr, err := user.Current(); err == nil && currentusr.Name != "" {
+		name = currentusr.Name
+	}
+	config := mypgp.Config{Expiry: 365 * 24 * time.Hour, Passphrase: n.passphrase}
+	key, err := mypgp.CreateKey(name, "openpgp:lynxkeys", name+"@lynx.com", &config)
+	if err != nil {
+		return err
+	}
+	return n.saveIdentity(key)
+}
+
+// Unlocks the node's saved identity with its passphrase. An identity saved by an earlier version
+// of Lynx, in the old format or under the passphrase kept beside it, is saved again the current
+// way under the node's passphrase.
+// @param string armored - The saved private key
+// @return error - An error is produced if the key can't be unlocked or saved again
+func (n *Node) readIdentity(armored string) error {
+	key, err := mypgp.ReadKey(armored, n.passphrase)
+	legacyPath := n.Home + legacyPassphraseFile
+	legacy, legacyErr := ioutil.ReadFile(legacyPath)
+	if err != nil && legacyErr == nil {
+		key, err = mypgp.ReadKey(armored, []byte(strings.TrimSpace(string(legacy))))
+	}
+	if err != nil {
+		return err
+	} else if key.PrivateKey == nil {
+		return errors.New("No Private Key In " + n.Home + identityFile)
+	}
+
+	// The old format kept the key in an encrypted OpenPGP message
+	if legacyErr == nil || strings.Contains(armored, "BEGIN PGP MESSAGE") {
+		if key.Private, err = key.ArmorPrivate(&mypgp.Config{Passphrase: n.passphrase}); err != nil {
+			return err
+		} else if err = n.saveIdentity(key); err != nil {
+			return err
+		}
+		os.Remove(legacyPath)
+		return nil
+	}
+	n.setIdentity(key)
+	return nil
+}
+
+// Saves a key as the node's identity and makes it the node's identity.
+// @param *mypgp.Key key - An unlocked key, whose Private is armored with the node's passphrase
+// @return error - An error is produced if the key can't be saved
+func (n *Node) saveIdentity(key *mypgp.Key) error {
+	if err := ioutil.WriteFile(n.Home+identityFile, []byte(key.Private), 0600); err != nil {
+		return err
+	}
+	n.setIdentity(key)
+	return nil
+}
+
+// Makes key the node's identity. The unlocked key is kept, so signing and unwrapping session
+// keys don't have to unlock it again.
+// @param *mypgp.Key key - An unlocked key, whose Private is armored with the node's passphrase
+func (n *Node) setIdentity(key *mypgp.Key) {
+	n.identity = key
+	n.PublicKey = key.Public
+	n.PrivateKey = key.Private
+	n.KeyFingerprint = key.Fingerprint()
+}
+
+// Sign - Signs data with the node's private key.
+// @param io.Reader src - The data to sign
+// @param io.Writer dest - Where the armored signature is written
+// @return error - An error is produced if the signature can't be written
+func (n *Node) Sign(src io.Reader, dest io.Writer) error {
+	return n.identity.Sign(src, dest)
+}
+
+// ExportIdentity - Returns the node's private key, still protected by its passphrase, so the
+// identity can be backed up or moved to another machine.
+// @return string - The armored private key
+func (n *Node) ExportIdentity() string {
+	return n.PrivateKey
+}
+
+// ImportIdentity - Replaces the node's identity with an exported one. The key is protected with
+// the node's own passphrase before it is saved.
+// @param string armored - The armored private key
+// @param []byte passphrase - The passphrase the key was exported with
+// @return error - An error is produced if the key can't be read or saved or has no private part
+func (n *Node) ImportIdentity(armored string, passphrase []byte) error {
+	key, err := mypgp.ReadKey(armored, passphrase)
+	if err != nil {
+		return err
+	} else if key.PrivateKey == nil {
+		return errors.New("Not A Private Key")
+	}
+
+	if key.Private, err = key.ArmorPrivate(&mypgp.Config{Passphrase: n.passphrase}); err != nil {
+		return err
+	}
+	return n.saveIdentity(key)
+}
+
+// ImportKey - Adds a peer's public key to the node's keyring.
+// @param string armored - The armored public key
+// @return string - The key's fingerprint
+// @return error - An error is produced if the key can't be read or saved
+func (n *Node) ImportKey(armored string) (string, error) {
+	key, err := mypgp.ReadKey(armored, nil)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint := key.Fingerprint()
+	if err = os.MkdirAll(n.Home+keyringDir, 0755); err != nil {
+		return "", err
+	}
+	// Only the public part is kept, whatever was imported
+	err = ioutil.WriteFile(n.Home+keyringDir+fingerprint+".asc", []byte(key.Public), 0644)
+	return fingerprint, err
+}
+
+// LookupKey - Returns a public key from the node's keyring.
+// @param string fingerprint - The key's fingerprint
+// @return string - The armored public key
+// @return error - An error is produced if the key isn't in the keyring
+func (n *Node) LookupKey(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(fingerprint)
+	if _, err := hex.DecodeString(fingerprint); err != nil || fingerprint == "" {
+		return "", errors.New("Invalid Fingerprint")
+	}
+	if fingerprint == n.KeyFingerprint {
+		return n.PublicKey, nil
+	}
+
+	armored, err := ioutil.ReadFile(n.Home + keyringDir + fingerprint + ".asc")
+	if err != nil {
+		return "", errors.New("Unknown Key " + fingerprint)
+	}
+	return string(armored), nil
+}
+
+// Fingerprint - Returns the fingerprint of an armored public key.
+// @param string armored - The armored public key
+// @return string - The key's hex fingerprint
+// @return error - An error is produced if the key can't be read
+func Fingerprint(armored string) (string, error) {
+	key, err := mypgp.ReadKey(armored, nil)
+	if err != nil {
+		return "", err
+	}
+	return key.Fingerprint(), nil
+}
+
+// PinKey - Checks the key a peer announced against the one pinned for its address. The first key
+// seen for an address is trusted and pinned, and added to the keyring.
+// @param string address - The peer's IP:Port
+// @param string armored - The armored public key the peer announced
+// @return string - The fingerprint of the announced key
+// @return error - ErrKeyChanged if another key is pinned for the address, or an error reading the
+// key or the pins
+func (n *Node) PinKey(address, armored string) (string, error) {
+	fingerprint, err := Fingerprint(armored)
+	if err != nil {
+		return "", err
+	}
+
+	n.pinsMu.Lock()
+	defer n.pinsMu.Unlock()
+	pins := n.readPins()
+	if pinned, ok := pins[address]; ok && pinned != fingerprint {
+		return fingerprint, ErrKeyChanged
+	} else if ok {
+		return fingerprint, nil
+	}
+
+	if _, err = n.ImportKey(armored); err != nil {
+		return "", err
+	}
+	pinFile, err := os.OpenFile(n.Home+pinsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	pinFile.WriteString(address + ":::" + fingerprint + "\n")
+	return fingerprint, pinFile.Close()
+}
+
+// PinnedKey - Returns the fingerprint pinned for a peer.
+// @param string address - The peer's IP:Port
+// @return string - The fingerprint
+// @return bool - False if no key is pinned for the peer yet
+func (n *Node) PinnedKey(address string) (string, bool) {
+	n.pinsMu.Lock()
+	defer n.pinsMu.Unlock()
+	fingerprint, ok := n.readPins()[address]
+	return fingerprint, ok
+}
+
+// Unpin - Forgets the key pinned for a peer, so the next key it announces is trusted - for when a
+// peer's key changed for a good reason, e.g. its identity was lost.
+// @param string address - The peer's IP:Port
+// @return error - An error is produced if the pins can't be saved
+func (n *Node) Unpin(address string) error {
+	n.pinsMu.Lock()
+	defer n.pinsMu.Unlock()
+	pins := n.readPins()
+	if _, ok := pins[address]; !ok {
+		return nil
+	}
+	delete(pins, address)
+
+	var newPins []string
+	for pinned, fingerprint := range pins {
+		newPins = append(newPins, pinned+":::"+fingerprint+"\n")
+	}
+	return ioutil.WriteFile(n.Home+pinsFile, []byte(strings.Join(newPins, "")), 0644)
+}
+
+// Reads the pins file - 'IP:Port:::Fingerprint' per line. The caller must hold pinsMu.
+// @return map[string]string - The fingerprint pinned for each address
+func (n *Node) readPins() map[string]string {
+	pins := map[string]string{}
+	pinFile, err := os.Open(n.Home + pinsFile)
+	if err != nil {
+		return pins
+	}
+	defer pinFile.Close()
+
+	scanner := bufio.NewScanner(pinFile)
+	for scanner.Scan() {
+		split := strings.Split(strings.TrimSpace(scanner.Text()), ":::")
+		if len(split) == 2 {
+			pins[split[0]] = split[1]
+		}
+	}
+	return pins
+}
@@ -5,12 +5,16 @@
 package lynxutil
 
 import (
-	"../mypgp"
+	"../mycrypt"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"os/user"
+	"path"
 	"strings"
 	"time"
 )
@@ -27,49 +31,94 @@ const GUIPort = "5000"
 // SockErr - Represents A Welcome Socket Error
 const SockErr = -1
 
-// ChunkLength - Represents Default Chunk Length
-const ChunkLength = 32
+// Separates a file's name from the peer and time in the name of a conflicting copy
+const conflictMarker = ".conflict-"
 
-// ReconnAttempts - Represents The Maximum Numbers Of Reconnection Attempts Lynx Will Make
-const ReconnAttempts = 3
+// The endings of files that are still being written, by Lynx (E.G. 'meta.info.tmp') or by editors
+// and browsers, and so are never shared
+var tempSuffixes = []string{".tmp", ".part", ".partial", ".crdownload", ".swp", "~"}
 
-// HomePath - The absolute path of the user's Lynx directory
-var HomePath string
+// ChunkLength - Represents Default Chunk Length In Bytes
+const ChunkLength = 262144
 
-// PrivateKey - This is the armored string that represents our private OpenPGP Key.
-var PrivateKey string
-
-// PublicKey - This is the armored string that represents our public OpenPGP Key.
-var PublicKey string
+// ReconnAttempts - Represents The Maximum Numbers Of Reconnection Attempts Lynx Will Make
+const ReconnAttempts = 3
 
 // Peer - A struct which represents a Peer of the client
 type Peer struct {
 	IP   string
 	Port string
-	Key  string
+	Key  string // The armored OpenPGP public key the peer announced - empty if it sent none
+}
+
+// SwarmLine - Returns the peer's line in a swarm.info - 'IP:::Port:::Key' with the key encoded by
+// EncodeKey, or 'IP:::Port' for a peer that announced no key.
+// @return string - The line, without its newline
+func (p Peer) SwarmLine() string {
+	if p.Key == "" {
+		return p.IP + ":::" + p.Port
+	}
+	return p.IP + ":::" + p.Port + ":::" + EncodeKey(p.Key)
+}
+
+// ParsePeer - Reads a peer from its line in a swarm.info.
+// @param string line - The line
+// @return Peer - The peer
+// @return error - An error is produced if the line isn't a peer or its key can't be decoded
+func ParsePeer(line string) (Peer, error) {
+	split := strings.Split(strings.TrimSpace(line), ":::")
+	if len(split) != 2 && len(split) != 3 {
+		return Peer{}, errors.New("Invalid Peer: " + line)
+	}
+
+	peer := Peer{IP: split[0], Port: split[1]}
+	if len(split) == 3 {
+		key, err := DecodeKey(split[2])
+		if err != nil {
+			return Peer{}, err
+		}
+		peer.Key = key
+	}
+	return peer, nil
 }
 
 // Lynk - A struct which holds all the information about a specific Lynk.
 type Lynk struct {
-	Name      string
-	Owner     string
-	Synced    string
-	Tracker   string
-	Files     []File
-	Peers     []Peer
-	FileNames []string
-	FileSize  []int
-	DLing     bool
+	Name       string
+	Owner      string
+	OwnerKey   string   // The fingerprint of the owner's key
+	Writers    []string // The fingerprints of the keys, besides the owner's, allowed to change it
+	Synced     string
+	Tracker    string
+	Files      []File
+	Tombstones []Tombstone
+	Peers      []Peer
+	FileNames  []string
+	FileSize   []int
 }
 
 // File - A struct based which represents a File in a Lynk's directory. It is based
 // upon BitTorrent protocol dictionaries
 type File struct {
-	Length      int
-	Path        string // Might not need path
-	Name        string
-	Chunks      string
-	ChunkLength int
+	Length      int      `json:"length"`
+	Path        string   `json:"path"`   // Relative to the lynk's directory - E.G. 'docs/notes.txt'
+	Name        string   `json:"name"`   // Just the file's name - E.G. 'notes.txt'
+	Chunks      []string `json:"chunks"` // Ordered SHA-256 hex digests of each chunk - like pieces
+	ChunkLength int      `json:"chunkLength"`
+	Hash        string   `json:"hash"` // SHA-256 hex digest of the whole file
+	// How many times each peer has changed the file - concurrent edits show up as neither
+	// version having seen all of the other's changes
+	Versions map[string]int `json:"versions,omitempty"`
+}
+
+// Tombstone - A struct which records that a file was deleted from a Lynk so that peers delete
+// their copies too.
+type Tombstone struct {
+	Path    string `json:"path"`
+	Hash    string `json:"hash"`    // SHA-256 hex digest of the version that was deleted
+	Deleted int64  `json:"deleted"` // When the file was deleted - seconds since the Unix epoch
+	// The file's version vector when it was deleted - changes made since bring the file back
+	Versions map[string]int `json:"versions,omitempty"`
 }
 
 // FileCopy - Copies a file from src to dst
@@ -97,6 +146,219 @@ func FileCopy(src, dst string) error {
 	return out.Close() // Checks for close error
 }
 
+// HashFile - Reads a file chunk by chunk and hashes each chunk as well as the whole file with
+// SHA-256. The file is never held in memory all at once.
+// @param string path - the file to be hashed
+// @param int chunkLength - the number of bytes in each chunk (the last chunk may be shorter)
+// @return []string - the ordered hex digests of every chunk
+// @return string - the hex digest of the whole file
+// @return error - An error can be produced if the file cannot be opened or read - otherwise
+// error will be nil.
+func HashFile(path string, chunkLength int) ([]string, string, error) {
+	if chunkLength <= 0 {
+		return nil, "", errors.New("Invalid Chunk Length")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	var chunks []string
+	whole := sha256.New()
+	buf := make([]byte, chunkLength)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			chunks = append(chunks, HashChunk(buf[:n]))
+			whole.Write(buf[:n])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return chunks, hex.EncodeToString(whole.Sum(nil)), nil
+}
+
+// HashChunk - Returns the SHA-256 hex digest of a single chunk of data.
+// @param []byte data - the chunk to be hashed
+// @return string - the hex digest of data
+func HashChunk(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyChunk - Checks a received chunk against the hash recorded for it in the meta.info.
+// @param []byte data - the chunk that was received
+// @param int index - the position of the chunk within the file
+// @param File file - the meta.info entry of the file the chunk belongs to
+// @return error - An error is produced if the chunk is out of range or does not match its
+// recorded hash - otherwise error will be nil.
+func VerifyChunk(data []byte, index int, file File) error {
+	if index < 0 || index >= len(file.Chunks) {
+		return errors.New("Chunk " + fmt.Sprint(index) + " Of " + file.Name + " Is Out Of Range")
+	}
+	if HashChunk(data) != file.Chunks[index] {
+		return errors.New("Chunk " + fmt.Sprint(index) + " Of " + file.Name + " Failed Verification")
+	}
+	return nil
+}
+
+// VerifyFile - Checks a whole received file against its per-chunk and whole-file hashes.
+// Entries from a meta.info written before hashes were recorded have no Hash and are not checked.
+// @param []byte data - the file contents that were received
+// @param File file - the meta.info entry of the file
+// @return error - An error is produced if the length, any chunk, or the whole-file hash does not
+// match - otherwise error will be nil.
+func VerifyFile(data []byte, file File) error {
+	if file.Hash == "" {
+		return nil // Legacy entry - nothing to verify against
+	}
+	if len(data) != file.Length || file.ChunkLength <= 0 {
+		return errors.New(file.Name + " Has An Unexpected Length")
+	}
+
+	numChunks := (len(data) + file.ChunkLength - 1) / file.ChunkLength
+	if numChunks != len(file.Chunks) {
+		return errors.New(file.Name + " Has An Unexpected Number Of Chunks")
+	}
+
+	i := 0
+	for i < numChunks {
+		end := (i + 1) * file.ChunkLength
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := VerifyChunk(data[i*file.ChunkLength:end], i, file); err != nil {
+			return err
+		}
+		i++
+	}
+
+	if HashChunk(data) != file.Hash {
+		return errors.New(file.Name + " Failed Verification")
+	}
+	return nil
+}
+
+// VerifyPath - Checks a file on disk against its per-chunk and whole-file hashes without reading
+// it into memory. Entries from a meta.info written before hashes were recorded are not checked.
+// @param string path - the received file
+// @param File file - the meta.info entry of the file
+// @return error - An error is produced if the length, any chunk, or the whole-file hash does not
+// match - otherwise error will be nil.
+func VerifyPath(path string, file File) error {
+	if file.Hash == "" {
+		return nil // Legacy entry - nothing to verify against
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	} else if int(stat.Size()) != file.Length {
+		return errors.New(file.Name + " Has An Unexpected Length")
+	}
+
+	chunks, hash, err := HashFile(path, file.ChunkLength)
+	if err != nil {
+		return err
+	} else if len(chunks) != len(file.Chunks) {
+		return errors.New(file.Name + " Has An Unexpected Number Of Chunks")
+	}
+
+	for i, chunk := range chunks {
+		if chunk != file.Chunks[i] {
+			return errors.New("Chunk " + fmt.Sprint(i) + " Of " + file.Name + " Failed Verification")
+		}
+	}
+	if hash != file.Hash {
+		return errors.New(file.Name + " Failed Verification")
+	}
+	return nil
+}
+
+// LimitedWriter - A writer that passes at most N bytes through to W and fails after that. It
+// keeps a misbehaving peer from making us buffer more than we expect.
+type LimitedWriter struct {
+	W io.Writer
+	N int64 // The number of bytes still allowed through
+}
+
+// Write - Writes p to the underlying writer as long as it fits within the limit.
+// @param []byte p - The data to write
+// @return int - The number of bytes written
+// @return error - An error is produced if p would go over the limit or W fails
+func (l *LimitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.N {
+		return 0, errors.New("Write Exceeds Limit")
+	}
+	n, err := l.W.Write(p)
+	l.N -= int64(n)
+	return n, err
+}
+
+// SendStream - Compresses and encrypts everything read from src as it is written to dst, so data
+// of any size can be sent to a peer using a fixed amount of memory. Every stream is encrypted
+// with a new session key, which is sent ahead of the data wrapped for the receiver's public key.
+// @param io.Reader src - The data to send, e.g. an open file
+// @param io.Writer dst - Where the encrypted data is written, e.g. a connection
+// @param string publicKey - The armored OpenPGP public key of the peer receiving the data
+// @return error - An error can be produced when reading src, encrypting, or writing to dst -
+// otherwise error will be nil.
+func SendStream(src io.Reader, dst io.Writer, publicKey string) error {
+	sessionKey, err := writeSessionKey(dst, publicKey)
+	if err != nil {
+		return err
+	}
+
+	enc, err := mycrypt.NewSealWriter(sessionKey, dst)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(enc)
+	if _, err = io.Copy(gz, src); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil { // Flushes the last compressed block through the encrypter
+		return err
+	}
+	return enc.Close() // Seals the last record - without it the receiver refuses the stream
+}
+
+// ReceiveStream - Decrypts and decompresses data sent with SendStream as it is read from src and
+// writes the original data to dst, using a fixed amount of memory. Only the node whose public key
+// the data was sent to can unwrap its session key.
+// @param io.Reader src - Where the encrypted data is read from, e.g. a connection
+// @param io.Writer dst - Where the original data is written, e.g. a file on disk
+// @return error - An error can be produced when unwrapping the session key, decrypting,
+// decompressing, or writing to dst - mycrypt.ErrAuthFailed if the data was tampered with on the
+// way - otherwise error will be nil.
+func (n *Node) ReceiveStream(src io.Reader, dst io.Writer) error {
+	sessionKey, err := n.readSessionKey(src)
+	if err != nil {
+		return err
+	}
+
+	dec, err := mycrypt.NewOpenReader(sessionKey, src)
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(dec)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	_, err = io.Copy(dst, gz)
+	return err
+}
+
 // GetIP - Finds the ip of the current pc
 // @return error - The single string ip
 func GetIP() string {
@@ -140,6 +402,33 @@ func GetLynk(l []Lynk, lynkName string) *Lynk {
 	return nil // Don't have Lynk
 }
 
+// GetFile - Simple helper method that checks a files array for a specific file.
+// @param f []File - The files array
+// @param filePath string - The file we are checking for, relative to the lynk's directory
+func GetFile(f []File, filePath string) *File {
+	for i, a := range f {
+		if a.Path == filePath {
+			return &f[i]
+		}
+	}
+	return nil // Don't have File
+}
+
+// CleanPath - Checks that a path from a meta.info or a peer's request stays inside its lynk and
+// puts it in the form stored in File.Path.
+// @param string relPath - A path relative to a lynk's directory
+// @return string - The cleaned path using '/' as the separator
+// @return error - An error is produced if the path is empty, absolute, or climbs out of the lynk
+func CleanPath(relPath string) (string, error) {
+	cleaned := path.Clean(strings.Replace(relPath, "\\", "/", -1))
+	isDrive := len(cleaned) > 1 && cleaned[1] == ':' // A Windows path such as C:/Users
+	if cleaned == "." || path.IsAbs(cleaned) || isDrive || cleaned == ".." ||
+		strings.HasPrefix(cleaned, "../") {
+		return "", errors.New(relPath + " Is Not A Valid Lynk Path")
+	}
+	return cleaned, nil
+}
+
 // Listen - Creates a welcomeSocket that listens for TCP connections - once someone connects a
 // goroutine is spawned to handle the request
 // @param handler func(net.Conn) err - This is the function we want to use to handle a new
@@ -154,35 +443,50 @@ func Listen(handler func(net.Conn) error, port string) {
 		fmt.Println("Could Not Create Server Welcome Socket - Aborting.")
 		os.Exit(SockErr) // Cannot recover from not being able to generate welcomeSocket
 	}
+	Serve(welcomeSocket, handler)
+}
 
-	var cErr error
-	for cErr == nil {
+// Serve - Accepts connections on a welcomeSocket that is already listening and spawns a goroutine
+// to handle each one, until the welcomeSocket is closed.
+// @param net.Listener welcomeSocket - The socket to accept connections on
+// @param func(net.Conn) error handler - This is the function we use to handle the requests we get
+func Serve(welcomeSocket net.Listener, handler func(net.Conn) error) {
+	for {
 		conn, cErr := welcomeSocket.Accept()
 		if cErr != nil {
-			// If a connection error occurs
-			continue // To avoid calling handler
+			if ne, ok := cErr.(net.Error); ok && ne.Temporary() {
+				continue // A connection error - the socket itself is still fine
+			}
+			return // The socket was closed
 		}
 		go handler(conn)
 	}
+}
 
+// ConflictName - Returns the name a losing copy of a file is kept under after a conflicting edit.
+// @param string relPath - The file's path inside the lynk - E.G. 'docs/notes.txt'
+// @param string peer - The ID of the peer whose copy lost
+// @param time.Time when - When the conflict was found
+// @return string - E.G. 'docs/notes.txt.conflict-1a2b3c4d5e6f7a8b-20160217-153000'
+func ConflictName(relPath, peer string, when time.Time) string {
+	return relPath + conflictMarker + peer + "-" + when.Format("20060102-150405")
 }
-// Function which  creates the root Lynx directory if it is not already defined
-// not currently in use still need to do extra testing - MK
-func CheckAndCreateLynxDir(){
-	if _, err := os.Stat(HomePath); os.IsNotExist(err) {
-		os.Mkdir(HomePath, 0644)
+
+// IsTemp - Returns whether a file is one that is still being written and shouldn't be shared.
+// @param string relPath - The file's path inside the lynk
+// @return bool - True if the file's name has a temporary ending
+func IsTemp(relPath string) bool {
+	for _, suffix := range tempSuffixes {
+		if strings.HasSuffix(relPath, suffix) {
+			return true
+		}
 	}
+	return false
 }
 
-// Function init runs as soon as this class is imported and allows us to setup our HomePath
-func init() {
-	currentusr, _ := user.Current()
-	HomePath = currentusr.HomeDir + "/Lynx/"
-	//check and create the Lynx directory if it is not there - not currently in use still need to do extra testing.
-	//CheckAndCreateLynxDir()
-	HomePath = strings.Replace(HomePath, "\\", "/", -1) // Replaces Windows "\" With Unix "/" in path
-	config := mypgp.Config{Expiry: 365 * 24 * time.Hour}
-	key, _ := mypgp.CreateKey(currentusr.Name, "openpgp:lynxkeys", currentusr.Name+"@lynx.com", &config)
-	PublicKey = key.Public
-	PrivateKey = key.Private
+// IsConflict - Returns whether a file is the losing copy of a conflicting edit.
+// @param string relPath - The file's path inside the lynk
+// @return bool - True if the file was named by ConflictName
+func IsConflict(relPath string) bool {
+	return strings.Contains(path.Base(relPath), conflictMarker)
 }
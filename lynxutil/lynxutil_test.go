@@ -5,8 +5,16 @@
 package lynxutil
 
 import (
+	"bufio"
+	"bytes"
+	"capstone/mypgp"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/user"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -14,7 +22,19 @@ import (
 var successful = 0
 
 // Total # of the tests.
-const total = 7
+const total = 27
+
+// The passphrase protecting the keys of the nodes the tests create
+var passphrase = []byte("lynx tests")
+
+// Loads the default node the tests run on. Its key is protected by LYNX_PASSPHRASE if that is
+// set, and by the test passphrase if it isn't.
+func init() {
+	if os.Getenv(PassphraseEnv) == "" {
+		os.Setenv(PassphraseEnv, string(passphrase))
+	}
+	LoadDefault()
+}
 
 // Gets user's home directory
 var cU, _ = user.Current()
@@ -83,6 +103,330 @@ func TestGetIP(t *testing.T) {
 	}
 }
 
+// Unit tests for our HashFile, VerifyChunk, and VerifyFile functions.
+// @param *testing.T t - The wrapper for the test
+func TestHashFile(t *testing.T) {
+	fmt.Println("\n----------------TestHashFile----------------")
+
+	chunks, hash, err := HashFile("test.txt", 4)
+	data, _ := ioutil.ReadFile("test.txt")
+
+	if err != nil || len(chunks) != (len(data)+3)/4 || hash != HashChunk(data) {
+		t.Error("Test failed, expected one hash per 4 byte chunk. Got ", len(chunks), err)
+	} else {
+		fmt.Println("Successfully Hashed File")
+		successful++
+	}
+
+	file := File{Name: "test.txt", Length: len(data), ChunkLength: 4, Chunks: chunks, Hash: hash}
+	if err = VerifyFile(data, file); err != nil {
+		t.Error("Test failed, expected no errors. Got ", err)
+	} else {
+		fmt.Println("Successfully Verified File")
+		successful++
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[0]++
+	if err = VerifyFile(tampered, file); err == nil {
+		t.Error("Test failed, expected verification failure for a tampered file.")
+	} else {
+		fmt.Println("Successfully Rejected Tampered File")
+		successful++
+	}
+}
+
+// Unit tests for our SendStream, ReceiveStream, and LimitedWriter.
+// @param *testing.T t - The wrapper for the test
+func TestStream(t *testing.T) {
+	fmt.Println("\n----------------TestStream----------------")
+
+	data := strings.Repeat("test contents ", 10000)
+	var wire, out bytes.Buffer
+
+	err := SendStream(strings.NewReader(data), &wire, Default.PublicKey)
+	if err == nil {
+		err = Default.ReceiveStream(&wire, &out)
+	}
+
+	if err != nil || out.String() != data {
+		t.Error("Test failed, expected the streamed data back. Got ", out.Len(), "bytes", err)
+	} else {
+		fmt.Println("Successfully Streamed Data")
+		successful++
+	}
+
+	limit := &LimitedWriter{W: &out, N: 4}
+	if _, err = limit.Write([]byte("too long")); err == nil {
+		t.Error("Test failed, expected the write to exceed the limit.")
+	} else {
+		fmt.Println("Successfully Limited Write")
+		successful++
+	}
+}
+
+// Unit tests for our WriteKey and ReadKey functions and for wrapping session keys.
+// @param *testing.T t - The wrapper for the test
+func TestSessionKey(t *testing.T) {
+	fmt.Println("\n----------------TestSessionKey----------------")
+
+	var wire bytes.Buffer
+	Default.WriteKey(&wire)
+	key, err := ReadKey(bufio.NewReader(&wire))
+	if err != nil || key != Default.PublicKey {
+		t.Error("Test failed, expected our public key back. Got ", err)
+	} else {
+		fmt.Println("Successfully Sent Public Key")
+		successful++
+	}
+
+	// Only the node the stream was sent to can unwrap its session key
+	home, _ := ioutil.TempDir("", "lynx")
+	defer os.RemoveAll(home)
+	other, err := NewNode(home, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire.Reset()
+	SendStream(strings.NewReader("for other only"), &wire, other.PublicKey)
+	var out bytes.Buffer
+	if err = Default.ReceiveStream(bytes.NewReader(wire.Bytes()), &out); err == nil {
+		t.Error("Test failed, expected a stream for another node to be refused.")
+	} else if err = other.ReceiveStream(&wire, &out); err != nil ||
+		out.String() != "for other only" {
+		t.Error("Test failed, expected the other node to receive the stream. Got ", err)
+	} else {
+		fmt.Println("Successfully Wrapped Session Key")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestSessionReuse----------------")
+
+	// Streams sent to the same node share a session key, but each is still encrypted on its own
+	var one, two, oneOut, twoOut bytes.Buffer
+	SendStream(strings.NewReader("one"), &one, other.PublicKey)
+	SendStream(strings.NewReader("two"), &two, other.PublicKey)
+	size := 4 + int(binary.BigEndian.Uint32(one.Bytes()[:4]))
+	shared := bytes.Equal(one.Bytes()[:size], two.Bytes()[:size])
+	oneErr := other.ReceiveStream(&one, &oneOut)
+	twoErr := other.ReceiveStream(&two, &twoOut)
+	if !shared || oneErr != nil || twoErr != nil || oneOut.String() != "one" ||
+		twoOut.String() != "two" {
+		t.Error("Test failed, expected both streams under one session key. Got ", shared, oneErr,
+			twoErr)
+	} else {
+		fmt.Println("Successfully Reused Session Key")
+		successful++
+	}
+}
+
+// Unit tests for a node's identity and keyring.
+// @param *testing.T t - The wrapper for the test
+func TestIdentity(t *testing.T) {
+	fmt.Println("\n----------------TestLoadIdentity----------------")
+
+	home, _ := ioutil.TempDir("", "lynx")
+	defer os.RemoveAll(home)
+	first, err := NewNode(home, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewNode(home, passphrase)
+	_, wrongErr := NewNode(home, []byte("wrong"))
+	_, noneErr := NewNode(home, nil)
+	saved, _ := ioutil.ReadFile(first.Home + identityFile)
+	_, lockedErr := mypgp.ReadKey(string(saved), nil)
+	_, passErr := os.Stat(first.Home + legacyPassphraseFile)
+	if err != nil || again.KeyFingerprint != first.KeyFingerprint ||
+		again.PublicKey != first.PublicKey || wrongErr == nil || noneErr != ErrNoPassphrase ||
+		lockedErr == nil || !os.IsNotExist(passErr) {
+		t.Error("Test failed, expected the same identity back only with its passphrase. Got ",
+			err, wrongErr, noneErr, lockedErr, first.KeyFingerprint, again.KeyFingerprint)
+	} else {
+		fmt.Println("Successfully Kept Identity")
+		successful++
+	}
+
+	// A peer ID too short to be told apart is replaced, and the new one is kept
+	ioutil.WriteFile(first.Home+"peer.id", []byte("1a2b3c4d\n"), 0644)
+	replaced, err := NewNode(home, passphrase)
+	kept, keptErr := NewNode(home, passphrase)
+	if err != nil || keptErr != nil || len(replaced.PeerID) != 2*peerIDLength ||
+		kept.PeerID != replaced.PeerID || first.PeerID != again.PeerID {
+		t.Error("Test failed, expected a short peer ID to be replaced once. Got ", err, keptErr)
+	} else {
+		fmt.Println("Successfully Replaced Short Peer ID")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestImportKey----------------")
+
+	fingerprint, err := Default.ImportKey(first.PublicKey)
+	key, lookupErr := Default.LookupKey(strings.ToUpper(fingerprint))
+	printed, _ := Fingerprint(key)
+	if err != nil || lookupErr != nil || fingerprint != first.KeyFingerprint ||
+		printed != fingerprint {
+		t.Error("Test failed, expected the key in our keyring. Got ", err, lookupErr)
+	} else {
+		fmt.Println("Successfully Imported Key")
+		successful++
+	}
+	os.Remove(Default.Home + keyringDir + fingerprint + ".asc")
+
+	fmt.Println("\n----------------TestImportIdentity----------------")
+
+	otherHome, _ := ioutil.TempDir("", "lynx")
+	defer os.RemoveAll(otherHome)
+	other, err := NewNode(otherHome, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.ImportIdentity(first.ExportIdentity(), first.passphrase)
+	wrongErr = other.ImportIdentity(first.ExportIdentity(), []byte("wrong"))
+	var wire, out bytes.Buffer
+	SendStream(strings.NewReader("moved"), &wire, first.PublicKey)
+	recvErr := other.ReceiveStream(&wire, &out)
+	if err != nil || wrongErr == nil || other.KeyFingerprint != first.KeyFingerprint ||
+		recvErr != nil || out.String() != "moved" {
+		t.Error("Test failed, expected the identity to move to the other node. Got ", err,
+			wrongErr, recvErr)
+	} else {
+		fmt.Println("Successfully Imported Identity")
+		successful++
+	}
+}
+
+// Unit tests for our SwarmLine and ParsePeer functions and for pinning peer keys.
+// @param *testing.T t - The wrapper for the test
+func TestPinKey(t *testing.T) {
+	fmt.Println("\n----------------TestParsePeer----------------")
+
+	peer := Peer{IP: "1.2.3.4", Port: "8080", Key: Default.PublicKey}
+	parsed, err := ParsePeer(peer.SwarmLine())
+	old, oldErr := ParsePeer("1.2.3.4:::8080")
+	if err != nil || parsed != peer || oldErr != nil || old.Key != "" {
+		t.Error("Test failed, expected the peer back with its key. Got ", err, oldErr)
+	} else {
+		fmt.Println("Successfully Parsed Peer")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestPinKey----------------")
+
+	home, _ := ioutil.TempDir("", "lynx")
+	defer os.RemoveAll(home)
+	n, err := NewNode(home, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := n.PinKey("1.2.3.4:8080", Default.PublicKey)
+	_, againErr := n.PinKey("1.2.3.4:8080", Default.PublicKey)
+	_, keyringErr := n.LookupKey(fingerprint)
+	if err != nil || againErr != nil || keyringErr != nil || fingerprint != Default.KeyFingerprint {
+		t.Error("Test failed, expected the first key to be trusted. Got ", err, againErr,
+			keyringErr)
+	} else {
+		fmt.Println("Successfully Pinned Key")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestKeyChanged----------------")
+
+	_, changedErr := n.PinKey("1.2.3.4:8080", n.PublicKey)
+	n.Unpin("1.2.3.4:8080")
+	_, unpinnedErr := n.PinKey("1.2.3.4:8080", n.PublicKey)
+	pinned, _ := n.PinnedKey("1.2.3.4:8080")
+	if changedErr != ErrKeyChanged || unpinnedErr != nil || pinned != n.KeyFingerprint {
+		t.Error("Test failed, expected a changed key to be refused until unpinned. Got ",
+			changedErr, unpinnedErr)
+	} else {
+		fmt.Println("Successfully Refused Changed Key")
+		successful++
+	}
+}
+
+// Unit tests for our CleanPath function.
+// @param *testing.T t - The wrapper for the test
+func TestCleanPath(t *testing.T) {
+	fmt.Println("\n----------------TestCleanPath----------------")
+
+	result, err := CleanPath("docs\\drafts/./notes.txt")
+	if err != nil || result != "docs/drafts/notes.txt" {
+		t.Error("Test failed, expected 'docs/drafts/notes.txt'. Got ", result, err)
+	} else {
+		fmt.Println("Successfully Cleaned Nested Path")
+		successful++
+	}
+
+	_, err1 := CleanPath("docs/../../secret.txt")
+	_, err2 := CleanPath("/etc/passwd")
+	if err1 == nil || err2 == nil {
+		t.Error("Test failed, expected paths leaving the lynk to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Paths Outside The Lynk")
+		successful++
+	}
+}
+
+// Unit tests for our LynkStore.
+// @param *testing.T t - The wrapper for the test
+func TestLynkStore(t *testing.T) {
+	fmt.Println("\n----------------TestLynkStore----------------")
+
+	store := NewLynkStore()
+	events, stop := store.Watch()
+	store.Add(Lynk{Name: "cool", Files: []File{{Path: "a.txt", Chunks: []string{"ab"}}}})
+
+	// Changing a copy leaves the store alone
+	lynk, _ := store.Get("cool")
+	lynk.Files[0].Chunks[0] = "changed"
+	file, ok := store.GetFile("cool", "a.txt")
+	if !ok || file.Chunks[0] != "ab" || store.Add(Lynk{Name: "cool"}) {
+		t.Error("Test failed, expected the store to hand out copies. Got ", file)
+	} else {
+		fmt.Println("Successfully Handed Out Copies")
+		successful++
+	}
+
+	// Reset keeps what is known about lynks that stay
+	store.Reset([]Lynk{{Name: "test", Synced: "Unsynced"}, {Name: "cool", Synced: "Synced"}})
+	lynk, _ = store.At(1)
+	if store.Len() != 2 || lynk.Name != "cool" || lynk.Synced != "Synced" || len(lynk.Files) != 1 {
+		t.Error("Test failed, expected cool to keep its files. Got ", store.All())
+	} else {
+		fmt.Println("Successfully Reset Lynks")
+		successful++
+	}
+
+	var wg sync.WaitGroup
+	i := 0
+	for i < 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Update("cool", func(l *Lynk) { l.Peers = append(l.Peers, Peer{IP: "1.2.3.4"}) })
+			store.All()
+		}()
+		i++
+	}
+	wg.Wait()
+	store.Remove("test")
+	stop()
+
+	kinds := map[string]int{}
+	for event := range events {
+		kinds[event.Kind]++
+	}
+	lynk, _ = store.Get("cool")
+	if len(lynk.Peers) != 8 || kinds[LynkAdded] != 2 || kinds[LynkChanged] != 9 ||
+		kinds[LynkRemoved] != 1 {
+		t.Error("Test failed, expected every change to be seen. Got ", len(lynk.Peers), kinds)
+	} else {
+		fmt.Println("Successfully Watched Concurrent Changes")
+		successful++
+	}
+}
+
 // Unit tests for our GetLynk function.
 // @param *testing.T t - The wrapper for the test
 func TestGetLynk(t *testing.T) {
@@ -0,0 +1,132 @@
+// A Lynx node - everything that tells one Lynx peer apart from another, so several can run in one
+// process or on one machine.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package lynxutil
+
+import (
+	"../mypgp"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+)
+
+// Node - The home directory, ports, identity and lynks of one Lynx peer. The client, server and
+// tracker of a peer all work on its node.
+type Node struct {
+	Home        string // The absolute path of the node's Lynx directory, ending in '/'
+	ServerPort  string
+	TrackerPort string
+	GUIPort     string
+
+	// The armored string that represents our private OpenPGP Key, protected by passphrase
+	PrivateKey     string
+	PublicKey      string // The armored string that represents our public OpenPGP Key
+	KeyFingerprint string // The hex fingerprint of our OpenPGP Key - it identifies a lynk's owner
+	passphrase     []byte
+	identity       *mypgp.Key // Our unlocked OpenPGP Key
+	pinsMu         sync.Mutex // Guards the file of pinned peer keys
+
+	sessions   map[string]session // The session keys we unwrapped, by their wrapped key's hash
+	sessionsMu sync.Mutex         // Guards sessions
+
+	// A random ID that identifies this peer in file version vectors. It is kept in Home so it
+	// stays the same between runs.
+	PeerID string
+
+	Lynks *LynkStore // The lynks found from parsing the lynks.txt file
+}
+
+// The length of a peer's ID in bytes - long enough that two peers of a lynk won't pick the same one
+const peerIDLength = 8
+
+// Default - The node of the user's own Lynx directory, on the default ports. It is nil until
+// LoadDefault has created it.
+var Default *Node
+
+// Guards the creation of the default node
+var defaultMu sync.Mutex
+
+// NewNode - Creates a node on the default ports, creating its Lynx directory and identity the
+// first time and loading them after that.
+// @param string home - The path of the node's Lynx directory
+// @param []byte passphrase - The passphrase protecting the node's private key
+// @return *Node - The node
+// @return error - An error is produced if there is no passphrase, if the directory or the identity
+// can't be created, or if the passphrase doesn't unlock the identity
+func NewNode(home string, passphrase []byte) (*Node, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrNoPassphrase
+	}
+	home = strings.Replace(home, "\\", "/", -1) // Replaces Windows "\" With Unix "/" in path
+	if !strings.HasSuffix(home, "/") {
+		home += "/"
+	}
+	if err := os.MkdirAll(home, 0755); err != nil {
+		return nil, err
+	}
+
+	n := &Node{Home: home, ServerPort: ServerPort, TrackerPort: TrackerPort, GUIPort: GUIPort,
+		passphrase: passphrase, Lynks: NewLynkStore()}
+	if err := n.loadIdentity(); err != nil {
+		return nil, err
+	}
+	peerID, err := n.loadPeerID()
+	if err != nil {
+		return nil, err
+	}
+	n.PeerID = peerID
+	return n, nil
+}
+
+// Returns this peer's ID, creating and saving a new one the first time Lynx runs. IDs too short to
+// be told apart reliably, as earlier versions made them, are replaced - version vectors keep the
+// old ID's counts, so nothing is lost.
+// @return string - The peer's ID
+// @return error - An error is produced if a new ID can't be generated or saved
+func (n *Node) loadPeerID() (string, error) {
+	idPath := n.Home + "peer.id"
+	if data, err := ioutil.ReadFile(idPath); err == nil &&
+		len(strings.TrimSpace(string(data))) >= 2*peerIDLength {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	id := make([]byte, peerIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(idPath, []byte(hex.EncodeToString(id)+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// LoadDefault - Returns the default node, creating it from the user's Lynx directory the first
+// time it is called. Nothing is created until a program asks for it, so importing Lynx never
+// touches the user's home directory. The node's key is unlocked with the passphrase in
+// PassphraseEnv.
+// @return *Node - The default node
+// @return error - An error is produced if the Lynx directory or the identity can't be created
+func LoadDefault() (*Node, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if Default != nil {
+		return Default, nil
+	}
+
+	currentusr, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	node, err := NewNode(currentusr.HomeDir+"/Lynx/", []byte(os.Getenv(PassphraseEnv)))
+	if err != nil {
+		return nil, err
+	}
+	Default = node
+	return Default, nil
+}
@@ -0,0 +1,195 @@
+// Session keys for peer transfers - streams are encrypted with a random AES key, which travels
+// ahead of the data wrapped for the receiver's OpenPGP public key. Wrapping and unwrapping are
+// slow, so a session key is used for every stream sent to one receiver for a while.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package lynxutil
+
+import (
+	"../mypgp"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionKeyLength - The length in bytes of a session key - long enough for AES-256
+const SessionKeyLength = 32
+
+// The longest wrapped session key we accept - a wrapped key is a few hundred bytes, so anything
+// longer is a misbehaving peer
+const maxWrappedKey = 16384
+
+// How long one session key is used for the streams sent to a receiver. Every
+// stream still starts from a random nonce of its own, so reusing the key is safe - it only saves
+// wrapping a new key for every chunk.
+const sessionLifetime = 10 * time.Minute
+
+// A session key and when it was made - wrapped is the key wrapped for its receiver
+type session struct {
+	key     []byte
+	wrapped []byte
+	created time.Time
+}
+
+// The session keys used for the streams we send, by the public key of their receiver
+var sentSessions = struct {
+	sync.Mutex
+	sessions map[string]session
+}{sessions: map[string]session{}}
+
+// WriteKey - Sends the node's public key on a single line, so the peer at the other end can wrap
+// session keys that only this node can unwrap.
+// @param io.Writer w - Where the key is written, e.g. a connection
+// @return error - An error is produced if the key can't be written
+func (n *Node) WriteKey(w io.Writer) error {
+	_, err := fmt.Fprintln(w, EncodeKey(n.PublicKey))
+	return err
+}
+
+// ReadKey - Reads a public key sent with WriteKey.
+// @param *bufio.Reader r - Where the key is read from, e.g. a connection
+// @return string - The armored OpenPGP public key
+// @return error - An error is produced if no key could be read
+func ReadKey(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return DecodeKey(line)
+}
+
+// EncodeKey - Encodes an armored key so it fits in one field of a request or a swarm.info line.
+// @param string armored - The armored OpenPGP public key
+// @return string - The key in base64, which has no ':' or newlines
+func EncodeKey(armored string) string {
+	return base64.StdEncoding.EncodeToString([]byte(armored))
+}
+
+// DecodeKey - Decodes a key encoded with EncodeKey.
+// @param string encoded - The key in base64
+// @return string - The armored OpenPGP public key
+// @return error - An error is produced if encoded isn't a key in base64
+func DecodeKey(encoded string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) == 0 {
+		return "", errors.New("Invalid Public Key")
+	}
+	return string(key), nil
+}
+
+// Writes a session key to w wrapped for a public key, preceded by its length. The receiver's
+// current session key is used if it has one, and a new one is made if not.
+// @param io.Writer w - Where the wrapped key is written
+// @param string publicKey - The armored OpenPGP public key of the receiver
+// @return []byte - The session key
+// @return error - An error is produced if the key can't be created, wrapped or written
+func writeSessionKey(w io.Writer, publicKey string) ([]byte, error) {
+	s, err := sentSession(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(s.wrapped)))
+	if _, err := w.Write(append(length, s.wrapped...)); err != nil {
+		return nil, err
+	}
+	return s.key, nil
+}
+
+// Returns the session key for the streams sent to a receiver, making and wrapping a new one when
+// the receiver has none or its key is older than sessionLifetime.
+// @param string publicKey - The armored OpenPGP public key of the receiver
+// @return session - The session key and the key wrapped for the receiver
+// @return error - An error is produced if a new key can't be created or wrapped
+func sentSession(publicKey string) (session, error) {
+	sentSessions.Lock()
+	defer sentSessions.Unlock()
+	now := time.Now()
+	if s, ok := sentSessions.sessions[publicKey]; ok && now.Sub(s.created) < sessionLifetime {
+		return s, nil
+	}
+
+	s := session{key: make([]byte, SessionKeyLength), created: now}
+	if _, err := io.ReadFull(rand.Reader, s.key); err != nil {
+		return session{}, err
+	}
+	var wrapped bytes.Buffer
+	if err := mypgp.Encode([]byte(publicKey), bytes.NewReader(s.key), &wrapped); err != nil {
+		return session{}, err
+	}
+	s.wrapped = wrapped.Bytes()
+
+	expireSessions(sentSessions.sessions, now)
+	sentSessions.sessions[publicKey] = s
+	return s, nil
+}
+
+// Forgets the session keys that are too old to be used.
+// @param map[string]session sessions - The session keys - the caller must hold their lock
+// @param time.Time now - The current time
+func expireSessions(sessions map[string]session, now time.Time) {
+	for key, s := range sessions {
+		if now.Sub(s.created) >= sessionLifetime {
+			delete(sessions, key)
+		}
+	}
+}
+
+// Reads a session key written by writeSessionKey and unwraps it with the node's private key. A
+// key unwrapped before is remembered, so the streams that share it only pay for it once.
+// @param io.Reader r - Where the wrapped key is read from
+// @return []byte - The session key
+// @return error - An error is produced if the key wasn't wrapped for this node
+func (n *Node) readSessionKey(r io.Reader) ([]byte, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size == 0 || size > maxWrappedKey {
+		return nil, errors.New("Invalid Session Key")
+	}
+
+	wrapped := make([]byte, size)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(wrapped)
+	id := string(sum[:])
+
+	n.sessionsMu.Lock()
+	s, ok := n.sessions[id]
+	n.sessionsMu.Unlock()
+	// A sender never uses a key for longer than sessionLifetime, so we keep them just as long
+	if ok && time.Since(s.created) < sessionLifetime {
+		return s.key, nil
+	}
+
+	var sessionKey bytes.Buffer
+	if err := n.identity.Decode(bytes.NewReader(wrapped), &sessionKey); err != nil {
+		return nil, err
+	}
+	if sessionKey.Len() != SessionKeyLength {
+		return nil, errors.New("Invalid Session Key")
+	}
+
+	n.sessionsMu.Lock()
+	defer n.sessionsMu.Unlock()
+	if n.sessions == nil {
+		n.sessions = map[string]session{}
+	}
+	expireSessions(n.sessions, time.Now())
+	n.sessions[id] = session{key: sessionKey.Bytes(), created: time.Now()}
+	return sessionKey.Bytes(), nil
+}
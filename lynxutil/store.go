@@ -0,0 +1,272 @@
+// A concurrency-safe registry of lynks - the client and the tracker keep their lynks in one, so
+// the GUI, the servers and the cron jobs can all read and change them at once.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package lynxutil
+
+import (
+	"sync"
+)
+
+// The kinds of change a LynkStore reports
+const (
+	LynkAdded   = "Added"
+	LynkChanged = "Changed"
+	LynkRemoved = "Removed"
+)
+
+// How many changes a watcher can fall behind before further changes are dropped for it
+const watchBuffer = 64
+
+// LynkEvent - A change to one of a LynkStore's lynks.
+type LynkEvent struct {
+	Name string // The name of the lynk
+	Kind string // LynkAdded, LynkChanged or LynkRemoved
+}
+
+// LynkStore - A list of lynks that is safe to use from many goroutines. Lynks are only ever
+// handed out as copies, so a lynk can't change underneath whoever is reading it.
+type LynkStore struct {
+	mu       sync.RWMutex
+	lynks    []Lynk
+	watchers map[chan LynkEvent]bool
+}
+
+// NewLynkStore - Creates an empty LynkStore.
+// @return *LynkStore - The store
+func NewLynkStore() *LynkStore {
+	return &LynkStore{watchers: map[chan LynkEvent]bool{}}
+}
+
+// Get - Returns a copy of a lynk.
+// @param string name - The name of the lynk
+// @return Lynk - The lynk
+// @return bool - False if there is no such lynk
+func (s *LynkStore) Get(name string) (Lynk, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.find(name); i != -1 {
+		return s.lynks[i].Clone(), true
+	}
+	return Lynk{}, false
+}
+
+// At - Returns a copy of the lynk at a position in the store, in the order the lynks were added.
+// @param int index - The position
+// @return Lynk - The lynk
+// @return bool - False if the position is out of range
+func (s *LynkStore) At(index int) (Lynk, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if index < 0 || index >= len(s.lynks) {
+		return Lynk{}, false
+	}
+	return s.lynks[index].Clone(), true
+}
+
+// GetFile - Returns a copy of one file of a lynk, without copying the rest of the lynk.
+// @param string name - The name of the lynk
+// @param string filePath - The file's path inside the lynk
+// @return File - The file
+// @return bool - False if there is no such lynk or file
+func (s *LynkStore) GetFile(name, filePath string) (File, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.find(name); i != -1 {
+		if file := GetFile(s.lynks[i].Files, filePath); file != nil {
+			return file.Clone(), true
+		}
+	}
+	return File{}, false
+}
+
+// All - Returns a copy of every lynk, in the order they were added.
+// @return []Lynk - The lynks
+func (s *LynkStore) All() []Lynk {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make([]Lynk, len(s.lynks))
+	for i, lynk := range s.lynks {
+		all[i] = lynk.Clone()
+	}
+	return all
+}
+
+// Names - Returns the name of every lynk, in the order they were added.
+// @return []string - The names
+func (s *LynkStore) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, len(s.lynks))
+	for i, lynk := range s.lynks {
+		names[i] = lynk.Name
+	}
+	return names
+}
+
+// Len - Returns how many lynks the store holds.
+// @return int - The number of lynks
+func (s *LynkStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.lynks)
+}
+
+// Add - Adds a lynk to the end of the store.
+// @param Lynk lynk - The lynk
+// @return bool - False if the store already holds a lynk with the same name
+func (s *LynkStore) Add(lynk Lynk) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(lynk.Name) != -1 {
+		return false
+	}
+	s.lynks = append(s.lynks, lynk.Clone())
+	s.notify(LynkEvent{Name: lynk.Name, Kind: LynkAdded})
+	return true
+}
+
+// Update - Changes a lynk in place. The change is made while every other reader and writer
+// waits, so fn must not use the store itself.
+// @param string name - The name of the lynk
+// @param func(*Lynk) fn - Makes the change
+// @return bool - False if there is no such lynk
+func (s *LynkStore) Update(name string, fn func(lynk *Lynk)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(name)
+	if i == -1 {
+		return false
+	}
+	fn(&s.lynks[i])
+	s.notify(LynkEvent{Name: name, Kind: LynkChanged})
+	return true
+}
+
+// Remove - Removes a lynk from the store.
+// @param string name - The name of the lynk
+// @return bool - False if there is no such lynk
+func (s *LynkStore) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(name)
+	if i == -1 {
+		return false
+	}
+	s.lynks = append(s.lynks[:i], s.lynks[i+1:]...)
+	s.notify(LynkEvent{Name: name, Kind: LynkRemoved})
+	return true
+}
+
+// Reset - Makes the store hold exactly the given lynks, in their order. A lynk the store already
+// held keeps its files, peers and tracker, so readers never see it half loaded.
+// @param []Lynk lynks - The lynks
+func (s *LynkStore) Reset(lynks []Lynk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := make([]Lynk, 0, len(lynks))
+	for _, lynk := range lynks {
+		if i := s.find(lynk.Name); i != -1 {
+			kept := s.lynks[i]
+			kept.Owner, kept.Synced = lynk.Owner, lynk.Synced
+			next = append(next, kept)
+			s.notify(LynkEvent{Name: lynk.Name, Kind: LynkChanged})
+		} else {
+			next = append(next, lynk.Clone())
+			s.notify(LynkEvent{Name: lynk.Name, Kind: LynkAdded})
+		}
+	}
+	for _, lynk := range s.lynks {
+		if GetLynk(lynks, lynk.Name) == nil {
+			s.notify(LynkEvent{Name: lynk.Name, Kind: LynkRemoved})
+		}
+	}
+	s.lynks = next
+}
+
+// Watch - Reports every change to the store's lynks from now on. A watcher that falls too far
+// behind misses changes rather than holding up the store.
+// @return <-chan LynkEvent - Where the changes are sent
+// @return func() - Stops watching and closes the channel - it must be called exactly once
+func (s *LynkStore) Watch() (<-chan LynkEvent, func()) {
+	events := make(chan LynkEvent, watchBuffer)
+	s.mu.Lock()
+	s.watchers[events] = true
+	s.mu.Unlock()
+
+	return events, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, events)
+		close(events)
+	}
+}
+
+// Returns the position of a lynk - callers must hold s.mu.
+// @param string name - The name of the lynk
+// @return int - The position, or -1 if there is no such lynk
+func (s *LynkStore) find(name string) int {
+	for i, lynk := range s.lynks {
+		if lynk.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Sends a change to every watcher without waiting on any of them - callers must hold s.mu.
+// @param LynkEvent event - The change
+func (s *LynkStore) notify(event LynkEvent) {
+	for events := range s.watchers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Clone - Returns a copy of a lynk that shares nothing with the original.
+// @return Lynk - The copy
+func (l Lynk) Clone() Lynk {
+	c := l
+	if l.Files != nil {
+		c.Files = make([]File, len(l.Files))
+		for i, file := range l.Files {
+			c.Files[i] = file.Clone()
+		}
+	}
+	c.Tombstones = append([]Tombstone(nil), l.Tombstones...)
+	for i := range c.Tombstones {
+		c.Tombstones[i].Versions = cloneVersions(l.Tombstones[i].Versions)
+	}
+	c.Writers = append([]string(nil), l.Writers...)
+	c.Peers = append([]Peer(nil), l.Peers...)
+	c.FileNames = append([]string(nil), l.FileNames...)
+	c.FileSize = append([]int(nil), l.FileSize...)
+	return c
+}
+
+// Clone - Returns a copy of a file that shares nothing with the original.
+// @return File - The copy
+func (f File) Clone() File {
+	c := f
+	c.Chunks = append([]string(nil), f.Chunks...)
+	c.Versions = cloneVersions(f.Versions)
+	return c
+}
+
+// Copies a version vector.
+// @param map[string]int versions - The version vector
+// @return map[string]int - The copy, or nil if versions is nil
+func cloneVersions(versions map[string]int) map[string]int {
+	if versions == nil {
+		return nil
+	}
+	c := make(map[string]int, len(versions))
+	for peerID, version := range versions {
+		c[peerID] = version
+	}
+	return c
+}
@@ -0,0 +1,166 @@
+// Version vectors for the metainfo package - every file records how many times each peer has
+// changed it, so a pushed meta.info can be merged with ours and concurrent edits detected.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package metainfo
+
+import (
+	"../lynxutil"
+	"time"
+)
+
+// The ways two version vectors can relate to one another
+const (
+	Same       = iota // Both have seen exactly the same changes
+	Older             // The first is missing changes the second has seen
+	Newer             // The first has seen every change the second has and more
+	Concurrent        // Each has changes the other hasn't seen
+)
+
+// Conflict - A file that was changed here and by another peer at the same time.
+type Conflict struct {
+	Path      string // The file's path inside the lynk
+	LocalHash string // The hash of our version, which loses and is kept under a conflict name
+}
+
+// CompareVersions - Compares two version vectors.
+// @param map[string]int a - The first vector
+// @param map[string]int b - The second vector
+// @return int - Same, Older, Newer or Concurrent, describing a relative to b
+func CompareVersions(a, b map[string]int) int {
+	aAhead, bAhead := false, false
+	for peer, count := range a {
+		if count > b[peer] {
+			aAhead = true
+		}
+	}
+	for peer, count := range b {
+		if count > a[peer] {
+			bAhead = true
+		}
+	}
+
+	if aAhead && bAhead {
+		return Concurrent
+	} else if aAhead {
+		return Newer
+	} else if bAhead {
+		return Older
+	}
+	return Same
+}
+
+// MergeVersions - Returns a vector that has seen every change either vector has.
+// @param map[string]int a - The first vector
+// @param map[string]int b - The second vector
+// @return map[string]int - The merged vector
+func MergeVersions(a, b map[string]int) map[string]int {
+	merged := map[string]int{}
+	for peer, count := range a {
+		merged[peer] = count
+	}
+	for peer, count := range b {
+		if count > merged[peer] {
+			merged[peer] = count
+		}
+	}
+	return merged
+}
+
+// BumpVersion - Returns a copy of a vector recording one more change by a peer.
+// @param map[string]int v - The vector
+// @param string peer - The ID of the peer that changed the file
+// @return map[string]int - The new vector
+func BumpVersion(v map[string]int, peer string) map[string]int {
+	bumped := MergeVersions(v, nil)
+	bumped[peer]++
+	return bumped
+}
+
+// Merge - Merges a meta.info pushed by another peer into ours. Files they changed replace ours,
+// files we changed that they haven't seen are kept, and files we both changed are conflicts -
+// theirs wins and ours has to be kept under a conflict name.
+// @param *Meta local - Our meta.info
+// @param *Meta incoming - The pushed meta.info
+// @return *Meta - The merged meta.info
+// @return []Conflict - The files we both changed
+// @return bool - True if the merged meta.info has changes the pushed one didn't and should be
+// pushed in turn
+func Merge(local, incoming *Meta) (*Meta, []Conflict, bool) {
+	merged := New(incoming.LynkName, incoming.Owner, incoming.Announce)
+	merged.OwnerKey = incoming.OwnerKey
+	merged.Sequence = incoming.Sequence
+	merged.PieceLength, merged.Pieces = incoming.PieceLength, incoming.Pieces
+	merged.Writers = append(merged.Writers, incoming.Writers...)
+	merged.Tombstones = append(merged.Tombstones, incoming.Tombstones...)
+	conflicts := []Conflict{}
+	newer := false
+	now := time.Now()
+
+	for _, theirs := range incoming.Files {
+		// A file we deleted stays deleted unless they changed it after we deleted it
+		t := local.tombstone(theirs.Path)
+		if t != nil && incoming.tombstone(theirs.Path) == nil && !expired(*t, now) {
+			if cmp := CompareVersions(theirs.Versions, t.Versions); cmp == Same || cmp == Older {
+				merged.Tombstones = append(merged.Tombstones, *t)
+				newer = true
+				continue
+			}
+		}
+
+		ours := lynxutil.GetFile(local.Files, theirs.Path)
+		if ours == nil {
+			merged.Files = append(merged.Files, theirs)
+			continue
+		}
+
+		switch CompareVersions(ours.Versions, theirs.Versions) {
+		case Newer:
+			merged.Files = append(merged.Files, *ours)
+			newer = true
+		case Concurrent:
+			if ours.Hash != theirs.Hash {
+				conflicts = append(conflicts, Conflict{Path: theirs.Path, LocalHash: ours.Hash})
+			}
+			theirs.Versions = MergeVersions(ours.Versions, theirs.Versions)
+			merged.Files = append(merged.Files, theirs)
+			newer = true
+		default:
+			merged.Files = append(merged.Files, theirs)
+		}
+	}
+
+	// Files only we have were added here and haven't been pushed yet - unless they deleted them
+	for _, ours := range local.Files {
+		if lynxutil.GetFile(incoming.Files, ours.Path) == nil && merged.tombstone(ours.Path) == nil {
+			merged.Files = append(merged.Files, ours)
+			newer = true
+		}
+	}
+
+	// Deletions they haven't heard about yet - ones they already dropped as too old stay dropped
+	for _, t := range local.Tombstones {
+		if merged.tombstone(t.Path) == nil && lynxutil.GetFile(merged.Files, t.Path) == nil &&
+			!expired(t, now) {
+			merged.Tombstones = append(merged.Tombstones, t)
+			newer = true
+		}
+	}
+
+	return merged, conflicts, newer
+}
+
+// Returns the tombstone of a file, if the meta.info has one.
+// @param string relPath - The file's path inside the lynk
+// @return *lynxutil.Tombstone - The tombstone, or nil if the file wasn't deleted
+func (m *Meta) tombstone(relPath string) *lynxutil.Tombstone {
+	i := 0
+	for i < len(m.Tombstones) {
+		if m.Tombstones[i].Path == relPath {
+			return &m.Tombstones[i]
+		}
+		i++
+	}
+	return nil
+}
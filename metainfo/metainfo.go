@@ -0,0 +1,330 @@
+// Package metainfo reads and writes meta.info files. Every part of Lynx that touches a meta.info
+// goes through this package so there is only one definition of the format.
+// @author: Michael Bruce
+// @author: Max Kernchen
+package metainfo
+
+import (
+	"../lynxutil"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version - The current version of the meta.info format. Version 1 was the original line based
+// 'key:::value' format, which is still read and is migrated to this version on load.
+const Version = 2
+
+// A special symbol version 1 used to denote the end of 1 entry in the metainfo file
+const legacyEndOfEntry = ":#!"
+
+// The array index of version 1's values
+const legacyValueIndex = 1
+
+// TombstoneRetention - How long a deleted file's tombstone is kept. Peers that haven't heard of a
+// deletion by then are unlikely to come back, and a lynk's tombstones would otherwise grow forever.
+const TombstoneRetention = 90 * 24 * time.Hour
+
+// Meta - A struct which holds everything stored in a meta.info file.
+type Meta struct {
+	Version  int             `json:"version"`
+	Announce string          `json:"announce"` // The tracker's IP:Port
+	LynkName string          `json:"lynkName"`
+	Owner    string          `json:"owner"`
+	OwnerKey string          `json:"ownerKey,omitempty"` // The fingerprint of the owner's key
+	Files    []lynxutil.File `json:"files"`
+	// Files that have been deleted from the lynk - kept so peers delete their copies too
+	Tombstones []lynxutil.Tombstone `json:"tombstones,omitempty"`
+	// The fingerprints of the keys, besides the owner's, the owner allowed to change the lynk
+	Writers []string `json:"writers,omitempty"`
+	// The SHA-1 pieces of the .torrent a lynk was imported from, hex encoded. Its files have no
+	// hashes of their own until it is shared from Lynx, so they are checked against these.
+	PieceLength int    `json:"pieceLength,omitempty"`
+	Pieces      string `json:"pieces,omitempty"`
+	// Raised by every push, so a push can't be replayed once a newer one has been taken
+	Sequence uint64 `json:"sequence,omitempty"`
+	// The armored public key of whoever last pushed the meta.info, and their signature of it
+	Signer    string `json:"signer,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// New - Creates an empty meta.info for a lynk in the current format.
+// @param string lynkName - The name of the lynk
+// @param string owner - The owner of the lynk
+// @param string announce - The tracker's IP:Port
+// @return *Meta - The new meta.info
+func New(lynkName, owner, announce string) *Meta {
+	return &Meta{Version: Version, Announce: announce, LynkName: lynkName, Owner: owner}
+}
+
+// FromLynk - Creates a meta.info in the current format describing a lynk.
+// @param *lynxutil.Lynk lynk - The lynk to describe
+// @return *Meta - The lynk's meta.info
+func FromLynk(lynk *lynxutil.Lynk) *Meta {
+	m := New(lynk.Name, lynk.Owner, lynk.Tracker)
+	m.OwnerKey = lynk.OwnerKey
+	m.Writers = append([]string{}, lynk.Writers...)
+	m.Files = append([]lynxutil.File{}, lynk.Files...)
+	m.Tombstones = append([]lynxutil.Tombstone{}, lynk.Tombstones...)
+	return m
+}
+
+// ApplyTo - Copies the meta.info's information onto a lynk.
+// @param *lynxutil.Lynk lynk - The lynk to update
+func (m *Meta) ApplyTo(lynk *lynxutil.Lynk) {
+	lynk.Tracker = m.Announce
+	lynk.Name = m.LynkName
+	lynk.Owner = m.Owner
+	lynk.OwnerKey = m.OwnerKey
+	lynk.Writers = append([]string{}, m.Writers...)
+	lynk.Files = append([]lynxutil.File{}, m.Files...)
+	lynk.Tombstones = append([]lynxutil.Tombstone{}, m.Tombstones...)
+}
+
+// Add - Adds a file to the meta.info. A file added back after being deleted loses its tombstone.
+// @param lynxutil.File file - The file to add
+func (m *Meta) Add(file lynxutil.File) {
+	m.Files = append(m.Files, file)
+	m.removeTombstone(file.Path)
+}
+
+// Delete - Removes a file from the meta.info and leaves a tombstone in its place.
+// @param string relPath - The file's path inside the lynk
+// @param time.Time when - When the file was deleted
+// @return bool - False if the file wasn't in the meta.info
+func (m *Meta) Delete(relPath string, when time.Time) bool {
+	i := 0
+	for i < len(m.Files) {
+		if m.Files[i].Path == relPath {
+			m.removeTombstone(relPath)
+			m.Tombstones = append(m.Tombstones, lynxutil.Tombstone{Path: relPath,
+				Hash: m.Files[i].Hash, Deleted: when.Unix(), Versions: m.Files[i].Versions})
+			m.Files = append(m.Files[:i], m.Files[i+1:]...)
+			return true
+		}
+		i++
+	}
+	return false
+}
+
+// PruneTombstones - Drops the tombstones that are older than TombstoneRetention.
+// @param time.Time now - The time to measure their age from
+// @return bool - True if a tombstone was dropped
+func (m *Meta) PruneTombstones(now time.Time) bool {
+	kept := []lynxutil.Tombstone{}
+	for _, t := range m.Tombstones {
+		if !expired(t, now) {
+			kept = append(kept, t)
+		}
+	}
+
+	pruned := len(kept) != len(m.Tombstones)
+	if pruned {
+		m.Tombstones = kept
+	}
+	return pruned
+}
+
+// Returns whether a tombstone is past TombstoneRetention.
+// @param lynxutil.Tombstone t - The tombstone
+// @param time.Time now - The time to measure its age from
+// @return bool - True if the tombstone can be dropped
+func expired(t lynxutil.Tombstone, now time.Time) bool {
+	return now.Sub(time.Unix(t.Deleted, 0)) > TombstoneRetention
+}
+
+// Removes the tombstone of a file if there is one.
+// @param string relPath - The file's path inside the lynk
+func (m *Meta) removeTombstone(relPath string) {
+	i := 0
+	for i < len(m.Tombstones) {
+		if m.Tombstones[i].Path == relPath {
+			m.Tombstones = append(m.Tombstones[:i], m.Tombstones[i+1:]...)
+		} else {
+			i++
+		}
+	}
+}
+
+// Decode - Reads a meta.info of any version.
+// @param io.Reader r - Where the meta.info is read from
+// @return *Meta - The meta.info, always converted to the current version
+// @return error - An error is produced if the data is not a meta.info or is from a newer version
+// of Lynx than this one
+func Decode(r io.Reader) (*Meta, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *Meta
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		m = &Meta{}
+		if err = json.Unmarshal(trimmed, m); err != nil {
+			return nil, err
+		} else if m.Version > Version {
+			return nil, errors.New("meta.info Version " + strconv.Itoa(m.Version) +
+				" Is Newer Than This Version Of Lynx")
+		} else if m.Version < 2 {
+			return nil, errors.New("Invalid meta.info Version")
+		}
+	} else if m, err = decodeLegacy(trimmed); err != nil {
+		return nil, err
+	}
+
+	// Every file path must stay inside the lynk. Version 1 stored the sharer's absolute path,
+	// which becomes a file at the top of the lynk.
+	for i := range m.Files {
+		relPath, err := lynxutil.CleanPath(m.Files[i].Path)
+		if err != nil {
+			if relPath, err = lynxutil.CleanPath(m.Files[i].Name); err != nil {
+				return nil, err
+			}
+		}
+		m.Files[i].Path = relPath
+	}
+
+	// A tombstone that points outside the lynk is dropped rather than acted on
+	tombstones := []lynxutil.Tombstone{}
+	for _, t := range m.Tombstones {
+		if relPath, err := lynxutil.CleanPath(t.Path); err == nil {
+			t.Path = relPath
+			tombstones = append(tombstones, t)
+		}
+	}
+	m.Tombstones = tombstones
+
+	m.Version = Version
+	return m, nil
+}
+
+// Encode - Writes a meta.info in the current format.
+// @param io.Writer w - Where the meta.info is written
+// @param *Meta m - The meta.info
+// @return error - An error can be produced if w cannot be written to
+func Encode(w io.Writer, m *Meta) error {
+	m.Version = Version
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Read - Reads a meta.info file from disk. A file in an older format is rewritten in the current
+// format so it only has to be migrated once.
+// @param string metaPath - The path to the meta.info file
+// @return *Meta - The meta.info
+// @return error - An error is produced if the file cannot be opened or is not a meta.info
+func Read(metaPath string) (*Meta, error) {
+	metaFile, err := os.Open(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(metaFile)
+	metaFile.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		Write(metaPath, m) // Migration is best effort - the file may be read only
+	}
+
+	return m, nil
+}
+
+// Write - Writes a meta.info file to disk in the current format. The new file replaces the old
+// one in a single rename so readers never see a half-written meta.info.
+// @param string metaPath - The path to the meta.info file
+// @param *Meta m - The meta.info
+// @return error - An error can be produced if the file cannot be created or renamed
+func Write(metaPath string, m *Meta) error {
+	tmpFile, err := os.Create(metaPath + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = Encode(tmpFile, m)
+	if cErr := tmpFile.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(metaPath + ".tmp")
+		return err
+	}
+
+	return os.Rename(metaPath+".tmp", metaPath)
+}
+
+// Reads a version 1 meta.info - one 'key:::value' per line with ':#!' ending each file's entry.
+// @param []byte data - The meta.info's contents
+// @return *Meta - The meta.info
+// @return error - An error is produced if the data doesn't look like a meta.info at all
+func decodeLegacy(data []byte) (*Meta, error) {
+	m := &Meta{Version: 1}
+	valid := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1) // Chunk hashes make for long lines
+	tempFile := lynxutil.File{}
+	for scanner.Scan() { // Scan each line
+		line := strings.TrimSpace(scanner.Text())
+		if line == legacyEndOfEntry {
+			m.Files = append(m.Files, tempFile) // Append the current file to the file array
+			tempFile = lynxutil.File{}          // Empty the current file
+			continue
+		}
+
+		split := strings.SplitN(line, ":::", 2)
+		if len(split) != 2 {
+			continue
+		}
+		value := split[legacyValueIndex]
+
+		if split[0] == "announce" {
+			m.Announce = value
+			valid = true
+		} else if split[0] == "owner" {
+			m.Owner = value
+		} else if split[0] == "lynkName" {
+			m.LynkName = value
+		} else if split[0] == "chunkLength" {
+			tempFile.ChunkLength, _ = strconv.Atoi(value)
+		} else if split[0] == "length" {
+			tempFile.Length, _ = strconv.Atoi(value)
+		} else if split[0] == "path" {
+			tempFile.Path = value
+		} else if split[0] == "name" {
+			tempFile.Name = value
+		} else if split[0] == "chunks" && value != "" {
+			tempFile.Chunks = strings.Split(value, ",")
+		} else if split[0] == "hash" {
+			tempFile.Hash = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	} else if !valid {
+		return nil, errors.New("Invalid File Type")
+	}
+	return m, nil
+}
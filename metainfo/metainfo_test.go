@@ -0,0 +1,359 @@
+// The unit tests for the metainfo package - reading legacy and JSON meta.info files, lynk URIs,
+// tombstones, merging pushed meta.info files, and signing them and checking pushes
+// @author: Michael Bruce
+// @author: Max Kernchen
+package metainfo
+
+import (
+	"bytes"
+	"capstone/lynxutil"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 21
+
+// The passphrase protecting the keys of the nodes the tests create
+var passphrase = []byte("lynx tests")
+
+// Loads the default node the tests run on, under LYNX_PASSPHRASE or else the test passphrase.
+func init() {
+	if os.Getenv(lynxutil.PassphraseEnv) == "" {
+		os.Setenv(lynxutil.PassphraseEnv, string(passphrase))
+	}
+	lynxutil.LoadDefault()
+}
+
+// A meta.info in the original line based format
+const legacyMeta = "announce:::127.0.0.1:9000\n" +
+	"lynkName:::Tests\n" +
+	"owner:::Tester\n" +
+	"length:::14\n" +
+	"path:::/Users/tester/gospace/src/capstone/client/test.txt\n" +
+	"name:::test.txt\n" +
+	"chunkLength:::262144\n" +
+	"chunks:::abc,def\n" +
+	"hash:::123\n" +
+	":#!\n"
+
+// Unit tests for decoding a meta.info in the original format.
+// @param *testing.T t - The wrapper for the test
+func TestDecodeLegacy(t *testing.T) {
+	fmt.Println("\n----------------TestDecodeLegacy----------------")
+
+	m, err := Decode(strings.NewReader(legacyMeta))
+	if err != nil || m.Announce != "127.0.0.1:9000" || m.LynkName != "Tests" || len(m.Files) != 1 {
+		t.Error("Test failed, expected the legacy meta.info to be read. Got ", m, err)
+		return
+	}
+	fmt.Println("Successfully Read Legacy meta.info")
+	successful++
+
+	// Absolute paths from the old format become files at the top of the lynk
+	if m.Files[0].Path != "test.txt" || len(m.Files[0].Chunks) != 2 || m.Version != Version {
+		t.Error("Test failed, expected a migrated entry for test.txt. Got ", m.Files[0])
+	} else {
+		fmt.Println("Successfully Migrated Legacy Entry")
+		successful++
+	}
+
+	_, err = Decode(strings.NewReader("this is not a meta.info\n"))
+	if err == nil {
+		t.Error("Test failed, expected an invalid file type error.")
+	} else {
+		fmt.Println("Successfully Rejected Non meta.info")
+		successful++
+	}
+}
+
+// Unit tests for encoding and decoding the current format.
+// @param *testing.T t - The wrapper for the test
+func TestEncodeDecode(t *testing.T) {
+	fmt.Println("\n----------------TestEncodeDecode----------------")
+
+	m := New("Tests", "Tester", "127.0.0.1:9000")
+	m.Files = append(m.Files, lynxutil.File{Length: 3, Path: "docs/a:::b.txt", Name: "a:::b.txt"})
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, m); err != nil {
+		t.Error("Test failed, expected no errors. Got ", err)
+		return
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil || len(decoded.Files) != 1 || decoded.Files[0].Path != "docs/a:::b.txt" {
+		t.Error("Test failed, expected 'docs/a:::b.txt' to survive a round trip. Got ", decoded, err)
+	} else {
+		fmt.Println("Successfully Round Tripped A Name Containing :::")
+		successful++
+	}
+
+	_, err = Decode(strings.NewReader("{\"version\": 99}"))
+	if err == nil {
+		t.Error("Test failed, expected a newer version to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Newer Version")
+		successful++
+	}
+}
+
+// Unit tests for our join URIs.
+// @param *testing.T t - The wrapper for the test
+func TestURI(t *testing.T) {
+	fmt.Println("\n----------------TestURI----------------")
+
+	m := New("Cool Lynk", "Tester", "127.0.0.1:9000")
+	m.OwnerKey = "abcd"
+	var buf bytes.Buffer
+	Encode(&buf, m)
+
+	u := URI{Tracker: m.Announce, LynkName: m.LynkName, OwnerKey: m.OwnerKey, MetaHash: Hash(buf.Bytes())}
+	parsed, err := ParseURI(u.String())
+	if err != nil || parsed != u {
+		t.Error("Test failed, expected the URI back. Got ", parsed, err)
+	} else if _, err = parsed.Check(buf.Bytes()); err != nil {
+		t.Error("Test failed, expected the meta.info to match. Got ", err)
+	} else {
+		fmt.Println("Successfully Round Tripped URI")
+		successful++
+	}
+
+	if _, err = parsed.Check(append(buf.Bytes(), ' ')); err == nil {
+		t.Error("Test failed, expected a changed meta.info to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Changed meta.info")
+		successful++
+	}
+
+	// Once the lynk changes, only a meta.info the URI's owner signed is taken
+	m.OwnerKey = lynxutil.Default.KeyFingerprint
+	buf.Reset()
+	Encode(&buf, m)
+	u = URI{Tracker: m.Announce, LynkName: m.LynkName, OwnerKey: m.OwnerKey, MetaHash: Hash(buf.Bytes())}
+	m.Add(lynxutil.File{Path: "a.txt", Hash: "1"})
+	m.Sign(lynxutil.Default)
+	buf.Reset()
+	Encode(&buf, m)
+	_, signedErr := u.Check(buf.Bytes())
+	m.Owner = "Someone Else"
+	buf.Reset()
+	Encode(&buf, m)
+	if _, err = u.Check(buf.Bytes()); signedErr != nil || err == nil {
+		t.Error("Test failed, expected only the owner's signed meta.info to be taken. Got ",
+			signedErr, err)
+	} else {
+		fmt.Println("Successfully Took Owner's Signed meta.info")
+		successful++
+	}
+}
+
+// Unit tests for our Add and Delete functions.
+// @param *testing.T t - The wrapper for the test
+func TestTombstones(t *testing.T) {
+	fmt.Println("\n----------------TestTombstones----------------")
+
+	m := New("Tests", "Tester", "127.0.0.1:9000")
+	m.Add(lynxutil.File{Path: "docs/a.txt", Hash: "123"})
+	m.Delete("docs/a.txt", time.Unix(100, 0))
+	if len(m.Files) != 0 || len(m.Tombstones) != 1 || m.Tombstones[0].Hash != "123" ||
+		m.Tombstones[0].Deleted != 100 {
+		t.Error("Test failed, expected a tombstone for docs/a.txt. Got ", m.Tombstones)
+	} else {
+		fmt.Println("Successfully Left Tombstone")
+		successful++
+	}
+
+	m.Add(lynxutil.File{Path: "docs/a.txt", Hash: "456"})
+	if len(m.Files) != 1 || len(m.Tombstones) != 0 {
+		t.Error("Test failed, expected adding the file back to clear its tombstone. Got ", m.Tombstones)
+	} else {
+		fmt.Println("Successfully Cleared Tombstone")
+		successful++
+	}
+
+	// Old tombstones are dropped, and a merge doesn't bring them back
+	m.Delete("docs/a.txt", time.Now().Add(-TombstoneRetention-time.Hour))
+	m.Add(lynxutil.File{Path: "b.txt", Hash: "789"})
+	m.Delete("b.txt", time.Now())
+	local := *m
+	pruned := m.PruneTombstones(time.Now())
+	merged, _, newer := Merge(&local, m)
+	if !pruned || len(m.Tombstones) != 1 || m.Tombstones[0].Path != "b.txt" || newer ||
+		len(merged.Tombstones) != 1 {
+		t.Error("Test failed, expected only the old tombstone to be dropped. Got ", m.Tombstones,
+			merged.Tombstones)
+	} else {
+		fmt.Println("Successfully Dropped Old Tombstone")
+		successful++
+	}
+}
+
+// Unit tests for merging a pushed meta.info into ours.
+// @param *testing.T t - The wrapper for the test
+func TestMerge(t *testing.T) {
+	fmt.Println("\n----------------TestMerge----------------")
+
+	if CompareVersions(map[string]int{"a": 2}, map[string]int{"a": 1, "b": 1}) != Concurrent ||
+		CompareVersions(map[string]int{"a": 2, "b": 1}, map[string]int{"a": 1, "b": 1}) != Newer {
+		t.Error("Test failed, expected version vectors to be compared correctly.")
+	} else {
+		fmt.Println("Successfully Compared Versions")
+		successful++
+	}
+
+	local := New("Tests", "Tester", "127.0.0.1:9000")
+	local.Add(lynxutil.File{Path: "both.txt", Hash: "ours", Versions: map[string]int{"a": 2}})
+	local.Add(lynxutil.File{Path: "ours.txt", Hash: "1", Versions: map[string]int{"a": 2}})
+	incoming := New("Tests", "Tester", "127.0.0.1:9000")
+	incoming.Add(lynxutil.File{Path: "both.txt", Hash: "theirs", Versions: map[string]int{"a": 1, "b": 1}})
+	incoming.Add(lynxutil.File{Path: "ours.txt", Hash: "0", Versions: map[string]int{"a": 1}})
+
+	merged, conflicts, newer := Merge(local, incoming)
+	both := lynxutil.GetFile(merged.Files, "both.txt")
+	if len(conflicts) != 1 || conflicts[0].LocalHash != "ours" || both.Hash != "theirs" ||
+		CompareVersions(both.Versions, map[string]int{"a": 2, "b": 1}) != Same {
+		t.Error("Test failed, expected a conflict on both.txt won by theirs. Got ", conflicts, both)
+	} else {
+		fmt.Println("Successfully Detected Conflict")
+		successful++
+	}
+
+	if !newer || lynxutil.GetFile(merged.Files, "ours.txt").Hash != "1" {
+		t.Error("Test failed, expected our newer ours.txt to be kept and pushed.")
+	} else {
+		fmt.Println("Successfully Kept Newer Local Edit")
+		successful++
+	}
+}
+
+// Signs a meta.info and sends it through Encode and Decode, as a push would.
+// @param *Meta m - The meta.info
+// @param *lynxutil.Node node - The node signing it
+// @return *Meta - The meta.info as the receiver reads it
+func signAndSend(m *Meta, node *lynxutil.Node) *Meta {
+	signed := *m
+	signed.Sign(node)
+	var wire bytes.Buffer
+	Encode(&wire, &signed)
+	received, _ := Decode(&wire)
+	return received
+}
+
+// Unit tests for signing meta.info files and checking pushes.
+// @param *testing.T t - The wrapper for the test
+func TestSign(t *testing.T) {
+	fmt.Println("\n----------------TestSign----------------")
+
+	home, _ := ioutil.TempDir("", "lynx")
+	defer os.RemoveAll(home)
+	writer, err := lynxutil.NewNode(home, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := New("Tests", "Tester", "127.0.0.1:9000")
+	local.OwnerKey = lynxutil.Default.KeyFingerprint
+	local.Add(lynxutil.File{Path: "a.txt", Hash: "1", Versions: map[string]int{"a": 1}})
+	incoming := signAndSend(FromLynk(&lynxutil.Lynk{Name: "Tests", Owner: "Tester",
+		OwnerKey: local.OwnerKey, Files: local.Files}), lynxutil.Default)
+	if err = CheckPush(local, incoming); err != nil {
+		t.Error("Test failed, expected the owner's push to be taken. Got ", err)
+	} else {
+		fmt.Println("Successfully Checked Owner's Push")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestCheckPush----------------")
+
+	unsignedErr := CheckPush(local, local)
+	incoming.Files[0].Hash = "2"
+	tamperedErr := CheckPush(local, incoming)
+	if unsignedErr != ErrUnsigned || tamperedErr != ErrBadSignature {
+		t.Error("Test failed, expected unsigned and tampered pushes to be refused. Got ",
+			unsignedErr, tamperedErr)
+	} else {
+		fmt.Println("Successfully Refused Unsigned And Tampered Pushes")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestAuthorised----------------")
+
+	strangerErr := CheckPush(local, signAndSend(local, writer))
+	local.Writers = []string{writer.KeyFingerprint}
+	writerErr := CheckPush(local, signAndSend(local, writer))
+	if strangerErr != ErrUnauthorised || writerErr != nil {
+		t.Error("Test failed, expected only authorised keys to push. Got ", strangerErr,
+			writerErr)
+	} else {
+		fmt.Println("Successfully Checked Authorised Keys")
+		successful++
+	}
+
+	// Writers can change the files but not who else may
+	grown := signAndSend(FromLynk(&lynxutil.Lynk{Name: "Tests", OwnerKey: local.OwnerKey,
+		Writers: []string{writer.KeyFingerprint, "ab"}}), writer)
+	if err = CheckPush(local, grown); err != ErrUnauthorised {
+		t.Error("Test failed, expected a writer adding writers to be refused. Got ", err)
+	} else {
+		fmt.Println("Successfully Refused Writer Adding Writers")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestReplay----------------")
+
+	taken := signAndSend(local, lynxutil.Default)
+	local.Sequence = taken.Sequence
+	if err = CheckPush(local, taken); err != ErrStale {
+		t.Error("Test failed, expected a replayed push to be refused. Got ", err)
+	} else if err = CheckPush(local, signAndSend(local, lynxutil.Default)); err != nil {
+		t.Error("Test failed, expected the next push to be taken. Got ", err)
+	} else {
+		fmt.Println("Successfully Refused Replayed Push")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestAdopt----------------")
+
+	unowned := New("Tests", "Tester", "127.0.0.1:9000")
+	unownedErr := CheckPush(unowned, signAndSend(unowned, lynxutil.Default))
+	err = unowned.Adopt(lynxutil.Default.KeyFingerprint)
+	if unownedErr != ErrUnauthorised || err != nil {
+		t.Error("Test failed, expected pushes to wait for an owner key. Got ", unownedErr, err)
+	} else if err = CheckPush(unowned, signAndSend(unowned, lynxutil.Default)); err != nil {
+		t.Error("Test failed, expected the adopted owner's push to be taken. Got ", err)
+	} else if err = unowned.Adopt(writer.KeyFingerprint); err != ErrOwned {
+		t.Error("Test failed, expected an owned lynk to keep its owner. Got ", err)
+	} else {
+		fmt.Println("Successfully Adopted Owner Key")
+		successful++
+	}
+}
+
+// Unit tests for our Read function.
+// @param *testing.T t - The wrapper for the test
+func TestRead(t *testing.T) {
+	fmt.Println("\n----------------TestRead----------------")
+
+	metaPath := "test_meta.info"
+	defer os.Remove(metaPath)
+	ioutil.WriteFile(metaPath, []byte(legacyMeta), 0644)
+
+	_, err := Read(metaPath)
+	data, _ := ioutil.ReadFile(metaPath)
+	if err != nil || !bytes.HasPrefix(data, []byte("{")) {
+		t.Error("Test failed, expected the legacy file to be rewritten. Got ", string(data), err)
+	} else {
+		fmt.Println("Successfully Migrated meta.info On Disk")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
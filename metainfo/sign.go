@@ -0,0 +1,169 @@
+// Signatures for the metainfo package - whoever pushes a meta.info signs it, and a push is only
+// taken if it was signed by the lynk's owner or by a writer the owner authorised.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package metainfo
+
+import (
+	"../lynxutil"
+	"../mypgp"
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ErrUnsigned - Returned when a pushed meta.info carries no signature
+var ErrUnsigned = errors.New("meta.info Is Not Signed")
+
+// ErrBadSignature - Returned when a meta.info's signature doesn't match its contents
+var ErrBadSignature = errors.New("meta.info Signature Is Invalid")
+
+// ErrUnauthorised - Returned when a meta.info was signed by a key not allowed to change the lynk
+var ErrUnauthorised = errors.New("meta.info Was Signed By A Key Not Authorised For The Lynk")
+
+// ErrStale - Returned when a pushed meta.info is no newer than ours, e.g. an old push replayed
+var ErrStale = errors.New("meta.info Is Not Newer Than Ours")
+
+// ErrOwned - Returned when adopting an owner key for a lynk that already has one
+var ErrOwned = errors.New("Lynk Already Has An Owner Key")
+
+// Sign - Signs the meta.info with a node's key as the next push of the lynk - its Sequence is
+// raised by one and signed with the rest. The node's public key goes in the meta.info too, so
+// peers can check the signature without having seen the key before.
+// @param *lynxutil.Node node - The node signing the meta.info
+// @return error - An error is produced if the node's key can't sign
+func (m *Meta) Sign(node *lynxutil.Node) error {
+	m.Sequence++
+	m.Signer = node.PublicKey
+	data, err := m.signedData()
+	if err != nil {
+		return err
+	}
+
+	var signature bytes.Buffer
+	if err = node.Sign(bytes.NewReader(data), &signature); err != nil {
+		return err
+	}
+	m.Signature = signature.String()
+	return nil
+}
+
+// Verify - Checks the meta.info's signature. It only says who signed the meta.info - whether they
+// were allowed to is up to Authorised.
+// @return string - The fingerprint of the key that signed the meta.info
+// @return error - ErrUnsigned or ErrBadSignature if the meta.info isn't signed by its Signer
+func (m *Meta) Verify() (string, error) {
+	if m.Signer == "" || m.Signature == "" {
+		return "", ErrUnsigned
+	}
+	data, err := m.signedData()
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, err := mypgp.Verify([]byte(m.Signer), bytes.NewReader(data),
+		strings.NewReader(m.Signature))
+	if err != nil {
+		return "", ErrBadSignature
+	}
+	return fingerprint, nil
+}
+
+// Authorised - Returns whether a key may change the lynk - the owner's key and the writers' keys
+// may. A lynk with no owner key predates signing, so no one may change it until an owner key is
+// adopted for it.
+// @param string fingerprint - The fingerprint of the key
+// @return bool - True if the key may change the lynk
+func (m *Meta) Authorised(fingerprint string) bool {
+	if m.OwnerKey == "" || fingerprint == "" {
+		return false
+	} else if strings.EqualFold(m.OwnerKey, fingerprint) {
+		return true
+	}
+	for _, writer := range m.Writers {
+		if strings.EqualFold(writer, fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adopt - Gives a lynk from before meta.infos were signed an owner key, so it can be changed again.
+// Whoever adopts it has to trust the key - peers only take pushes signed by it from then on.
+// @param string fingerprint - The fingerprint of the owner's key
+// @return error - ErrOwned if the lynk already has another owner key
+func (m *Meta) Adopt(fingerprint string) error {
+	if strings.EqualFold(m.OwnerKey, fingerprint) {
+		return nil
+	} else if m.OwnerKey != "" {
+		return ErrOwned
+	}
+	m.OwnerKey = strings.ToLower(fingerprint)
+	return nil
+}
+
+// CheckPush - Checks a pushed meta.info before it replaces ours. The push has to be allowed by
+// CheckSigner and be newer than ours, so an old push can't be replayed to undo later changes.
+// @param *Meta local - Our meta.info
+// @param *Meta incoming - The pushed meta.info
+// @return error - ErrUnsigned, ErrBadSignature, ErrUnauthorised or ErrStale if the push must be
+// refused
+func CheckPush(local, incoming *Meta) error {
+	if err := CheckSigner(local, incoming); err != nil {
+		return err
+	} else if incoming.Sequence <= local.Sequence {
+		return ErrStale
+	}
+	return nil
+}
+
+// CheckSigner - Checks who signed a meta.info. Our meta.info decides who may push - the pushed one
+// has to be signed by a key it authorises and keep the same owner, and only the owner may change
+// who the writers are.
+// @param *Meta local - Our meta.info
+// @param *Meta incoming - The pushed meta.info
+// @return error - ErrUnsigned, ErrBadSignature or ErrUnauthorised if the meta.info must be refused
+func CheckSigner(local, incoming *Meta) error {
+	fingerprint, err := incoming.Verify()
+	if err != nil {
+		return err
+	}
+
+	owner := strings.EqualFold(local.OwnerKey, fingerprint)
+	if !local.Authorised(fingerprint) || !strings.EqualFold(local.OwnerKey, incoming.OwnerKey) ||
+		!owner && !sameWriters(local, incoming) {
+		return ErrUnauthorised
+	}
+	return nil
+}
+
+// Returns whether two meta.infos authorise the same writers.
+// @param *Meta a - The first meta.info
+// @param *Meta b - The second meta.info
+// @return bool - True if every writer of each is a writer of the other
+func sameWriters(a, b *Meta) bool {
+	for _, writer := range a.Writers {
+		if !b.Authorised(writer) {
+			return false
+		}
+	}
+	for _, writer := range b.Writers {
+		if !a.Authorised(writer) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the bytes a meta.info's signature covers - the meta.info in the current format without
+// its signature.
+// @return []byte - The signed bytes
+// @return error - An error is produced if the meta.info can't be encoded
+func (m *Meta) signedData() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+	var data bytes.Buffer
+	err := Encode(&data, &unsigned)
+	return data.Bytes(), err
+}
@@ -0,0 +1,111 @@
+// Join URIs for the metainfo package - a lynx:// URI names a lynk's tracker, its owner's key and
+// the meta.info it had when the URI was made, so people can share a link instead of the meta.info
+// itself.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// URIScheme - The scheme of a lynk join URI
+const URIScheme = "lynx"
+
+// URI - A parsed lynk join URI. E.G. -
+// 'lynx://10.0.0.5:9000/Cool_Lynk?owner=<key fingerprint>&meta=<meta.info SHA-256>'
+type URI struct {
+	Tracker  string // The tracker's IP:Port
+	LynkName string
+	OwnerKey string // The fingerprint of the owner's key
+	MetaHash string // The SHA-256 hex digest of the meta.info
+}
+
+// Hash - Hashes a meta.info exactly as it is stored or sent over the network.
+// @param []byte data - The meta.info's contents
+// @return string - The SHA-256 hex digest
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// String - Formats the URI so it can be shared.
+// @return string - The lynx:// URI
+func (u URI) String() string {
+	query := url.Values{}
+	if u.OwnerKey != "" {
+		query.Set("owner", u.OwnerKey)
+	}
+	query.Set("meta", u.MetaHash)
+
+	return (&url.URL{
+		Scheme:   URIScheme,
+		Host:     u.Tracker,
+		Path:     "/" + u.LynkName,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// ParseURI - Parses a lynk join URI.
+// @param string rawURI - The lynx:// URI
+// @return URI - The parsed URI
+// @return error - An error is produced if the URI is not a lynx:// URI or is missing the tracker,
+// lynk name or meta.info hash
+func ParseURI(rawURI string) (URI, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawURI))
+	if err != nil {
+		return URI{}, err
+	} else if parsed.Scheme != URIScheme {
+		return URI{}, errors.New("Not A " + URIScheme + ":// URI")
+	}
+
+	u := URI{
+		Tracker:  parsed.Host,
+		LynkName: strings.TrimPrefix(parsed.Path, "/"),
+		OwnerKey: parsed.Query().Get("owner"),
+		MetaHash: strings.ToLower(parsed.Query().Get("meta")),
+	}
+
+	if u.Tracker == "" {
+		return URI{}, errors.New("URI Has No Tracker")
+	} else if u.LynkName == "" || strings.ContainsAny(u.LynkName, "/\\:") {
+		return URI{}, errors.New("Invalid Lynk Name In URI")
+	} else if len(u.MetaHash) != sha256.Size*2 {
+		return URI{}, errors.New("URI Has No meta.info Hash")
+	}
+	return u, nil
+}
+
+// Check - Checks that a meta.info fetched for a URI is the one the URI names. The hash only pins
+// the meta.info as it was when the URI was made - once the lynk has changed, a meta.info signed by
+// the owner named in the URI, or a writer they authorised, is taken instead. A URI without an
+// owner only ever matches the one meta.info.
+// @param []byte data - The meta.info's contents as fetched
+// @return *Meta - The meta.info
+// @return error - An error is produced if the lynk name or owner doesn't match, or the meta.info
+// neither has the pinned hash nor is signed by the owner
+func (u URI) Check(data []byte) (*Meta, error) {
+	m, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	} else if m.LynkName != u.LynkName {
+		return nil, errors.New("meta.info Is For " + m.LynkName + " Not " + u.LynkName)
+	} else if u.OwnerKey != "" && !strings.EqualFold(m.OwnerKey, u.OwnerKey) {
+		return nil, errors.New("meta.info Is Not From The Lynk's Owner")
+	} else if Hash(data) == u.MetaHash {
+		return m, nil
+	} else if u.OwnerKey == "" {
+		return nil, errors.New("meta.info Does Not Match The Link - Ask For A New One")
+	}
+
+	if signer, err := m.Verify(); err != nil || !m.Authorised(signer) {
+		return nil, errors.New("meta.info Is Not Signed By The Lynk's Owner")
+	}
+	return m, nil
+}
@@ -0,0 +1,264 @@
+// Authenticated encryption using AES-GCM. Data is sealed in length-prefixed records, each with a
+// nonce of its own, so a record that was changed on the way, sealed with another key, moved or cut
+// off is refused with an error instead of being decrypted into garbage.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package mycrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrAuthFailed - Returned when sealed data was tampered with or sealed with a different key
+var ErrAuthFailed = errors.New("Authentication Failed")
+
+// ErrTruncated - Returned when sealed data ends before its last record
+var ErrTruncated = errors.New("Sealed Data Was Cut Short")
+
+// RecordSize - The most plaintext a single record of a sealed stream holds
+const RecordSize = 65536
+
+// Set in a record's length to mark the last record of a stream
+const finalRecord = 1 << 31
+
+// Seal - This function takes a key and a plain text byte slice and encrypts and authenticates that
+// slice using AES-GCM.
+// @param []byte key - The key to be used for the encryption - 16, 24 or 32 bytes
+// @param []byte plaintext - The data that we would like sealed
+// @returns []byte - A random nonce followed by the sealed data
+// @returns error - An error can be produced if a cipher cannot be created from the passed in key
+func Seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open - This function checks and decrypts data sealed with Seal.
+// @param []byte key - The key the data was sealed with
+// @param []byte sealed - The sealed data
+// @returns []byte - The original data
+// @returns error - ErrAuthFailed if the data was tampered with or sealed with another key
+func Open(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrTruncated
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	return plaintext, nil
+}
+
+// NewSealWriter - This function wraps a writer so everything written to it is sealed in records
+// of at most RecordSize bytes. The writer must be closed to write the last record - a stream that
+// was never closed can't be opened.
+// @param []byte key - The key to be used for the encryption - 16, 24 or 32 bytes
+// @param io.Writer w - Where the sealed records should be written
+// @returns io.WriteCloser - A writer that seals into w
+// @returns error - An error can be produced if a cipher cannot be created from the passed in key
+// or if the stream's nonce cannot be written
+func NewSealWriter(key []byte, w io.Writer) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sealWriter{aead: aead, w: w, nonce: make([]byte, aead.NonceSize())}
+	if _, err = io.ReadFull(rand.Reader, s.nonce); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(s.nonce); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewOpenReader - This function wraps a reader of records written by NewSealWriter so everything
+// read from it is checked and decrypted. Nothing from a record is returned until the whole record
+// has been authenticated, and nothing past the last record is read from r.
+// @param []byte key - The key the stream was sealed with
+// @param io.Reader r - Where the sealed records will be read from
+// @returns io.Reader - A reader that opens from r. Its Read returns ErrAuthFailed if a record was
+// tampered with and ErrTruncated if the stream ends early.
+// @returns error - An error can be produced if a cipher cannot be created from the passed in key
+// or if the stream's nonce cannot be read
+func NewOpenReader(key []byte, r io.Reader) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &openReader{aead: aead, r: r, nonce: make([]byte, aead.NonceSize())}
+	if _, err = io.ReadFull(r, o.nonce); err != nil {
+		return nil, ErrTruncated
+	}
+	return o, nil
+}
+
+// sealWriter - Seals everything written to it into records.
+type sealWriter struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	nonce   []byte // The stream's nonce - each record's counter is mixed into it
+	counter uint64
+	buf     []byte // Plaintext waiting to fill a record
+	closed  bool
+}
+
+// Write - Seals every full record of the data and keeps the rest for later.
+// @param []byte p - The data
+// @return int - How much of p was taken
+// @return error - An error is produced if a record can't be written or the writer is closed
+func (s *sealWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("Write To Closed Seal Writer")
+	}
+
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= RecordSize {
+		if err := s.writeRecord(s.buf[:RecordSize], false); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[RecordSize:]
+	}
+	return len(p), nil
+}
+
+// Close - Seals whatever is left as the last record. It does not close the underlying writer.
+// @return error - An error is produced if the record can't be written
+func (s *sealWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.writeRecord(s.buf, true)
+}
+
+// Seals a record and writes it preceded by its length. The length is authenticated along with
+// the record, so a record can't be passed off as the last one.
+// @param []byte plaintext - The record's data
+// @param bool final - Whether this is the last record of the stream
+// @return error - An error is produced if the record can't be written
+func (s *sealWriter) writeRecord(plaintext []byte, final bool) error {
+	header := make([]byte, 4)
+	length := uint32(len(plaintext) + s.aead.Overhead())
+	if final {
+		length |= finalRecord
+	}
+	binary.BigEndian.PutUint32(header, length)
+
+	record := append(make([]byte, 0, 4+int(length&^finalRecord)), header...)
+	record = s.aead.Seal(record, recordNonce(s.nonce, s.counter), plaintext, header)
+	s.counter++
+	_, err := s.w.Write(record)
+	return err
+}
+
+// openReader - Checks and decrypts records one at a time.
+type openReader struct {
+	aead    cipher.AEAD
+	r       io.Reader
+	nonce   []byte
+	counter uint64
+	buf     []byte // Opened plaintext not yet read
+	done    bool   // The last record has been opened
+	err     error
+}
+
+// Read - Returns opened data, reading and opening the next record when needed.
+// @param []byte p - Where the data is copied
+// @return int - How much was copied
+// @return error - io.EOF after the last record, or the reason a record couldn't be opened
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.err != nil {
+			return 0, o.err
+		} else if o.done {
+			return 0, io.EOF
+		}
+		o.buf, o.err = o.readRecord()
+	}
+
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+// Reads and opens the next record.
+// @return []byte - The record's data
+// @return error - ErrAuthFailed or ErrTruncated if the record can't be opened
+func (o *openReader) readRecord() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(o.r, header); err != nil {
+		return nil, truncated(err)
+	}
+	length := binary.BigEndian.Uint32(header)
+	size := int(length &^ finalRecord)
+	if size < o.aead.Overhead() || size > RecordSize+o.aead.Overhead() {
+		return nil, ErrAuthFailed
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(o.r, sealed); err != nil {
+		return nil, truncated(err)
+	}
+	plaintext, err := o.aead.Open(sealed[:0], recordNonce(o.nonce, o.counter), sealed, header)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	o.counter++
+	o.done = length&finalRecord != 0
+	return plaintext, nil
+}
+
+// Creates an AES-GCM cipher.
+// @param []byte key - The key - 16, 24 or 32 bytes
+// @return cipher.AEAD - The cipher
+// @return error - An error is produced if the key has the wrong length
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the nonce of one record of a stream - the stream's nonce with the record's number mixed
+// into its last 8 bytes, so no two records share a nonce.
+// @param []byte nonce - The stream's nonce
+// @param uint64 counter - The record's number
+// @return []byte - The record's nonce
+func recordNonce(nonce []byte, counter uint64) []byte {
+	n := append([]byte{}, nonce...)
+	tail := n[len(n)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^counter)
+	return n
+}
+
+// Turns the end of the data in the middle of a record into ErrTruncated.
+// @param error err - The error from reading the record
+// @return error - ErrTruncated, or err if it wasn't caused by the data ending
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
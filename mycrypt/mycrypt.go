@@ -13,6 +13,8 @@ import (
 )
 
 // Encrypt - This function takes a key and a plain text byte slice and encrypts that slice using AES
+// CFB. Nothing detects if the result is tampered with - Seal should be used for data that crosses
+// the network.
 // @param []byte key - The key to be used for the encryption (AES requires only a single key
 // for encryption / decryption)
 // @param []byte text - The data that we would like encrypted.
@@ -69,3 +71,51 @@ func Decrypt(key, ciphertext []byte) (plaintext []byte, err error) {
 
 	return
 }
+
+// NewEncryptWriter - This function wraps a writer so everything written to it is encrypted using
+// AES. The output has the same layout as Encrypt's so it can be read back with Decrypt. Like
+// Encrypt it is unauthenticated - NewSealWriter should be used for data that crosses the network.
+// @param []byte key - The key to be used for the encryption
+// @param io.Writer w - Where the encrypted data should be written
+// @returns io.Writer - A writer that encrypts into w. Nothing is buffered so it never needs to be
+// flushed or closed.
+// @returns error err - An error can be produced if a cipher cannot be created from the passed
+// in key or if the initialization vector cannot be written. Otherwise it will be nil.
+func NewEncryptWriter(key []byte, w io.Writer) (io.Writer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// iv =  initialization vector
+	iv := make([]byte, aes.BlockSize)
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(iv); err != nil {
+		return nil, err
+	}
+
+	return &cipher.StreamWriter{S: cipher.NewCFBEncrypter(block, iv), W: w}, nil
+}
+
+// NewDecryptReader - This function wraps a reader of data produced by Encrypt or NewEncryptWriter
+// so everything read from it is decrypted.
+// @param []byte key - The key to be used for the decryption
+// @param io.Reader r - Where the encrypted data will be read from
+// @returns io.Reader - A reader that decrypts from r
+// @returns error err - An error can be produced if a cipher cannot be created from the passed
+// in key or if the initialization vector cannot be read. Otherwise it will be nil.
+func NewDecryptReader(key []byte, r io.Reader) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err = io.ReadFull(r, iv); err != nil {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return &cipher.StreamReader{S: cipher.NewCFBDecrypter(block, iv), R: r}, nil
+}
@@ -5,7 +5,11 @@
 package mycrypt
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -13,7 +17,130 @@ import (
 var successful = 0
 
 // Total # of the tests.
-const total = 3
+const total = 10
+
+// Unit tests for our NewEncryptWriter and NewDecryptReader functions.
+// @param *testing.T t - The wrapper for the test
+func TestStream(t *testing.T) {
+	fmt.Println("\n----------------TestEncryptWriter----------------")
+
+	key := []byte("longer means more possible keys ")
+	plaintext := "This is the unecrypted data. Referring to it as plain text."
+
+	var ciphertext bytes.Buffer
+	w, err := NewEncryptWriter(key, &ciphertext)
+	if err == nil {
+		_, err = io.Copy(w, strings.NewReader(plaintext))
+	}
+
+	// Streamed output must still be readable by Decrypt
+	decrypted, _ := Decrypt(key, append([]byte{}, ciphertext.Bytes()...))
+	if err != nil || string(decrypted) != plaintext {
+		t.Error("Test failed, expected Decrypt to read the stream. Got ", string(decrypted), err)
+	} else {
+		fmt.Println("Successfully Encrypted Stream")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestDecryptReader----------------")
+
+	r, err := NewDecryptReader(key, &ciphertext)
+	var out []byte
+	if err == nil {
+		out, err = ioutil.ReadAll(r)
+	}
+
+	if err != nil || string(out) != plaintext {
+		t.Error("Test failed, expected '"+plaintext+"'. Got ", string(out), err)
+	} else {
+		fmt.Println("Successfully Decrypted Stream")
+		successful++
+	}
+}
+
+// Unit tests for our Seal and Open functions.
+// @param *testing.T t - The wrapper for the test
+func TestSeal(t *testing.T) {
+	fmt.Println("\n----------------TestSeal----------------")
+
+	key := []byte("longer means more possible keys ")
+	sealed, err := Seal(key, []byte("sealed data"))
+	var opened []byte
+	if err == nil {
+		opened, err = Open(key, sealed)
+	}
+
+	if err != nil || string(opened) != "sealed data" {
+		t.Error("Test failed, expected the sealed data back. Got ", string(opened), err)
+	} else {
+		fmt.Println("Successfully Sealed Data")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestOpen----------------")
+
+	sealed[len(sealed)-1] ^= 1
+	_, tamperedErr := Open(key, sealed)
+	sealed[len(sealed)-1] ^= 1
+	_, keyErr := Open([]byte("a different key of the same size"), sealed)
+	if tamperedErr != ErrAuthFailed || keyErr != ErrAuthFailed {
+		t.Error("Test failed, expected tampered data and the wrong key to be refused. Got ",
+			tamperedErr, keyErr)
+	} else {
+		fmt.Println("Successfully Refused Tampered Data")
+		successful++
+	}
+}
+
+// Unit tests for our NewSealWriter and NewOpenReader functions.
+// @param *testing.T t - The wrapper for the test
+func TestSealStream(t *testing.T) {
+	fmt.Println("\n----------------TestSealWriter----------------")
+
+	key := []byte("longer means more possible keys ")
+	plaintext := strings.Repeat("spread over several records ", RecordSize/10)
+
+	var sealed bytes.Buffer
+	w, err := NewSealWriter(key, &sealed)
+	if err == nil {
+		io.Copy(w, strings.NewReader(plaintext))
+		err = w.Close()
+	}
+	open := func(data []byte) (string, error) {
+		r, err := NewOpenReader(key, bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		out, err := ioutil.ReadAll(r)
+		return string(out), err
+	}
+
+	if out, oErr := open(sealed.Bytes()); err != nil || oErr != nil || out != plaintext {
+		t.Error("Test failed, expected the stream back. Got ", len(out), err, oErr)
+	} else {
+		fmt.Println("Successfully Sealed Stream")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestOpenReader----------------")
+
+	tampered := append([]byte{}, sealed.Bytes()...)
+	tampered[len(tampered)/2] ^= 1
+	if _, err = open(tampered); err != ErrAuthFailed {
+		t.Error("Test failed, expected a tampered record to be refused. Got ", err)
+	} else {
+		fmt.Println("Successfully Refused Tampered Record")
+		successful++
+	}
+
+	// Whole records cut off the end are noticed too
+	if _, err = open(sealed.Bytes()[:sealed.Len()-RecordSize/2]); err != ErrTruncated {
+		t.Error("Test failed, expected a cut off stream to be refused. Got ", err)
+	} else {
+		fmt.Println("Successfully Refused Cut Off Stream")
+		successful++
+	}
+}
 
 // Unit tests for our Encrypt and Decrypt functions.
 // @param *testing.T t - The wrapper for the test
@@ -2,6 +2,10 @@ package mypgp
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
 	"errors"
 	"io"
 	"strings"
@@ -10,6 +14,7 @@ import (
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
 )
 
 // Decode - this function decodes using the openpgp library.
@@ -32,38 +37,25 @@ type Decoder struct {
 // @param io.Reader[] src - This parameter will be used to read the encrypted data
 // @param io.Writer[] dest - This parameter will be used to write the unencrypted data
 func (d *Decoder) Decode(r io.Reader, w io.Writer) error {
-	entitylist, err := openpgp.ReadArmoredKeyRing(bytes.NewBuffer(d.Key))
+	key, err := ReadKey(string(d.Key), d.Passphrase)
 	if err != nil {
 		return err
 	}
-	entity := entitylist[0]
-
-	if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
-		if len(d.Passphrase) == 0 {
-			return errors.New("Private key is encrypted but you did not provide a passphrase")
-		}
-		err := entity.PrivateKey.Decrypt(d.Passphrase)
-		if err != nil {
-			return errors.New("Failed to decrypt private key. Did you use the wrong passphrase? (" + err.Error() + ")")
-		}
-	}
-	for _, subkey := range entity.Subkeys {
-		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
-			err := subkey.PrivateKey.Decrypt(d.Passphrase)
-			if err != nil {
-				return errors.New("Failed to decrypt subkey. Did you use the wrong passphrase? (" + err.Error() + ")")
-			}
-		}
-	}
+	return key.Decode(r, w)
+}
 
-	read, err := openpgp.ReadMessage(r, entitylist, nil, nil)
+// Decode - this function decodes data encrypted for our key, which must already be unlocked.
+// Callers that decode often should read their key once and keep it, as unlocking is slow.
+// @param io.Reader[] src - This parameter will be used to read the encrypted data
+// @param io.Writer[] dest - This parameter will be used to write the unencrypted data
+func (key *Key) Decode(r io.Reader, w io.Writer) error {
+	read, err := openpgp.ReadMessage(r, openpgp.EntityList{&key.Entity}, nil, nil)
 	if err != nil {
 		return err
 	}
 
 	_, err = io.Copy(w, read.LiteralData.Body)
 	return err
-
 }
 
 // Encode - this function decodes using the openpgp library.
@@ -88,7 +80,21 @@ func (e *Encoder) Encode(r io.Reader, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	return encode(entitylist, r, w)
+}
+
+// Encode - this function encodes data so only the holder of our key's private part can read it.
+// @param io.Reader[] src - This parameter will be used to read the unencrypted data
+// @param io.Writer[] dest - This parameter will be used to write the encrypted data
+func (key *Key) Encode(r io.Reader, w io.Writer) error {
+	return encode(openpgp.EntityList{&key.Entity}, r, w)
+}
 
+// Encrypts data for a list of keys.
+// @param openpgp.EntityList entitylist - The keys the data is encrypted for
+// @param io.Reader[] src - This parameter will be used to read the unencrypted data
+// @param io.Writer[] dest - This parameter will be used to write the encrypted data
+func encode(entitylist openpgp.EntityList, r io.Reader, w io.Writer) error {
 	// Encrypt message using public key
 	buf := new(bytes.Buffer)
 	encrypter, err := openpgp.Encrypt(buf, entitylist, nil, nil, nil)
@@ -110,20 +116,77 @@ func (e *Encoder) Encode(r io.Reader, w io.Writer) error {
 	return err
 }
 
+// Sign - this function signs data using the openpgp library.
+// @param byte[] key - This is the armored private key to sign with
+// @param byte[] passphrase - This parameter will be used if the key is protected with a passphrase
+// @param io.Reader[] src - This parameter will be used to read the data to sign
+// @param io.Writer[] dest - This parameter will be used to write the armored signature
+func Sign(key, passphrase []byte, src io.Reader, dest io.Writer) error {
+	signer, err := ReadKey(string(key), passphrase)
+	if err != nil {
+		return err
+	}
+	return signer.Sign(src, dest)
+}
+
+// Sign - this function signs data with our key, which must already be unlocked.
+// @param io.Reader[] src - This parameter will be used to read the data to sign
+// @param io.Writer[] dest - This parameter will be used to write the armored signature
+func (key *Key) Sign(src io.Reader, dest io.Writer) error {
+	if key.PrivateKey == nil {
+		return errors.New("Signing requires a private key")
+	}
+	return openpgp.ArmoredDetachSign(dest, &key.Entity, src, nil)
+}
+
+// Verify - this function checks a signature made by Sign using the openpgp library.
+// @param byte[] key - This is the armored public key the data should have been signed with
+// @param io.Reader[] src - This parameter will be used to read the signed data
+// @param io.Reader[] signature - This parameter will be used to read the armored signature
+// @return string - The fingerprint of the key that made the signature
+// @return error - An error is produced if the signature doesn't match the data or the key
+func Verify(key []byte, src, signature io.Reader) (string, error) {
+	signer, err := ReadKey(string(key), nil)
+	if err != nil {
+		return "", err
+	}
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{&signer.Entity}, src,
+		signature)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]), nil
+}
+
 // Config for generating keys.
 type Config struct {
 	packet.Config
 	// Expiry is the duration that the generated key will be valid for.
 	Expiry time.Duration
+	// Passphrase, if set, encrypts the private key ArmorPrivate returns.
+	Passphrase []byte
 }
 
 // Key represents an OpenPGP key.
 type Key struct {
 	openpgp.Entity
-	Public  string
-	Private string
+	Public  string // The armored public key
+	Private string // The armored private key, empty if we only have the public one
 }
 
+// The armor type of private keys protected by earlier versions of Lynx, which kept the key in an
+// OpenPGP message symmetrically encrypted with the passphrase. ReadKey still reads them so they
+// can be armored again the standard way.
+const legacyProtectedType = "PGP MESSAGE"
+
+// S2K usage and cipher for private keys encrypted with a passphrase - the key material is
+// encrypted with AES-256 and checked with SHA-1, as RFC 4880 section 5.5.3 describes
+const (
+	s2kUsageSHA1 = 254
+	s2kCount     = 65011712
+)
+
 // Constants for encryption formats.
 const (
 	md5       = 1
@@ -144,14 +207,12 @@ const (
 func CreateKey(name, comment, email string, config *Config) (*Key, error) {
 	// Create the key
 	key, err := openpgp.NewEntity(name, comment, email, &config.Config)
-	s, _ := (&Key{*key, comment, name}).Armor()
 	if err != nil {
 		return nil, err
 	}
 
 	// Set expiry and algorithms. Self-sign the identity.
 	dur := uint32(config.Expiry.Seconds())
-	s = strings.Replace(s, s, comment, -1)
 	for _, id := range key.Identities {
 		id.SelfSignature.KeyLifetimeSecs = &dur
 
@@ -191,10 +252,92 @@ func CreateKey(name, comment, email string, config *Config) (*Key, error) {
 		}
 	}
 
-	r := Key{*key, s, s}
+	r := Key{Entity: *key}
+	if r.Public, err = r.Armor(); err != nil {
+		return nil, err
+	}
+	if r.Private, err = r.ArmorPrivate(config); err != nil {
+		return nil, err
+	}
 	return &r, nil
 }
 
+// ReadKey - this function reads an armored key, public or private. A private key encrypted with a
+// passphrase, by ArmorPrivate or by gpg, is unlocked with the passphrase.
+// @param string armored - The armored key
+// @param []byte passphrase - The passphrase protecting a private key - nil for public keys
+// @return *Key - The key, with Private set to armored if it holds the private part
+// @return error - An error is produced if the key can't be read or the passphrase is wrong
+func ReadKey(armored string, passphrase []byte) (*Key, error) {
+	block, err := armor.Decode(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := block.Body
+	if block.Type == legacyProtectedType {
+		if len(passphrase) == 0 {
+			return nil, errors.New("Private key is encrypted but you did not provide a passphrase")
+		}
+		tried := false
+		prompt := func(_ []openpgp.Key, _ bool) ([]byte, error) {
+			if tried { // ReadMessage keeps asking until the passphrase works
+				return nil, errors.New("Failed to decrypt private key. Wrong passphrase?")
+			}
+			tried = true
+			return passphrase, nil
+		}
+		md, err := openpgp.ReadMessage(block.Body, nil, prompt, nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = md.UnverifiedBody
+	} else if block.Type != openpgp.PublicKeyType && block.Type != openpgp.PrivateKeyType {
+		return nil, errors.New("Not an OpenPGP key: " + block.Type)
+	}
+
+	entitylist, err := openpgp.ReadKeyRing(keys)
+	if err != nil {
+		return nil, err
+	}
+	entity := entitylist[0]
+
+	if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, errors.New("Private key is encrypted but you did not provide a passphrase")
+		}
+		err := entity.PrivateKey.Decrypt(passphrase)
+		if err != nil {
+			return nil, errors.New("Failed to decrypt private key. Did you use the wrong passphrase? (" +
+				err.Error() + ")")
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			err := subkey.PrivateKey.Decrypt(passphrase)
+			if err != nil {
+				return nil, errors.New("Failed to decrypt subkey. Did you use the wrong passphrase? (" +
+					err.Error() + ")")
+			}
+		}
+	}
+
+	key := &Key{Entity: *entity}
+	if key.Public, err = key.Armor(); err != nil {
+		return nil, err
+	}
+	if entity.PrivateKey != nil {
+		key.Private = armored
+	}
+	return key, nil
+}
+
+// Fingerprint - this function returns the fingerprint that identifies our key.
+// @return string - The hex fingerprint of our primary key
+func (key *Key) Fingerprint() string {
+	return hex.EncodeToString(key.PrimaryKey.Fingerprint[:])
+}
+
 // Armor - this function returns the public part of our armored key.
 // @return string - This is our public key
 // @return error - An error can be produced when trying to access an unarmored string.
@@ -210,18 +353,160 @@ func (key *Key) Armor() (string, error) {
 	return buf.String(), nil
 }
 
-// ArmorPrivate - this function returns the private part of our armored key.
+// ArmorPrivate - this function returns the private part of our armored key. If the config has a
+// passphrase the key material is encrypted with it the standard OpenPGP way, so gpg can read
+// the key too.
+// @param *Config config - The config used to self-sign the key and, if set, the passphrase
 // @return string - This is our private key
 // @return error - An error can be produced when trying to access an unarmored string.
 func (key *Key) ArmorPrivate(config *Config) (string, error) {
+	c := config.Config
 	buf := new(bytes.Buffer)
 	armor, err := armor.Encode(buf, openpgp.PrivateKeyType, nil)
 	if err != nil {
 		return "", err
 	}
-	c := config.Config
-	key.SerializePrivate(armor, &c)
+	if len(config.Passphrase) == 0 {
+		err = key.SerializePrivate(armor, &c)
+	} else {
+		err = key.serializeEncrypted(armor, config.Passphrase, &c)
+	}
+	if err != nil {
+		return "", err
+	}
 	armor.Close()
 
 	return buf.String(), nil
 }
+
+// Writes our key like SerializePrivate does, but with the primary key and subkeys encrypted with
+// a passphrase.
+// @param io.Writer w - Where the key is written
+// @param []byte passphrase - The passphrase the key material is encrypted with
+// @param *packet.Config config - The config used to self-sign the key
+// @return error - An error is produced if the key can't be signed or written
+func (key *Key) serializeEncrypted(w io.Writer, passphrase []byte, config *packet.Config) error {
+	err := writeEncrypted(w, key.PrivateKey, passphrase, config)
+	if err != nil {
+		return err
+	}
+	for _, ident := range key.Identities {
+		if err = ident.UserId.Serialize(w); err != nil {
+			return err
+		}
+		err = ident.SelfSignature.SignUserId(ident.UserId.Id, key.PrimaryKey, key.PrivateKey, config)
+		if err != nil {
+			return err
+		}
+		if err = ident.SelfSignature.Serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, subkey := range key.Subkeys {
+		if err = writeEncrypted(w, subkey.PrivateKey, passphrase, config); err != nil {
+			return err
+		}
+		if err = subkey.Sig.SignKey(subkey.PublicKey, key.PrivateKey, config); err != nil {
+			return err
+		}
+		if err = subkey.Sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes a secret key packet whose key material is encrypted with a passphrase. The openpgp
+// library only writes unencrypted secret keys, so the packet it writes is split into the public
+// key and the key material, and the material is encrypted in its place.
+// @param io.Writer w - Where the packet is written
+// @param *packet.PrivateKey pk - The unlocked private key or subkey
+// @param []byte passphrase - The passphrase the key material is encrypted with
+// @param *packet.Config config - The config supplying randomness
+// @return error - An error is produced if the key can't be serialized or written
+func writeEncrypted(w io.Writer, pk *packet.PrivateKey, passphrase []byte,
+	config *packet.Config) error {
+	var plain, public bytes.Buffer
+	if err := pk.Serialize(&plain); err != nil {
+		return err
+	} else if err = pk.PublicKey.Serialize(&public); err != nil {
+		return err
+	}
+	tag, body := splitPacket(plain.Bytes())
+	_, publicBody := splitPacket(public.Bytes())
+	// The unencrypted body is the public key, a zero S2K usage, the key material and a checksum
+	if len(body) < len(publicBody)+3 {
+		return errors.New("Unexpected private key packet")
+	}
+	material := body[len(publicBody)+1 : len(body)-2]
+
+	encrypted := bytes.NewBuffer(append([]byte{}, publicBody...))
+	encrypted.Write([]byte{s2kUsageSHA1, byte(packet.CipherAES256)})
+	aesKey := make([]byte, packet.CipherAES256.KeySize())
+	s2kConfig := &s2k.Config{Hash: crypto.SHA256, S2KCount: s2kCount}
+	if err := s2k.Serialize(encrypted, aesKey, config.Random(), passphrase, s2kConfig); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err = io.ReadFull(config.Random(), iv); err != nil {
+		return err
+	}
+	encrypted.Write(iv)
+
+	checksum := crypto.SHA1.New()
+	checksum.Write(material)
+	data := checksum.Sum(append([]byte{}, material...))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(data, data)
+	encrypted.Write(data)
+
+	if err = writeHeader(w, tag, encrypted.Len()); err != nil {
+		return err
+	}
+	_, err = w.Write(encrypted.Bytes())
+	return err
+}
+
+// Splits a packet written by the openpgp library, which always uses new format headers, into
+// its tag and its body.
+// @param []byte data - The packet
+// @return byte - The packet's tag
+// @return []byte - The packet's body
+func splitPacket(data []byte) (byte, []byte) {
+	if len(data) < 2 {
+		return 0, nil
+	}
+	tag := data[0] & 0x3f
+	switch {
+	case data[1] < 192:
+		return tag, data[2:]
+	case data[1] < 224 && len(data) >= 3:
+		return tag, data[3:]
+	case data[1] == 255 && len(data) >= 6:
+		return tag, data[6:]
+	}
+	return tag, nil
+}
+
+// Writes a new format packet header, as RFC 4880 section 4.2.2 describes.
+// @param io.Writer w - Where the header is written
+// @param byte tag - The packet's tag
+// @param int length - The length of the packet's body
+// @return error - An error is produced if the header can't be written
+func writeHeader(w io.Writer, tag byte, length int) error {
+	header := []byte{0xc0 | tag}
+	if length < 192 {
+		header = append(header, byte(length))
+	} else if length < 8384 {
+		length -= 192
+		header = append(header, 192+byte(length>>8), byte(length))
+	} else {
+		header = append(header, 255, byte(length>>24), byte(length>>16), byte(length>>8),
+			byte(length))
+	}
+	_, err := w.Write(header)
+	return err
+}
@@ -1,23 +1,27 @@
-// The unit tests for our mycrypt helper functions
+// The unit tests for our mypgp helper functions
 // @author: Michael Bruce
 // @author: Max Kernchen
 // @verison: 2/17/2016
 package mypgp
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
 	"os/user"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/openpgp"
 )
 
 // Count of the # of successful tests.
 var successful = 0
 
 // Total # of the tests.
-const total = 3
+const total = 7
 
 // The name of the current user
 var currentusr, _ = user.Current()
@@ -25,6 +29,77 @@ var currentusr, _ = user.Current()
 // The home path for Lynx
 var homePath = currentusr.HomeDir + "/Lynx/"
 
+// Unit tests for reading back keys armored with a passphrase.
+// @param *testing.T t - The wrapper for the test
+func TestReadKey(t *testing.T) {
+	fmt.Println("\n----------------TestReadKey----------------")
+
+	config := Config{Expiry: 365 * 24 * time.Hour, Passphrase: []byte("correct horse")}
+	key, err := CreateKey("JohnDoe", "test key", "test@example.com", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The private key must be an ordinary OpenPGP secret key, encrypted with the passphrase
+	public, _ := key.Armor()
+	private, readErr := openpgp.ReadArmoredKeyRing(strings.NewReader(key.Private))
+	if key.Public != public || readErr != nil || !private[0].PrivateKey.Encrypted ||
+		!private[0].Subkeys[0].PrivateKey.Encrypted {
+		t.Error("Test failed, expected armored keys with an encrypted private key. Got ",
+			readErr, key.Public, key.Private)
+	} else {
+		fmt.Println("Successfully Armored Keys")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestReadKey----------------")
+
+	read, err := ReadKey(key.Private, config.Passphrase)
+	_, wrongErr := ReadKey(key.Private, []byte("wrong horse"))
+	pub, pubErr := ReadKey(key.Public, nil)
+	if err != nil || read.Fingerprint() != key.Fingerprint() || read.PrivateKey == nil ||
+		wrongErr == nil || pubErr != nil || pub.PrivateKey != nil || pub.Private != "" {
+		t.Error("Test failed, expected the key back only with the right passphrase. Got ", err,
+			wrongErr, pubErr)
+	} else {
+		fmt.Println("Successfully Read Protected Key")
+		successful++
+	}
+}
+
+// Unit tests for our Sign and Verify functions.
+// @param *testing.T t - The wrapper for the test
+func TestSign(t *testing.T) {
+	fmt.Println("\n----------------TestSign----------------")
+
+	config := Config{Expiry: 365 * 24 * time.Hour, Passphrase: []byte("correct horse")}
+	key, err := CreateKey("JohnDoe", "test key", "test@example.com", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signature bytes.Buffer
+	err = Sign([]byte(key.Private), config.Passphrase, strings.NewReader("signed"), &signature)
+	signer, vErr := Verify([]byte(key.Public), strings.NewReader("signed"),
+		bytes.NewReader(signature.Bytes()))
+	if err != nil || vErr != nil || signer != key.Fingerprint() {
+		t.Error("Test failed, expected the signature to be verified. Got ", err, vErr)
+	} else {
+		fmt.Println("Successfully Signed Data")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestVerify----------------")
+
+	_, err = Verify([]byte(key.Public), strings.NewReader("changed"), &signature)
+	if err == nil {
+		t.Error("Test failed, expected a signature of other data to be refused.")
+	} else {
+		fmt.Println("Successfully Refused Bad Signature")
+		successful++
+	}
+}
+
 // Unit tests for creating, encoding, and decoding using OpenPGP public @ private keys.
 // @param *testing.T t - The wrapper for the test
 func TestPGP(t *testing.T) {
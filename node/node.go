@@ -0,0 +1,69 @@
+// Package node runs a whole Lynx peer - its client, server and tracker - on ports of its own, so
+// several peers can run side by side in one process or on one machine.
+// @author: Michael Bruce
+// @author: Max Kernchen
+package node
+
+import (
+	"../client"
+	"../lynxutil"
+	"../server"
+	"../tracker"
+	"net"
+	"strconv"
+)
+
+// Node - A running Lynx peer. The client's methods are the peer's own, so a node can create, join
+// and update lynks directly.
+type Node struct {
+	*client.Client
+	Server  *server.Server
+	Tracker *tracker.Tracker
+
+	listeners []net.Listener // The server's and the tracker's welcomeSockets
+}
+
+// Start - Starts a peer whose Lynx directory is home. The server and the tracker listen on ports
+// the system picks, which are recorded in the peer's node.
+// @param string home - The path of the peer's Lynx directory
+// @param []byte passphrase - The passphrase protecting the peer's private key
+// @return *Node - The running peer
+// @return error - An error is produced if the directory, the identity or a welcomeSocket can't be
+// created
+func Start(home string, passphrase []byte) (*Node, error) {
+	ln, err := lynxutil.NewNode(home, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{}
+	for _, port := range []*string{&ln.ServerPort, &ln.TrackerPort} {
+		welcomeSocket, err := net.Listen("tcp", ":0")
+		if err != nil {
+			n.Close()
+			return nil, err
+		}
+		n.listeners = append(n.listeners, welcomeSocket)
+		*port = strconv.Itoa(welcomeSocket.Addr().(*net.TCPAddr).Port)
+	}
+
+	n.Client = client.New(ln)
+	n.Server = server.New(n.Client)
+	n.Tracker = tracker.New(ln)
+	go n.Server.Serve(n.listeners[0])
+	go n.Tracker.Serve(n.listeners[1])
+	return n, nil
+}
+
+// Close - Stops the peer's server and tracker. Connections already being handled are left to
+// finish.
+// @return error - An error is produced if a welcomeSocket can't be closed
+func (n *Node) Close() error {
+	var err error
+	for _, welcomeSocket := range n.listeners {
+		if cErr := welcomeSocket.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,258 @@
+// The integration tests for running several peers in one process
+// @author: Michael Bruce
+// @author: Max Kernchen
+package node
+
+import (
+	"bufio"
+	"capstone/lynxutil"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 9
+
+// Starts a peer in a temporary directory.
+// @param *testing.T t - The wrapper for the test
+// @return *Node - The peer - the test closes it and removes its directory when it is done
+func startTestNode(t *testing.T) *Node {
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Start(home, []byte("lynx tests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Unit tests for Start.
+// @param *testing.T t - The wrapper for the test
+func TestStart(t *testing.T) {
+	fmt.Println("\n----------------TestStart----------------")
+
+	a, b := startTestNode(t), startTestNode(t)
+	defer os.RemoveAll(a.Home)
+	defer a.Close()
+	defer os.RemoveAll(b.Home)
+	defer b.Close()
+
+	if a.Home == b.Home || a.ServerPort == b.ServerPort || a.TrackerPort == b.TrackerPort ||
+		a.PeerID == b.PeerID || a.Lynks == b.Lynks {
+		t.Error("Test failed, expected the peers to share nothing. Got ", a.ServerPort,
+			b.ServerPort, a.PeerID, b.PeerID)
+	} else {
+		fmt.Println("Successfully Started Two Peers")
+		successful++
+	}
+}
+
+// Integration tests for a lynk shared between two peers in one process.
+// @param *testing.T t - The wrapper for the test
+func TestSwarm(t *testing.T) {
+	fmt.Println("\n----------------TestSwarm----------------")
+
+	owner, joiner := startTestNode(t), startTestNode(t)
+	defer os.RemoveAll(owner.Home)
+	defer owner.Close()
+	defer os.RemoveAll(joiner.Home)
+	defer joiner.Close()
+
+	contents := strings.Repeat("shared between peers ", 20000)
+	os.MkdirAll(owner.Home+"Shared/docs", 0755)
+	ioutil.WriteFile(owner.Home+"Shared/a.txt", []byte(contents), 0644)
+	ioutil.WriteFile(owner.Home+"Shared/docs/b.txt", []byte("nested"), 0644)
+	owner.CreateMeta("Shared")
+	owner.Tracker.CreateSwarm("Shared")
+	uri, err := owner.LynkURI("Shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = joiner.JoinURI(uri)
+	a, _ := ioutil.ReadFile(joiner.Home + "Shared/a.txt")
+	b, _ := ioutil.ReadFile(joiner.Home + "Shared/docs/b.txt")
+	if err != nil || string(a) != contents || string(b) != "nested" {
+		t.Error("Test failed, expected the joiner to download the lynk. Got ", err, len(a),
+			string(b))
+	} else {
+		fmt.Println("Successfully Synced Lynk Between Peers")
+		successful++
+	}
+
+	if _, ok := joiner.GetLynk("Shared"); !ok || owner.GetLynksLen() != 1 ||
+		joiner.GetLynksLen() != 1 {
+		t.Error("Test failed, expected each peer to hold the lynk once. Got ",
+			owner.GetLynksLen(), joiner.GetLynksLen())
+	} else {
+		fmt.Println("Successfully Kept Lynks Apart")
+		successful++
+	}
+
+	lynk, _ := joiner.GetLynk("Shared")
+	pinned, _ := joiner.PinnedKey(lynxutil.GetIP() + ":" + owner.ServerPort)
+	if len(lynk.Peers) == 0 || lynk.Peers[0].Key != owner.PublicKey ||
+		pinned != owner.KeyFingerprint {
+		t.Error("Test failed, expected the joiner to pin the owner's key. Got ", pinned)
+	} else {
+		fmt.Println("Successfully Pinned Owner's Key")
+		successful++
+	}
+
+	// Someone announcing the joiner's address with another key must not take its place
+	impostor := startTestNode(t)
+	defer os.RemoveAll(impostor.Home)
+	defer impostor.Close()
+	if conn, err := net.Dial("tcp", lynk.Tracker); err == nil {
+		fmt.Fprintf(conn, "Swarm_Request:"+lynxutil.GetIP()+":"+joiner.ServerPort+":Shared:"+
+			lynxutil.EncodeKey(impostor.PublicKey)+"\n")
+		ioutil.ReadAll(conn)
+		conn.Close()
+	}
+	swarm, _ := ioutil.ReadFile(owner.Home + "Shared/Shared_Tracker/swarm.info")
+	kept := false
+	for _, line := range strings.Split(string(swarm), "\n") {
+		peer, err := lynxutil.ParsePeer(line)
+		if err == nil && peer.Port == joiner.ServerPort {
+			kept = peer.Key == joiner.PublicKey
+		}
+	}
+	if !kept {
+		t.Error("Test failed, expected the tracker to keep the joiner's first key.")
+	} else {
+		fmt.Println("Successfully Refused Changed Key")
+		successful++
+	}
+
+	// Data is only encrypted for the keys of the lynk's peers
+	reply := ""
+	if conn, err := net.Dial("tcp", "127.0.0.1:"+owner.ServerPort); err == nil {
+		fmt.Fprintf(conn, "Chunk_Request:Shared/a.txt:0\n"+impostor.KeyFingerprint+"\n")
+		reply, _ = bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+	}
+	if strings.TrimSpace(reply) != "NO" {
+		t.Error("Test failed, expected a request for an unknown key to be refused. Got ", reply)
+	} else {
+		fmt.Println("Successfully Refused Unknown Key")
+		successful++
+	}
+
+	// Only the owner and writers they authorised may push
+	if err = joiner.Server.PushMeta(joiner.Home + "Shared/meta.info"); err == nil {
+		t.Error("Test failed, expected the joiner's push to be refused.")
+	} else {
+		fmt.Println("Successfully Refused Unauthorised Push")
+		successful++
+	}
+
+	// A push reaches the joiner through the tracker, which fetches the new file
+	ioutil.WriteFile(owner.Home+"Shared/c.txt", []byte("pushed"), 0644)
+	owner.RefreshMeta("Shared")
+	owner.Server.PushMeta(owner.Home + "Shared/meta.info")
+	deadline := time.Now().Add(10 * time.Second)
+	c, _ := ioutil.ReadFile(joiner.Home + "Shared/c.txt")
+	for string(c) != "pushed" && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		c, _ = ioutil.ReadFile(joiner.Home + "Shared/c.txt")
+	}
+	if string(c) != "pushed" {
+		t.Error("Test failed, expected the pushed file to reach the joiner. Got ", string(c))
+	} else {
+		fmt.Println("Successfully Pushed Meta Through Tracker")
+		successful++
+	}
+
+}
+
+// Integration tests for pushes handled by a peer's server while the peer is downloading the same
+// lynk - run with -race
+// @param *testing.T t - The wrapper for the test
+func TestConcurrentPush(t *testing.T) {
+	fmt.Println("\n----------------TestConcurrentPush----------------")
+
+	owner, joiner := startTestNode(t), startTestNode(t)
+	defer os.RemoveAll(owner.Home)
+	defer owner.Close()
+	defer os.RemoveAll(joiner.Home)
+	defer joiner.Close()
+
+	os.MkdirAll(owner.Home+"Busy", 0755)
+	ioutil.WriteFile(owner.Home+"Busy/a.txt", []byte(strings.Repeat("version 0 ", 200000)), 0644)
+	owner.CreateMeta("Busy")
+	owner.Tracker.CreateSwarm("Busy")
+	uri, err := owner.LynkURI("Busy")
+	if err == nil {
+		err = joiner.JoinURI(uri)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The joiner keeps downloading and reading the lynk while the owner's pushes arrive
+	stop := make(chan bool)
+	done := make(chan bool)
+	jobs := []func(){
+		func() { joiner.UpdateLynk("Busy") },
+		func() { joiner.GetLynks(); joiner.PopulateFilesAndSize() },
+		func() { joiner.GetQueueState("Busy") },
+	}
+	for _, job := range jobs {
+		go func(job func()) {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+					job()
+				}
+			}
+		}(job)
+	}
+
+	last := ""
+	i := 1
+	for i <= 5 {
+		last = strings.Repeat("version "+strconv.Itoa(i)+" ", 200000)
+		ioutil.WriteFile(owner.Home+"Busy/a.txt", []byte(last), 0644)
+		ioutil.WriteFile(owner.Home+"Busy/new"+strconv.Itoa(i)+".txt", []byte("new"), 0644)
+		owner.RefreshMeta("Busy")
+		owner.Server.PushMeta(owner.Home + "Busy/meta.info")
+		i++
+	}
+
+	deadline := time.Now().Add(20 * time.Second)
+	a, _ := ioutil.ReadFile(joiner.Home + "Busy/a.txt")
+	_, newErr := os.Stat(joiner.Home + "Busy/new5.txt")
+	for (string(a) != last || newErr != nil) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		a, _ = ioutil.ReadFile(joiner.Home + "Busy/a.txt")
+		_, newErr = os.Stat(joiner.Home + "Busy/new5.txt")
+	}
+	close(stop)
+	for range jobs {
+		<-done
+	}
+
+	if string(a) != last || newErr != nil {
+		t.Error("Test failed, expected the joiner to end up with the last push. Got ", len(a),
+			newErr)
+	} else {
+		fmt.Println("Successfully Downloaded While Pushes Arrived")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -1,6 +1,12 @@
 #!/bin/bash
 
 cd guiserver
+# The passphrase protecting our key is never stored, so ask for it if it wasn't given
+if [ -z "$LYNX_PASSPHRASE" ]; then
+	read -s -p "Lynx Passphrase: " LYNX_PASSPHRASE
+	echo
+	export LYNX_PASSPHRASE
+fi
 echo Starting Lynx...
 go run guiserver.go
 exit
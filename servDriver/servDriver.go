@@ -4,9 +4,18 @@
 // @verison: 2/17/2016
 package main
 
-import "capstone/server"
+import (
+	"capstone/server"
+	"fmt"
+	"os"
+)
 
 // Function used to drive and test our server's functions
 func main() {
-	server.Listen()
+	s, err := server.LoadDefault()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	s.Listen()
 }
@@ -8,27 +8,65 @@ import (
 	"bufio"
 	"bytes"
 	"../client"
+	"../delta"
 	"../lynxutil"
-	"../mycrypt"
-	"compress/gzip"
+	"../metainfo"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"os"
-	//"path/filepath"
+	"strconv"
 	"strings"
-	//"path/filepath"
-	//"path/filepath"
+	"sync"
 )
 
-// Holds the currentLynk being worked on in an update
-var currentLynk *lynxutil.Lynk
+// Server - The server of a Lynx node. It answers other peers' requests for the files and
+// meta.info files of the node's lynks.
+type Server struct {
+	*client.Client
+}
+
+// Default - The server of the default node. It is nil until LoadDefault has created it.
+var Default *Server
+
+// Guards the creation of the default server
+var defaultMu sync.Mutex
+
+// LoadDefault - Returns the server of the default node, creating it and the default client the
+// first time it is called.
+// @return *Server - The default server
+// @return error - An error is produced if the default node can't be created
+func LoadDefault() (*Server, error) {
+	c, err := client.LoadDefault()
+	if err != nil {
+		return nil, err
+	}
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if Default == nil {
+		Default = New(c)
+	}
+	return Default, nil
+}
+
+// New - Creates the server of a node.
+// @param *client.Client c - The client of the node, which the server reads the node's lynks through
+// @return *Server - The server
+func New(c *client.Client) *Server {
+	return &Server{Client: c}
+}
 
 // Listen - Calls lynxutil to create a welcomeSocket that listens for TCP connections - once
 // someone connects a goroutine is spawned to handle the request
-func Listen() {
-	lynxutil.Listen(handleFileRequest, lynxutil.ServerPort)
+func (s *Server) Listen() {
+	lynxutil.Listen(s.handleFileRequest, s.ServerPort)
+}
+
+// Serve - Handles requests on a welcomeSocket that is already listening until it is closed.
+// @param net.Listener welcomeSocket - The socket to accept connections on
+func (s *Server) Serve(welcomeSocket net.Listener) {
+	lynxutil.Serve(welcomeSocket, s.handleFileRequest)
 }
 
 // handleFileRequest - Handles a file request sent by another peer - this involves checking to see
@@ -36,208 +74,317 @@ func Listen() {
 // @param net.Conn conn - The socket which the client is asking on
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
-func handleFileRequest(conn net.Conn) error {
-	request, err := bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
+func (s *Server) handleFileRequest(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	request, err := reader.ReadString('\n') // Waits for a String ending in newline
 	if err != nil {
 		return err
 	}
 
 	// Will handle tracker request & receiving of Meta
-	tmpArr := strings.Split(request, ":")
+	// Only the first colon separates the request - file paths can contain colons of their own
+	tmpArr := strings.SplitN(strings.TrimSpace(request), ":", 2)
 	if len(tmpArr) != 2 {
 		conn.Close()
 		return errors.New("Invalid Request Syntax")
 	}
 
 	if tmpArr[0] == "Meta_Push" {
-		handlePush(request, conn)
-	} else {
-		fileReq := tmpArr[1] // Gets the name of requested file
-		fileReq = strings.TrimSpace(fileReq)
-
-		//fmt.Println("Asked for " + fileReq)
-
-		haveFile := client.HaveFile(fileReq)
-		//fmt.Println(haveFile)
-
-		// Depending on if we have the file - we write back to our client accordingly
-		if haveFile {
-			fmt.Fprintf(conn, "YES\n")    // Reply
-			err = sendFile(fileReq, conn) // Sending The File
-			if err != nil {
-				return err
-			}
-		} else {
-			fmt.Fprintf(conn, "NO\n") // Reply
+		s.handlePush(request, reader)
+		return conn.Close()
+	} else if tmpArr[0] == "Bitfield_Request" {
+		fmt.Fprintf(conn, s.GetBitfield(tmpArr[1])+"\n")
+		return conn.Close()
+	} else if tmpArr[0] == "Tracker_Request" {
+		s.handleTrackerRequest(request, conn)
+		return conn.Close()
+	}
+
+	// Every other request is for data, so it is followed by the fingerprint of whoever asked. The
+	// data's session key is wrapped for the key the tracker listed for that peer, so only a peer
+	// of the lynk can read it.
+	fingerprint, err := reader.ReadString('\n')
+	var key string
+	if err == nil {
+		key, err = s.PeerKey(strings.SplitN(tmpArr[1], "/", 2)[0], strings.TrimSpace(fingerprint))
+	}
+	if err != nil {
+		fmt.Fprintf(conn, "NO\n")
+		conn.Close()
+		return err
+	}
+
+	if tmpArr[0] == "Chunk_Request" {
+		// Client syntax is "Chunk_Request:<LynkName>/<FilePath>:<ChunkIndex>\n"
+		split := strings.LastIndex(tmpArr[1], ":")
+		if split == -1 {
+			conn.Close()
+			return errors.New("Invalid Request Syntax")
+		}
+		err = s.handleChunkRequest(tmpArr[1][:split], tmpArr[1][split+1:], key, conn)
+		conn.Close()
+		return err
+	}
+
+	if tmpArr[0] == "Delta_Request" {
+		// Client syntax is "Delta_Request:<LynkName>/<FilePath>:<BlockLength>:<Blocks>\n"
+		// followed by their key's fingerprint and the signature of their older version
+		err = s.handleDeltaRequest(tmpArr[1], key, reader, conn)
+		conn.Close()
+		return err
+	}
+
+	fileReq := tmpArr[1] // Gets the path of requested file - E.G. 'Cool_Lynk/docs/coolFile.txt'
+
+	//fmt.Println("Asked for " + fileReq)
+
+	// The file is opened by the path HaveFile checked, not the one asked for
+	lynkName, relPath, err := client.SplitFilePath(fileReq)
+	haveFile := err == nil && s.HaveFile(fileReq)
+	//fmt.Println(haveFile)
+
+	// Depending on if we have the file - we write back to our client accordingly
+	if haveFile {
+		fmt.Fprintf(conn, "YES\n")                                        // Reply
+		err = s.sendFile(lynkName, relPath, key, s.upload(fileReq, conn)) // Sending The File
+		if err != nil {
+			return err
 		}
+	} else {
+		fmt.Fprintf(conn, "NO\n") // Reply
 	}
 
 	//fmt.Println("No Errors")
 	return conn.Close()
 }
 
+// handleChunkRequest - Handles a request for a single chunk of a file sent by another peer.
+// @param string fileReq - The requested file including its lynk name
+// @param string index - The index of the requested chunk
+// @param string key - The armored public key of the peer that asked
+// @param net.Conn conn - The socket which the client is asking on
+// @return error - An error can be produced if the chunk cannot be read or sent - otherwise
+// error will be nil.
+func (s *Server) handleChunkRequest(fileReq, index, key string, conn net.Conn) error {
+	chunkIndex, err := strconv.Atoi(index)
+	if err != nil {
+		fmt.Fprintf(conn, "NO\n")
+		return err
+	}
+
+	chunk, err := s.ReadChunk(fileReq, chunkIndex)
+	if err != nil {
+		fmt.Fprintf(conn, "NO\n")
+		return err
+	}
+
+	fmt.Fprintf(conn, "YES\n")
+	return lynxutil.SendStream(bytes.NewReader(chunk), s.upload(fileReq, conn), key)
+}
+
+// handleDeltaRequest - Handles a request for the delta between a peer's older version of a file
+// and ours, so only the blocks that changed are sent.
+// @param string request - The request after its name -
+// '<LynkName>/<FilePath>:<BlockLength>:<Blocks>'
+// @param string key - The armored public key of the peer that asked
+// @param *bufio.Reader reader - Where the signature of the peer's older version is read from
+// @param net.Conn conn - The socket which the client is asking on
+// @return error - An error can be produced if the request is invalid or the delta cannot be
+// computed or sent - otherwise error will be nil.
+func (s *Server) handleDeltaRequest(request, key string, reader *bufio.Reader,
+	conn net.Conn) error {
+	tmpArr := strings.Split(request, ":")
+	if len(tmpArr) < 3 {
+		fmt.Fprintf(conn, "NO\n")
+		return errors.New("Invalid Request Syntax")
+	}
+
+	// File paths can contain colons of their own so the numbers are taken from the end
+	fileReq := strings.Join(tmpArr[:len(tmpArr)-2], ":")
+	blockLen, err := strconv.Atoi(tmpArr[len(tmpArr)-2])
+	count, cErr := strconv.Atoi(tmpArr[len(tmpArr)-1])
+	if err != nil || cErr != nil {
+		fmt.Fprintf(conn, "NO\n")
+		return errors.New("Invalid Request Syntax")
+	}
+
+	sums, err := delta.ReadSignature(reader, count)
+	if err != nil {
+		fmt.Fprintf(conn, "NO\n")
+		return err
+	}
+
+	ops, err := s.OpenDelta(fileReq, blockLen, sums)
+	if err != nil {
+		fmt.Fprintf(conn, "NO\n")
+		return err
+	}
+	defer ops.Close()
+
+	fmt.Fprintf(conn, "YES\n")
+	return lynxutil.SendStream(ops, s.upload(fileReq, conn), key)
+}
+
 // handleTrackerRequest - Handles a tracker request sent by another peer - this involves opening
 // the meta.info file and passing the requesting peer the IP address stored inside.
 // @param string request - The request the client made
 // @param net.Conn conn - The socket which the client is asking on
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
-func handleTrackerRequest(request string, conn net.Conn) error {
+func (s *Server) handleTrackerRequest(request string, conn net.Conn) error {
 	tmpArr := strings.Split(request, ":")
 	if len(tmpArr) != 2 {
 		conn.Close()
 		return errors.New("Invalid Request Syntax")
 	}
 
-	mPath := lynxutil.HomePath + tmpArr[1] + "meta.info"
+	mPath := s.Home + tmpArr[1] + "meta.info"
 	mPath = strings.TrimSpace(mPath)
 
-	mFile, _ := os.Open(mPath)
-	scanner := bufio.NewScanner(mFile)
-	ip := (strings.Split(scanner.Text(), ":::"))[1] // Splits first line of metainfo and gets the IP
-	fmt.Fprintf(conn, ip+"\n")
+	m, err := metainfo.Read(mPath)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	fmt.Fprintf(conn, m.Announce+"\n")
 
 	return nil
 }
 
 // Helper function for handleRequest - handles the case where we are received meta.info file.
-// @param net.Conn conn - The socket which the client is asking on
 // @param string request - The request sent to tracker
+// @param io.Reader conn - The socket which the client is asking on, positioned after the request
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
-func handlePush(request string, conn net.Conn) error {
+func (s *Server) handlePush(request string, conn io.Reader) error {
 	// Client syntax for push is "Meta_Push:<LynkName>\n"
 	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkName>
 	tmpArr := strings.Split(request, ":")
 	if len(tmpArr) != 2 {
-		conn.Close()
 		return errors.New("Invalid Request Syntax")
 	}
 
 	lynkName := strings.TrimSpace(tmpArr[1])
-	metaPath := lynxutil.HomePath + lynkName + "/meta.info"
-
-	bufIn, err := ioutil.ReadAll(conn)
-
-	//fmt.Println(request, "SERVER BUFIN:", len(bufIn))
-
-	// Decrypt
-	key := []byte(lynxutil.PrivateKey)
-	var plainFile []byte
-	if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
-		return err
+	metaPath := s.Home + lynkName + "/meta.info"
+
+	// The new meta.info is only read into memory, so a failed push leaves the old one intact
+	var received bytes.Buffer
+	err := s.ReceiveStream(conn, &received)
+	var incoming *metainfo.Meta
+	if err == nil {
+		incoming, err = metainfo.Decode(&received)
 	}
-
-	// Decompress
-	r, _ := gzip.NewReader(bytes.NewBuffer(plainFile))
-	bufOut, _ := ioutil.ReadAll(r)
-	r.Read(bufOut)
-	r.Close()
-
-	// Creates the new meta.info
-	newMetainfo, err := os.Create(metaPath)
-	if err != nil {
+	var merged *metainfo.Meta
+	newer := false
+	if err == nil {
+		merged, newer, err = s.MergePush(lynkName, incoming)
+	}
+	if err == metainfo.ErrStale {
+		return err // Pushes can arrive out of order, and our own come back to us
+	} else if err != nil {
 		fmt.Println("PUSH ERROR: " + err.Error())
 		return err
 	}
 
-	//fmt.Println(len(bufIn), "Bytes Received IN META")
-	//fmt.Println(bufOut)
-	newMetainfo.Write(bufOut)
-	client.ParseMetainfo(metaPath)
+	s.UpdateLynk(lynkName)
 
-	// Sets currentLynk so it can be used in rmFiles
-	currentLynk = lynxutil.GetLynk(client.GetLynks(), lynkName)
-	// Removes files that are no longer in meta.info
-	//filepath.Walk(lynxutil.HomePath+lynkName, rmFiles)
-
-	client.UpdateLynk(lynkName)
+	// Lets everyone else know about the changes they were missing
+	if newer && merged.Authorised(s.KeyFingerprint) {
+		return s.PushMeta(metaPath)
+	}
 	return nil // No errors if we reached this point
 }
 
-// Sends a file across the network to a peer.
-// @param string fileName - The name of the file to send to the peer. It will have path from root
-// of Lynx Directory.
-// @param net.Conn conn - The socket over which we will send the file
+// Sends a file across the network to a peer. The file is streamed through compression and
+// encryption so memory use does not depend on its size.
+// @param string lynkName - The name of the lynk the file is in
+// @param string relPath - The cleaned path of the file inside the lynk
+// @param string key - The armored public key of the peer receiving the file
+// @param io.Writer conn - The socket over which we will send the file
 // @return error - An error can be produced when trying open a file or write over
 // the network - otherwise error will be nil.
-func sendFile(fileName string, conn net.Conn) error {
-	//fmt.Println(fileName)
-
-	// Can use read when implementing chunking
-	fBytes, err := ioutil.ReadFile(lynxutil.HomePath + fileName)
-	//fmt.Println("File Contents: ", string(fBytes))
-
-	// Begin Compression
-	var b bytes.Buffer
-	gz := gzip.NewWriter(&b)
-	gz.Write(fBytes)
-	gz.Close()
-	// End Compression
-
-	// Begin Encryption
-	var cipherFile []byte
-	publicKey := lynxutil.Peer{IP: conn.LocalAddr().String()}.Key + lynxutil.PrivateKey
-	key := []byte(publicKey)
-	if cipherFile, err = mycrypt.Encrypt(key, b.Bytes()); err != nil {
-		return err
-	}
-	// End Encryption
-
-	_, err = conn.Write(cipherFile)
+func (s *Server) sendFile(lynkName, relPath, key string, conn io.Writer) error {
+	fileToSend, err := os.Open(s.Home + lynkName + "/" + relPath)
 	if err != nil {
 		return err
 	}
+	defer fileToSend.Close()
 
-	//fmt.Println(n, "Bytes were sent")
+	return lynxutil.SendStream(fileToSend, conn, key)
+}
 
-	return nil // No Errors occurred If We Reached Here
+// Limits how fast a file of a lynk is sent to the peer that asked for it.
+// @param string fileReq - The requested file including its lynk name
+// @param net.Conn conn - The socket which the client is asking on
+// @return io.Writer - Writes to conn no faster than the upload limits allow
+func (s *Server) upload(fileReq string, conn net.Conn) io.Writer {
+	return s.UploadWriter(conn, strings.SplitN(fileReq, "/", 2)[0], conn)
 }
 
-// PushMeta - Sends the meta.info file to the tracker. Gets the tracker IP from the client.
+// PushMeta - Signs the meta.info file and sends it to the tracker, encrypted for the key pinned
+// for the tracker. Gets the tracker IP from the client.
 // @param string metaPath - The meta.info path associated with the lynk we're interested in
-// @return error - An error can be produced when trying to connect to the tracker
-// over the network - otherwise error will be nil.
-func PushMeta(metaPath string) error {
-	trackerIP := client.GetTracker(metaPath)
+// @return error - An error can be produced if we aren't authorised to change the lynk or when
+// trying to connect to the tracker over the network - otherwise error will be nil.
+func (s *Server) PushMeta(metaPath string) error {
+	lynkName := s.GetLynkName(metaPath)
+	m, err := metainfo.Read(metaPath)
+	if err == nil && !m.Authorised(s.KeyFingerprint) {
+		err = errors.New("Not Authorised To Change " + lynkName)
+	}
+	var signed bytes.Buffer
+	if err == nil {
+		err = m.Sign(s.Node)
+	}
+	if err == nil {
+		err = metainfo.Encode(&signed, m)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	trackerIP := s.GetTracker(metaPath)
 	conn, err := net.Dial("tcp", trackerIP)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	lynkName := client.GetLynkName(metaPath)
 	fmt.Fprintf(conn, "Meta_Push:"+lynkName+"\n") // Lets tracker know we are pushing
-
-	err = sendFile(lynkName+"/meta.info", conn)
-
+	reader := bufio.NewReader(conn)
+	key, err := lynxutil.ReadKey(reader) // The tracker's public key
+	if err == nil {
+		// The key must be the one pinned for the tracker the first time we pushed to it, or
+		// anyone answering at the tracker's address could read the push
+		_, err = s.PinKey(trackerIP, key)
+	}
 	if err != nil {
 		fmt.Println(err)
+		conn.Close()
 		return err
 	}
 
-	return conn.Close()
-}
-
-// Function which removes a file from a directory if it's not in the Lynk's files array
-// @param path string - the path where the root directory is located
-// @param file os.FileInfo - each file within the root or inner directories
-// @param err error - any error we way encounter along the way
-// @return error - An error can produced if we encounter an invalid file.
-func rmFiles(path string, file os.FileInfo, err error) error {
-
-	inMeta := false
-	for _, f := range currentLynk.Files {
-		if f.Name == file.Name() {
-			inMeta = true
-		}
+	// Pushes count against the upload limits like any other transfer of the lynk
+	err = lynxutil.SendStream(&signed, s.UploadWriter(conn, lynkName, conn), key)
+	reply := ""
+	if err == nil {
+		reply, err = reader.ReadString('\n')
 	}
-
-	// Don't add directories, trackers, or a meta.info file to the new meta.info
-	if !file.IsDir() && !strings.Contains(path, "_Tracker") && file.Name() != "meta.info" && !inMeta {
-		//fmt.Println("Removing ", file.Name())
-		os.Remove(path)
+	conn.Close()
+	if err == nil && strings.TrimSpace(reply) != "YES" {
+		err = errors.New("Tracker Refused The Push Of " + lynkName)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
 	}
 
+	// Our copy counts the push too, so our next push is newer than this one
+	defer s.LockMeta(lynkName)()
+	if local, err := metainfo.Read(metaPath); err == nil && local.Sequence < m.Sequence {
+		local.Sequence = m.Sequence
+		return metainfo.Write(metaPath, local)
+	}
 	return nil
 }
@@ -6,13 +6,9 @@ package server
 
 import (
 	"bufio"
-	"bytes"
 	"capstone/lynxutil"
-	"capstone/mycrypt"
-	"compress/gzip"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
 	"strings"
@@ -25,6 +21,14 @@ var successful = 0
 // Total # of the tests.
 const total = 6
 
+// Loads the default node the tests run on, under LYNX_PASSPHRASE or else a test passphrase.
+func init() {
+	if os.Getenv(lynxutil.PassphraseEnv) == "" {
+		os.Setenv(lynxutil.PassphraseEnv, "lynx tests")
+	}
+	LoadDefault()
+}
+
 // Unit tests for listen, handle, and send functions as well as push meta
 // @param *testing.T t - The wrapper for the test
 func TestListenHandleSend(t *testing.T) {
@@ -42,6 +46,7 @@ func TestListenHandleSend(t *testing.T) {
 	fmt.Println("\n----------------TestHandleRequest----------------")
 
 	fmt.Fprintf(conn, "Do_You_Have_FileName:Tests/fake.txt\n")
+	fmt.Fprintln(conn, Default.KeyFingerprint)
 
 	reply, err := bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
 	reply = strings.TrimSpace(reply)
@@ -57,8 +62,10 @@ func TestListenHandleSend(t *testing.T) {
 	conn, err = net.Dial("tcp", "127.0.0.1:8080")
 
 	fmt.Fprintf(conn, "Do_You_Have_FileName:Tests/test.txt\n")
+	fmt.Fprintln(conn, Default.KeyFingerprint)
 
-	reply, err = bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
+	reader := bufio.NewReader(conn)
+	reply, err = reader.ReadString('\n') // Waits for a String ending in newline
 	reply = strings.TrimSpace(reply)
 
 	if reply == "YES" {
@@ -76,21 +83,8 @@ func TestListenHandleSend(t *testing.T) {
 	}
 	defer file.Close()
 
-	bufIn := make([]byte, 512) // Set to 512 because we know this file is small
-	_, err = conn.Read(bufIn)
-	// Decrypt
-	//key := []byte("abcdefghijklmnopqrstuvwxyz123456")
-	key := []byte(lynxutil.PrivateKey)
-	var plainFile []byte
-	if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
-		log.Fatal(err)
-	}
-	// Decompress
-	r, err := gzip.NewReader(bytes.NewBuffer(plainFile))
-	bufOut := make([]byte, 512) // Set to 512 because we know this file is small
-	r.Read(bufOut)
-	file.Write(bufOut)
-	r.Close()
+	// Decrypt & Decompress - the session key was wrapped for the key we sent
+	err = Default.ReceiveStream(reader, file)
 
 	if err != nil {
 		t.Error(err.Error())
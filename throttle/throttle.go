@@ -0,0 +1,298 @@
+// Package throttle limits how fast data moves through readers and writers using token buckets,
+// so a big transfer can't use up a whole connection's bandwidth, and splits a rate fairly between
+// the users sharing it.
+// @author: Michael Bruce
+// @author: Max Kernchen
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// The most bytes read or written in one step - larger reads and writes are split so the rate
+// stays smooth
+const maxStep = 16384
+
+// How long a bucket of a Share goes unused before its part of the rate goes to the others
+const shareIdle = time.Second
+
+// Bucket - A token bucket. Every byte passed through takes a token, tokens are added at the
+// bucket's rate, and the bucket holds at most one second's worth so bursts stay short.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   int64   // Bytes per second - 0 means unlimited
+	tokens float64 // Negative when bytes have been let through ahead of the rate
+	last   time.Time
+
+	used  time.Time // When the bucket last let bytes through, or was created
+	share *Share    // The Share the bucket's rate is a part of, if any
+	key   string    // The bucket's user in its Share
+}
+
+// NewBucket - Creates a full token bucket.
+// @param int64 rate - The rate in bytes per second - 0 means unlimited
+// @return *Bucket - The bucket
+func NewBucket(rate int64) *Bucket {
+	b := &Bucket{last: time.Now(), used: time.Now()}
+	b.SetRate(rate)
+	return b
+}
+
+// SetRate - Changes the bucket's rate. It takes effect for the next bytes through, including on
+// transfers that are already going.
+// @param int64 rate - The rate in bytes per second - 0 means unlimited
+func (b *Bucket) SetRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rate < 0 {
+		rate = 0
+	}
+	b.rate = rate
+	b.tokens = float64(b.capacity())
+	b.last = time.Now()
+}
+
+// Rate - Returns the bucket's rate.
+// @return int64 - The rate in bytes per second - 0 means unlimited
+func (b *Bucket) Rate() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// Idle - Returns how long it has been since bytes last went through the bucket, so buckets nothing
+// uses any more can be dropped.
+// @return time.Duration - The time since the bucket was last used
+func (b *Bucket) Idle() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Since(b.used)
+}
+
+// Takes tokens for n bytes and returns how long to wait before they may go through.
+// @param int n - The number of bytes
+// @return time.Duration - How long to wait
+func (b *Bucket) reserve(n int) time.Duration {
+	if b.share != nil {
+		b.share.use(b)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.used = now
+	if b.rate == 0 {
+		return 0
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.capacity()) {
+		b.tokens = float64(b.capacity())
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+// Returns the most tokens the bucket holds - callers must hold b.mu.
+// @return int64 - The capacity in bytes
+func (b *Bucket) capacity() int64 {
+	if b.rate < maxStep {
+		return maxStep // A step must always fit
+	}
+	return b.rate
+}
+
+// Share - Splits a rate evenly between the buckets drawing on it, so that many transfers of one
+// user can't crowd out a user with a single transfer. Buckets that go unused give their part of
+// the rate back to the others.
+type Share struct {
+	mu       sync.Mutex
+	rate     int64 // Bytes per second - 0 means unlimited
+	buckets  map[string]*Bucket
+	balanced time.Time // When the rate was last split
+}
+
+// NewShare - Creates a share of a rate.
+// @param int64 rate - The rate in bytes per second - 0 means unlimited
+// @return *Share - The share
+func NewShare(rate int64) *Share {
+	return &Share{rate: rate, buckets: map[string]*Bucket{}}
+}
+
+// SetRate - Changes the rate being split, including for transfers that are already going.
+// @param int64 rate - The rate in bytes per second - 0 means unlimited
+func (s *Share) SetRate(rate int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rate < 0 {
+		rate = 0
+	}
+	s.rate = rate
+	s.balance(time.Now())
+}
+
+// Rate - Returns the rate being split.
+// @return int64 - The rate in bytes per second - 0 means unlimited
+func (s *Share) Rate() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate
+}
+
+// Bucket - Returns the bucket of one user of the share, creating it the first time.
+// @param string key - The user, e.g. a lynk's name
+// @return *Bucket - The user's bucket
+func (s *Share) Bucket(key string) *Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = NewBucket(0)
+		b.share, b.key = s, key
+		s.buckets[key] = b
+		s.balance(time.Now())
+	}
+	return b
+}
+
+// Remove - Drops the bucket of a user, e.g. once it has gone unused for a long time. A transfer
+// still holding the bucket puts it back when it is used again.
+// @param string key - The user
+func (s *Share) Remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[key]; ok {
+		delete(s.buckets, key)
+		s.balance(time.Now())
+	}
+}
+
+// Marks a bucket as used, splitting the rate again when it was idle or the split is stale.
+// @param *Bucket b - The bucket
+func (s *Share) use(b *Bucket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	b.mu.Lock()
+	idle := now.Sub(b.used) > shareIdle
+	b.used = now
+	b.mu.Unlock()
+
+	if _, ok := s.buckets[b.key]; !ok {
+		s.buckets[b.key] = b // It was removed while a transfer still held it
+		idle = true
+	}
+	if idle || now.Sub(s.balanced) > shareIdle {
+		s.balance(now)
+	}
+}
+
+// Splits the rate evenly between the buckets used lately - callers must hold s.mu. Idle buckets
+// get the part they would have if they were used again.
+// @param time.Time now - The time
+func (s *Share) balance(now time.Time) {
+	s.balanced = now
+	used := 0
+	for _, b := range s.buckets {
+		if b.Idle() <= shareIdle {
+			used++
+		}
+	}
+
+	for _, b := range s.buckets {
+		parts := used
+		if b.Idle() > shareIdle {
+			parts++
+		}
+		rate := s.rate
+		if parts > 1 {
+			rate = s.rate / int64(parts)
+			if rate < 1 {
+				rate = 1
+			}
+		}
+
+		// Unlike SetRate the tokens are kept, so splitting often doesn't hand out bursts
+		b.mu.Lock()
+		b.rate = rate
+		if b.tokens > float64(b.capacity()) {
+			b.tokens = float64(b.capacity())
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Waits until n bytes may go through every bucket.
+// @param []*Bucket buckets - The buckets - nil entries are ignored
+// @param int n - The number of bytes
+func wait(buckets []*Bucket, n int) {
+	var longest time.Duration
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		if d := b.reserve(n); d > longest {
+			longest = d
+		}
+	}
+	if longest > 0 {
+		time.Sleep(longest)
+	}
+}
+
+// Writer - Limits how fast data is written to W. Every bucket is drawn from, so the slowest one
+// sets the rate.
+type Writer struct {
+	W       io.Writer
+	Buckets []*Bucket
+}
+
+// Write - Writes p to the underlying writer no faster than the buckets allow.
+// @param []byte p - The data to write
+// @return int - The number of bytes written
+// @return error - Any error from the underlying writer
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		step := len(p) - written
+		if step > maxStep {
+			step = maxStep
+		}
+		wait(w.Buckets, step)
+
+		n, err := w.W.Write(p[written : written+step])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Reader - Limits how fast data is read from R. Every bucket is drawn from, so the slowest one
+// sets the rate.
+type Reader struct {
+	R       io.Reader
+	Buckets []*Bucket
+}
+
+// Read - Reads from the underlying reader no faster than the buckets allow.
+// @param []byte p - Where the data is read into
+// @return int - The number of bytes read
+// @return error - Any error from the underlying reader
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) > maxStep {
+		p = p[:maxStep]
+	}
+	n, err := r.R.Read(p)
+	if n > 0 {
+		wait(r.Buckets, n) // Data that arrives faster than the rate leaves the sender waiting
+	}
+	return n, err
+}
@@ -0,0 +1,128 @@
+// The unit tests for the throttle package - limited readers and writers, changing a rate while
+// data is moving, and splitting a rate between the users sharing it
+// @author: Michael Bruce
+// @author: Max Kernchen
+package throttle
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 6
+
+// Unit tests for Writer.
+// @param *testing.T t - The wrapper for the test
+func TestWriter(t *testing.T) {
+	fmt.Println("\n----------------TestWriter----------------")
+
+	// The first 64KB fill the bucket, the next 64KB take a second
+	var buf bytes.Buffer
+	w := &Writer{W: &buf, Buckets: []*Bucket{NewBucket(65536)}}
+	start := time.Now()
+	n, err := w.Write(make([]byte, 131072))
+	elapsed := time.Since(start)
+
+	if err != nil || n != 131072 || buf.Len() != 131072 || elapsed < 900*time.Millisecond ||
+		elapsed > 3*time.Second {
+		t.Error("Test failed, expected the write to take a second. Got ", n, err, elapsed)
+	} else {
+		fmt.Println("Successfully Limited Write")
+		successful++
+	}
+
+	// The slowest bucket sets the rate and nil buckets are ignored
+	buf.Reset()
+	w = &Writer{W: &buf, Buckets: []*Bucket{nil, NewBucket(0), NewBucket(32768)}}
+	start = time.Now()
+	w.Write(make([]byte, 65536))
+	elapsed = time.Since(start)
+
+	if buf.Len() != 65536 || elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Error("Test failed, expected the slowest bucket to win. Got ", elapsed)
+	} else {
+		fmt.Println("Successfully Used Slowest Bucket")
+		successful++
+	}
+}
+
+// Unit tests for Reader.
+// @param *testing.T t - The wrapper for the test
+func TestReader(t *testing.T) {
+	fmt.Println("\n----------------TestReader----------------")
+
+	bucket := NewBucket(65536)
+	r := &Reader{R: bytes.NewReader(make([]byte, 131072)), Buckets: []*Bucket{bucket}}
+	start := time.Now()
+	data, err := ioutil.ReadAll(r)
+	elapsed := time.Since(start)
+
+	if err != nil || len(data) != 131072 || elapsed < 900*time.Millisecond ||
+		elapsed > 3*time.Second {
+		t.Error("Test failed, expected the read to take a second. Got ", len(data), err, elapsed)
+	} else {
+		fmt.Println("Successfully Limited Read")
+		successful++
+	}
+}
+
+// Unit tests for SetRate.
+// @param *testing.T t - The wrapper for the test
+func TestSetRate(t *testing.T) {
+	fmt.Println("\n----------------TestSetRate----------------")
+
+	// Lifting the limit lets a slow transfer finish straight away
+	bucket := NewBucket(16384)
+	w := &Writer{W: ioutil.Discard, Buckets: []*Bucket{bucket}}
+	bucket.SetRate(0)
+	start := time.Now()
+	w.Write(make([]byte, 1<<20))
+	elapsed := time.Since(start)
+
+	if bucket.Rate() != 0 || elapsed > 500*time.Millisecond {
+		t.Error("Test failed, expected the limit to be lifted. Got ", bucket.Rate(), elapsed)
+	} else {
+		fmt.Println("Successfully Lifted Limit")
+		successful++
+	}
+}
+
+// Unit tests for Share.
+// @param *testing.T t - The wrapper for the test
+func TestShare(t *testing.T) {
+	fmt.Println("\n----------------TestShare----------------")
+
+	// Two busy users get half the rate each, however many transfers each one has
+	share := NewShare(65536)
+	big, small := share.Bucket("Big"), share.Bucket("Small")
+	big.reserve(1)
+	big.reserve(1)
+	small.reserve(1)
+
+	if big.Rate() != 32768 || small.Rate() != 32768 || share.Rate() != 65536 {
+		t.Error("Test failed, expected the rate to be split evenly. Got ", big.Rate(),
+			small.Rate())
+	} else {
+		fmt.Println("Successfully Split Rate")
+		successful++
+	}
+
+	// Once the small user goes quiet the big one gets the whole rate back
+	time.Sleep(shareIdle + 100*time.Millisecond)
+	big.reserve(1)
+	if big.Rate() != 65536 {
+		t.Error("Test failed, expected an idle user's part to be given back. Got ", big.Rate())
+	} else {
+		fmt.Println("Successfully Gave Back Idle Part")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
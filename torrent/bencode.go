@@ -0,0 +1,181 @@
+// Bencoding for the torrent package - the encoding BitTorrent uses for .torrent files.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+package torrent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Encode - Bencodes a value. Strings and []byte become byte strings, ints become integers,
+// []interface{} becomes a list and map[string]interface{} becomes a dictionary with its keys sorted.
+// @param io.Writer w - Where the bencoded value is written
+// @param interface{} v - The value to encode
+// @return error - An error is produced if v holds a type bencoding can't represent
+func Encode(w io.Writer, v interface{}) error {
+	var err error
+	switch v := v.(type) {
+	case string:
+		_, err = io.WriteString(w, strconv.Itoa(len(v))+":"+v)
+	case []byte:
+		if _, err = io.WriteString(w, strconv.Itoa(len(v))+":"); err == nil {
+			_, err = w.Write(v)
+		}
+	case int:
+		_, err = io.WriteString(w, "i"+strconv.Itoa(v)+"e")
+	case int64:
+		_, err = io.WriteString(w, "i"+strconv.FormatInt(v, 10)+"e")
+	case []interface{}:
+		if _, err = io.WriteString(w, "l"); err != nil {
+			return err
+		}
+		i := 0
+		for i < len(v) {
+			if err = Encode(w, v[i]); err != nil {
+				return err
+			}
+			i++
+		}
+		_, err = io.WriteString(w, "e")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys) // Dictionaries must be sorted by their raw keys
+
+		if _, err = io.WriteString(w, "d"); err != nil {
+			return err
+		}
+		i := 0
+		for i < len(keys) {
+			if err = Encode(w, keys[i]); err != nil {
+				return err
+			}
+			if err = Encode(w, v[keys[i]]); err != nil {
+				return err
+			}
+			i++
+		}
+		_, err = io.WriteString(w, "e")
+	default:
+		return errors.New("Cannot Bencode Value")
+	}
+	return err
+}
+
+// The deepest lists and dictionaries may be nested - far more than any .torrent needs, but few
+// enough that hostile data can't exhaust the stack
+const maxDepth = 64
+
+// Decode - Reads a single bencoded value. Byte strings decode to string, integers to int64,
+// lists to []interface{} and dictionaries to map[string]interface{}.
+// @param io.Reader r - Where the bencoded value is read from
+// @return interface{} - The decoded value
+// @return error - An error is produced if the data is not valid bencoding
+func Decode(r io.Reader) (interface{}, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return decodeValue(br, 0)
+}
+
+// Reads the value starting at the reader's current position.
+// @param *bufio.Reader r - Where the bencoded value is read from
+// @param int depth - How many lists and dictionaries the value is inside
+// @return interface{} - The decoded value
+// @return error - An error is produced if the data is not valid bencoding or is nested too deep
+func decodeValue(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("Bencoding Is Nested Too Deep")
+	}
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c == 'i':
+		num, err := readUntil(r, 'e')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(num, 10, 64)
+	case c == 'l':
+		list := []interface{}{}
+		for {
+			if next, err := r.Peek(1); err != nil {
+				return nil, err
+			} else if next[0] == 'e' {
+				r.ReadByte()
+				return list, nil
+			}
+			v, err := decodeValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		dict := map[string]interface{}{}
+		for {
+			if next, err := r.Peek(1); err != nil {
+				return nil, err
+			} else if next[0] == 'e' {
+				r.ReadByte()
+				return dict, nil
+			}
+			key, err := decodeValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, errors.New("Dictionary Keys Must Be Strings")
+			}
+			if dict[keyStr], err = decodeValue(r, depth+1); err != nil {
+				return nil, err
+			}
+		}
+	case c >= '0' && c <= '9':
+		r.UnreadByte()
+		length, err := readUntil(r, ':')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, errors.New("Invalid String Length")
+		}
+		// The string only grows as its bytes arrive, so a huge length can't allocate up front
+		var data bytes.Buffer
+		if _, err = io.CopyN(&data, r, int64(n)); err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		return data.String(), nil
+	}
+
+	return nil, errors.New("Invalid Bencoding")
+}
+
+// Reads up to and including delim, returning everything before it.
+// @param *bufio.Reader r - Where the data is read from
+// @param byte delim - The byte that ends the data
+// @return string - The data before delim
+// @return error - An error is produced if delim is never found
+func readUntil(r *bufio.Reader, delim byte) (string, error) {
+	s, err := r.ReadString(delim)
+	if err != nil {
+		return "", err
+	}
+	return s[:len(s)-1], nil
+}
@@ -0,0 +1,308 @@
+// Package torrent converts between lynks and BitTorrent .torrent files so a lynk's files can be
+// moved between Lynx and ordinary BitTorrent tooling.
+// @author: Michael Bruce
+// @author: Max Kernchen
+package torrent
+
+import (
+	"../lynxutil"
+	"../metainfo"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// The length of a SHA-1 piece hash in bytes
+const pieceHashLength = sha1.Size
+
+// Export - Writes a multi-file .torrent describing a lynk. BitTorrent hashes fixed-length pieces
+// that run across file boundaries with SHA-1, so the pieces are computed from the files on disk
+// rather than taken from the per-file SHA-256 chunks in meta.info. The announce names the lynk's
+// Lynx tracker, which only Lynx can talk to - other clients have to find peers some other way.
+// @param string lynkDir - The lynk's directory, ending in a slash
+// @param *metainfo.Meta m - The lynk's meta.info
+// @param io.Writer w - Where the .torrent is written
+// @return error - An error is produced if the lynk has no files, or a file is missing or has
+// changed since it was shared
+func Export(lynkDir string, m *metainfo.Meta, w io.Writer) error {
+	if len(m.Files) == 0 {
+		return errors.New("Lynk Has No Files To Export")
+	}
+	pieces, err := hashPieces(lynkDir, m.Files, lynxutil.ChunkLength)
+	if err != nil {
+		return err
+	}
+
+	files := []interface{}{}
+	i := 0
+	for i < len(m.Files) {
+		path := []interface{}{}
+		for _, part := range strings.Split(m.Files[i].Path, "/") {
+			path = append(path, part)
+		}
+		files = append(files, map[string]interface{}{
+			"length": m.Files[i].Length,
+			"path":   path,
+		})
+		i++
+	}
+
+	return Encode(w, map[string]interface{}{
+		"announce":   announceURL(m.Announce),
+		"created by": "Lynx",
+		"owner":      m.Owner, // Ignored by other clients - lets Lynx keep the lynk's owner
+		"info": map[string]interface{}{
+			"name":         m.LynkName,
+			"piece length": lynxutil.ChunkLength,
+			"pieces":       pieces,
+			"files":        files,
+		},
+	})
+}
+
+// Import - Reads a .torrent and creates the meta.info of a lynk holding its files. The .torrent's
+// SHA-1 pieces can't be turned into per-file SHA-256 chunks, so the files are listed without
+// hashes and the pieces are kept to check them with until someone re-shares the lynk from Lynx.
+// @param io.Reader r - Where the .torrent is read from
+// @return *metainfo.Meta - The new lynk's meta.info
+// @return error - An error is produced if r is not a valid .torrent
+func Import(r io.Reader) (*metainfo.Meta, error) {
+	decoded, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Invalid Torrent")
+	}
+	info, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Torrent Has No Info Dictionary")
+	}
+	announce, _ := root["announce"].(string)
+	if announce == "" {
+		return nil, errors.New("Torrent Has No Tracker")
+	}
+	name, _ := info["name"].(string)
+	if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return nil, errors.New("Invalid Torrent Name")
+	}
+	pieceLength, _ := info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	if pieceLength <= 0 || len(pieces) == 0 || len(pieces)%pieceHashLength != 0 {
+		return nil, errors.New("Invalid Torrent Pieces")
+	}
+
+	owner, _ := root["owner"].(string)
+	m := metainfo.New(name, owner, trackerAddress(announce))
+	m.PieceLength = int(pieceLength)
+	m.Pieces = hex.EncodeToString([]byte(pieces))
+
+	if list, ok := info["files"].([]interface{}); ok {
+		i := 0
+		for i < len(list) {
+			file, err := importFile(list[i], int(pieceLength))
+			if err != nil {
+				return nil, err
+			}
+			m.Files = append(m.Files, file)
+			i++
+		}
+	} else if length, ok := info["length"].(int64); ok {
+		// A single file torrent - the file sits at the top of a lynk of the same name
+		m.Files = append(m.Files, lynxutil.File{Length: int(length), Path: name, Name: name,
+			ChunkLength: int(pieceLength)})
+	} else {
+		return nil, errors.New("Torrent Has No Files")
+	}
+
+	// Every byte of the files has to be covered by exactly the pieces there are
+	total := int64(0)
+	for _, file := range m.Files {
+		total += int64(file.Length)
+	}
+	if (total+pieceLength-1)/pieceLength != int64(len(pieces)/pieceHashLength) {
+		return nil, errors.New("Invalid Torrent Pieces")
+	}
+
+	return m, nil
+}
+
+// Verify - Checks the files of a lynk imported from a .torrent against the .torrent's pieces. A
+// piece runs across file boundaries, so a bad piece condemns every file it touches. Pieces that
+// touch a file we don't have yet can't be checked and are skipped.
+// @param string lynkDir - The lynk's directory, ending in a slash
+// @param *metainfo.Meta m - The lynk's meta.info
+// @return []string - The paths of the files in bad pieces
+// @return error - An error is produced if the meta.info's pieces are invalid
+func Verify(lynkDir string, m *metainfo.Meta) ([]string, error) {
+	pieces, err := hex.DecodeString(m.Pieces)
+	if err != nil || m.PieceLength <= 0 || len(pieces)%pieceHashLength != 0 {
+		return nil, errors.New("Invalid Torrent Pieces")
+	}
+
+	bad := map[int]bool{} // Indexes of the files in bad pieces
+	h := sha1.New()
+	filled, piece := 0, 0 // Bytes of the current piece hashed so far, and its index
+	touching, unknown := []int{}, false
+	endPiece := func() {
+		start := piece * pieceHashLength
+		if start+pieceHashLength > len(pieces) ||
+			!unknown && !bytes.Equal(h.Sum(nil), pieces[start:start+pieceHashLength]) {
+			for _, i := range touching {
+				bad[i] = true
+			}
+		}
+		h.Reset()
+		filled, piece, touching, unknown = 0, piece+1, []int{}, false
+	}
+
+	for i, file := range m.Files {
+		f, openErr := os.Open(lynkDir + file.Path)
+		remaining := file.Length
+		for remaining > 0 {
+			n := m.PieceLength - filled
+			if remaining < n {
+				n = remaining
+			}
+			touching = append(touching, i)
+			if openErr != nil {
+				unknown = true
+			} else if _, err := io.CopyN(h, f, int64(n)); err != nil {
+				bad[i] = true // Shorter than it should be
+			}
+			filled += n
+			remaining -= n
+			if filled == m.PieceLength {
+				endPiece()
+			}
+		}
+		if openErr == nil {
+			if info, err := f.Stat(); err == nil && info.Size() != int64(file.Length) {
+				bad[i] = true
+			}
+			f.Close()
+		}
+	}
+	if filled > 0 { // The last piece may be shorter
+		endPiece()
+	}
+
+	paths := []string{}
+	for i, file := range m.Files {
+		if bad[i] {
+			paths = append(paths, file.Path)
+		}
+	}
+	return paths, nil
+}
+
+// Turns one entry of a multi-file torrent's file list into a File.
+// @param interface{} entry - The decoded entry
+// @param int pieceLength - The torrent's piece length
+// @return lynxutil.File - The file
+// @return error - An error is produced if the entry is malformed or its path leaves the lynk
+func importFile(entry interface{}, pieceLength int) (lynxutil.File, error) {
+	dict, ok := entry.(map[string]interface{})
+	if !ok {
+		return lynxutil.File{}, errors.New("Invalid Torrent File Entry")
+	}
+	length, ok := dict["length"].(int64)
+	parts, _ := dict["path"].([]interface{})
+	if !ok || len(parts) == 0 {
+		return lynxutil.File{}, errors.New("Invalid Torrent File Entry")
+	}
+
+	names := []string{}
+	for _, part := range parts {
+		name, ok := part.(string)
+		if !ok || strings.ContainsAny(name, "/\\") {
+			return lynxutil.File{}, errors.New("Invalid Torrent File Path")
+		}
+		names = append(names, name)
+	}
+
+	relPath, err := lynxutil.CleanPath(strings.Join(names, "/"))
+	if err != nil {
+		return lynxutil.File{}, err
+	}
+
+	return lynxutil.File{Length: int(length), Path: relPath, Name: names[len(names)-1],
+		ChunkLength: pieceLength}, nil
+}
+
+// Computes the SHA-1 piece hashes of a lynk's files laid end to end.
+// @param string lynkDir - The lynk's directory, ending in a slash
+// @param []lynxutil.File files - The files in torrent order
+// @param int pieceLength - The number of bytes in each piece
+// @return string - The concatenated 20 byte piece hashes
+// @return error - An error is produced if a file can't be read or its length has changed
+func hashPieces(lynkDir string, files []lynxutil.File, pieceLength int) (string, error) {
+	pieces := []byte{}
+	h := sha1.New()
+	filled := 0 // Bytes of the current piece hashed so far
+
+	i := 0
+	for i < len(files) {
+		f, err := os.Open(lynkDir + files[i].Path)
+		if err != nil {
+			return "", err
+		}
+
+		read := 0
+		for {
+			n, err := io.CopyN(h, f, int64(pieceLength-filled))
+			read += int(n)
+			filled += int(n)
+			if filled == pieceLength {
+				pieces = h.Sum(pieces)
+				h.Reset()
+				filled = 0
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				f.Close()
+				return "", err
+			}
+		}
+		f.Close()
+
+		if read != files[i].Length {
+			return "", errors.New(files[i].Path + " Has Changed Since It Was Shared")
+		}
+		i++
+	}
+
+	if filled > 0 { // The last piece may be shorter
+		pieces = h.Sum(pieces)
+	}
+	return string(pieces), nil
+}
+
+// Turns a Lynx tracker's IP:Port into the URL form .torrent files use. A Lynx tracker doesn't
+// speak the HTTP tracker protocol, so the URL uses Lynx's own scheme rather than pretend it does.
+// @param string tracker - The tracker's IP:Port
+// @return string - The announce URL
+func announceURL(tracker string) string {
+	return metainfo.URIScheme + "://" + tracker
+}
+
+// Turns an announce URL back into the IP:Port Lynx dials. Any scheme is accepted - earlier
+// versions of Lynx exported 'http://<IP:Port>/announce'.
+// @param string announce - The announce URL
+// @return string - The tracker's IP:Port
+func trackerAddress(announce string) string {
+	if i := strings.Index(announce, "://"); i != -1 {
+		announce = announce[i+3:]
+	}
+	if i := strings.Index(announce, "/"); i != -1 {
+		announce = announce[:i]
+	}
+	return announce
+}
@@ -0,0 +1,138 @@
+// The unit tests for the torrent package - bencoding, including hostile input, and exporting lynks
+// to .torrent files and importing them back with their pieces checked
+// @author: Michael Bruce
+// @author: Max Kernchen
+package torrent
+
+import (
+	"bytes"
+	"capstone/lynxutil"
+	"capstone/metainfo"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 8
+
+// Unit tests for our Encode and Decode functions.
+// @param *testing.T t - The wrapper for the test
+func TestBencode(t *testing.T) {
+	fmt.Println("\n----------------TestBencode----------------")
+
+	var buf bytes.Buffer
+	Encode(&buf, map[string]interface{}{"spam": []interface{}{"a", 42}, "cow": "moo"})
+	if buf.String() != "d3:cow3:moo4:spaml1:ai42eee" {
+		t.Error("Test failed, expected 'd3:cow3:moo4:spaml1:ai42eee'. Got ", buf.String())
+	} else {
+		fmt.Println("Successfully Bencoded Dictionary")
+		successful++
+	}
+
+	decoded, err := Decode(&buf)
+	dict, _ := decoded.(map[string]interface{})
+	list, _ := dict["spam"].([]interface{})
+	if err != nil || dict["cow"] != "moo" || len(list) != 2 || list[1] != int64(42) {
+		t.Error("Test failed, expected the dictionary back. Got ", decoded, err)
+	} else {
+		fmt.Println("Successfully Decoded Dictionary")
+		successful++
+	}
+
+	if _, err = Decode(strings.NewReader("d3:cow")); err == nil {
+		t.Error("Test failed, expected truncated data to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Truncated Data")
+		successful++
+	}
+
+	_, hugeErr := Decode(strings.NewReader("999999999999:short"))
+	_, deepErr := Decode(strings.NewReader(strings.Repeat("l", maxDepth+2)))
+	if hugeErr == nil || deepErr == nil {
+		t.Error("Test failed, expected huge strings and deep nesting to be rejected. Got ",
+			hugeErr, deepErr)
+	} else {
+		fmt.Println("Successfully Rejected Hostile Data")
+		successful++
+	}
+}
+
+// Unit tests for our Export and Import functions.
+// @param *testing.T t - The wrapper for the test
+func TestExportImport(t *testing.T) {
+	fmt.Println("\n----------------TestExportImport----------------")
+
+	lynkDir, _ := ioutil.TempDir("", "lynk")
+	defer os.RemoveAll(lynkDir)
+	lynkDir += "/"
+	os.Mkdir(lynkDir+"docs", 0755)
+
+	// The first file ends part way through a piece so the second piece spans both files
+	first := bytes.Repeat([]byte("a"), lynxutil.ChunkLength+10)
+	second := []byte("hello")
+	ioutil.WriteFile(lynkDir+"big.bin", first, 0644)
+	ioutil.WriteFile(lynkDir+"docs/notes.txt", second, 0644)
+
+	m := metainfo.New("Tests", "Tester", "127.0.0.1:9000")
+	m.Files = []lynxutil.File{
+		{Length: len(first), Path: "big.bin", Name: "big.bin"},
+		{Length: len(second), Path: "docs/notes.txt", Name: "notes.txt"},
+	}
+
+	var buf bytes.Buffer
+	if err := Export(lynkDir, m, &buf); err != nil {
+		t.Error("Test failed, expected no errors. Got ", err)
+		return
+	}
+
+	decoded, _ := Decode(bytes.NewReader(buf.Bytes()))
+	info := decoded.(map[string]interface{})["info"].(map[string]interface{})
+	whole := append(append([]byte{}, first...), second...)
+	firstPiece := sha1.Sum(whole[:lynxutil.ChunkLength])
+	lastPiece := sha1.Sum(whole[lynxutil.ChunkLength:])
+	pieces := string(firstPiece[:]) + string(lastPiece[:])
+	if info["pieces"] != pieces {
+		t.Error("Test failed, expected pieces to span file boundaries.")
+	} else {
+		fmt.Println("Successfully Hashed Pieces")
+		successful++
+	}
+
+	imported, err := Import(&buf)
+	if err != nil || imported.Announce != "127.0.0.1:9000" || imported.Owner != "Tester" ||
+		len(imported.Files) != 2 || imported.Files[1].Path != "docs/notes.txt" {
+		t.Error("Test failed, expected the lynk back from its .torrent. Got ", imported, err)
+		return
+	}
+	fmt.Println("Successfully Imported Torrent")
+	successful++
+
+	good, goodErr := Verify(lynkDir, imported)
+	first[0] = 'b'
+	ioutil.WriteFile(lynkDir+"big.bin", first, 0644)
+	os.Remove(lynkDir + "docs/notes.txt")
+	bad, badErr := Verify(lynkDir, imported)
+	if goodErr != nil || badErr != nil || len(good) != 0 || len(bad) != 1 || bad[0] != "big.bin" {
+		t.Error("Test failed, expected only the corrupt file to fail its pieces. Got ", good, bad,
+			goodErr, badErr)
+	} else {
+		fmt.Println("Successfully Verified Pieces")
+		successful++
+	}
+
+	if err = Export(lynkDir, metainfo.New("Empty", "Tester", "127.0.0.1:9000"), &buf); err == nil {
+		t.Error("Test failed, expected an empty lynk not to be exported.")
+	} else {
+		fmt.Println("Successfully Refused Empty Lynk")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
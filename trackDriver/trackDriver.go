@@ -4,9 +4,18 @@
 // @verison: 2/17/2016
 package main
 
-import "capstone/tracker"
+import (
+	"capstone/tracker"
+	"fmt"
+	"os"
+)
 
 // Function used to drive and test our tracker's functions
 func main() {
-	tracker.Listen()
+	t, err := tracker.LoadDefault()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	t.Listen()
 }
@@ -9,56 +9,114 @@ import (
 	"bufio"
 	"bytes"
 	"../lynxutil"
-	"../mycrypt"
-	"compress/gzip"
+	"../metainfo"
+	"../mypgp"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
-	"net/textproto"
 	"os"
-	"os/user"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
-// An array of tLynks this tracker presides over
-var tLynks []lynxutil.Lynk
+// Tracker - The tracker of a Lynx node. It keeps the swarm of every lynk the node presides over
+// and tells peers about each other.
+type Tracker struct {
+	*lynxutil.Node
+
+	lynks *lynxutil.LynkStore // The lynks this tracker presides over
+
+	// Guards the swarm.info files - peers are added and removed by many requests at once
+	swarmMu sync.Mutex
+	// Guards the meta.info files, so each push is checked against the one the last push left
+	pushMu sync.Mutex
+}
+
+// Default - The tracker of the default node. It is nil until LoadDefault has created it.
+var Default *Tracker
+
+// Guards the creation of the default tracker
+var defaultMu sync.Mutex
+
+// LoadDefault - Returns the tracker of the default node, creating both the first time it is
+// called.
+// @return *Tracker - The default tracker
+// @return error - An error is produced if the default node can't be created
+func LoadDefault() (*Tracker, error) {
+	node, err := lynxutil.LoadDefault()
+	if err != nil {
+		return nil, err
+	}
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if Default == nil {
+		Default = New(node)
+	}
+	return Default, nil
+}
+
+// New - Creates the tracker of a node, presiding over every lynk the node holds tracker files for.
+// @param *lynxutil.Node node - The node
+// @return *Tracker - The tracker
+func New(node *lynxutil.Node) *Tracker {
+	t := &Tracker{Node: node, lynks: lynxutil.NewLynkStore()}
+	filepath.Walk(t.Home, t.visitTrackers)
+	return t
+}
 
 // Function that deletes an entry from a lynk's peers array and the swarm.info file.
 // @param string peerToDelete - This is the peer struct we want to delete - uses the IP address
 // @param string lynkName - The lynk we want to delete it from
-func deletePeer(peerToDelete, lynkName string) {
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
-
-	i := 0
-	for i < len(lynk.Peers) {
-		if peerToDelete == lynk.Peers[i].IP {
-			lynk.Peers = append(lynk.Peers[:i], lynk.Peers[i+1:]...)
+func (t *Tracker) deletePeer(peerToDelete, lynkName string) {
+	t.swarmMu.Lock()
+	defer t.swarmMu.Unlock()
+	var peers []lynxutil.Peer
+	found := t.lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		i := 0
+		for i < len(lynk.Peers) {
+			if peerToDelete == lynk.Peers[i].IP {
+				lynk.Peers = append(lynk.Peers[:i], lynk.Peers[i+1:]...)
+			} else {
+				i++
+			}
 		}
-		i++
+		peers = append(peers, lynk.Peers...)
+	})
+	if !found {
+		return
 	}
 
-	swarmPath := lynxutil.HomePath + lynkName + "/" + lynkName + "_Tracker/" + "swarm.info"
+	swarmPath := t.Home + lynkName + "/" + lynkName + "_Tracker/" + "swarm.info"
+	t.writeSwarminfo(swarmPath, peers)
+}
 
+// Replaces a swarm.info with one listing peers. The caller must hold swarmMu.
+// @param string swarmPath - The path to the swarminfo file
+// @param []lynxutil.Peer peers - The peers of the lynk
+// @return error - An error is produced if the swarm file can't be written
+func (t *Tracker) writeSwarminfo(swarmPath string, peers []lynxutil.Peer) error {
 	os.Remove(swarmPath)
-	newSwarmInfo, _ := os.Create(swarmPath)
+	newSwarmInfo, err := os.Create(swarmPath)
+	if err != nil {
+		return err
+	}
 
-	i = 0
-	for i < len(lynk.Peers) {
-		newSwarmInfo.WriteString(lynk.Peers[i].IP + ":::" + lynk.Peers[i].Port + "\n")
-		i++
+	for _, peer := range peers {
+		newSwarmInfo.WriteString(peer.SwarmLine() + "\n")
 	}
+	return newSwarmInfo.Close()
 }
 
 // Deletes the current swarm.info and replaces it with a new version that
 // accurately reflects the array of Peers after they have been modified
 // @return error - An error can be produced when issues arise from trying to create
 // or remove the swarm file - otherwise error will be nil.
-func updateSwarminfo(swarmPath string) error {
-	parseSwarminfo(swarmPath)
+func (t *Tracker) updateSwarminfo(swarmPath string) error {
+	t.parseSwarminfo(swarmPath)
 
 	err := os.Remove(swarmPath)
 	if err != nil {
@@ -72,12 +130,11 @@ func updateSwarminfo(swarmPath string) error {
 		return err
 	}
 
-	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
+	lynk, _ := t.lynks.Get(t.getTLynkName(swarmPath))
 
 	i := 0
 	for i < len(lynk.Peers) {
-		newSwarmInfo.WriteString(lynk.Peers[i].IP + ":::" + lynk.Peers[i].Port + "\n")
+		newSwarmInfo.WriteString(lynk.Peers[i].SwarmLine() + "\n")
 		i++
 	}
 
@@ -89,76 +146,100 @@ func updateSwarminfo(swarmPath string) error {
 // @param string swarmPath - The path to the swarminfo file
 // @return error - An error can be produced when issues arise from trying to access
 // the swarm file or from an invalid swarm file type - otherwise error will be nil.
-func parseSwarminfo(swarmPath string) error {
-	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
-
-	//fmt.Println(lynk)
-	lynk.Peers = nil // Resets peers array
+func (t *Tracker) parseSwarminfo(swarmPath string) error {
+	lynkName := t.getTLynkName(swarmPath)
+	peers := []lynxutil.Peer{}
+	// Resets peers array - the swarm.info is read first so readers never wait on the disk
+	defer t.lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Peers = peers })
 
 	swarmFile, err := os.Open(swarmPath)
 	if err != nil {
 		return err
 	} else if !strings.Contains(swarmPath, "swarm.info") {
+		swarmFile.Close()
 		return errors.New("Invalid File Type")
 	}
 
 	scanner := bufio.NewScanner(swarmFile)
-	tempPeer := lynxutil.Peer{}
 
 	// Scan each line
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text()) // Trim helps with errors in \n
-		split := strings.Split(line, ":::")
-
-		tempPeer.IP = split[0]
-		tempPeer.Port = split[1]
-		lynk.Peers = append(lynk.Peers, tempPeer)
+		tempPeer, err := lynxutil.ParsePeer(scanner.Text())
+		if err != nil {
+			continue
+		}
+		peers = append(peers, tempPeer)
 	}
 
-	//fmt.Println(lynk.Peers)
+	//fmt.Println(peers)
 	return swarmFile.Close()
 }
 
-// Adds a peer to the swarm.info file
+// Adds a peer to the swarm.info file. The first key announced for an IP:Port is the one the swarm
+// keeps - a different key announced later is refused, as it could be someone posing as the peer.
+// A peer that really changed its key has to leave the swarm first.
 // @param string addPath - the path of the file to be added
 // @param string swarmPath - the path of the swarminfo file
 // @return error - An error can be produced when issues arise from trying to access
-// the swarm file or if the file to be added already exists in the swarm file - otherwise
-// error will be nil.
-func addToSwarminfo(addPeer lynxutil.Peer, swarmPath string) error {
+// the swarm file or if the file to be added already exists in the swarm file, or
+// lynxutil.ErrKeyChanged if it announced a different key - otherwise error will be nil.
+func (t *Tracker) addToSwarminfo(addPeer lynxutil.Peer, swarmPath string) error {
+	t.swarmMu.Lock()
+	defer t.swarmMu.Unlock()
 	swarmFile, err := os.OpenFile(swarmPath, os.O_APPEND|os.O_WRONLY, 0644) // Opens for appending
 	if err != nil {
 		return err
 	}
 
-	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
-	if lynk == nil {
-		tLynks = append(tLynks, lynxutil.Lynk{Name: lynkName})
-		lynk = lynxutil.GetLynk(tLynks, lynkName)
-	}
+	lynkName := t.getTLynkName(swarmPath)
+	t.lynks.Add(lynxutil.Lynk{Name: lynkName}) // Does nothing if we already preside over it
 
-	parseSwarminfo(swarmPath)
+	t.parseSwarminfo(swarmPath)
+	lynk, _ := t.lynks.Get(lynkName)
 
 	i := 0
 	for i < len(lynk.Peers) {
 		if lynk.Peers[i].IP == addPeer.IP && lynk.Peers[i].Port == addPeer.Port {
-			return errors.New("Can't Add Duplicates To Swarminfo")
+			swarmFile.Close()
+			if addPeer.Key == "" || addPeer.Key == lynk.Peers[i].Key {
+				return errors.New("Can't Add Duplicates To Swarminfo")
+			} else if lynk.Peers[i].Key != "" && !sameKey(addPeer.Key, lynk.Peers[i].Key) {
+				fmt.Println("Refused A New Key For " + addPeer.IP + ":" + addPeer.Port)
+				return lynxutil.ErrKeyChanged
+			}
+			// The peer joined before it announced a key - the first one it announces is kept
+			lynk.Peers[i].Key = addPeer.Key
+			return t.writeSwarminfo(swarmPath, lynk.Peers)
 		}
 		i++
 	}
 
-	// Write to swarminfo file using ::: to IP and Port
-	swarmFile.WriteString(addPeer.IP + ":::" + addPeer.Port + "\n")
+	// Write to swarminfo file using ::: between IP, Port and Key
+	swarmFile.WriteString(addPeer.SwarmLine() + "\n")
 
 	return swarmFile.Close()
 }
 
+// Returns whether two armored public keys are the same key, however they were armored.
+// @param string a - The first key
+// @param string b - The second key
+// @return bool - True if both keys have the same fingerprint
+func sameKey(a, b string) bool {
+	aPrint, aErr := lynxutil.Fingerprint(a)
+	bPrint, bErr := lynxutil.Fingerprint(b)
+	return aErr == nil && bErr == nil && aPrint == bPrint
+}
+
 // Listen - Calls lynxutil to create a welcomeSocket that listens for TCP connections - once
 // someone connects a goroutine is spawned to handle the request
-func Listen() {
-	lynxutil.Listen(handleRequest, lynxutil.TrackerPort)
+func (t *Tracker) Listen() {
+	lynxutil.Listen(t.handleRequest, t.TrackerPort)
+}
+
+// Serve - Handles requests on a welcomeSocket that is already listening until it is closed.
+// @param net.Listener welcomeSocket - The socket to accept connections on
+func (t *Tracker) Serve(welcomeSocket net.Listener) {
+	lynxutil.Serve(welcomeSocket, t.handleRequest)
 }
 
 // Handles a request / push sent by a client, can either be a swarm or meta request or a push
@@ -166,22 +247,36 @@ func Listen() {
 // @param net.Conn conn - The socket which the client is asking on
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
-func handleRequest(conn net.Conn) error {
-	request, err := bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
+func (t *Tracker) handleRequest(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	request, err := reader.ReadString('\n') // Waits for a String ending in newline
 	if err != nil {
 		return err
 	}
 	request = strings.TrimSpace(request)
 
 	if strings.Contains(request, "Meta_Push:") { // We are receiving a meta.info file
-		handlePush(request, conn)
-		notifyPeers(request)
+		t.WriteKey(conn) // The pusher wraps the meta.info's session key for us
+		if t.handlePush(request, reader) == nil {
+			fmt.Fprintf(conn, "YES\n") // The pusher's copy only counts the push once we took it
+			t.notifyPeers(request)
+		} else {
+			fmt.Fprintf(conn, "NO\n")
+		}
+	} else if strings.HasPrefix(request, "Tracker_Transfer:") {
+		// Syntax is "Tracker_Transfer:<LynkName>:<Key>\n" followed by the swarm.info, the
+		// meta.info and the sender's signature of both
+		if t.handleTransfer(strings.TrimPrefix(request, "Tracker_Transfer:"), reader) == nil {
+			fmt.Fprintf(conn, "YES\n")
+		} else {
+			fmt.Fprintf(conn, "NO\n")
+		}
 	} else if strings.Contains(request, "Disconnect:") {
 		// tmpArr[0] - Disconnect | tmpArr[1] - <IP> | tmpArr[2] - <LynkName>
 		tmpArr := strings.Split(request, ":")
-		deletePeer(tmpArr[1], tmpArr[2])
+		t.deletePeer(tmpArr[1], tmpArr[2])
 	} else { // We are receiving a pull request
-		handlePull(request, conn)
+		t.handlePull(request, conn)
 	}
 	return conn.Close()
 }
@@ -192,78 +287,91 @@ func handleRequest(conn net.Conn) error {
 // @param string request - The request sent to tracker
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
-func handlePull(request string, conn net.Conn) error {
-	// Client syntax for request is "X_Request:<IP>:<Port>:<LynkName>\n"
+func (t *Tracker) handlePull(request string, conn net.Conn) error {
+	// Client syntax for request is "X_Request:<IP>:<Port>:<LynkName>:<Key>\n"
 	// So tmpArr[0] - X_Request | tmpArr[1] - <IP> | tmpArr[2] - <Port> | tmpArr[3] - <LynkName>
+	// tmpArr[4] - <Key> is the client's public key encoded by lynxutil.EncodeKey, and is left
+	// out by clients that don't announce one
 	tmpArr := strings.Split(request, ":")
-	if len(tmpArr) != 4 {
+	if len(tmpArr) != 4 && len(tmpArr) != 5 {
 		conn.Close()
 		return errors.New("Invalid Request Syntax")
 	}
+	key := ""
+	if len(tmpArr) == 5 {
+		var err error
+		if key, err = lynxutil.DecodeKey(tmpArr[4]); err != nil {
+			conn.Close()
+			return err
+		}
+	}
 
 	fileToSend := ""
 	// Checks to see if we are dealing w/ a Swarm or Meta Request
-	swarmPath := lynxutil.HomePath + tmpArr[3] + "/" + tmpArr[3] + "_Tracker/" + "swarm.info"
+	swarmPath := t.Home + tmpArr[3] + "/" + tmpArr[3] + "_Tracker/" + "swarm.info"
 	if tmpArr[0] == "Swarm_Request" {
 		fileToSend = swarmPath
 	} else if tmpArr[0] == "Meta_Request" {
-		fileToSend = lynxutil.HomePath + tmpArr[3] + "/meta.info"
+		// Our copy is the one pushes land on, still signed by whoever pushed it last
+		fileToSend = t.Home + tmpArr[3] + "/" + tmpArr[3] + "_Tracker/" + "meta.info"
 	} else {
 		conn.Close()
 		return errors.New("Invalid Request Syntax")
 	}
 
-	tmpPeer := lynxutil.Peer{IP: strings.TrimSpace(tmpArr[1]), Port: strings.TrimSpace(tmpArr[2])}
+	tmpPeer := lynxutil.Peer{IP: strings.TrimSpace(tmpArr[1]), Port: strings.TrimSpace(tmpArr[2]),
+		Key: key}
 	err := sendFile(fileToSend, conn) // Sending The file
 	if err != nil {
 		conn.Close()
 		return err
 	}
 
-	addToSwarminfo(tmpPeer, swarmPath) // So we only add peer to swarmlist on success
-	return nil                         // No errors if we reached this point
+	t.addToSwarminfo(tmpPeer, swarmPath) // So we only add peer to swarmlist on success
+	return nil                           // No errors if we reached this point
 }
 
 // Helper function for handleRequest - handles the case where we are received meta.info file.
-// @param net.Conn conn - The socket which the client is asking on
 // @param string request - The request sent to tracker
+// @param io.Reader conn - The socket which the client is asking on, positioned after the request
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
-func handlePush(request string, conn net.Conn) error {
+func (t *Tracker) handlePush(request string, conn io.Reader) error {
 	// Client syntax for push is "Meta_Push:<LynkName>\n"
 	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkName>
 	tmpArr := strings.Split(request, ":")
-	metaPath := lynxutil.HomePath + tmpArr[1] + "/" + tmpArr[1] + "_Tracker/" + "meta.info"
-
-	bufIn, err := ioutil.ReadAll(conn)
-
-	// Decrypt
-	key := []byte(lynxutil.PrivateKey)
-	var plainFile []byte
-	if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
-		return err
+	metaPath := t.Home + tmpArr[1] + "/" + tmpArr[1] + "_Tracker/" + "meta.info"
+
+	// Reads the new meta.info into memory first so a failed push leaves the old one intact
+	var received bytes.Buffer
+	err := t.ReceiveStream(conn, &received)
+	var incoming, local *metainfo.Meta
+	if err == nil {
+		// Only a valid meta.info replaces the old one - older formats are migrated on the way in
+		incoming, err = metainfo.Decode(bytes.NewReader(received.Bytes()))
 	}
 
-	// Decompress
-	r, _ := gzip.NewReader(bytes.NewBuffer(plainFile))
-	bufOut, _ := ioutil.ReadAll(r)
-	r.Read(bufOut)
-	r.Close()
-
-	//fmt.Println(len(bufIn), "Bytes Received")
-
-	err = os.Remove(metaPath)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	t.pushMu.Lock()
+	defer t.pushMu.Unlock()
+	if err == nil {
+		local, err = metainfo.Read(metaPath)
+	}
+	if err == nil {
+		// Only the owner and the writers they authorised may change the lynk. The signature stays
+		// in the meta.info so the peers we pass it on to can check it too.
+		err = metainfo.CheckPush(local, incoming)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(metaPath+".tmp", received.Bytes(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(metaPath+".tmp", metaPath)
 	}
-
-	newMetainfo, err := os.Create(metaPath)
 	if err != nil {
+		os.Remove(metaPath + ".tmp")
 		fmt.Println(err)
 		return err
 	}
-	newMetainfo.Write(bufOut)
 
 	return nil // No errors if we reached this point
 }
@@ -273,58 +381,52 @@ func handlePush(request string, conn net.Conn) error {
 // @param string request - The request sent to tracker
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
-func notifyPeers(request string) error {
+func (t *Tracker) notifyPeers(request string) error {
 	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkName>
 	tmpArr := strings.Split(request, ":")
-	metaPath := lynxutil.HomePath + tmpArr[1] + "/" + tmpArr[1] + "_Tracker/" + "meta.info"
-	swarmPath := lynxutil.HomePath + tmpArr[1] + "/" + tmpArr[1] + "_Tracker/" + "swarm.info"
-
-	// Opens the swarm file for the specific Lynk and notifies all of the listed peers
-	swarmFile, _ := os.Open(swarmPath)
-	r := bufio.NewReader(swarmFile)
-	tp := textproto.NewReader(r)
-	line, e := tp.ReadLine()
-	for e == nil {
-		peerArray := strings.Split(line, ":::")
-		// [0] is IP / [1 ]is Port
-		pConn, err := net.Dial("tcp", peerArray[0]+":"+peerArray[1])
+	return t.pushToPeers(tmpArr[1])
+}
+
+// Sends the tracker's meta.info of a lynk to every peer in its swarm. Each copy is encrypted for
+// the key the peer announced when it joined the swarm - never for a key sent back on the
+// connection, which anyone answering at the peer's address could send. Peers that announced no
+// key are skipped.
+// @param string lynkName - The name of the lynk
+// @return error - An error can be produced when the meta.info can't be read - otherwise error will
+// be nil.
+func (t *Tracker) pushToPeers(lynkName string) error {
+	trackerDir := t.Home + lynkName + "/" + lynkName + "_Tracker/"
+	t.swarmMu.Lock()
+	t.parseSwarminfo(trackerDir + "swarm.info")
+	lynk, _ := t.lynks.Get(lynkName)
+	t.swarmMu.Unlock()
+
+	for _, peer := range lynk.Peers {
+		if peer.Key == "" {
+			continue
+		}
+		pConn, err := net.Dial("tcp", peer.IP+":"+peer.Port)
 		if err != nil {
-			line, e = tp.ReadLine()
 			continue
 		}
 
-		fmt.Fprintf(pConn, "Meta_Push:"+tmpArr[1]+"\n")
-
-		fBytes, err := ioutil.ReadFile(metaPath)
-		//fmt.Println("fBytes: ", string(fBytes))
-
-		// Begin Compression
-		var b bytes.Buffer
-		gz := gzip.NewWriter(&b)
-		gz.Write(fBytes)
-		gz.Close()
-		// End Compression
-
-		// Begin Encryption
-		var cipherFile []byte
-		publicKey := lynxutil.Peer{IP: pConn.LocalAddr().String()}.Key + lynxutil.PrivateKey
-		key := []byte(publicKey)
-		if cipherFile, err = mycrypt.Encrypt(key, b.Bytes()); err != nil {
+		fmt.Fprintf(pConn, "Meta_Push:"+lynkName+"\n")
+		metaFile, err := os.Open(trackerDir + "meta.info")
+		if err != nil {
+			pConn.Close()
 			return err
 		}
-		// End Encryption
 
-		_, err = pConn.Write(cipherFile)
+		err = lynxutil.SendStream(metaFile, pConn, peer.Key)
+		metaFile.Close()
 		if err != nil {
 			fmt.Println("CONNECTION ERROR:", err)
-			return err
 		}
 
 		time.Sleep(time.Duration(1) * time.Second)
 		//fmt.Println("TRACKER SENT", n, "BYTES TO PEER")
 
 		pConn.Close()
-		line, e = tp.ReadLine()
 	}
 
 	return nil // No errors if we reached this point
@@ -355,83 +457,125 @@ func sendFile(fileName string, conn net.Conn) error {
 
 // CreateSwarm - Creates a new swarm.info upon clicking of create button in gui
 // @param string name - the name of the lynk
-func CreateSwarm(name string) {
-	p1 := lynxutil.Peer{IP: "", Port: "8080"}
+func (t *Tracker) CreateSwarm(name string) {
+	p1 := lynxutil.Peer{IP: "", Port: t.ServerPort, Key: t.PublicKey}
 
-	currentuser, err := user.Current()
-	trackerDir := currentuser.HomeDir + "/Lynx/" + name + "/" + name + "_Tracker"
+	trackerDir := t.Home + name + "/" + name + "_Tracker"
 	os.Mkdir(trackerDir, 0755)
 
-	_, err = os.Create(trackerDir + "/swarm.info")
+	_, err := os.Create(trackerDir + "/swarm.info")
 	if err != nil {
 		fmt.Println(err)
 	}
 
 	p1.IP = lynxutil.GetIP()
-	addToSwarminfo(p1, trackerDir+"/swarm.info")
-
-	lynxutil.FileCopy(currentuser.HomeDir+"/Lynx/"+name+"/meta.info", trackerDir+"/meta.info")
+	t.addToSwarminfo(p1, trackerDir+"/swarm.info")
+
+	// The meta.info we hand out is signed, so joiners can check it came from the owner. It is
+	// signed as the lynk's first push, which our own copy has to know about too.
+	m, err := metainfo.Read(t.Home + name + "/meta.info")
+	if err == nil && m.Authorised(t.KeyFingerprint) {
+		if err = m.Sign(t.Node); err == nil {
+			err = metainfo.Write(t.Home+name+"/meta.info", m)
+		}
+		if err == nil {
+			err = metainfo.Write(trackerDir+"/meta.info", m)
+		}
+	} else {
+		err = lynxutil.FileCopy(t.Home+name+"/meta.info", trackerDir+"/meta.info")
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
 // Function which visits each tracker directory within the Lynx root
 // @param path: the path where the root directory is located
 // @param file: each file within the root or inner directories
 // @param err: any error we way encoutner along the way
-func visitTrackers(path string, file os.FileInfo, err error) error {
+func (t *Tracker) visitTrackers(path string, file os.FileInfo, err error) error {
 	path = strings.Replace(path, "\\", "/", -1) // Switches windows \ to unix /
-	base := strings.TrimPrefix(path, lynxutil.HomePath)
+	base := strings.TrimPrefix(path, t.Home)
 	split := strings.Split(base, "/")
 
 	// Checks that there is directory beneath another directory and has _tracker
 	if file.IsDir() && len(split) == 2 && strings.Contains(split[1], "_Tracker") {
 		//fmt.Println(file.Name())
 		lynkName := strings.TrimSuffix(file.Name(), "_Tracker")
-		tLynks = append(tLynks, lynxutil.Lynk{Name: lynkName})
+		t.lynks.Add(lynxutil.Lynk{Name: lynkName})
 		// Need to populate Peers here.
 	}
 
 	return nil
 }
 
-// Function init runs before main and allows us to setup our tracker properly.
-func init() {
-	filepath.Walk(lynxutil.HomePath, visitTrackers)
-}
-
 // Helper function that returns a lynk's name give it's swarm.info filepath.
 // @param string swarmPath - The swarm.info path associated with the lynk we're interested in
 // @returns string - The lynk name
-func getTLynkName(swarmPath string) string {
-	tmpStr := strings.TrimSuffix(strings.TrimPrefix(swarmPath, lynxutil.HomePath), "/swarm.info")
+func (t *Tracker) getTLynkName(swarmPath string) string {
+	tmpStr := strings.TrimSuffix(strings.TrimPrefix(swarmPath, t.Home), "/swarm.info")
 	split := strings.Split(tmpStr, "/")
 	//fmt.Println(split[0])
 	return split[0]
 }
 
-// BroadcastNewIP - This function broadcasts a tracker's new IP address to all of its peers
-// @param string swarmPath - The swarm.info path associated with the lynk we're interested in
-func BroadcastNewIP(swarmPath string) {
-	// Can update Meta here if needed
-	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
+// AdoptOwner - Gives the tracker's copy of a lynk from before meta.infos were signed an owner key,
+// so the tracker takes pushes signed by it. Lynks we aren't the tracker of are left alone.
+// @param string lynkName - The name of the lynk
+// @param string fingerprint - The fingerprint of the owner's key
+// @return error - An error is produced if the lynk already has another owner key
+func (t *Tracker) AdoptOwner(lynkName, fingerprint string) error {
+	metaPath := t.Home + lynkName + "/" + lynkName + "_Tracker/meta.info"
+	if _, err := os.Stat(metaPath); os.IsNotExist(err) {
+		return nil
+	}
 
-	i := 0
-	for i < len(lynk.Peers) {
-		//fmt.Println(i)
-		conn, err := net.Dial("tcp", lynk.Peers[i].IP+":"+lynk.Peers[i].Port)
-		if err == nil {
-			sendFile(lynxutil.HomePath+lynk.Name+"/meta.info", conn)
-		}
-		//fmt.Println(lynk.Peers[i].IP)
-		i++
+	t.pushMu.Lock()
+	defer t.pushMu.Unlock()
+	m, err := metainfo.Read(metaPath)
+	if err == nil {
+		err = m.Adopt(fingerprint)
+	}
+	if err == nil {
+		err = metainfo.Write(metaPath, m)
+	}
+	return err
+}
+
+// BroadcastNewIP - This function broadcasts a tracker's new IP address to all of its peers. The
+// tracker's meta.info is announced at our current IP and signed again, which only the owner or a
+// writer can do, then pushed to the peers like any other change.
+// @param string swarmPath - The swarm.info path associated with the lynk we're interested in
+// @return error - An error is produced if we can't change the lynk or its meta.info
+func (t *Tracker) BroadcastNewIP(swarmPath string) error {
+	lynkName := t.getTLynkName(swarmPath)
+	metaPath := t.Home + lynkName + "/" + lynkName + "_Tracker/meta.info"
+	t.pushMu.Lock()
+	m, err := metainfo.Read(metaPath)
+	if err == nil && !m.Authorised(t.KeyFingerprint) {
+		err = errors.New("Not Authorised To Change " + lynkName)
+	}
+	if err == nil {
+		m.Announce = lynxutil.GetIP() + ":" + t.TrackerPort
+		err = m.Sign(t.Node)
+	}
+	if err == nil {
+		err = metainfo.Write(metaPath, m)
+	}
+	t.pushMu.Unlock()
+	if err != nil {
+		fmt.Println(err)
+		return err
 	}
+
+	return t.pushToPeers(lynkName)
 }
 
 // PurgeOldIPs - This function tries to connect to every peer in the swarm.info file and removes
 // them if unable to connect.
-func PurgeOldIPs() {
+func (t *Tracker) PurgeOldIPs() {
 	// Loops through all tracker lynks.
-	for _, lynk := range tLynks {
+	for _, lynk := range t.lynks.All() {
 
 		// Loops through all peers of a given lynk
 		i := 0
@@ -440,32 +584,159 @@ func PurgeOldIPs() {
 
 			// If we cannot connect, remove the peer
 			if err != nil {
-				deletePeer(lynk.Peers[i].IP, lynk.Name)
+				t.deletePeer(lynk.Peers[i].IP, lynk.Name)
+			} else {
+				conn.Close()
 			}
-			conn.Close()
 			i++
 		}
 	}
 }
 
 // TransferTracker - This function transfers the needed tracker files (swarm/meta.info) to the
-// specified IP and then deletes the local copies of these files.
-func TransferTracker(lynkName, owner, IP string) error {
-	conn, _ := net.Dial("tcp", IP+":"+lynxutil.TrackerPort)
+// specified IP and then deletes the local copies of these files. The files are encrypted for the
+// key the swarm.info lists for the peer at that IP, so only that peer can take over.
+// @param string lynkName - The name of the lynk
+// @param string owner - The owner of the lynk
+// @param string IP - The IP of the peer taking over as tracker
+// @return error - An error is produced if the peer has no key, the files can't be sent or the
+// peer refused them
+func (t *Tracker) TransferTracker(lynkName, owner, IP string) error {
+	trackerDir := t.Home + lynkName + "/" + lynkName + "_Tracker/"
+	t.swarmMu.Lock()
+	t.parseSwarminfo(trackerDir + "swarm.info")
+	lynk, _ := t.lynks.Get(lynkName)
+	t.swarmMu.Unlock()
+
+	key := ""
+	for _, peer := range lynk.Peers {
+		if peer.IP == IP && peer.Key != "" {
+			key = peer.Key
+		}
+	}
+	if key == "" {
+		return errors.New("No Key Known For " + IP)
+	}
 
-	// Sends the new peer the needed tracker files
-	err := sendFile(lynxutil.HomePath+lynkName+"/"+lynkName+"_Tracker/swarm.info", conn)
+	conn, err := net.Dial("tcp", IP+":"+t.TrackerPort)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+
+	// Sends the new peer the needed tracker files, signed so it knows they came from us
+	swarm, err := ioutil.ReadFile(trackerDir + "swarm.info")
+	if err != nil {
+		return err
+	}
+	meta, err := ioutil.ReadFile(trackerDir + "meta.info")
+	if err != nil {
+		return err
+	}
+	var signature bytes.Buffer
+	if err = t.Sign(bytes.NewReader(transferData(swarm, meta)), &signature); err != nil {
+		return err
+	}
+	fmt.Fprintf(conn, "Tracker_Transfer:"+lynkName+":"+lynxutil.EncodeKey(t.PublicKey)+"\n")
+	for _, data := range [][]byte{swarm, meta, signature.Bytes()} {
+		if err = lynxutil.SendStream(bytes.NewReader(data), conn, key); err != nil {
+			return err
+		}
+	}
 
-	err = sendFile(lynxutil.HomePath+lynkName+"/"+lynkName+"_Tracker/meta.info", conn)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
 	if err != nil {
 		return err
+	} else if strings.TrimSpace(reply) != "YES" {
+		return errors.New(IP + " Refused To Take Over " + lynkName)
 	}
 
 	// Removes the tracker directory from this computer
-	os.RemoveAll(lynxutil.HomePath + lynkName + "/" + lynkName + "_Tracker/")
+	os.RemoveAll(trackerDir)
+	t.lynks.Remove(lynkName)
 
 	return nil // No errors if we reach this point
 }
+
+// Helper function for handleRequest - handles the case where another tracker hands a lynk over to
+// us. We must already be a peer of the lynk, the files must be signed by the lynk's current
+// tracker or its owner, and the meta.info has to be signed by a key our copy of it authorises and
+// be no older than our copy.
+// @param string request - The lynk's name and the sender's key - '<LynkName>:<Key>'
+// @param io.Reader conn - The socket the tracker files are read from, positioned after the request
+// @return error - An error is produced if the files can't be received or are refused
+func (t *Tracker) handleTransfer(request string, conn io.Reader) error {
+	tmpArr := strings.Split(request, ":")
+	if len(tmpArr) != 2 {
+		return errors.New("Invalid Request Syntax")
+	}
+	lynkName := tmpArr[0]
+	if lynkName == "" || strings.ContainsAny(lynkName, "/\\") || lynkName == ".." {
+		return errors.New("Invalid Request Syntax")
+	}
+	key, err := lynxutil.DecodeKey(tmpArr[1])
+	if err != nil {
+		return err
+	}
+
+	var swarm, meta, signature bytes.Buffer
+	if err = t.ReceiveStream(conn, &swarm); err != nil {
+		return err
+	} else if err = t.ReceiveStream(conn, &meta); err != nil {
+		return err
+	} else if err = t.ReceiveStream(conn, &signature); err != nil {
+		return err
+	}
+	sender, err := mypgp.Verify([]byte(key), bytes.NewReader(transferData(swarm.Bytes(),
+		meta.Bytes())), &signature)
+	if err != nil {
+		return err
+	}
+
+	// Checked against our copy as the last push left it, like a push
+	t.pushMu.Lock()
+	defer t.pushMu.Unlock()
+	local, err := metainfo.Read(t.Home + lynkName + "/meta.info")
+	if err != nil {
+		return err
+	}
+
+	// Only the tracker we know for the lynk or its owner may hand it over - anyone else could give
+	// us a swarm of their choosing
+	tracker, _ := t.PinnedKey(local.Announce)
+	if !strings.EqualFold(sender, local.OwnerKey) && !strings.EqualFold(sender, tracker) {
+		return errors.New("Transfer Of " + lynkName + " Not Sent By Its Tracker Or Owner")
+	}
+
+	incoming, err := metainfo.Decode(bytes.NewReader(meta.Bytes()))
+	if err == nil {
+		err = metainfo.CheckSigner(local, incoming)
+	}
+	if err == nil && incoming.Sequence < local.Sequence {
+		err = metainfo.ErrStale // We already took pushes the old tracker hasn't seen
+	}
+	if err != nil {
+		return err
+	}
+
+	trackerDir := t.Home + lynkName + "/" + lynkName + "_Tracker/"
+	t.swarmMu.Lock()
+	defer t.swarmMu.Unlock()
+	if err = os.MkdirAll(trackerDir, 0755); err != nil {
+		return err
+	} else if err = ioutil.WriteFile(trackerDir+"swarm.info", swarm.Bytes(), 0644); err != nil {
+		return err
+	} else if err = ioutil.WriteFile(trackerDir+"meta.info", meta.Bytes(), 0644); err != nil {
+		return err
+	}
+	t.lynks.Add(lynxutil.Lynk{Name: lynkName})
+	return t.parseSwarminfo(trackerDir + "swarm.info")
+}
+
+// Joins a lynk's tracker files into the data a transfer's signature is made over.
+// @param []byte swarm - The swarm.info
+// @param []byte meta - The meta.info
+// @return []byte - The data to sign
+func transferData(swarm, meta []byte) []byte {
+	return bytes.Join([][]byte{swarm, meta}, []byte("\n"))
+}
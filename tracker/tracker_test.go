@@ -6,10 +6,13 @@ package tracker
 
 import (
 	"bufio"
+	"bytes"
 	"capstone/lynxutil"
+	"capstone/metainfo"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 	"os/user"
 	"strings"
 	"testing"
@@ -19,7 +22,15 @@ import (
 var successful = 0
 
 // Total # of the tests.
-const total = 8
+const total = 10
+
+// Loads the default node the tests run on, under LYNX_PASSPHRASE or else a test passphrase.
+func init() {
+	if os.Getenv(lynxutil.PassphraseEnv) == "" {
+		os.Setenv(lynxutil.PassphraseEnv, "lynx tests")
+	}
+	LoadDefault()
+}
 
 // Gets user's home directory */
 var cU, _ = user.Current()
@@ -100,12 +111,73 @@ func TestListenHandleSend(t *testing.T) {
 
 }
 
+// Unit tests for taking over a lynk from another tracker - only its tracker or owner may hand it
+// over
+// @param *testing.T t - The wrapper for the test
+func TestTransfer(t *testing.T) {
+	fmt.Println("\n----------------TestTransfer----------------")
+
+	nodes := []*lynxutil.Node{}
+	for len(nodes) < 3 {
+		home, err := ioutil.TempDir("", "lynx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(home)
+		node, err := lynxutil.NewNode(home, []byte("lynx tests"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+	receiver, owner, impostor := New(nodes[0]), nodes[1], nodes[2]
+
+	os.MkdirAll(receiver.Home+"Tests", 0755)
+	m := metainfo.New("Tests", "Tester", "127.0.0.1:9000")
+	m.OwnerKey = owner.KeyFingerprint
+	metainfo.Write(receiver.Home+"Tests/meta.info", m)
+	m.Sign(owner)
+	var meta bytes.Buffer
+	metainfo.Encode(&meta, m)
+	swarm := []byte("127.0.0.1:::4500\n")
+
+	// Sends the tracker files signed by one node, claiming to be from the node with key
+	transfer := func(signer *lynxutil.Node, key string) error {
+		var signature, conn bytes.Buffer
+		signer.Sign(bytes.NewReader(transferData(swarm, meta.Bytes())), &signature)
+		for _, data := range [][]byte{swarm, meta.Bytes(), signature.Bytes()} {
+			lynxutil.SendStream(bytes.NewReader(data), &conn, receiver.PublicKey)
+		}
+		return receiver.handleTransfer("Tests:"+lynxutil.EncodeKey(key), &conn)
+	}
+
+	swarmPath := receiver.Home + "Tests/Tests_Tracker/swarm.info"
+	err := transfer(impostor, impostor.PublicKey)
+	forged := transfer(impostor, owner.PublicKey)
+	if _, statErr := os.Stat(swarmPath); err == nil || forged == nil || statErr == nil {
+		t.Error("Test failed, expected a transfer from anyone else to be refused. Got ", err,
+			forged)
+	} else {
+		fmt.Println("Successfully Refused Transfer From Impostor")
+		successful++
+	}
+
+	err = transfer(owner, owner.PublicKey)
+	lynk, _ := receiver.lynks.Get("Tests")
+	if err != nil || len(lynk.Peers) != 1 {
+		t.Error("Test failed, expected the owner's transfer to be taken. Got ", err, lynk.Peers)
+	} else {
+		fmt.Println("Successfully Took Transfer From Owner")
+		successful++
+	}
+}
+
 // Unit tests for parsing, updating, and adding to swarm.info
 // @param *testing.T t - The wrapper for the test
 func TestSwarminfo(t *testing.T) {
 	fmt.Println("\n----------------TestParseSwarminfo----------------")
 
-	result := parseSwarminfo(sPath)
+	result := Default.parseSwarminfo(sPath)
 
 	if result != nil {
 		t.Error("Test failed, expected no error. Got ", result)
@@ -117,7 +189,7 @@ func TestSwarminfo(t *testing.T) {
 	fmt.Println("\n----------------TestAddToSwarminfo----------------")
 
 	p1 := lynxutil.Peer{IP: "000.000.000.000", Port: "4500"}
-	result = addToSwarminfo(p1, sPath)
+	result = Default.addToSwarminfo(p1, sPath)
 
 	if result == nil {
 		t.Error("*Run Test Twice If This Is First Time* Test failed, expected duplicate error. Got ",
@@ -129,7 +201,7 @@ func TestSwarminfo(t *testing.T) {
 
 	fmt.Println("\n----------------TestUpdateSwarminfo----------------")
 
-	result = updateSwarminfo(sPath)
+	result = Default.updateSwarminfo(sPath)
 
 	if result != nil {
 		t.Error("Test failed, expected no error. Got ", result)
@@ -140,7 +212,7 @@ func TestSwarminfo(t *testing.T) {
 
 	fmt.Println("\n----------------TestBroadcastIP----------------")
 
-	BroadcastNewIP(sPath)
+	Default.BroadcastNewIP(sPath)
 
 	if result != nil {
 		t.Error("Test failed, expected no error. Got ", result)
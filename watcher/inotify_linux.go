@@ -0,0 +1,246 @@
+// Native events for the watcher package - inotify watches every directory inside each lynk, and
+// watches are added as new directories appear. The inotify descriptor is waited on with epoll,
+// alongside a pipe that is written to when the source is closed.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+//go:build linux
+// +build linux
+
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The inotify events that mean a lynk's files may have changed
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// The name of a lynk's ignore files, as in client.IgnoreName - when one changes, folders it no
+// longer ignores are watched
+const ignoreName = ".lynxignore"
+
+// inotifySource - Reports changes with inotify.
+type inotifySource struct {
+	mu     sync.Mutex
+	fd     int    // The inotify descriptor
+	epfd   int    // Waits for events or for the source to be closed
+	wake   [2]int // Writing to wake[1] stops the read loop
+	done   chan struct{}
+	notify func(string, string)
+	skip   func(string, string, bool) bool
+	wds    map[int32]watch   // Every watched directory by its watch descriptor
+	dirs   map[string]string // Every lynk's directory by its name
+}
+
+// watch - A directory being watched and the lynk it belongs to.
+type watch struct {
+	lynkName string
+	dir      string
+}
+
+// Creates an inotifySource and starts reading its events.
+// @param func(string, string) notify - Called with a lynk's name and the path when it changes
+// @param func(string, string, bool) skip - Returns whether a path inside a lynk isn't watched
+// @return source - The new source
+// @return error - An error is produced if inotify isn't available
+func newNativeSource(notify func(string, string), skip func(string, string, bool) bool) (source,
+	error) {
+	s := &inotifySource{fd: -1, epfd: -1, wake: [2]int{-1, -1}, done: make(chan struct{}),
+		notify: notify, skip: skip, wds: map[int32]watch{}, dirs: map[string]string{}}
+
+	var err error
+	s.fd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err == nil {
+		s.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	}
+	if err == nil {
+		err = syscall.Pipe2(s.wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK)
+	}
+	for _, fd := range []int{s.fd, s.wake[0]} {
+		if err == nil {
+			event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+			err = syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_ADD, fd, &event)
+		}
+	}
+	if err != nil {
+		s.closeAll()
+		return nil, err
+	}
+
+	go s.read()
+	return s, nil
+}
+
+// Watches every directory inside a lynk.
+// @param string lynkName - The name of the lynk
+// @param string dir - The lynk's directory
+// @return error - An error is produced if the lynk's directory can't be watched
+func (s *inotifySource) add(lynkName, dir string) error {
+	if err := s.addDir(lynkName, dir); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.dirs[lynkName] = dir
+	s.mu.Unlock()
+	s.addTree(lynkName, dir)
+	return nil
+}
+
+// Watches the directories below dir - ones that can't be watched are skipped.
+// @param string lynkName - The name of the lynk
+// @param string dir - The directory to walk
+func (s *inotifySource) addTree(lynkName, dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || path == dir {
+			return nil
+		} else if ignored(path) || s.skip(lynkName, path, true) {
+			return filepath.SkipDir
+		}
+		s.addDir(lynkName, path)
+		return nil
+	})
+}
+
+// Watches a single directory.
+// @param string lynkName - The name of the lynk
+// @param string dir - The directory
+// @return error - An error is produced if the directory can't be watched
+func (s *inotifySource) addDir(lynkName, dir string) error {
+	wd, err := syscall.InotifyAddWatch(s.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.wds[int32(wd)] = watch{lynkName: lynkName, dir: dir}
+	s.mu.Unlock()
+	return nil
+}
+
+// Stops watching a lynk's directories.
+// @param string lynkName - The name of the lynk
+func (s *inotifySource) remove(lynkName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dirs, lynkName)
+	for wd, w := range s.wds {
+		if w.lynkName == lynkName {
+			syscall.InotifyRmWatch(s.fd, uint32(wd))
+			delete(s.wds, wd)
+		}
+	}
+}
+
+// Stops watching every lynk, once the read loop has stopped.
+// @return error - An error can be produced if the inotify descriptor can't be closed
+func (s *inotifySource) close() error {
+	syscall.Write(s.wake[1], []byte{0})
+	<-s.done
+	return s.closeAll()
+}
+
+// Closes every descriptor the source opened.
+// @return error - An error can be produced if the inotify descriptor can't be closed
+func (s *inotifySource) closeAll() error {
+	var err error
+	if s.fd != -1 {
+		err = syscall.Close(s.fd)
+	}
+	for _, fd := range []int{s.epfd, s.wake[0], s.wake[1]} {
+		if fd != -1 {
+			syscall.Close(fd)
+		}
+	}
+	return err
+}
+
+// Reads events until the source is closed.
+func (s *inotifySource) read() {
+	defer close(s.done)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	ready := make([]syscall.EpollEvent, 2)
+	for {
+		n, err := syscall.EpollWait(s.epfd, ready, -1)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return
+		}
+		for _, event := range ready[:n] {
+			if event.Fd == int32(s.wake[0]) {
+				return
+			}
+		}
+
+		// Reads until the descriptor is drained - it is non-blocking, so an empty one says so
+		for {
+			n, err = syscall.Read(s.fd, buf)
+			if err == syscall.EINTR {
+				continue
+			} else if err != nil || n <= 0 {
+				break
+			}
+
+			offset := 0
+			for offset+syscall.SizeofInotifyEvent <= n {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]),
+					"\x00")
+				s.handle(event.Wd, event.Mask, name)
+				offset = nameStart + int(event.Len)
+			}
+		}
+	}
+}
+
+// Reports a single event, watching any directory it created.
+// @param int32 wd - The watch descriptor the event is for
+// @param uint32 mask - What happened
+// @param string name - The name of the file inside the watched directory, if any
+func (s *inotifySource) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost - every lynk may have changed
+		s.mu.Lock()
+		lynkDirs := map[string]string{}
+		for lynkName, dir := range s.dirs {
+			lynkDirs[lynkName] = dir
+		}
+		s.mu.Unlock()
+		for lynkName, dir := range lynkDirs {
+			s.notify(lynkName, dir)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	w, ok := s.wds[wd]
+	if ok && mask&syscall.IN_IGNORED != 0 {
+		delete(s.wds, wd) // The directory is gone
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	path := filepath.Join(w.dir, name)
+	isDir := mask&syscall.IN_ISDIR != 0
+	if ignored(path) || s.skip(w.lynkName, path, isDir) {
+		return
+	}
+	if isDir && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		// Files may already be inside a directory that was just created or moved in
+		s.addDir(w.lynkName, path)
+		s.addTree(w.lynkName, path)
+	} else if name == ignoreName {
+		s.addTree(w.lynkName, w.dir) // Folders it ignored before may be synced now
+	}
+	s.notify(w.lynkName, path)
+}
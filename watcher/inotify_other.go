@@ -0,0 +1,20 @@
+// Native events for the watcher package on platforms without inotify - lynks are polled instead.
+// @author: Michael Bruce
+// @author: Max Kernchen
+
+//go:build !linux
+// +build !linux
+
+package watcher
+
+import "errors"
+
+// Reports that native events aren't available so the watcher falls back to polling.
+// @param func(string, string) notify - Called with a lynk's name and the path when it changes
+// @param func(string, string, bool) skip - Returns whether a path inside a lynk isn't watched
+// @return source - Always nil
+// @return error - Always an error
+func newNativeSource(notify func(string, string), skip func(string, string, bool) bool) (source,
+	error) {
+	return nil, errors.New("Filesystem Events Are Not Supported On This Platform")
+}
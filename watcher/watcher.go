@@ -0,0 +1,436 @@
+// Package watcher watches lynk directories for changes. It uses inotify where the platform has it
+// and falls back to polling, debounces bursts of events until the changed files stop growing, and
+// queues each changed lynk once.
+// @author: Michael Bruce
+// @author: Max Kernchen
+package watcher
+
+import (
+	"../lynxutil"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PollInterval - How often lynk directories are walked when native events aren't available
+const PollInterval = 10 * time.Second
+
+// Filter - Decides whether a path inside a lynk is left out of syncing, e.g. by a .lynxignore
+// pattern. Changes to paths it skips aren't reported.
+// @param string lynkName - The name of the lynk
+// @param string relPath - The path inside the lynk, with forward slashes
+// @param bool isDir - Whether the path is a folder
+// @return bool - True if the path is skipped
+type Filter func(lynkName, relPath string, isDir bool) bool
+
+// source - Something that reports changes inside lynk directories.
+type source interface {
+	add(lynkName, dir string) error
+	remove(lynkName string)
+	close() error
+}
+
+// Watcher - Watches lynk directories and queues the lynks that changed.
+type Watcher struct {
+	mu       sync.Mutex
+	src      source
+	debounce time.Duration
+	filter   Filter
+	timers   map[string]*time.Timer
+	stamps   map[string]map[string]string // The size of each path that changed, by lynk
+	dirs     map[string]string
+	queue    []string
+	queued   map[string]bool
+	notify   chan struct{}
+	closed   bool
+}
+
+// New - Creates a Watcher. Native filesystem events are used if they're available, otherwise lynk
+// directories are polled.
+// @param time.Duration debounce - How long a lynk must go without events before it is queued
+// @return *Watcher - The new watcher
+func New(debounce time.Duration) *Watcher {
+	w := &Watcher{
+		debounce: debounce,
+		timers:   map[string]*time.Timer{},
+		stamps:   map[string]map[string]string{},
+		dirs:     map[string]string{},
+		queued:   map[string]bool{},
+		notify:   make(chan struct{}, 1),
+	}
+
+	src, err := newNativeSource(w.event, w.skips)
+	if err != nil {
+		fmt.Println("Watching By Polling: " + err.Error())
+		src = newPollSource(w.event, w.skips, PollInterval)
+	}
+	w.src = src
+	return w
+}
+
+// SetFilter - Leaves the paths a filter skips out of watching. It applies to the events that come
+// after it is set.
+// @param Filter filter - The filter, or nil to watch every path
+func (w *Watcher) SetFilter(filter Filter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.filter = filter
+}
+
+// Add - Starts watching a lynk's directory. Adding a lynk that is already watched does nothing.
+// @param string lynkName - The name of the lynk
+// @param string dir - The lynk's directory
+// @return error - An error can be produced if the directory cannot be watched
+func (w *Watcher) Add(lynkName, dir string) error {
+	w.mu.Lock()
+	if _, ok := w.dirs[lynkName]; ok || w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.dirs[lynkName] = dir
+	w.mu.Unlock()
+
+	err := w.src.add(lynkName, dir)
+	if err != nil {
+		w.mu.Lock()
+		delete(w.dirs, lynkName)
+		w.mu.Unlock()
+	}
+	return err
+}
+
+// Remove - Stops watching a lynk's directory and drops any change queued for it.
+// @param string lynkName - The name of the lynk
+func (w *Watcher) Remove(lynkName string) {
+	w.src.remove(lynkName)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.dirs, lynkName)
+	if timer, ok := w.timers[lynkName]; ok {
+		timer.Stop()
+		delete(w.timers, lynkName)
+		delete(w.stamps, lynkName)
+	}
+	if w.queued[lynkName] {
+		delete(w.queued, lynkName)
+		i := 0
+		for i < len(w.queue) {
+			if w.queue[i] == lynkName {
+				w.queue = append(w.queue[:i], w.queue[i+1:]...)
+			} else {
+				i++
+			}
+		}
+	}
+}
+
+// Watched - Returns the lynks being watched.
+// @return []string - The lynks' names
+func (w *Watcher) Watched() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := []string{}
+	for name := range w.dirs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Next - Waits for a lynk to change. A lynk is queued at most once no matter how many events it
+// had, and is queued again if it changes while it is being handled.
+// @return string - The name of the lynk that changed
+// @return bool - False once the watcher has been closed
+func (w *Watcher) Next() (string, bool) {
+	for {
+		w.mu.Lock()
+		if len(w.queue) > 0 {
+			lynkName := w.queue[0]
+			w.queue = w.queue[1:]
+			delete(w.queued, lynkName)
+			w.mu.Unlock()
+			return lynkName, true
+		} else if w.closed {
+			w.mu.Unlock()
+			return "", false
+		}
+		w.mu.Unlock()
+		<-w.notify
+	}
+}
+
+// Close - Stops watching every lynk. Next returns false once the queue is empty.
+// @return error - An error can be produced if the event source can't be closed
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	for _, timer := range w.timers {
+		timer.Stop()
+	}
+	w.mu.Unlock()
+
+	w.wake()
+	return w.src.close()
+}
+
+// Records an event in a lynk's directory. The lynk is queued once events stop for the debounce
+// period and the paths that changed have stopped growing, so a file still being written isn't
+// synced half-finished.
+// @param string lynkName - The name of the lynk
+// @param string path - The path that changed - the lynk's directory if it isn't known
+func (w *Watcher) event(lynkName, path string) {
+	size := stamp(path)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.dirs[lynkName]; !ok || w.closed {
+		return
+	}
+
+	if w.stamps[lynkName] == nil {
+		w.stamps[lynkName] = map[string]string{}
+	}
+	w.stamps[lynkName][path] = size
+	if timer, ok := w.timers[lynkName]; ok {
+		timer.Reset(w.debounce)
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(w.debounce, func() { w.settle(lynkName, timer) })
+	w.timers[lynkName] = timer
+}
+
+// Queues a lynk whose events have stopped, unless a path that changed is still growing - then it
+// waits another debounce period.
+// @param string lynkName - The name of the lynk
+// @param *time.Timer timer - The timer that fired - if the lynk was removed since, it's ignored
+func (w *Watcher) settle(lynkName string, timer *time.Timer) {
+	w.mu.Lock()
+	if w.timers[lynkName] != timer || w.closed {
+		w.mu.Unlock()
+		return
+	}
+	paths := []string{}
+	for path := range w.stamps[lynkName] {
+		paths = append(paths, path)
+	}
+	w.mu.Unlock()
+
+	sizes := map[string]string{}
+	for _, path := range paths {
+		sizes[path] = stamp(path)
+	}
+
+	w.mu.Lock()
+	if w.timers[lynkName] != timer || w.closed {
+		w.mu.Unlock()
+		return
+	}
+	growing := false
+	for path, size := range sizes {
+		if w.stamps[lynkName][path] != size {
+			w.stamps[lynkName][path] = size
+			growing = true
+		}
+	}
+	if growing {
+		timer.Reset(w.debounce)
+		w.mu.Unlock()
+		return
+	}
+
+	delete(w.timers, lynkName)
+	delete(w.stamps, lynkName)
+	if _, watched := w.dirs[lynkName]; watched && !w.queued[lynkName] {
+		w.queued[lynkName] = true
+		w.queue = append(w.queue, lynkName)
+	}
+	w.mu.Unlock()
+	w.wake()
+}
+
+// Returns whether a path inside a lynk is skipped by the watcher's filter.
+// @param string lynkName - The name of the lynk
+// @param string path - The path
+// @param bool isDir - Whether the path is a folder
+// @return bool - True if changes to the path aren't reported
+func (w *Watcher) skips(lynkName, path string, isDir bool) bool {
+	w.mu.Lock()
+	dir, ok := w.dirs[lynkName]
+	filter := w.filter
+	w.mu.Unlock()
+	if !ok || filter == nil {
+		return false
+	}
+
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil || relPath == "." {
+		return false
+	}
+	return filter(lynkName, filepath.ToSlash(relPath), isDir)
+}
+
+// Returns the size and modification time of a path, which stop changing once it is written. A
+// directory's stamp covers every file inside it.
+// @param string path - The path
+// @return string - The stamp, or "" if the path doesn't exist
+func stamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	} else if info.IsDir() {
+		return strconv.FormatUint(dirSum(path, nil), 10)
+	}
+	return strconv.FormatInt(info.Size(), 10) + ":" +
+		strconv.FormatInt(info.ModTime().UnixNano(), 10)
+}
+
+// Wakes up a call to Next that is waiting for the queue.
+func (w *Watcher) wake() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Returns whether a path inside a lynk is one Lynx writes itself, is still being written or is
+// never shared, so changes to it aren't reported.
+// @param string path - The path
+// @return bool - True if the path should be ignored
+func ignored(path string) bool {
+	name := filepath.Base(path)
+	return name == "meta.info" || lynxutil.IsTemp(name) || strings.Contains(path, "_Tracker")
+}
+
+// pollSource - Reports changes by walking every lynk directory on an interval and comparing the
+// names, sizes and modification times of its files.
+type pollSource struct {
+	mu     sync.Mutex
+	notify func(string, string)
+	skip   func(string, string, bool) bool
+	dirs   map[string]string
+	sums   map[string]uint64
+	stop   chan struct{}
+}
+
+// Creates a pollSource and starts it polling.
+// @param func(string, string) notify - Called with a lynk's name and directory when it changes
+// @param func(string, string, bool) skip - Returns whether a path inside a lynk isn't watched
+// @param time.Duration interval - How often to walk the directories
+// @return *pollSource - The new source
+func newPollSource(notify func(string, string), skip func(string, string, bool) bool,
+	interval time.Duration) *pollSource {
+	p := &pollSource{notify: notify, skip: skip, dirs: map[string]string{},
+		sums: map[string]uint64{}, stop: make(chan struct{})}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.poll()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Starts polling a lynk's directory.
+// @param string lynkName - The name of the lynk
+// @param string dir - The lynk's directory
+// @return error - Always nil - a missing directory is simply reported once it appears
+func (p *pollSource) add(lynkName, dir string) error {
+	sum := dirSum(dir, p.skipper(lynkName))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dirs[lynkName] = dir
+	p.sums[lynkName] = sum
+	return nil
+}
+
+// Stops polling a lynk's directory.
+// @param string lynkName - The name of the lynk
+func (p *pollSource) remove(lynkName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.dirs, lynkName)
+	delete(p.sums, lynkName)
+}
+
+// Stops polling.
+// @return error - Always nil
+func (p *pollSource) close() error {
+	close(p.stop)
+	return nil
+}
+
+// Walks every directory once and reports the lynks whose files changed.
+func (p *pollSource) poll() {
+	p.mu.Lock()
+	dirs := map[string]string{}
+	for name, dir := range p.dirs {
+		dirs[name] = dir
+	}
+	p.mu.Unlock()
+
+	for name, dir := range dirs {
+		sum := dirSum(dir, p.skipper(name))
+		p.mu.Lock()
+		old, ok := p.sums[name]
+		if ok {
+			p.sums[name] = sum
+		}
+		p.mu.Unlock()
+
+		if ok && old != sum {
+			p.notify(name, dir)
+		}
+	}
+}
+
+// Returns the paths of a lynk that aren't polled.
+// @param string lynkName - The name of the lynk
+// @return func(string, bool) bool - Returns whether a path and what is below it are skipped
+func (p *pollSource) skipper(lynkName string) func(string, bool) bool {
+	return func(path string, isDir bool) bool {
+		return p.skip(lynkName, path, isDir)
+	}
+}
+
+// Sums up the names, sizes and modification times of every file in a directory. Files are only
+// stat'ed - never read.
+// @param string dir - The directory
+// @param func(string, bool) bool skip - Returns whether a path and what is below it are left out,
+// or nil to only leave out the paths Lynx writes itself
+// @return uint64 - A checksum that changes whenever a file is added, removed or modified
+func dirSum(dir string, skip func(string, bool) bool) uint64 {
+	h := fnv.New64a()
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || ignored(path) {
+			return nil
+		} else if path != dir && skip != nil && skip(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if info.IsDir() {
+			return nil
+		}
+		h.Write([]byte(path + "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" +
+			strconv.FormatInt(info.ModTime().UnixNano(), 10) + "\x00"))
+		return nil
+	})
+	return h.Sum64()
+}
@@ -0,0 +1,175 @@
+// The unit tests for the watcher package - debouncing bursts of events, waiting for growing files,
+// leaving filtered paths out, and the polling fallback
+// @author: Michael Bruce
+// @author: Max Kernchen
+package watcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 8
+
+// Waits for the next queued lynk, giving up after a while.
+// @param *Watcher w - The watcher
+// @param time.Duration timeout - How long to wait
+// @return string - The lynk's name, or "" if nothing was queued in time
+func nextWithin(w *Watcher, timeout time.Duration) string {
+	result := make(chan string, 1)
+	go func() {
+		lynkName, _ := w.Next()
+		result <- lynkName
+	}()
+
+	select {
+	case lynkName := <-result:
+		return lynkName
+	case <-time.After(timeout):
+		return ""
+	}
+}
+
+// Unit tests for debouncing bursts of events.
+// @param *testing.T t - The wrapper for the test
+func TestDebounce(t *testing.T) {
+	fmt.Println("\n----------------TestDebounce----------------")
+
+	dir, _ := ioutil.TempDir("", "lynk")
+	defer os.RemoveAll(dir)
+	w := New(200 * time.Millisecond)
+	defer w.Close()
+	w.Add("Tests", dir)
+
+	// A burst of writes - including an edit that is immediately reverted
+	ioutil.WriteFile(dir+"/a.txt", []byte("one"), 0644)
+	ioutil.WriteFile(dir+"/a.txt", []byte("two"), 0644)
+	ioutil.WriteFile(dir+"/a.txt", []byte("one"), 0644)
+	ioutil.WriteFile(dir+"/meta.info", []byte("ignored"), 0644)
+
+	if lynkName := nextWithin(w, 5*time.Second); lynkName != "Tests" {
+		t.Error("Test failed, expected 'Tests' to be queued. Got ", lynkName)
+	} else {
+		fmt.Println("Successfully Queued Changed Lynk")
+		successful++
+	}
+
+	if lynkName := nextWithin(w, time.Second); lynkName != "" {
+		t.Error("Test failed, expected the burst to be queued once. Got ", lynkName)
+	} else {
+		fmt.Println("Successfully Debounced Burst")
+		successful++
+	}
+}
+
+// Unit tests for waiting until changed files stop growing.
+// @param *testing.T t - The wrapper for the test
+func TestGrowing(t *testing.T) {
+	fmt.Println("\n----------------TestGrowing----------------")
+
+	dir, _ := ioutil.TempDir("", "lynk")
+	defer os.RemoveAll(dir)
+	w := New(200 * time.Millisecond)
+	defer w.Close()
+	w.Add("Tests", dir)
+
+	// A file that keeps growing holds the lynk back until it is written
+	grown := make(chan bool, 1)
+	go func() {
+		for i := 1; i <= 6; i++ {
+			ioutil.WriteFile(dir+"/big.bin", make([]byte, i*1024), 0644)
+			time.Sleep(100 * time.Millisecond)
+		}
+		grown <- true
+	}()
+	lynkName := nextWithin(w, 5*time.Second)
+	if len(grown) != 1 || lynkName != "Tests" {
+		t.Error("Test failed, expected the lynk to be queued once the file stopped growing. Got ",
+			lynkName)
+	} else {
+		fmt.Println("Successfully Waited For Growing File")
+		successful++
+	}
+
+	// A lynk removed before its burst settles isn't queued
+	ioutil.WriteFile(dir+"/b.txt", []byte("one"), 0644)
+	time.Sleep(50 * time.Millisecond)
+	w.Remove("Tests")
+	if lynkName := nextWithin(w, time.Second); lynkName != "" {
+		t.Error("Test failed, expected a removed lynk not to be queued. Got ", lynkName)
+	} else {
+		fmt.Println("Successfully Dropped Removed Lynk")
+		successful++
+	}
+}
+
+// Unit tests for leaving filtered paths out of watching.
+// @param *testing.T t - The wrapper for the test
+func TestFilter(t *testing.T) {
+	fmt.Println("\n----------------TestFilter----------------")
+
+	dir, _ := ioutil.TempDir("", "lynk")
+	defer os.RemoveAll(dir)
+	os.Mkdir(dir+"/build", 0755)
+	w := New(200 * time.Millisecond)
+	defer w.Close()
+	w.SetFilter(func(lynkName, relPath string, isDir bool) bool {
+		return relPath == "build" || relPath == "a.log"
+	})
+	w.Add("Tests", dir)
+
+	ioutil.WriteFile(dir+"/a.log", []byte("ignored"), 0644)
+	ioutil.WriteFile(dir+"/build/out.o", []byte("ignored"), 0644)
+	if lynkName := nextWithin(w, time.Second); lynkName != "" {
+		t.Error("Test failed, expected changes to filtered paths to be ignored. Got ", lynkName)
+	} else {
+		fmt.Println("Successfully Ignored Filtered Paths")
+		successful++
+	}
+
+	skip := func(path string, isDir bool) bool { return w.skips("Tests", path, isDir) }
+	before := dirSum(dir, skip)
+	ioutil.WriteFile(dir+"/build/out.o", []byte("still ignored"), 0644)
+	if dirSum(dir, skip) != before {
+		t.Error("Test failed, expected polling to leave filtered folders out.")
+	} else {
+		fmt.Println("Successfully Polled Around Filtered Folder")
+		successful++
+	}
+}
+
+// Unit tests for the polling fallback.
+// @param *testing.T t - The wrapper for the test
+func TestPoll(t *testing.T) {
+	fmt.Println("\n----------------TestPoll----------------")
+
+	dir, _ := ioutil.TempDir("", "lynk")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/a.txt", []byte("one"), 0644)
+	before := dirSum(dir, nil)
+
+	ioutil.WriteFile(dir+"/meta.info", []byte("ignored"), 0644)
+	if dirSum(dir, nil) != before {
+		t.Error("Test failed, expected meta.info to be ignored.")
+	} else {
+		fmt.Println("Successfully Ignored meta.info")
+		successful++
+	}
+
+	ioutil.WriteFile(dir+"/a.txt", []byte("two!"), 0644)
+	if dirSum(dir, nil) == before {
+		t.Error("Test failed, expected a changed file to change the checksum.")
+	} else {
+		fmt.Println("Successfully Detected Changed File")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}